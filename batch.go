@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+
+	"gopkg.in/gomisc/errors.v1"
+)
+
+// Виды запросов пакета
+const (
+	BatchExec BatchKind = iota + 1
+	BatchQuery
+	BatchQueryRow
+)
+
+const (
+	ErrBatchAborted = errors.Const("batch aborted")
+)
+
+type (
+	// BatchKind - вид запроса пакета, определяет способ получения результата
+	BatchKind int
+
+	// Batcher - хранилище, выполняющее пакет запросов за один обмен с сервером
+	Batcher interface {
+		// SendBatch - выполняет запросы пакета в порядке добавления и заполняет их результаты,
+		// возвращает объединенную ошибку запросов пакета
+		SendBatch(ctx context.Context, batch *Batch) error
+	}
+
+	// Batch - пакет запросов с приемниками результатов
+	Batch struct {
+		// Atomic - пакет выполняется целиком или не выполняется: вне транзакции для него
+		// открывается транзакция, в транзакции контекста - точка сохранения
+		Atomic bool
+
+		items []*BatchItem
+	}
+
+	// BatchItem - запрос пакета, результат и ошибка заполняются после выполнения пакета
+	BatchItem struct {
+		// Kind - вид запроса
+		Kind BatchKind
+		// Query - запрос
+		Query Query
+		// Result - приемник результата BatchQuery, аналогичный аргументу Storage.Query
+		Result any
+		// Dest - приемники колонок строки BatchQueryRow
+		Dest []any
+		// ExecResult - результат выполнения BatchExec
+		ExecResult sql.Result
+		// Err - ошибка выполнения запроса, ErrBatchAborted для невыполненных запросов
+		Err error
+	}
+)
+
+// Exec - добавляет в пакет запрос без результата
+func (b *Batch) Exec(query Query) *BatchItem {
+	return b.add(&BatchItem{Kind: BatchExec, Query: query})
+}
+
+// Query - добавляет в пакет запрос с результатом произвольного типа
+func (b *Batch) Query(query Query, result any) *BatchItem {
+	return b.add(&BatchItem{Kind: BatchQuery, Query: query, Result: result})
+}
+
+// QueryRow - добавляет в пакет запрос единственной строки, колонки которой сканируются в dest
+func (b *Batch) QueryRow(query Query, dest ...any) *BatchItem {
+	return b.add(&BatchItem{Kind: BatchQueryRow, Query: query, Dest: dest})
+}
+
+// Len - количество запросов пакета
+func (b *Batch) Len() int {
+	return len(b.items)
+}
+
+// Items - запросы пакета в порядке добавления
+func (b *Batch) Items() []*BatchItem {
+	return b.items
+}
+
+// Abort - отмечает запросы пакета без ошибки как невыполненные: после отказа атомарного пакета
+// их действия отменены вместе с транзакцией
+func (b *Batch) Abort() {
+	for _, item := range b.items {
+		if item.Err == nil {
+			item.Err = ErrBatchAborted
+		}
+	}
+}
+
+// Err - ошибки запросов пакета, объединенные errors.And, с номерами запросов в контексте
+func (b *Batch) Err() error {
+	var err error
+
+	for i, item := range b.items {
+		if item.Err != nil && !errors.Is(item.Err, ErrBatchAborted) {
+			err = errors.And(err, errors.Ctx().Int("batch-item", i).Stringer("query", item.Query).Just(item.Err))
+		}
+	}
+
+	return err
+}
+
+// Reset - сбрасывает результаты и ошибки запросов перед повторным выполнением пакета
+func (b *Batch) Reset() {
+	for _, item := range b.items {
+		item.ExecResult, item.Err = nil, nil
+	}
+}
+
+func (b *Batch) add(item *BatchItem) *BatchItem {
+	b.items = append(b.items, item)
+
+	return item
+}
+
+// SendBatch - выполняет пакет хранилищем, если оно реализует Batcher, иначе последовательно
+// запросами Storage в контексте ctx (в транзакции контекста, если она есть). В атомарном пакете
+// выполнение прекращается на первой ошибке, остальные запросы получают ErrBatchAborted
+func SendBatch(ctx context.Context, s Storage, batch *Batch) error {
+	if batcher, ok := s.(Batcher); ok {
+		return batcher.SendBatch(ctx, batch)
+	}
+
+	batch.Reset()
+
+	if !batch.Atomic {
+		runBatch(ctx, s, batch)
+
+		return batch.Err()
+	}
+
+	err := RunInTx(ctx, s, nil, func(ctx context.Context) error {
+		runBatch(ctx, s, batch)
+
+		return batch.Err()
+	}, RetryPolicy{MaxAttempts: 1})
+	if err != nil {
+		batch.Abort()
+
+		if batchErr := batch.Err(); batchErr != nil {
+			return batchErr
+		}
+
+		return errors.Wrap(err, "run batch transaction")
+	}
+
+	return nil
+}
+
+// runBatch - последовательно выполняет запросы пакета, атомарный пакет прерывается на первой ошибке
+func runBatch(ctx context.Context, s Storage, batch *Batch) {
+	for _, item := range batch.items {
+		switch item.Kind {
+		case BatchExec:
+			item.ExecResult, item.Err = s.Exec(ctx, item.Query)
+		case BatchQuery:
+			item.Err = s.Query(ctx, item.Query, item.Result)
+		case BatchQueryRow:
+			item.Err = s.QueryRow(ctx, item.Query, item.Dest...)
+		default:
+			item.Err = errors.Ctx().Int("kind", int(item.Kind)).Just(ErrNotSupported)
+		}
+
+		if item.Err != nil && batch.Atomic {
+			batch.Abort()
+
+			return
+		}
+	}
+}
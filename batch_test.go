@@ -0,0 +1,71 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+	"gopkg.in/gomisc/storage.v1/storagetest/fake"
+)
+
+var errDuplicate = &storage.DriverError{
+	Class: storage.ErrUniqueViolation,
+	Code:  "23505",
+	Err:   errors.Const("duplicate key value violates unique constraint"),
+}
+
+func TestSendBatch(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		atomic bool
+		expect func(s *fake.Storage)
+		// errs - ожидаемые ошибки запросов пакета
+		errs []error
+	}{
+		{
+			name: "sequential",
+			expect: func(s *fake.Storage) {
+				s.ExpectExec(`'alpha'`).WillReturnResult(0, 1)
+				s.ExpectExec(`'beta'`).WillReturnError(errDuplicate)
+				s.ExpectQuery(`^SELECT`).WillReturnRows(storage.Table{Headers: []string{"name"}})
+			},
+			errs: []error{nil, storage.ErrUniqueViolation, storage.ErrEmptyResult},
+		},
+		{
+			name:   "atomic",
+			atomic: true,
+			expect: func(s *fake.Storage) {
+				s.ExpectBegin()
+				s.ExpectExec(`'alpha'`).WillReturnResult(0, 1)
+				s.ExpectExec(`'beta'`).WillReturnError(errDuplicate)
+				s.ExpectRollback()
+			},
+			errs: []error{storage.ErrBatchAborted, storage.ErrUniqueViolation, storage.ErrBatchAborted},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := fake.New(t)
+			test.expect(s)
+
+			var name string
+
+			batch := &storage.Batch{Atomic: test.atomic}
+			batch.Exec(storage.NewQuery("INSERT INTO users (name) VALUES ('alpha')"))
+			batch.Exec(storage.NewQuery("INSERT INTO users (name) VALUES ('beta')"))
+			batch.QueryRow(storage.NewQuery("SELECT name FROM users WHERE id = 3"), &name)
+
+			err := storage.SendBatch(context.Background(), s, batch)
+			if !errors.Is(err, storage.ErrUniqueViolation) || errors.Is(err, storage.ErrBatchAborted) {
+				t.Fatalf("send batch: got %v, want %v", err, storage.ErrUniqueViolation)
+			}
+
+			for i, item := range batch.Items() {
+				if !errors.Is(item.Err, test.errs[i]) {
+					t.Fatalf("item %d: got %v, want %v", i, item.Err, test.errs[i])
+				}
+			}
+		})
+	}
+}
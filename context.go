@@ -0,0 +1,19 @@
+package storage
+
+import (
+	"context"
+)
+
+type transactionKey struct{}
+
+// ContextWithTransaction - возвращает контекст, несущий транзакцию
+func ContextWithTransaction(ctx context.Context, tx Transaction) context.Context {
+	return context.WithValue(ctx, transactionKey{}, tx)
+}
+
+// TransactionFromContext - возвращает транзакцию, в контексте которой выполняется код
+func TransactionFromContext(ctx context.Context) (Transaction, bool) {
+	tx, ok := ctx.Value(transactionKey{}).(Transaction)
+
+	return tx, ok
+}
@@ -0,0 +1,366 @@
+package storage
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/gomisc/errors.v1"
+)
+
+// copyInsertParams - ограничение числа параметров одной инструкции INSERT при загрузке без BulkLoader
+const copyInsertParams = 900
+
+// Стили квотирования идентификаторов в инструкциях INSERT загрузки без BulkLoader
+const (
+	// QuoteNone - идентификаторы без кавычек, подходят для любого диалекта, стиль по умолчанию
+	QuoteNone QuoteStyle = iota
+	// QuoteDouble - идентификаторы в двойных кавычках: PostgreSQL, SQLite
+	QuoteDouble
+	// QuoteBacktick - идентификаторы в обратных кавычках: MySQL
+	QuoteBacktick
+)
+
+const (
+	ErrCopyColumns = errors.Const("copy requires column names")
+	ErrCopySource  = errors.Const("unsupported copy source")
+)
+
+var (
+	_ RowSource = (*sliceSource)(nil)
+	_ RowSource = (*structSource)(nil)
+	_ RowSource = (*iteratorSource)(nil)
+	_ RowSource = (*csvSource)(nil)
+)
+
+type (
+	// BulkLoader - хранилище с массовой загрузкой строк средствами сервера
+	BulkLoader interface {
+		// CopyFrom - загружает строки source в колонки columns таблицы table, возвращает количество
+		// загруженных строк. Источники строк описаны в NewRowSource, в транзакции контекста
+		// загрузка выполняется в ней. Загрузка атомарна, при ошибке возвращается 0
+		CopyFrom(ctx context.Context, table string, columns []string, source any) (int64, error)
+	}
+
+	// QuoteStyle - стиль квотирования идентификаторов таблицы и колонок
+	QuoteStyle int
+
+	// RowSource - источник строк массовой загрузки, совместим с pgx.CopyFromSource
+	RowSource interface {
+		// Next - переходит к следующей строке
+		Next() bool
+		// Values - значения колонок текущей строки в порядке колонок загрузки
+		Values() ([]any, error)
+		// Err - ошибка источника
+		Err() error
+	}
+
+	sliceSource struct {
+		rows [][]any
+		pos  int
+	}
+
+	structSource struct {
+		columns []string
+		slice   reflect.Value
+		pos     int
+	}
+
+	iteratorSource struct {
+		ctx     context.Context
+		columns []string
+		iter    Iterator
+	}
+
+	csvSource struct {
+		reader *csv.Reader
+		record []string
+		err    error
+	}
+)
+
+// CopyFrom - загружает строки в таблицу средствами хранилища, если оно реализует BulkLoader,
+// иначе инструкциями INSERT в транзакции (в точке сохранения транзакции контекста). Стиль quote
+// задает квотирование идентификаторов в INSERT, по умолчанию QuoteNone
+func CopyFrom(ctx context.Context, s Storage, table string, columns []string, source any, quote ...QuoteStyle) (int64, error) {
+	if loader, ok := s.(BulkLoader); ok {
+		return loader.CopyFrom(ctx, table, columns, source)
+	}
+
+	rows, err := NewRowSource(ctx, columns, source)
+	if err != nil {
+		return 0, err
+	}
+
+	style := QuoteNone
+	if len(quote) != 0 {
+		style = quote[0]
+	}
+
+	var count int64
+
+	err = RunInTx(ctx, s, nil, func(ctx context.Context) error {
+		count, err = insertRows(ctx, s, style, table, columns, rows)
+
+		return err
+	}, RetryPolicy{MaxAttempts: 1})
+	if err != nil {
+		return 0, errors.Ctx().Str("table", table).Wrap(err, "copy rows")
+	}
+
+	return count, nil
+}
+
+// NewRowSource - приводит источник строк к RowSource. Поддерживаются RowSource, [][]any
+// со значениями в порядке columns, слайс структур или указателей на структуры (значения колонок
+// берутся по тегам db, как именованные параметры), Iterator (строки декодируются в map[string]any)
+// и io.Reader с CSV без строки заголовка, поля которого передаются строками
+func NewRowSource(ctx context.Context, columns []string, source any) (RowSource, error) {
+	if len(columns) == 0 {
+		return nil, ErrCopyColumns
+	}
+
+	switch src := source.(type) {
+	case RowSource:
+		return src, nil
+	case [][]any:
+		return &sliceSource{rows: src, pos: -1}, nil
+	case Iterator:
+		return &iteratorSource{ctx: ctx, columns: columns, iter: src}, nil
+	case io.Reader:
+		reader := csv.NewReader(src)
+		reader.FieldsPerRecord = len(columns)
+		reader.ReuseRecord = true
+
+		return &csvSource{reader: reader}, nil
+	}
+
+	slice := reflect.ValueOf(source)
+
+	if slice.Kind() == reflect.Slice && isStructType(slice.Type().Elem()) {
+		return &structSource{columns: columns, slice: slice, pos: -1}, nil
+	}
+
+	return nil, errors.Ctx().Str("type", fmt.Sprintf("%T", source)).Just(ErrCopySource)
+}
+
+// insertRows - загружает строки инструкциями INSERT по несколько строк
+func insertRows(ctx context.Context, s Storage, quote QuoteStyle, table string, columns []string, rows RowSource) (int64, error) {
+	var (
+		count int64
+		chunk = copyInsertParams / len(columns)
+		args  = make(map[string]any, chunk*len(columns))
+		sql   strings.Builder
+		n     int
+	)
+
+	if chunk == 0 {
+		chunk = 1
+	}
+
+	flush := func() error {
+		if n == 0 {
+			return nil
+		}
+
+		res, err := s.Exec(ctx, NewBindQuery(sql.String(), args))
+		if err != nil {
+			return err
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		count += affected
+		n = 0
+		args = make(map[string]any, chunk*len(columns))
+
+		return nil
+	}
+
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return count, errors.Ctx().Int64("row", count+int64(n)).Wrap(err, "read source row")
+		}
+
+		if len(values) != len(columns) {
+			return count, errors.Ctx().Int("values", len(values)).Int("columns", len(columns)).Just(ErrColumnsMismatch)
+		}
+
+		if n == 0 {
+			sql.Reset()
+			sql.WriteString("INSERT INTO " + quote.ident(table) + " (")
+
+			for i, column := range columns {
+				if i > 0 {
+					sql.WriteString(", ")
+				}
+
+				sql.WriteString(quote.ident(column))
+			}
+
+			sql.WriteString(") VALUES ")
+		} else {
+			sql.WriteString(", ")
+		}
+
+		sql.WriteByte('(')
+
+		for i, value := range values {
+			name := "r" + strconv.Itoa(n) + "c" + strconv.Itoa(i)
+			args[name] = value
+
+			if i > 0 {
+				sql.WriteString(", ")
+			}
+
+			sql.WriteString(":" + name)
+		}
+
+		sql.WriteByte(')')
+
+		if n++; n == chunk {
+			if err = flush(); err != nil {
+				return count, err
+			}
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return count, errors.Wrap(err, "read source rows")
+	}
+
+	return count, flush()
+}
+
+// ident - идентификатор в кавычках стиля, имя со схемой квотируется по частям
+func (style QuoteStyle) ident(name string) string {
+	var quote string
+
+	switch style {
+	case QuoteDouble:
+		quote = `"`
+	case QuoteBacktick:
+		quote = "`"
+	default:
+		return name
+	}
+
+	parts := strings.Split(name, ".")
+
+	for i, part := range parts {
+		parts[i] = quote + strings.ReplaceAll(part, quote, quote+quote) + quote
+	}
+
+	return strings.Join(parts, ".")
+}
+
+func isStructType(typ reflect.Type) bool {
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	return typ.Kind() == reflect.Struct
+}
+
+func (src *sliceSource) Next() bool {
+	src.pos++
+
+	return src.pos < len(src.rows)
+}
+
+func (src *sliceSource) Values() ([]any, error) {
+	return src.rows[src.pos], nil
+}
+
+func (src *sliceSource) Err() error {
+	return nil
+}
+
+func (src *structSource) Next() bool {
+	src.pos++
+
+	return src.pos < src.slice.Len()
+}
+
+func (src *structSource) Values() ([]any, error) {
+	elem := src.slice.Index(src.pos)
+	if elem.Kind() == reflect.Ptr && elem.IsNil() {
+		return nil, errors.Ctx().Int("row", src.pos).Just(ErrCopySource)
+	}
+
+	return columnValues(src.columns, namedArgsValues(elem.Interface()))
+}
+
+func (src *structSource) Err() error {
+	return nil
+}
+
+func (src *iteratorSource) Next() bool {
+	return src.iter.Next(src.ctx)
+}
+
+func (src *iteratorSource) Values() ([]any, error) {
+	row := make(map[string]any, len(src.columns))
+
+	if err := src.iter.Decode(&row); err != nil {
+		return nil, err
+	}
+
+	return columnValues(src.columns, row)
+}
+
+func (src *iteratorSource) Err() error {
+	return src.iter.Err()
+}
+
+func (src *csvSource) Next() bool {
+	if src.err != nil {
+		return false
+	}
+
+	src.record, src.err = src.reader.Read()
+
+	return src.err == nil
+}
+
+func (src *csvSource) Values() ([]any, error) {
+	values := make([]any, len(src.record))
+
+	for i, field := range src.record {
+		values[i] = field
+	}
+
+	return values, nil
+}
+
+func (src *csvSource) Err() error {
+	if errors.Is(src.err, io.EOF) {
+		return nil
+	}
+
+	return src.err
+}
+
+// columnValues - значения колонок загрузки из значений строки по именам
+func columnValues(columns []string, row map[string]any) ([]any, error) {
+	values := make([]any, len(columns))
+
+	for i, column := range columns {
+		value, ok := row[column]
+		if !ok {
+			return nil, errors.Ctx().Str("column", column).Just(ErrNamedArgMissing)
+		}
+
+		values[i] = value
+	}
+
+	return values, nil
+}
@@ -0,0 +1,198 @@
+package storage_test
+
+import (
+	"context"
+	"encoding/csv"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+	"gopkg.in/gomisc/storage.v1/storagetest/fake"
+)
+
+type (
+	copyUser struct {
+		ID      int64  `db:"id"`
+		Name    string `db:"name"`
+		Comment string `db:"-"`
+	}
+
+	// failingIterator - итератор, завершающийся ошибкой err
+	failingIterator struct {
+		storage.Iterator
+		err error
+	}
+)
+
+func TestNewRowSource(t *testing.T) {
+	columns := []string{"id", "name"}
+	want := [][]any{{int64(1), "alpha"}, {int64(2), "beta"}}
+
+	for _, test := range []struct {
+		name    string
+		columns []string
+		source  any
+		want    [][]any
+		err     error
+	}{
+		{
+			name:   "rows",
+			source: want,
+			want:   want,
+		},
+		{
+			name:   "structs",
+			source: []copyUser{{ID: 1, Name: "alpha"}, {ID: 2, Name: "beta", Comment: "skipped"}},
+			want:   want,
+		},
+		{
+			name:   "struct pointers",
+			source: []*copyUser{{ID: 1, Name: "alpha"}, {ID: 2, Name: "beta"}},
+			want:   want,
+		},
+		{
+			name:   "nil struct pointer",
+			source: []*copyUser{{ID: 1, Name: "alpha"}, nil},
+			err:    storage.ErrCopySource,
+		},
+		{
+			name:    "struct without column",
+			columns: []string{"id", "email"},
+			source:  []copyUser{{ID: 1}},
+			err:     storage.ErrNamedArgMissing,
+		},
+		{
+			name:   "iterator",
+			source: usersIterator(nil),
+			want:   want,
+		},
+		{
+			name:   "iterator error",
+			source: usersIterator(errFailed),
+			err:    errFailed,
+		},
+		{
+			name:   "csv",
+			source: strings.NewReader("1,alpha\n2,\"beta, \"\"quoted\"\"\"\n3,\n"),
+			want:   [][]any{{"1", "alpha"}, {"2", `beta, "quoted"`}, {"3", ""}},
+		},
+		{
+			name:   "csv with wrong field count",
+			source: strings.NewReader("1,alpha\n2\n"),
+			err:    csv.ErrFieldCount,
+		},
+		{name: "no columns", columns: []string{}, source: want, err: storage.ErrCopyColumns},
+		{name: "unsupported", source: []int{1}, err: storage.ErrCopySource},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if test.columns == nil {
+				test.columns = columns
+			}
+
+			rows, err := storage.NewRowSource(context.Background(), test.columns, test.source)
+			if err == nil {
+				var got [][]any
+
+				for rows.Next() {
+					var values []any
+
+					if values, err = rows.Values(); err != nil {
+						break
+					}
+
+					// источник CSV переиспользует запись, значения копируются
+					got = append(got, append([]any(nil), values...))
+				}
+
+				if err == nil {
+					err = rows.Err()
+				}
+
+				if err == nil && !reflect.DeepEqual(got, test.want) {
+					t.Fatalf("rows: got %v, want %v", got, test.want)
+				}
+			}
+
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("read rows: got %v, want %v", err, test.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("read rows: %s", errors.Formatted(err))
+			}
+		})
+	}
+}
+
+func TestCopyFrom(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		quote  []storage.QuoteStyle
+		insert string
+	}{
+		{name: "unquoted", insert: `^INSERT INTO public\.users \(id, name\) VALUES`},
+		{name: "double quotes", quote: []storage.QuoteStyle{storage.QuoteDouble}, insert: `^INSERT INTO "public"\."users" \("id", "name"\) VALUES`},
+		{name: "backticks", quote: []storage.QuoteStyle{storage.QuoteBacktick}, insert: "^INSERT INTO `public`\\.`users` \\(`id`, `name`\\) VALUES"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := fake.New(t)
+
+			s.ExpectBegin()
+			s.ExpectExec(test.insert).WillReturnResult(0, 2)
+			s.ExpectCommit()
+
+			rows := []copyUser{{ID: 1, Name: "alpha"}, {ID: 2, Name: "beta"}}
+
+			count, err := storage.CopyFrom(context.Background(), s, "public.users", []string{"id", "name"}, rows, test.quote...)
+			if err != nil {
+				t.Fatalf("copy: %s", errors.Formatted(err))
+			}
+
+			if count != 2 {
+				t.Fatalf("copy: got %d rows, want 2", count)
+			}
+		})
+	}
+}
+
+func TestCopyFromError(t *testing.T) {
+	s := fake.New(t)
+
+	// строки загружаются инструкциями INSERT по нескольку строк, ошибка откатывает всю загрузку
+	rows := make([][]any, 500)
+	for i := range rows {
+		rows[i] = []any{int64(i), "user"}
+	}
+
+	s.ExpectBegin()
+	s.ExpectExec(`^INSERT`).WillReturnResult(0, 450)
+	s.ExpectExec(`^INSERT`).WillReturnError(errDuplicate)
+	s.ExpectRollback()
+
+	count, err := storage.CopyFrom(context.Background(), s, "users", []string{"id", "name"}, rows)
+	if !errors.Is(err, storage.ErrUniqueViolation) || count != 0 {
+		t.Fatalf("copy: got %d rows, %v; want 0 rows, %v", count, err, storage.ErrUniqueViolation)
+	}
+}
+
+// usersIterator - итератор по строкам пользователей, Err которого возвращает err
+func usersIterator(err error) storage.Iterator {
+	return &failingIterator{
+		Iterator: storage.NewTableIterator(storage.Table{
+			Headers: []string{"name", "id"},
+			Rows:    [][]any{{"alpha", int64(1)}, {"beta", int64(2)}},
+		}),
+		err: err,
+	}
+}
+
+func (iter *failingIterator) Err() error {
+	return iter.err
+}
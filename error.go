@@ -1,9 +1,221 @@
 package storage
 
 import (
+	"hash/fnv"
+	"strconv"
+	"strings"
+
 	"gopkg.in/gomisc/errors.v1"
 )
 
+// Операции драйверов, указываемые в контексте ошибок
+const (
+	OpConnect   Operation = "connect"
+	OpBegin     Operation = "begin"
+	OpCommit    Operation = "commit"
+	OpRollback  Operation = "rollback"
+	OpSavepoint Operation = "savepoint"
+	OpPrepare   Operation = "prepare"
+	OpExec      Operation = "exec"
+	OpQuery     Operation = "query"
+	OpQueryRow  Operation = "query-row"
+	OpScan      Operation = "scan"
+	OpIterate   Operation = "iterate"
+	OpDecode    Operation = "decode"
+	OpClose     Operation = "close"
+	OpBatch     Operation = "batch"
+	OpCopy      Operation = "copy"
+)
+
 const (
-	ErrEmptyResult = errors.Const("empty query result")
+	ErrEmptyResult  = errors.Const("empty query result")
+	ErrNotSupported = errors.Const("operation is not supported by driver")
 )
+
+// Классы ошибок драйверов, errors.Is(err, ErrX) работает одинаково для всех драйверов
+const (
+	ErrUniqueViolation      = errors.Const("unique constraint violation")
+	ErrForeignKeyViolation  = errors.Const("foreign key constraint violation")
+	ErrNotNullViolation     = errors.Const("not null constraint violation")
+	ErrCheckViolation       = errors.Const("check constraint violation")
+	ErrSerializationFailure = errors.Const("serialization failure")
+	ErrDeadlock             = errors.Const("deadlock detected")
+	ErrLockTimeout          = errors.Const("lock wait timeout")
+	ErrQueryCanceled        = errors.Const("query canceled")
+	ErrConnectionLost       = errors.Const("connection lost")
+	ErrReadOnlyViolation    = errors.Const("read-only transaction violation")
+)
+
+// Operation - имя операции драйвера для контекста ошибок
+type Operation string
+
+// DriverError - ошибка драйвера базы данных, отнесенная к одному из классов ошибок пакета,
+// errors.Is(err, Class) истинно для любой обертки над ней
+type DriverError struct {
+	// Class - класс ошибки, одна из констант Err* пакета
+	Class error
+	// Code - код ошибки драйвера (SQLSTATE для PostgreSQL, номер ошибки для MySQL)
+	Code string
+	// Constraint - имя нарушенного ограничения, если сервер его сообщает
+	Constraint string
+	// Table - имя таблицы, если сервер его сообщает
+	Table string
+	// Column - имя колонки, если сервер его сообщает
+	Column string
+	// Err - исходная ошибка драйвера
+	Err error
+}
+
+// Classify - относит ошибку драйвера к классу, при пустом классе возвращает ошибку как есть
+func Classify(err error, class error, code string) error {
+	if err == nil || class == nil {
+		return err
+	}
+
+	return &DriverError{Class: class, Code: code, Err: err}
+}
+
+// AsDriverError - ищет классифицированную ошибку драйвера в цепочке оберток
+func AsDriverError(err error) (*DriverError, bool) {
+	var drvErr *DriverError
+
+	if errors.As(err, &drvErr) {
+		return drvErr, true
+	}
+
+	return nil, false
+}
+
+// ConstraintName - возвращает имя нарушенного ограничения из ошибки драйвера
+func ConstraintName(err error) string {
+	if drvErr, ok := AsDriverError(err); ok {
+		return drvErr.Constraint
+	}
+
+	return ""
+}
+
+// TableName - возвращает имя таблицы из ошибки драйвера
+func TableName(err error) string {
+	if drvErr, ok := AsDriverError(err); ok {
+		return drvErr.Table
+	}
+
+	return ""
+}
+
+// ColumnName - возвращает имя колонки из ошибки драйвера
+func ColumnName(err error) string {
+	if drvErr, ok := AsDriverError(err); ok {
+		return drvErr.Column
+	}
+
+	return ""
+}
+
+// IsRetryable - проверяет, что операция завершилась ошибкой, после которой
+// транзакцию можно безопасно повторить
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrSerializationFailure) ||
+		errors.Is(err, ErrDeadlock) ||
+		errors.Is(err, ErrLockTimeout)
+}
+
+func (e *DriverError) Error() string {
+	return e.Err.Error()
+}
+
+// Is - сопоставляет ошибку с ее классом
+func (e *DriverError) Is(target error) bool {
+	return e.Class != nil && target == e.Class
+}
+
+func (e *DriverError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorContext - базовый контекст ошибки операции драйвера: имя операции, имя и отпечаток запроса.
+// Драйверы дополняют его кодом ошибки сервера и позицией в тексте запроса
+func ErrorContext(op Operation, query Query) errors.ContextError {
+	errCtx := errors.Ctx().Str("operation", string(op))
+
+	if query == nil {
+		return errCtx
+	}
+
+	if name := QueryName(query); name != "" {
+		errCtx = errCtx.Str("query-name", name)
+	}
+
+	if sql, ok := query.Query().(string); ok {
+		errCtx = errCtx.Str("query-fingerprint", Fingerprint(sql))
+	}
+
+	return errCtx
+}
+
+// Fingerprint - возвращает отпечаток запроса: хеш его текста, в котором литералы заменены
+// на плейсхолдеры, а пробельные символы и регистр нормализованы. Запросы, отличающиеся
+// только значениями литералов, имеют одинаковый отпечаток
+func Fingerprint(sql string) string {
+	var (
+		norm  strings.Builder
+		space bool
+	)
+
+	for i := 0; i < len(sql); i++ {
+		ch := sql[i]
+
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			space = true
+
+			continue
+		case ch == '\'':
+			i = skipStringLiteral(sql, i)
+			ch = '?'
+		case isDigit(ch) && (i == 0 || !isIdentPart(sql[i-1])):
+			for i+1 < len(sql) && (isDigit(sql[i+1]) || sql[i+1] == '.') {
+				i++
+			}
+
+			ch = '?'
+		case ch >= 'A' && ch <= 'Z':
+			ch += 'a' - 'A'
+		}
+
+		if space && norm.Len() > 0 {
+			norm.WriteByte(' ')
+		}
+
+		space = false
+		norm.WriteByte(ch)
+	}
+
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(norm.String()))
+
+	return strconv.FormatUint(hash.Sum64(), 16)
+}
+
+func skipStringLiteral(sql string, start int) int {
+	for i := start + 1; i < len(sql); i++ {
+		if sql[i] != '\'' {
+			continue
+		}
+
+		if i+1 < len(sql) && sql[i+1] == '\'' {
+			i++
+
+			continue
+		}
+
+		return i
+	}
+
+	return len(sql) - 1
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
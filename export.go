@@ -0,0 +1,300 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/gomisc/errors.v1"
+)
+
+// Форматы выгрузки
+const (
+	// CopyCSV - CSV, формат по умолчанию
+	CopyCSV CopyKind = iota
+	// CopyTSV - поля через табуляцию, специальные символы экранируются обратной косой чертой
+	// (текстовый формат COPY PostgreSQL)
+	CopyTSV
+	// CopyBinary - бинарный формат COPY PostgreSQL
+	CopyBinary
+)
+
+// tsvNull - представление NULL в TSV по умолчанию
+const tsvNull = `\N`
+
+const (
+	ErrCopyFormat = errors.Const("invalid copy format")
+)
+
+type (
+	// CopyKind - формат выгрузки
+	CopyKind int
+
+	// CopyFormat - формат и параметры выгрузки результата запроса
+	CopyFormat struct {
+		// Kind - формат выгрузки
+		Kind CopyKind
+		// Header - первой строкой выгрузить имена колонок (CSV и TSV)
+		Header bool
+		// Null - представление NULL, по умолчанию пустое поле в CSV и \N в TSV. Значение не NULL,
+		// совпадающее с ним, в CSV записывается в кавычках
+		Null string
+		// Delimiter - разделитель полей, по умолчанию запятая в CSV и табуляция в TSV
+		Delimiter rune
+	}
+
+	// BulkExporter - хранилище с потоковой выгрузкой результата запроса
+	BulkExporter interface {
+		// CopyTo - выгружает результат запроса в w, не накапливая его в памяти,
+		// возвращает количество выгруженных строк
+		CopyTo(ctx context.Context, query Query, w io.Writer, format CopyFormat) (int64, error)
+	}
+
+	// CopyRows - строки результата для потоковой выгрузки, совместимы с *sql.Rows
+	CopyRows interface {
+		// Columns - имена колонок результата
+		Columns() ([]string, error)
+		// Next - переходит к следующей строке
+		Next() bool
+		// Scan - сканирует колонки текущей строки
+		Scan(dest ...any) error
+		// Err - ошибка чтения строк
+		Err() error
+	}
+)
+
+// CopyTo - выгружает результат запроса средствами хранилища, если оно реализует BulkExporter,
+// иначе результат читается в Table и записывается WriteRows. Бинарный формат поддерживают
+// только хранилища с собственной выгрузкой
+func CopyTo(ctx context.Context, s Storage, query Query, w io.Writer, format CopyFormat) (int64, error) {
+	if exporter, ok := s.(BulkExporter); ok {
+		return exporter.CopyTo(ctx, query, w, format)
+	}
+
+	if err := format.Validate(); err != nil {
+		return 0, err
+	}
+
+	if format.Kind == CopyBinary {
+		return 0, errors.Ctx().Stringer("format", format.Kind).Just(ErrNotSupported)
+	}
+
+	var table Table
+
+	if err := s.Query(ctx, query, &table); err != nil {
+		return 0, err
+	}
+
+	return WriteRows(w, &tableRows{table: &table, row: -1}, format)
+}
+
+// Validate - проверяет параметры формата выгрузки
+func (f CopyFormat) Validate() error {
+	switch f.Kind {
+	case CopyCSV, CopyTSV:
+	case CopyBinary:
+		if f.Header || f.Null != "" || f.Delimiter != 0 {
+			return errors.Ctx().Str("reason", "binary format has no text options").Just(ErrCopyFormat)
+		}
+	default:
+		return errors.Ctx().Stringer("format", f.Kind).Just(ErrCopyFormat)
+	}
+
+	if f.Delimiter == '\n' || f.Delimiter == '\r' || f.Delimiter == '"' || f.Delimiter == '\\' {
+		return errors.Ctx().Str("delimiter", string(f.Delimiter)).Just(ErrCopyFormat)
+	}
+
+	return nil
+}
+
+// FieldDelimiter - разделитель полей с учетом значения по умолчанию
+func (f CopyFormat) FieldDelimiter() rune {
+	switch {
+	case f.Delimiter != 0:
+		return f.Delimiter
+	case f.Kind == CopyTSV:
+		return '\t'
+	default:
+		return ','
+	}
+}
+
+// NullString - представление NULL с учетом значения по умолчанию
+func (f CopyFormat) NullString() string {
+	if f.Null == "" && f.Kind == CopyTSV {
+		return tsvNull
+	}
+
+	return f.Null
+}
+
+func (k CopyKind) String() string {
+	switch k {
+	case CopyCSV:
+		return "csv"
+	case CopyTSV:
+		return "tsv"
+	case CopyBinary:
+		return "binary"
+	default:
+		return fmt.Sprintf("copy format %d", int(k))
+	}
+}
+
+// WriteRows - построчно записывает строки в w в формате CSV или TSV, возвращает количество
+// записанных строк. Значения []byte записываются строкой, время - в формате RFC 3339
+func WriteRows(w io.Writer, rows CopyRows, format CopyFormat) (int64, error) {
+	if err := format.Validate(); err != nil {
+		return 0, err
+	}
+
+	if format.Kind == CopyBinary {
+		return 0, errors.Ctx().Stringer("format", format.Kind).Just(ErrNotSupported)
+	}
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, errors.Wrap(err, "get result columns")
+	}
+
+	out := newFieldWriter(w, format)
+
+	if format.Header {
+		if err = out.write(columns, nil); err != nil {
+			return 0, err
+		}
+	}
+
+	var (
+		count  int64
+		values = make([]any, len(columns))
+		dest   = make([]any, len(columns))
+		fields = make([]string, len(columns))
+		nulls  = make([]bool, len(columns))
+	)
+
+	for i := range dest {
+		dest[i] = &values[i]
+	}
+
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return count, errors.Ctx().Int64("row", count).Wrap(err, "scan result row")
+		}
+
+		for i, value := range values {
+			fields[i], nulls[i] = formatValue(value), value == nil
+		}
+
+		if err = out.write(fields, nulls); err != nil {
+			return count, err
+		}
+
+		count++
+	}
+
+	if err = rows.Err(); err != nil {
+		return count, errors.Wrap(err, "read result rows")
+	}
+
+	return count, out.flush()
+}
+
+// fieldWriter - запись строк полей CSV с кавычками или TSV с экранированием
+type fieldWriter struct {
+	out       *bufio.Writer
+	escaper   *strings.Replacer
+	delimiter string
+	null      string
+	// special - символы, из-за которых поле CSV заключается в кавычки
+	special string
+}
+
+func newFieldWriter(w io.Writer, format CopyFormat) *fieldWriter {
+	fw := &fieldWriter{
+		out:       bufio.NewWriter(w),
+		delimiter: string(format.FieldDelimiter()),
+		null:      format.NullString(),
+	}
+
+	if format.Kind == CopyTSV {
+		pairs := []string{`\`, `\\`, "\n", `\n`, "\r", `\r`, "\t", `\t`}
+
+		if fw.delimiter != "\t" {
+			pairs = append(pairs, fw.delimiter, `\`+fw.delimiter)
+		}
+
+		fw.escaper = strings.NewReplacer(pairs...)
+
+		return fw
+	}
+
+	fw.special = fw.delimiter + "\"\r\n"
+
+	return fw
+}
+
+// write - записывает строку полей, поля, отмеченные в nulls, записываются представлением NULL
+func (fw *fieldWriter) write(fields []string, nulls []bool) error {
+	for i := range fields {
+		if i > 0 {
+			_, _ = fw.out.WriteString(fw.delimiter)
+		}
+
+		_, _ = fw.out.WriteString(fw.field(fields[i], nulls != nil && nulls[i]))
+	}
+
+	if err := fw.out.WriteByte('\n'); err != nil {
+		return errors.Wrap(err, "write record")
+	}
+
+	return nil
+}
+
+// field - представление поля. Как в COPY ... CSV PostgreSQL, поле CSV заключается в кавычки,
+// если содержит разделитель, кавычку или перевод строки либо совпадает с представлением NULL:
+// при NULL по умолчанию пустая строка записывается как ""
+func (fw *fieldWriter) field(value string, null bool) string {
+	switch {
+	case null:
+		return fw.null
+	case fw.escaper != nil:
+		return fw.escaper.Replace(value)
+	case value == fw.null || value == `\.` || strings.ContainsAny(value, fw.special):
+		return `"` + strings.ReplaceAll(value, `"`, `""`) + `"`
+	default:
+		return value
+	}
+}
+
+func (fw *fieldWriter) flush() error {
+	if err := fw.out.Flush(); err != nil {
+		return errors.Wrap(err, "flush rows")
+	}
+
+	return nil
+}
+
+// formatValue - текстовое представление значения колонки
+func formatValue(value any) string {
+	switch val := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case []byte:
+		return string(val)
+	case time.Time:
+		return val.Format(time.RFC3339Nano)
+	case bool:
+		return strconv.FormatBool(val)
+	case fmt.Stringer:
+		return val.String()
+	default:
+		return fmt.Sprint(val)
+	}
+}
@@ -0,0 +1,100 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+	"gopkg.in/gomisc/storage.v1/storagetest/fake"
+)
+
+var exportTable = storage.Table{
+	Headers: []string{"id", "name", "note"},
+	Rows: [][]any{
+		{int64(1), "alpha", nil},
+		{int64(2), "", "a,b"},
+		{int64(3), `say "hi"`, "line\nbreak\ttab\\"},
+		{int64(4), []byte("NULL"), time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)},
+		{int64(5), `\.`, true},
+	},
+}
+
+func TestCopyToFallback(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		format storage.CopyFormat
+		want   string
+		err    error
+	}{
+		{
+			name: "csv",
+			want: "1,alpha,\n" +
+				"2,\"\",\"a,b\"\n" +
+				"3,\"say \"\"hi\"\"\",\"line\nbreak\ttab\\\"\n" +
+				"4,NULL,2024-03-01T12:30:00Z\n" +
+				"5,\"\\.\",true\n",
+		},
+		{
+			name:   "csv with options",
+			format: storage.CopyFormat{Header: true, Null: "NULL", Delimiter: ';'},
+			want: "id;name;note\n" +
+				"1;alpha;NULL\n" +
+				"2;;a,b\n" +
+				"3;\"say \"\"hi\"\"\";\"line\nbreak\ttab\\\"\n" +
+				"4;\"NULL\";2024-03-01T12:30:00Z\n" +
+				"5;\"\\.\";true\n",
+		},
+		{
+			name:   "tsv",
+			format: storage.CopyFormat{Kind: storage.CopyTSV},
+			want: "1\talpha\t\\N\n" +
+				"2\t\ta,b\n" +
+				"3\tsay \"hi\"\tline\\nbreak\\ttab\\\\\n" +
+				"4\tNULL\t2024-03-01T12:30:00Z\n" +
+				"5\t\\\\.\ttrue\n",
+		},
+		{
+			name:   "tsv with options",
+			format: storage.CopyFormat{Kind: storage.CopyTSV, Header: true, Null: "-", Delimiter: ','},
+			want: "id,name,note\n" +
+				"1,alpha,-\n" +
+				"2,,a\\,b\n" +
+				"3,say \"hi\",line\\nbreak\\ttab\\\\\n" +
+				"4,NULL,2024-03-01T12:30:00Z\n" +
+				"5,\\\\.,true\n",
+		},
+		{name: "binary", format: storage.CopyFormat{Kind: storage.CopyBinary}, err: storage.ErrNotSupported},
+		{name: "binary with options", format: storage.CopyFormat{Kind: storage.CopyBinary, Header: true}, err: storage.ErrCopyFormat},
+		{name: "quote delimiter", format: storage.CopyFormat{Delimiter: '"'}, err: storage.ErrCopyFormat},
+		{name: "unknown format", format: storage.CopyFormat{Kind: 10}, err: storage.ErrCopyFormat},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := fake.New(t)
+			s.ExpectQuery(`^SELECT`).WillReturnRows(exportTable).Maybe()
+
+			var buf bytes.Buffer
+
+			count, err := storage.CopyTo(context.Background(), s, storage.NewQuery("SELECT id, name, note FROM notes"), &buf, test.format)
+
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("copy to: got %v, want %v", err, test.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("copy to: %s", errors.Formatted(err))
+			}
+
+			if count != int64(len(exportTable.Rows)) || buf.String() != test.want {
+				t.Fatalf("copy to: got %d rows\n%s\nwant\n%s", count, buf.String(), test.want)
+			}
+		})
+	}
+}
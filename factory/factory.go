@@ -2,21 +2,15 @@ package factory
 
 import (
 	"context"
-	"fmt"
-	"net/url"
-	"strings"
 	"sync"
 
 	"gopkg.in/gomisc/errors.v1"
 
 	"gopkg.in/gomisc/storage.v1"
-	"gopkg.in/gomisc/storage.v1/mysql"
-	"gopkg.in/gomisc/storage.v1/pg"
-)
-
-const (
-	errUnsupportedDriver = errors.Const("unsupported database driver")
-	errDatabaseName      = errors.Const("database name not found in dsn")
+	// встроенные драйверы регистрируются в реестре storage при импорте
+	_ "gopkg.in/gomisc/storage.v1/mysql"
+	_ "gopkg.in/gomisc/storage.v1/pg"
+	_ "gopkg.in/gomisc/storage.v1/sqlite"
 )
 
 type driversFactory struct {
@@ -26,7 +20,8 @@ type driversFactory struct {
 	drivers map[string]storage.Storage
 }
 
-// New конструктор фабрики драйверов баз данных
+// New конструктор фабрики драйверов баз данных, драйвер выбирается по схеме DSN
+// среди зарегистрированных через storage.RegisterDriver
 func New(ctx context.Context) storage.Factory {
 	return &driversFactory{
 		ctx:     ctx,
@@ -61,42 +56,14 @@ func (f *driversFactory) get(dsn string) (storage.Storage, bool) {
 }
 
 func (f *driversFactory) create(dsn string) (storage.Storage, error) {
-	uri, err := url.Parse(dsn)
+	uri, err := storage.ParseDSN(dsn)
 	if err != nil {
-		return nil, errors.Wrap(err, "parse dsn")
+		return nil, err
 	}
 
-	errCtx := errors.Ctx().Str("uri", uri.Redacted())
-	parts := strings.Split(uri.Path, "/")
-	if len(parts) == 0 {
-		return nil, errCtx.Just(errDatabaseName)
-	}
-
-	var driver storage.Storage
-
-	switch uri.Scheme {
-	case pg.DefaultScheme, pg.PsqlScheme, pg.ShortScheme:
-		uri.Scheme = pg.DefaultScheme
-
-		driver, err = pg.New(f.ctx, uri.String())
-		if err != nil {
-			return nil, errCtx.Wrap(err, "create postgres connection")
-		}
-	case mysql.DefaultScheme:
-		paswd, _ := uri.User.Password()
-
-		driver, err = mysql.New(f.ctx, fmt.Sprintf("%s:%s@tcp(%s)/%s?%s",
-			uri.User.Username(),
-			paswd,
-			uri.Host,
-			uri.Path,
-			uri.RawQuery,
-		))
-		if err != nil {
-			return nil, errCtx.Wrap(err, "create mysql connection")
-		}
-	default:
-		return nil, errUnsupportedDriver
+	driver, err := storage.OpenURL(f.ctx, uri)
+	if err != nil {
+		return nil, errors.Ctx().Str("uri", uri.Redacted()).Just(err)
 	}
 
 	f.Lock()
@@ -0,0 +1,81 @@
+package factory_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+	"gopkg.in/gomisc/storage.v1/factory"
+	"gopkg.in/gomisc/storage.v1/storagetest/fake"
+)
+
+const errOpen = errors.Const("connection refused")
+
+// opens - число вызовов тестового драйвера, ошибка возвращается для DSN с параметром fail
+var opens int
+
+func init() {
+	storage.RegisterDriver("factory-test", func(ctx context.Context, uri *url.URL) (storage.Storage, error) {
+		opens++
+
+		if uri.Query().Has("fail") {
+			return nil, errOpen
+		}
+
+		return &fake.Storage{}, nil
+	}, "factory-alias")
+}
+
+func TestStorage(t *testing.T) {
+	f := factory.New(context.Background())
+
+	first, err := f.Storage("factory-test://db.local/app")
+	if err != nil {
+		t.Fatalf("storage: %s", errors.Formatted(err))
+	}
+
+	second, err := f.Storage("factory-test://db.local/app")
+	if err != nil {
+		t.Fatalf("storage: %s", errors.Formatted(err))
+	}
+
+	if first != second || opens != 1 {
+		t.Fatalf("cached storage: got same instance %t after %d opens", first == second, opens)
+	}
+
+	// псевдоним - другой DSN, для него открывается отдельный клиент
+	alias, err := f.Storage("factory-alias://db.local/app")
+	if err != nil {
+		t.Fatalf("storage by alias: %s", errors.Formatted(err))
+	}
+
+	if alias == first || opens != 2 {
+		t.Fatalf("storage by alias: got same instance %t after %d opens", alias == first, opens)
+	}
+}
+
+func TestStorageErrors(t *testing.T) {
+	f := factory.New(context.Background())
+
+	for _, test := range []struct {
+		name string
+		dsn  string
+		err  error
+	}{
+		{name: "malformed dsn", dsn: "factory-test://db.local:port/app"},
+		{name: "unsupported scheme", dsn: "factory-unknown://db.local/app", err: storage.ErrUnsupportedDriver},
+		{name: "driver error", dsn: "factory-test://db.local/app?fail=1", err: errOpen},
+		// ошибка открытия не кэшируется
+		{name: "driver error again", dsn: "factory-test://db.local/app?fail=1", err: errOpen},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := f.Storage(test.dsn)
+			if err == nil || test.err != nil && !errors.Is(err, test.err) {
+				t.Fatalf("storage: got %v, %v; want %v", s, err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"gopkg.in/gomisc/errors.v1"
+)
+
+const (
+	ErrBatchDest = errors.Const("batch destination must be a pointer to slice")
+	ErrBatchSize = errors.Const("batch size must be positive")
+)
+
+type (
+	fetchSizeKey struct{}
+
+	// BatchIterator - итератор, декодирующий несколько строк результата за вызов
+	BatchIterator interface {
+		Iterator
+		// NextBatch - декодирует до n следующих строк в слайс по указателю dest, прежнее содержимое
+		// слайса заменяется, его емкость используется повторно. Возвращает количество декодированных
+		// строк, 0 - строки закончились
+		NextBatch(ctx context.Context, dest any, n int) (int, error)
+	}
+)
+
+// WithFetchSize - возвращает контекст, в котором Iterate читает результат с сервера частями
+// по size строк (курсором на сервере, если драйвер их поддерживает), ограничивая память,
+// занятую результатом. Драйверы, читающие результат из соединения потоком, размер не учитывают
+func WithFetchSize(ctx context.Context, size int) context.Context {
+	return context.WithValue(ctx, fetchSizeKey{}, size)
+}
+
+// FetchSize - размер части результата, заданный WithFetchSize
+func FetchSize(ctx context.Context) (int, bool) {
+	size, ok := ctx.Value(fetchSizeKey{}).(int)
+
+	return size, ok && size > 0
+}
+
+// NextBatch - декодирует до n следующих строк итератора в слайс по указателю dest средствами
+// итератора, если он реализует BatchIterator, иначе вызовами Next и Decode
+func NextBatch(ctx context.Context, iter Iterator, dest any, n int) (int, error) {
+	if batcher, ok := iter.(BatchIterator); ok {
+		return batcher.NextBatch(ctx, dest, n)
+	}
+
+	count, err := DecodeBatch(dest, n, func() bool { return iter.Next(ctx) }, iter.Decode)
+	if err != nil {
+		return count, err
+	}
+
+	return count, iter.Err()
+}
+
+// DecodeBatch - декодирует до n строк в слайс по указателю dest: next переходит к следующей строке,
+// decode декодирует ее в указатель на элемент слайса. Элементы-указатели создаются заново
+func DecodeBatch(dest any, n int, next func() bool, decode func(result any) error) (int, error) {
+	if n < 1 {
+		return 0, errors.Ctx().Int("size", n).Just(ErrBatchSize)
+	}
+
+	ptr := reflect.ValueOf(dest)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() || ptr.Elem().Kind() != reflect.Slice {
+		return 0, errors.Ctx().Str("type", fmt.Sprintf("%T", dest)).Just(ErrBatchDest)
+	}
+
+	slice := ptr.Elem()
+	elemType := slice.Type().Elem()
+
+	if slice.Cap() < n {
+		slice.Set(reflect.MakeSlice(slice.Type(), n, n))
+	} else {
+		slice.SetLen(n)
+	}
+
+	var count int
+
+	for count < n && next() {
+		elem := slice.Index(count)
+
+		if elemType.Kind() == reflect.Ptr {
+			elem.Set(reflect.New(elemType.Elem()))
+		} else {
+			elem.Set(reflect.Zero(elemType))
+			elem = elem.Addr()
+		}
+
+		if err := decode(elem.Interface()); err != nil {
+			slice.SetLen(count)
+
+			return count, errors.Ctx().Int("batch-row", count).Wrap(err, "decode batch row")
+		}
+
+		count++
+	}
+
+	slice.SetLen(count)
+
+	return count, nil
+}
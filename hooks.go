@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"sync"
+
+	"gopkg.in/gomisc/errors.v1"
+)
+
+const (
+	ErrTxRolledBack = errors.Const("transaction rolled back")
+)
+
+// TxHooks - список функций жизненного цикла транзакции, встраивается в реализации Transaction
+type TxHooks struct {
+	mu       sync.Mutex
+	commit   []func(ctx context.Context)
+	rollback []func(ctx context.Context, err error)
+}
+
+// OnCommit - имплементация Transaction
+func (h *TxHooks) OnCommit(fn func(ctx context.Context)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.commit = append(h.commit, fn)
+}
+
+// OnRollback - имплементация Transaction
+func (h *TxHooks) OnRollback(fn func(ctx context.Context, err error)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.rollback = append(h.rollback, fn)
+}
+
+// RunCommitHooks - вызывает функции фиксации в порядке регистрации, функции отката отбрасываются
+func (h *TxHooks) RunCommitHooks(ctx context.Context) {
+	commit, _ := h.take()
+
+	for _, fn := range commit {
+		fn(ctx)
+	}
+}
+
+// RunRollbackHooks - вызывает функции отката в порядке регистрации, функции фиксации отбрасываются
+func (h *TxHooks) RunRollbackHooks(ctx context.Context, err error) {
+	_, rollback := h.take()
+
+	if err == nil {
+		err = ErrTxRolledBack
+	}
+
+	for _, fn := range rollback {
+		fn(ctx, err)
+	}
+}
+
+// MoveTo - передает зарегистрированные функции внешней транзакции,
+// используется при фиксации вложенной транзакции на точке сохранения
+func (h *TxHooks) MoveTo(parent *TxHooks) {
+	commit, rollback := h.take()
+
+	parent.mu.Lock()
+	defer parent.mu.Unlock()
+
+	parent.commit = append(parent.commit, commit...)
+	parent.rollback = append(parent.rollback, rollback...)
+}
+
+func (h *TxHooks) take() ([]func(ctx context.Context), []func(ctx context.Context, err error)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	commit, rollback := h.commit, h.rollback
+	h.commit, h.rollback = nil, nil
+
+	return commit, rollback
+}
+
+// OnCommit - регистрирует функцию фиксации в транзакции из контекста,
+// возвращает false если контекст не несет транзакцию
+func OnCommit(ctx context.Context, fn func(ctx context.Context)) bool {
+	tx, ok := TransactionFromContext(ctx)
+	if ok {
+		tx.OnCommit(fn)
+	}
+
+	return ok
+}
+
+// OnRollback - регистрирует функцию отката в транзакции из контекста,
+// возвращает false если контекст не несет транзакцию
+func OnRollback(ctx context.Context, fn func(ctx context.Context, err error)) bool {
+	tx, ok := TransactionFromContext(ctx)
+	if ok {
+		tx.OnRollback(fn)
+	}
+
+	return ok
+}
@@ -0,0 +1,217 @@
+// Package failover - общая для драйверов логика DSN с несколькими узлами: разбор списка узлов,
+// требуемая роль узла (target_session_attrs в терминах libpq), порядок перебора узлов по их
+// состоянию и поколение соединений, которое сбрасывается после переключения ведущего узла
+package failover
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+// Param - параметр DSN с требуемой ролью узла
+const Param = "target_session_attrs"
+
+// Cooldown - время после отказа узла, в течение которого он перебирается последним
+const Cooldown = 30 * time.Second
+
+// Роли узла, которые можно потребовать в DSN
+const (
+	TargetAny           Target = "any"
+	TargetReadWrite     Target = "read-write"
+	TargetReadOnly      Target = "read-only"
+	TargetPrimary       Target = "primary"
+	TargetStandby       Target = "standby"
+	TargetPreferStandby Target = "prefer-standby"
+)
+
+const (
+	ErrUnknownTarget = errors.Const("unknown target session attrs")
+	ErrEmptyHost     = errors.Const("empty host in host list")
+	ErrNoSuitable    = errors.Const("no host with requested role")
+)
+
+type (
+	// Target - требуемая роль узла
+	Target string
+
+	// Role - роль, о которой сообщил узел
+	Role struct {
+		// Standby - узел является репликой (находится в режиме восстановления)
+		Standby bool
+		// ReadOnly - транзакции на узле по умолчанию только на чтение
+		ReadOnly bool
+	}
+
+	// Health - состояние узлов и поколение соединений одного хранилища
+	Health struct {
+		cooldown   time.Duration
+		generation atomic.Uint64
+
+		mu    sync.Mutex
+		hosts map[string]*hostState
+	}
+
+	hostState struct {
+		failures    int
+		lastFailure time.Time
+	}
+)
+
+// ParseTarget - разбирает требуемую роль узла, пустое значение означает TargetAny
+func ParseTarget(value string) (Target, error) {
+	switch target := Target(value); target {
+	case "":
+		return TargetAny, nil
+	case TargetAny, TargetReadWrite, TargetReadOnly, TargetPrimary, TargetStandby, TargetPreferStandby:
+		return target, nil
+	default:
+		return "", errors.Ctx().Str(Param, value).Just(ErrUnknownTarget)
+	}
+}
+
+// Accepts - подходит ли узел с ролью role для работы
+func (t Target) Accepts(role Role) bool {
+	switch t {
+	case TargetReadWrite:
+		return !role.Standby && !role.ReadOnly
+	case TargetReadOnly:
+		return role.Standby || role.ReadOnly
+	case TargetPrimary:
+		return !role.Standby
+	case TargetStandby:
+		return role.Standby
+	default:
+		return true
+	}
+}
+
+// Prefers - является ли узел с ролью role предпочтительным, непредпочтительный узел
+// используется только при отсутствии предпочтительных
+func (t Target) Prefers(role Role) bool {
+	if t == TargetPreferStandby {
+		return role.Standby
+	}
+
+	return t.Accepts(role)
+}
+
+// SplitHosts - разбирает список узлов вида host1:port1,host2,[::1]:port3 и дополняет
+// узлы без порта портом по умолчанию
+func SplitHosts(hosts, defaultPort string) ([]string, error) {
+	list := strings.Split(hosts, ",")
+	result := make([]string, 0, len(list))
+
+	for _, host := range list {
+		if host == "" {
+			return nil, errors.Ctx().Str("hosts", hosts).Just(ErrEmptyHost)
+		}
+
+		name, port, err := net.SplitHostPort(host)
+		if err != nil {
+			// узел без порта, в том числе IPv6 в квадратных скобках
+			name, port = strings.Trim(host, "[]"), defaultPort
+		}
+
+		result = append(result, net.JoinHostPort(name, port))
+	}
+
+	return result, nil
+}
+
+// NewHealth - состояние узлов, отказавший узел перебирается последним в течение cooldown
+func NewHealth(cooldown time.Duration) *Health {
+	return &Health{
+		cooldown: cooldown,
+		hosts:    make(map[string]*hostState),
+	}
+}
+
+// Order - порядок перебора узлов в виде индексов hosts: сначала исправные узлы в порядке DSN,
+// затем отказавшие недавно, начиная с самого давнего отказа
+func (h *Health) Order(hosts []string) []int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	order := make([]int, len(hosts))
+	suspect := make([]time.Time, len(hosts))
+
+	for i, host := range hosts {
+		order[i] = i
+
+		if state, ok := h.hosts[host]; ok && state.failures > 0 && now.Sub(state.lastFailure) < h.cooldown {
+			suspect[i] = state.lastFailure
+		}
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := suspect[order[i]], suspect[order[j]]
+
+		if a.IsZero() || b.IsZero() {
+			return a.IsZero() && !b.IsZero()
+		}
+
+		return a.Before(b)
+	})
+
+	return order
+}
+
+// Success - отмечает успешное подключение к узлу
+func (h *Health) Success(host string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.hosts, host)
+}
+
+// Failure - отмечает отказ узла или несоответствие его роли
+func (h *Health) Failure(host string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	state, ok := h.hosts[host]
+	if !ok {
+		state = &hostState{}
+		h.hosts[host] = state
+	}
+
+	state.failures++
+	state.lastFailure = time.Now()
+}
+
+// Generation - текущее поколение соединений, соединения прошлых поколений не используются
+func (h *Health) Generation() uint64 {
+	return h.generation.Load()
+}
+
+// Invalidate - начинает новое поколение соединений, существующие соединения будут закрыты
+// пулом и открыты заново с выбором узла
+func (h *Health) Invalidate() {
+	h.generation.Add(1)
+}
+
+// Observe - начинает новое поколение соединений, если ошибка говорит о потере узла
+// или о смене его роли, и сообщает об этом
+func (h *Health) Observe(err error) bool {
+	if !ShouldInvalidate(err) {
+		return false
+	}
+
+	h.Invalidate()
+
+	return true
+}
+
+// ShouldInvalidate - говорит ли ошибка о потере узла или о смене его роли
+func ShouldInvalidate(err error) bool {
+	return errors.Is(err, storage.ErrConnectionLost) || errors.Is(err, storage.ErrReadOnlyViolation)
+}
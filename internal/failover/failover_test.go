@@ -0,0 +1,146 @@
+package failover_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+	"gopkg.in/gomisc/storage.v1/internal/failover"
+)
+
+func TestSplitHosts(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		hosts string
+		want  []string
+		err   error
+	}{
+		{name: "single", hosts: "db1", want: []string{"db1:5432"}},
+		{name: "ports", hosts: "db1:5433,db2", want: []string{"db1:5433", "db2:5432"}},
+		{name: "ipv6", hosts: "[::1]:5433,[fe80::1],::2", want: []string{"[::1]:5433", "[fe80::1]:5432", "[::2]:5432"}},
+		{name: "empty entry", hosts: "db1,,db2", err: failover.ErrEmptyHost},
+		{name: "trailing comma", hosts: "db1,", err: failover.ErrEmptyHost},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			hosts, err := failover.SplitHosts(test.hosts, "5432")
+			if !errors.Is(err, test.err) || (test.err == nil && err != nil) {
+				t.Fatalf("split %q: got error %v, want %v", test.hosts, err, test.err)
+			}
+
+			if !reflect.DeepEqual(hosts, test.want) {
+				t.Fatalf("split %q: got %q, want %q", test.hosts, hosts, test.want)
+			}
+		})
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	if target, err := failover.ParseTarget(""); err != nil || target != failover.TargetAny {
+		t.Fatalf("parse empty: got %q, %v, want %q", target, err, failover.TargetAny)
+	}
+
+	if _, err := failover.ParseTarget("master"); !errors.Is(err, failover.ErrUnknownTarget) {
+		t.Fatalf("parse master: got %v, want %v", err, failover.ErrUnknownTarget)
+	}
+}
+
+func TestTarget(t *testing.T) {
+	var (
+		primary  = failover.Role{}
+		readOnly = failover.Role{ReadOnly: true}
+		standby  = failover.Role{Standby: true, ReadOnly: true}
+	)
+
+	for _, test := range []struct {
+		target  failover.Target
+		accepts []bool
+		prefers []bool
+	}{
+		{target: failover.TargetAny, accepts: []bool{true, true, true}, prefers: []bool{true, true, true}},
+		{target: failover.TargetReadWrite, accepts: []bool{true, false, false}, prefers: []bool{true, false, false}},
+		{target: failover.TargetReadOnly, accepts: []bool{false, true, true}, prefers: []bool{false, true, true}},
+		{target: failover.TargetPrimary, accepts: []bool{true, true, false}, prefers: []bool{true, true, false}},
+		{target: failover.TargetStandby, accepts: []bool{false, false, true}, prefers: []bool{false, false, true}},
+		{target: failover.TargetPreferStandby, accepts: []bool{true, true, true}, prefers: []bool{false, false, true}},
+	} {
+		t.Run(string(test.target), func(t *testing.T) {
+			for i, role := range []failover.Role{primary, readOnly, standby} {
+				if got := test.target.Accepts(role); got != test.accepts[i] {
+					t.Errorf("accepts %+v: got %t, want %t", role, got, test.accepts[i])
+				}
+
+				if got := test.target.Prefers(role); got != test.prefers[i] {
+					t.Errorf("prefers %+v: got %t, want %t", role, got, test.prefers[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHealthOrder(t *testing.T) {
+	hosts := []string{"db1:5432", "db2:5432", "db3:5432", "db4:5432"}
+	health := failover.NewHealth(time.Minute)
+
+	if order := health.Order(hosts); !reflect.DeepEqual(order, []int{0, 1, 2, 3}) {
+		t.Fatalf("order without failures: got %v", order)
+	}
+
+	health.Failure("db2:5432")
+	time.Sleep(time.Millisecond)
+	health.Failure("db1:5432")
+
+	// отказавшие узлы перебираются последними, начиная с самого давнего отказа
+	if order := health.Order(hosts); !reflect.DeepEqual(order, []int{2, 3, 1, 0}) {
+		t.Fatalf("order after failures: got %v, want [2 3 1 0]", order)
+	}
+
+	health.Success("db2:5432")
+
+	if order := health.Order(hosts); !reflect.DeepEqual(order, []int{1, 2, 3, 0}) {
+		t.Fatalf("order after success: got %v, want [1 2 3 0]", order)
+	}
+}
+
+func TestHealthCooldown(t *testing.T) {
+	hosts := []string{"db1:5432", "db2:5432"}
+	health := failover.NewHealth(10 * time.Millisecond)
+
+	health.Failure("db1:5432")
+
+	if order := health.Order(hosts); !reflect.DeepEqual(order, []int{1, 0}) {
+		t.Fatalf("order during cooldown: got %v, want [1 0]", order)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if order := health.Order(hosts); !reflect.DeepEqual(order, []int{0, 1}) {
+		t.Fatalf("order after cooldown: got %v, want [0 1]", order)
+	}
+}
+
+func TestHealthObserve(t *testing.T) {
+	health := failover.NewHealth(failover.Cooldown)
+
+	for _, test := range []struct {
+		err        error
+		invalidate bool
+	}{
+		{err: errors.Const("syntax error")},
+		{err: storage.Classify(errors.Const("unique"), storage.ErrUniqueViolation, "23505")},
+		{err: storage.Classify(errors.Const("eof"), storage.ErrConnectionLost, ""), invalidate: true},
+		{err: errors.Wrap(storage.Classify(errors.Const("25006"), storage.ErrReadOnlyViolation, "25006"), "exec"), invalidate: true},
+	} {
+		generation := health.Generation()
+
+		if got := health.Observe(test.err); got != test.invalidate {
+			t.Fatalf("observe %v: got %t, want %t", test.err, got, test.invalidate)
+		}
+
+		if changed := health.Generation() != generation; changed != test.invalidate {
+			t.Fatalf("observe %v: generation changed %t, want %t", test.err, changed, test.invalidate)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package mysql
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"gopkg.in/gomisc/errors.v1"
+	"gopkg.in/gomisc/tracing.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+const (
+	// readerPrefix - префикс имени файла LOAD DATA, который драйвер читает из обработчика Reader
+	readerPrefix = "Reader::"
+	// csvFormat - формат CSV: поля через запятую, необязательные двойные кавычки, удвоенная кавычка внутри поля
+	csvFormat = ` FIELDS TERMINATED BY ',' OPTIONALLY ENCLOSED BY '"' ESCAPED BY ''`
+	// nullField - NULL в формате LOAD DATA по умолчанию
+	nullField = `\N`
+	// datetimeFormat - формат значений DATETIME и TIMESTAMP
+	datetimeFormat = "2006-01-02 15:04:05.999999"
+)
+
+var (
+	_ storage.BulkLoader = (*databaseClient)(nil)
+
+	readerSeq atomic.Uint64
+
+	fieldEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`, "\x00", `\0`)
+)
+
+// CopyFrom - имплементация storage.BulkLoader инструкцией LOAD DATA LOCAL INFILE с данными
+// из обработчика Reader драйвера. CSV из io.Reader передается серверу как есть (пустое поле
+// загружается пустой строкой), строки источника - в формате LOAD DATA по умолчанию с NULL как \N.
+// Сервер должен разрешать загрузку локальных данных (local_infile)
+func (cli *databaseClient) CopyFrom(ctx context.Context, table string, columns []string, source any) (int64, error) {
+	span := tracing.SetTrace(ctx)
+	defer span.End()
+
+	count, err := cli.copyFrom(span.Context(), table, columns, source)
+	if err != nil {
+		err = errors.Ctx().Str("table", table).Just(wrapMySQlErr(err, storage.OpCopy, nil, "load data"))
+		span, err = span.WithError(err)
+
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (cli *databaseClient) copyFrom(ctx context.Context, table string, columns []string, source any) (int64, error) {
+	if len(columns) == 0 {
+		return 0, storage.ErrCopyColumns
+	}
+
+	var (
+		reader io.Reader
+		format string
+	)
+
+	if csv, isCSV := source.(io.Reader); isCSV {
+		// драйвер закрывает прочитанный io.Closer, источник вызывающего остается открытым
+		reader, format = struct{ io.Reader }{csv}, csvFormat
+	} else {
+		rows, err := storage.NewRowSource(ctx, columns, source)
+		if err != nil {
+			return 0, err
+		}
+
+		pr, pw := io.Pipe()
+		defer pr.Close()
+
+		go func() {
+			pw.CloseWithError(writeRows(pw, rows))
+		}()
+
+		reader = pr
+	}
+
+	name := "storage-copy-" + strconv.FormatUint(readerSeq.Add(1), 10)
+
+	mysql.RegisterReaderHandler(name, func() io.Reader {
+		return reader
+	})
+	defer mysql.DeregisterReaderHandler(name)
+
+	quoted := make([]string, len(columns))
+
+	for i, column := range columns {
+		quoted[i] = quoteIdent(column)
+	}
+
+	sql := "LOAD DATA LOCAL INFILE '" + readerPrefix + name + "' INTO TABLE " + quoteIdent(table) +
+		format + " (" + strings.Join(quoted, ", ") + ")"
+
+	res, err := cli.getExecutor(ctx).ExecContext(ctx, sql)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// writeRows - записывает строки источника в формате LOAD DATA по умолчанию: поля через табуляцию,
+// строки через перевод строки, специальные символы экранируются обратной косой чертой
+func writeRows(w io.Writer, rows storage.RowSource) error {
+	buf := bufio.NewWriter(w)
+
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return errors.Wrap(err, "read source row")
+		}
+
+		for i, value := range values {
+			if i > 0 {
+				_ = buf.WriteByte('\t')
+			}
+
+			field, err := formatField(value)
+			if err != nil {
+				return err
+			}
+
+			_, _ = buf.WriteString(field)
+		}
+
+		if err = buf.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "read source rows")
+	}
+
+	return buf.Flush()
+}
+
+// formatField - значение поля LOAD DATA, значения приводятся к типам database/sql/driver
+func formatField(value any) (string, error) {
+	value, err := driver.DefaultParameterConverter.ConvertValue(value)
+	if err != nil {
+		return "", errors.Wrap(err, "convert field value")
+	}
+
+	switch val := value.(type) {
+	case nil:
+		return nullField, nil
+	case string:
+		return fieldEscaper.Replace(val), nil
+	case []byte:
+		return fieldEscaper.Replace(string(val)), nil
+	case bool:
+		if val {
+			return "1", nil
+		}
+
+		return "0", nil
+	case int64:
+		return strconv.FormatInt(val, 10), nil
+	case float64:
+		return strconv.FormatFloat(val, 'g', -1, 64), nil
+	case time.Time:
+		return val.Format(datetimeFormat), nil
+	default:
+		return "", errors.Ctx().Any("value", val).Just(errWrongParameters)
+	}
+}
+
+// quoteIdent - идентификатор в обратных кавычках, имя со схемой квотируется по частям
+func quoteIdent(name string) string {
+	parts := strings.Split(name, ".")
+
+	for i, part := range parts {
+		parts[i] = "`" + strings.ReplaceAll(part, "`", "``") + "`"
+	}
+
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,174 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+// Номера ошибок сервера MySQL
+const (
+	codeServerShutdown       = 1053
+	codeParseError           = 1064
+	codeLockWaitTimeout      = 1205
+	codeOptionPreventsStmt   = 1290
+	codeDeadlock             = 1213
+	codeBadNull              = 1048
+	codeDupEntry             = 1062
+	codeRowIsReferenced      = 1216
+	codeNoReferencedRow      = 1217
+	codeQueryInterrupted     = 1317
+	codeNoDefaultForField    = 1364
+	codeRowIsReferenced2     = 1451
+	codeNoReferencedRow2     = 1452
+	codeDupEntryWithKeyName  = 1586
+	codeReadOnlyTransaction  = 1792
+	codeInnodbReadOnly       = 1836
+	codeConnectionKilled     = 1927
+	codeQueryTimeout         = 3024
+	codeLockNowait           = 3572
+	codeCheckConstraintFails = 3819
+)
+
+var (
+	dupEntryRe    = regexp.MustCompile(`for key '(?:([^'.]+)\.)?([^']+)'`)
+	foreignKeyRe  = regexp.MustCompile("\\(`[^`]+`\\.`([^`]+)`, CONSTRAINT `([^`]+)` FOREIGN KEY \\(`([^`]+)`")
+	columnRe      = regexp.MustCompile(`(?:Column|Field) '([^']+)'`)
+	checkConstrRe = regexp.MustCompile(`Check constraint '([^']+)'`)
+	syntaxNearRe  = regexp.MustCompile(`(?s)near '(.*)' at line \d+$`)
+)
+
+// wrapMySQlErr - единая точка обогащения ошибок драйвера: операция, имя и отпечаток запроса,
+// номер ошибки сервера, позиция в тексте запроса и класс ошибки
+func wrapMySQlErr(err error, op storage.Operation, query storage.Query, message string) error {
+	errCtx := storage.ErrorContext(op, query).Pos(2)
+
+	var mysqlErr *mysql.MySQLError
+
+	if errors.As(err, &mysqlErr) {
+		errCtx = errCtx.
+			Str("code", strconv.Itoa(int(mysqlErr.Number))).
+			Str("message", mysqlErr.Message)
+
+		if pos := syntaxErrorPosition(mysqlErr, query); pos > 0 {
+			errCtx = errCtx.Int32("sql-position", pos)
+		}
+	}
+
+	return errCtx.Wrap(classifyErr(err), message)
+}
+
+// classifyErr - относит ошибку драйвера к классу. Ошибку нужно классифицировать до первой обертки
+// errors.Wrap: обертка находит вложенную ошибку с контекстом через DriverError.Unwrap, дополняет ее
+// на месте и возвращает без DriverError
+func classifyErr(err error) error {
+	if _, ok := storage.AsDriverError(err); ok {
+		return err
+	}
+
+	var mysqlErr *mysql.MySQLError
+
+	if errors.As(err, &mysqlErr) {
+		return classifyMySQLErr(err, mysqlErr)
+	}
+
+	return storage.Classify(err, classifyConnErr(err), "")
+}
+
+// syntaxErrorPosition - вычисляет позицию (начиная с 1) фрагмента запроса, на который указывает
+// ошибка синтаксиса MySQL, аналогично позиции, которую сообщает PostgreSQL
+func syntaxErrorPosition(mysqlErr *mysql.MySQLError, query storage.Query) int32 {
+	if mysqlErr.Number != codeParseError || query == nil {
+		return 0
+	}
+
+	sql, ok := query.Query().(string)
+	if !ok {
+		return 0
+	}
+
+	match := syntaxNearRe.FindStringSubmatch(mysqlErr.Message)
+	if match == nil || match[1] == "" {
+		return 0
+	}
+
+	return int32(strings.Index(sql, match[1]) + 1)
+}
+
+func classifyMySQLErr(err error, mysqlErr *mysql.MySQLError) error {
+	drvErr := &storage.DriverError{
+		Code: strconv.Itoa(int(mysqlErr.Number)),
+		Err:  err,
+	}
+
+	switch mysqlErr.Number {
+	case codeDupEntry, codeDupEntryWithKeyName:
+		drvErr.Class = storage.ErrUniqueViolation
+
+		if match := dupEntryRe.FindStringSubmatch(mysqlErr.Message); match != nil {
+			drvErr.Table, drvErr.Constraint = match[1], match[2]
+		}
+	case codeRowIsReferenced, codeNoReferencedRow, codeRowIsReferenced2, codeNoReferencedRow2:
+		drvErr.Class = storage.ErrForeignKeyViolation
+
+		if match := foreignKeyRe.FindStringSubmatch(mysqlErr.Message); match != nil {
+			drvErr.Table, drvErr.Constraint, drvErr.Column = match[1], match[2], match[3]
+		}
+	case codeBadNull, codeNoDefaultForField:
+		drvErr.Class = storage.ErrNotNullViolation
+
+		if match := columnRe.FindStringSubmatch(mysqlErr.Message); match != nil {
+			drvErr.Column = match[1]
+		}
+	case codeCheckConstraintFails:
+		drvErr.Class = storage.ErrCheckViolation
+
+		if match := checkConstrRe.FindStringSubmatch(mysqlErr.Message); match != nil {
+			drvErr.Constraint = match[1]
+		}
+	case codeDeadlock:
+		drvErr.Class = storage.ErrDeadlock
+	case codeLockWaitTimeout, codeLockNowait:
+		drvErr.Class = storage.ErrLockTimeout
+	case codeQueryInterrupted, codeQueryTimeout:
+		drvErr.Class = storage.ErrQueryCanceled
+	case codeReadOnlyTransaction, codeInnodbReadOnly:
+		drvErr.Class = storage.ErrReadOnlyViolation
+	case codeOptionPreventsStmt:
+		// 1290 сообщает о любой опции сервера, запрещающей инструкцию, к классу относятся --read-only и --super-read-only
+		if !strings.Contains(mysqlErr.Message, "read-only") {
+			return err
+		}
+
+		drvErr.Class = storage.ErrReadOnlyViolation
+	case codeServerShutdown, codeConnectionKilled:
+		drvErr.Class = storage.ErrConnectionLost
+	default:
+		return err
+	}
+
+	return drvErr
+}
+
+func classifyConnErr(err error) error {
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return storage.ErrQueryCanceled
+	case errors.Is(err, mysql.ErrInvalidConn), errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.As(err, &netErr):
+		return storage.ErrConnectionLost
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,389 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"net/url"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+	"gopkg.in/gomisc/storage.v1/internal/failover"
+)
+
+// defaultPort - порт узла MySQL, если он не указан в списке узлов
+const defaultPort = "3306"
+
+// roleQuery - запрос роли узла: реплики MySQL работают в режиме read_only
+const roleQuery = "SELECT @@global.read_only"
+
+var (
+	_ driver.Connector          = (*failoverConnector)(nil)
+	_ driver.ConnBeginTx        = (*failoverConn)(nil)
+	_ driver.ConnPrepareContext = (*failoverConn)(nil)
+	_ driver.ExecerContext      = (*failoverConn)(nil)
+	_ driver.QueryerContext     = (*failoverConn)(nil)
+	_ driver.Pinger             = (*failoverConn)(nil)
+	_ driver.SessionResetter    = (*failoverConn)(nil)
+	_ driver.Validator          = (*failoverConn)(nil)
+	_ driver.NamedValueChecker  = (*failoverConn)(nil)
+	_ driver.StmtExecContext    = (*failoverStmt)(nil)
+	_ driver.StmtQueryContext   = (*failoverStmt)(nil)
+	_ driver.NamedValueChecker  = (*failoverStmt)(nil)
+)
+
+type (
+	// failoverConnector - подключение к первому подходящему по роли узлу из списка,
+	// узлы перебираются в порядке DSN, недавно отказавшие - последними
+	failoverConnector struct {
+		target     failover.Target
+		health     *failover.Health
+		hosts      []string
+		connectors []driver.Connector
+	}
+
+	// failoverConn - соединение с узлом, которое пул закрывает после смены поколения соединений
+	failoverConn struct {
+		conn       driver.Conn
+		host       string
+		health     *failover.Health
+		generation uint64
+		inTx       bool
+		broken     bool
+	}
+
+	failoverTx struct {
+		tx   driver.Tx
+		conn *failoverConn
+	}
+
+	failoverStmt struct {
+		stmt driver.Stmt
+		conn *failoverConn
+	}
+)
+
+// isFailoverDSN - DSN содержит несколько узлов или требуемую роль узла
+func isFailoverDSN(uri *url.URL) bool {
+	return strings.Contains(uri.Host, ",") || uri.Query().Has(failover.Param)
+}
+
+// newFailover - хранилище с выбором узла для DSN вида mysql://user:pass@h1,h2:3307/db?target_session_attrs=read-write
+func newFailover(uri *url.URL) (storage.Storage, error) {
+	query := uri.Query()
+
+	target, err := failover.ParseTarget(query.Get(failover.Param))
+	if err != nil {
+		return nil, err
+	}
+
+	query.Del(failover.Param)
+
+	hosts, err := failover.SplitHosts(uri.Host, defaultPort)
+	if err != nil {
+		return nil, err
+	}
+
+	connector := &failoverConnector{
+		target: target,
+		health: failover.NewHealth(failover.Cooldown),
+		hosts:  hosts,
+	}
+
+	for _, host := range hosts {
+		hostURI := *uri
+		hostURI.Host = host
+		hostURI.RawQuery = query.Encode()
+
+		cfg, err := Config(&hostURI)
+		if err != nil {
+			return nil, errors.Ctx().Str("host", host).Just(err)
+		}
+
+		hostConnector, err := mysql.NewConnector(cfg)
+		if err != nil {
+			return nil, errors.Ctx().Str("host", host).Str("reason", err.Error()).Just(ErrMalformedDSN)
+		}
+
+		connector.connectors = append(connector.connectors, hostConnector)
+	}
+
+	return &databaseClient{
+		pool: sqlx.NewDb(sql.OpenDB(connector), DefaultScheme),
+	}, nil
+}
+
+// Connect - подключается к узлам по порядку и возвращает первое соединение с узлом требуемой роли.
+// Для prefer-standby при отсутствии реплик используется первый доступный ведущий узел
+func (c *failoverConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	var (
+		fallback *failoverConn
+		lastHost string
+		lastErr  error
+	)
+
+	for _, i := range c.health.Order(c.hosts) {
+		conn, role, err := c.connect(ctx, i)
+		if err != nil {
+			c.health.Failure(c.hosts[i])
+			lastHost, lastErr = c.hosts[i], err
+
+			if ctx.Err() != nil {
+				break
+			}
+
+			continue
+		}
+
+		switch {
+		case !c.target.Accepts(role):
+			c.health.Failure(c.hosts[i])
+			lastHost, lastErr = c.hosts[i], failover.ErrNoSuitable
+
+			_ = conn.Close()
+		case !c.target.Prefers(role):
+			if fallback == nil {
+				fallback = conn
+			} else {
+				_ = conn.Close()
+			}
+		default:
+			if fallback != nil {
+				_ = fallback.Close()
+			}
+
+			c.health.Success(c.hosts[i])
+
+			return conn, nil
+		}
+	}
+
+	if fallback != nil {
+		c.health.Success(fallback.host)
+
+		return fallback, nil
+	}
+
+	if lastErr == nil {
+		lastErr = failover.ErrNoSuitable
+	}
+
+	// ошибка классифицируется до обертки, иначе wrapMySQlErr не сможет отнести ее к классу
+	return nil, errors.Ctx().
+		Strings("hosts", c.hosts).
+		Str("host", lastHost).
+		Str("target", string(c.target)).
+		Wrap(storage.Classify(lastErr, classifyConnErr(lastErr), ""), "connect to mysql host")
+}
+
+// Driver реализация driver.Connector
+func (c *failoverConnector) Driver() driver.Driver {
+	return &mysql.MySQLDriver{}
+}
+
+// connect - подключается к узлу с индексом i и определяет его роль
+func (c *failoverConnector) connect(ctx context.Context, i int) (*failoverConn, failover.Role, error) {
+	conn, err := c.connectors[i].Connect(ctx)
+	if err != nil {
+		return nil, failover.Role{}, err
+	}
+
+	wrapped := &failoverConn{
+		conn:       conn,
+		host:       c.hosts[i],
+		health:     c.health,
+		generation: c.health.Generation(),
+	}
+
+	role, err := wrapped.role(ctx)
+	if err != nil {
+		_ = conn.Close()
+
+		return nil, failover.Role{}, err
+	}
+
+	return wrapped, role, nil
+}
+
+func (c *failoverConn) role(ctx context.Context) (failover.Role, error) {
+	rows, err := c.QueryContext(ctx, roleQuery, nil)
+	if err != nil {
+		return failover.Role{}, err
+	}
+
+	defer rows.Close()
+
+	dest := make([]driver.Value, 1)
+
+	if err = rows.Next(dest); err != nil {
+		return failover.Role{}, err
+	}
+
+	var readOnly bool
+
+	switch value := dest[0].(type) {
+	case []byte:
+		readOnly = string(value) == "1"
+	case int64:
+		readOnly = value == 1
+	}
+
+	return failover.Role{Standby: readOnly, ReadOnly: readOnly}, nil
+}
+
+// observe - после потери соединения или отказа в записи на узле, ставшем репликой, начинает новое
+// поколение соединений. Отказ в записи вне транзакции заменяется на driver.ErrBadConn: инструкция
+// не выполнена, и database/sql повторит ее на новом соединении с подходящим узлом
+func (c *failoverConn) observe(err error) error {
+	if err == nil || errors.Is(err, driver.ErrSkip) || errors.Is(err, driver.ErrBadConn) {
+		return err
+	}
+
+	var mysqlErr *mysql.MySQLError
+
+	switch {
+	case errors.As(err, &mysqlErr) && errors.Is(classifyMySQLErr(err, mysqlErr), storage.ErrReadOnlyViolation):
+		c.broken = true
+		c.health.Failure(c.host)
+		c.health.Invalidate()
+
+		if !c.inTx {
+			return driver.ErrBadConn
+		}
+	case errors.Is(classifyConnErr(err), storage.ErrConnectionLost):
+		c.broken = true
+		c.health.Invalidate()
+	}
+
+	return err
+}
+
+func (c *failoverConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *failoverConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := c.conn.(driver.ConnPrepareContext).PrepareContext(ctx, query)
+	if err != nil {
+		return nil, c.observe(err)
+	}
+
+	return &failoverStmt{stmt: stmt, conn: c}, nil
+}
+
+func (c *failoverConn) Close() error {
+	return c.conn.Close()
+}
+
+// Begin реализация driver.Conn, database/sql использует BeginTx
+func (c *failoverConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *failoverConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	tx, err := c.conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+	if err != nil {
+		return nil, c.observe(err)
+	}
+
+	c.inTx = true
+
+	return &failoverTx{tx: tx, conn: c}, nil
+}
+
+func (c *failoverConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res, err := c.conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+
+	return res, c.observe(err)
+}
+
+func (c *failoverConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := c.conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+
+	return rows, c.observe(err)
+}
+
+func (c *failoverConn) Ping(ctx context.Context) error {
+	return c.observe(c.conn.(driver.Pinger).Ping(ctx))
+}
+
+func (c *failoverConn) ResetSession(ctx context.Context) error {
+	if !c.IsValid() {
+		return driver.ErrBadConn
+	}
+
+	return c.conn.(driver.SessionResetter).ResetSession(ctx)
+}
+
+// IsValid - соединение исправно и относится к текущему поколению
+func (c *failoverConn) IsValid() bool {
+	if c.broken || c.generation != c.health.Generation() {
+		return false
+	}
+
+	if validator, ok := c.conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+
+	return true
+}
+
+func (c *failoverConn) CheckNamedValue(value *driver.NamedValue) error {
+	return c.conn.(driver.NamedValueChecker).CheckNamedValue(value)
+}
+
+func (tx *failoverTx) Commit() error {
+	err := tx.conn.observe(tx.tx.Commit())
+	tx.conn.inTx = false
+
+	return err
+}
+
+func (tx *failoverTx) Rollback() error {
+	err := tx.conn.observe(tx.tx.Rollback())
+	tx.conn.inTx = false
+
+	return err
+}
+
+func (stmt *failoverStmt) Close() error {
+	return stmt.stmt.Close()
+}
+
+func (stmt *failoverStmt) NumInput() int {
+	return stmt.stmt.NumInput()
+}
+
+// Exec реализация driver.Stmt, database/sql использует ExecContext
+func (stmt *failoverStmt) Exec(args []driver.Value) (driver.Result, error) {
+	res, err := stmt.stmt.Exec(args)
+
+	return res, stmt.conn.observe(err)
+}
+
+// Query реализация driver.Stmt, database/sql использует QueryContext
+func (stmt *failoverStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows, err := stmt.stmt.Query(args)
+
+	return rows, stmt.conn.observe(err)
+}
+
+func (stmt *failoverStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	res, err := stmt.stmt.(driver.StmtExecContext).ExecContext(ctx, args)
+
+	return res, stmt.conn.observe(err)
+}
+
+func (stmt *failoverStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := stmt.stmt.(driver.StmtQueryContext).QueryContext(ctx, args)
+
+	return rows, stmt.conn.observe(err)
+}
+
+func (stmt *failoverStmt) CheckNamedValue(value *driver.NamedValue) error {
+	return stmt.stmt.(driver.NamedValueChecker).CheckNamedValue(value)
+}
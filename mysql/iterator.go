@@ -5,21 +5,29 @@ import (
 
 	"github.com/georgysavva/scany/sqlscan"
 	"github.com/jmoiron/sqlx"
-	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
 )
 
+var _ storage.BatchIterator = (*sqlIterator)(nil)
+
 type sqlIterator struct {
 	rows    *sqlx.Rows
+	query   storage.Query
 	scanner *sqlscan.RowScanner
 }
 
-func newIterator(rows *sqlx.Rows) *sqlIterator {
-	return &sqlIterator{rows: rows}
+func newIterator(rows *sqlx.Rows, query storage.Query) *sqlIterator {
+	return &sqlIterator{
+		rows:    rows,
+		query:   query,
+		scanner: sqlscan.NewRowScanner(rows.Rows),
+	}
 }
 
 func (it *sqlIterator) Close() error {
 	if err := it.rows.Close(); err != nil {
-		return errors.Wrap(err, "close iterator rows")
+		return wrapMySQlErr(err, storage.OpClose, it.query, "close iterator rows")
 	}
 
 	return nil
@@ -30,13 +38,27 @@ func (it *sqlIterator) Next(_ context.Context) bool {
 }
 
 func (it *sqlIterator) Err() error {
-	return it.rows.Err()
+	if err := it.rows.Err(); err != nil {
+		return wrapMySQlErr(err, storage.OpIterate, it.query, "iterate query result")
+	}
+
+	return nil
 }
 
 func (it *sqlIterator) Decode(result any) error {
 	if err := it.scanner.Scan(result); err != nil {
-		return errors.Wrap(err, "decode item result")
+		return wrapMySQlErr(err, storage.OpDecode, it.query, "decode item result")
 	}
 
 	return nil
 }
+
+// NextBatch - имплементация storage.BatchIterator, строки декодируются сканером итератора напрямую
+func (it *sqlIterator) NextBatch(_ context.Context, dest any, n int) (int, error) {
+	count, err := storage.DecodeBatch(dest, n, it.rows.Next, it.scanner.Scan)
+	if err != nil {
+		return count, wrapMySQlErr(err, storage.OpDecode, it.query, "decode batch result")
+	}
+
+	return count, it.Err()
+}
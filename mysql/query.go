@@ -23,7 +23,15 @@ func (cli *databaseClient) prepare(query storage.Query) (*sqlQuery, error) {
 
 	params, ok := query.Params().([]any)
 	if !ok {
-		return nil, errors.Ctx().Any("params", query.Params()).Just(errWrongParameters)
+		if !storage.IsNamedArgs(query.Params()) {
+			return nil, errors.Ctx().Any("params", query.Params()).Just(errWrongParameters)
+		}
+
+		var err error
+
+		if sql, params, err = storage.BindNamed(storage.PlaceholderQuestion, sql, query.Params()); err != nil {
+			return nil, errors.Ctx().Stringer("query", query).Wrap(err, "bind named parameters")
+		}
 	}
 
 	return &sqlQuery{sql: sql, params: params}, nil
@@ -32,13 +40,13 @@ func (cli *databaseClient) prepare(query storage.Query) (*sqlQuery, error) {
 func (cli *databaseClient) exec(ctx context.Context, query storage.Query) (sql.Result, error) {
 	sq, err := cli.prepare(query)
 	if err != nil {
-		return nil, errors.Wrap(err, "prepare query")
+		return nil, wrapMySQlErr(err, storage.OpPrepare, query, "prepare query")
 	}
 
 	var res sql.Result
 
 	if res, err = cli.getExecutor(ctx).ExecContext(ctx, sq.sql, sq.params...); err != nil {
-		return nil, errors.Wrap(err, "execute query")
+		return nil, wrapMySQlErr(err, storage.OpExec, query, "execute query")
 	}
 
 	return res, nil
@@ -47,7 +55,7 @@ func (cli *databaseClient) exec(ctx context.Context, query storage.Query) (sql.R
 func (cli *databaseClient) queryRow(ctx context.Context, query storage.Query) (*sqlx.Row, error) {
 	sq, err := cli.prepare(query)
 	if err != nil {
-		return nil, errors.Wrap(err, "prepare query data")
+		return nil, wrapMySQlErr(err, storage.OpPrepare, query, "prepare query data")
 	}
 
 	return cli.getExecutor(ctx).QueryRowxContext(ctx, sq.sql, sq.params...), nil
@@ -56,14 +64,14 @@ func (cli *databaseClient) queryRow(ctx context.Context, query storage.Query) (*
 func (cli *databaseClient) query(ctx context.Context, query storage.Query) (*sqlx.Rows, error) {
 	sq, err := cli.prepare(query)
 	if err != nil {
-		return nil, errors.Wrap(err, "prepare query data")
+		return nil, wrapMySQlErr(err, storage.OpPrepare, query, "prepare query data")
 	}
 
 	var rows *sqlx.Rows
 
 	rows, err = cli.getExecutor(ctx).QueryxContext(ctx, sq.sql, sq.params...)
 	if err != nil {
-		return nil, wrapMySQlErr(err, "execute query")
+		return nil, wrapMySQlErr(err, storage.OpQuery, query, "execute query")
 	}
 
 	return rows, nil
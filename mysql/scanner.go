@@ -1,7 +1,10 @@
 package mysql
 
 import (
+	"database/sql"
 	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/georgysavva/scany/sqlscan"
 	"github.com/jmoiron/sqlx"
@@ -23,51 +26,118 @@ func (scan *customScanner) Scan(result any) error {
 
 	if val.Type().Elem().Kind() == reflect.Slice {
 		if err := sqlscan.ScanAll(result, scan.rows.Rows); err != nil {
-			return errors.Wrap(err, "scan objects to slice")
+			return errors.Wrap(classifyErr(err), "scan objects to slice")
 		}
+
+		return nil
 	}
 
 	if err := sqlscan.ScanOne(result, scan.rows.Rows); err != nil {
-		return errors.Wrap(err, "scan row to object")
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.ErrEmptyResult
+		}
+
+		return errors.Wrap(classifyErr(err), "scan row to object")
 	}
 
 	return nil
 }
 
 func (scan *customScanner) ScanResult(result *storage.Result) error {
+	defer scan.rows.Close()
+
+	types, err := scan.rows.ColumnTypes()
+	if err != nil {
+		return errors.Wrap(classifyErr(err), "get result column types")
+	}
+
 	for scan.rows.Next() {
 		row := make(map[string]any)
 
-		if err := scan.rows.MapScan(row); err != nil {
-			return errors.Wrap(err, "scan result row")
+		if err = scan.rows.MapScan(row); err != nil {
+			return errors.Wrap(classifyErr(err), "scan result row")
+		}
+
+		for _, colType := range types {
+			row[colType.Name()] = columnValue(colType, row[colType.Name()])
 		}
 
 		*result = append(*result, row)
 	}
 
+	if err = scan.rows.Err(); err != nil {
+		return errors.Wrap(classifyErr(err), "read result rows")
+	}
+
 	return nil
 }
 
 func (scan *customScanner) ScanTable(table *storage.Table) error {
+	defer scan.rows.Close()
+
 	fields, err := scan.rows.Columns()
 	if err != nil {
-		return errors.Wrap(err, "scan result table row")
+		return errors.Wrap(classifyErr(err), "scan result table row")
 	}
 
 	for h := 0; h < len(fields); h++ {
 		table.Headers = append(table.Headers, fields[h])
 	}
 
+	types, err := scan.rows.ColumnTypes()
+	if err != nil {
+		return errors.Wrap(classifyErr(err), "get table column types")
+	}
+
 	for scan.rows.Next() {
 		var values []any
 
 		values, err = scan.rows.SliceScan()
 		if err != nil {
-			return errors.Wrap(err, "get row values")
+			return errors.Wrap(classifyErr(err), "get row values")
+		}
+
+		for i := range values {
+			values[i] = columnValue(types[i], values[i])
 		}
 
 		table.Rows = append(table.Rows, values)
 	}
 
+	if err = scan.rows.Err(); err != nil {
+		return errors.Wrap(classifyErr(err), "read table rows")
+	}
+
 	return nil
 }
+
+// columnValue - приводит значение текстового протокола MySQL ([]byte) к типу колонки,
+// чтобы Result и Table не зависели от того, передавались ли в запрос параметры
+func columnValue(colType *sql.ColumnType, value any) any {
+	raw, ok := value.([]byte)
+	if !ok {
+		return value
+	}
+
+	typeName := colType.DatabaseTypeName()
+	unsigned := strings.HasPrefix(typeName, "UNSIGNED ")
+
+	switch strings.TrimPrefix(typeName, "UNSIGNED ") {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "YEAR":
+		if unsigned {
+			if val, err := strconv.ParseUint(string(raw), 10, 64); err == nil {
+				return val
+			}
+		} else if val, err := strconv.ParseInt(string(raw), 10, 64); err == nil {
+			return val
+		}
+	case "FLOAT", "DOUBLE":
+		if val, err := strconv.ParseFloat(string(raw), 64); err == nil {
+			return val
+		}
+	case "BLOB", "BINARY", "VARBINARY", "GEOMETRY", "BIT":
+		return raw
+	}
+
+	return string(raw)
+}
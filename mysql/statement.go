@@ -0,0 +1,172 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/gomisc/errors.v1"
+	"gopkg.in/gomisc/tracing.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+var (
+	_ storage.Preparer  = (*databaseClient)(nil)
+	_ storage.Statement = (*mysqlStatement)(nil)
+)
+
+// mysqlStatement - подготовленный запрос пула database/sql: пул готовит его на соединениях
+// по мере выполнения, в транзакции контекста запрос выполняется на ее соединении
+type mysqlStatement struct {
+	stmt   *sqlx.Stmt
+	query  storage.Query
+	named  *storage.NamedSQL
+	closed atomic.Bool
+}
+
+// Prepare - имплементация storage.Preparer, запрос готовится на сервере сразу, чтобы ошибки
+// разбора возвращались из Prepare
+func (cli *databaseClient) Prepare(ctx context.Context, query storage.Query) (storage.Statement, error) {
+	span := tracing.SetTrace(ctx)
+	defer span.End()
+
+	stmt, err := cli.prepareStatement(span.Context(), query)
+	if err != nil {
+		span, err = span.WithError(wrapMySQlErr(err, storage.OpPrepare, query, "prepare statement"))
+
+		return nil, err
+	}
+
+	return stmt, nil
+}
+
+func (cli *databaseClient) prepareStatement(ctx context.Context, query storage.Query) (*mysqlStatement, error) {
+	sql, isString := query.Query().(string)
+	if !isString {
+		return nil, errors.Ctx().Stringer("query", query).Just(errWrongQueryType)
+	}
+
+	stmt := &mysqlStatement{query: query}
+
+	if _, positional := query.Params().([]any); !positional {
+		named, err := storage.ParseNamed(storage.PlaceholderQuestion, sql)
+		if err != nil {
+			return nil, errors.Ctx().Stringer("query", query).Wrap(err, "parse named parameters")
+		}
+
+		stmt.named, sql = named, named.SQL
+	}
+
+	var err error
+
+	if stmt.stmt, err = cli.pool.PreparexContext(ctx, sql); err != nil {
+		return nil, err
+	}
+
+	return stmt, nil
+}
+
+// Close - имплементация io.Closer
+func (st *mysqlStatement) Close() error {
+	if st.closed.Swap(true) {
+		return nil
+	}
+
+	if err := st.stmt.Close(); err != nil {
+		return wrapMySQlErr(err, storage.OpClose, st.query, "close statement")
+	}
+
+	return nil
+}
+
+// Exec - имплементация storage.Statement
+func (st *mysqlStatement) Exec(ctx context.Context, args any) (res sql.Result, err error) {
+	span := tracing.SetTrace(ctx)
+	defer span.End()
+
+	err = st.withStmt(span.Context(), args, func(stmt *sqlx.Stmt, params []any) (err error) {
+		res, err = stmt.ExecContext(span.Context(), params...)
+
+		return err
+	})
+	if err != nil {
+		span, err = span.WithError(wrapMySQlErr(err, storage.OpExec, st.query, "execute statement"))
+
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// Query - имплементация storage.Statement
+func (st *mysqlStatement) Query(ctx context.Context, args any, result any) error {
+	if result == nil {
+		_, err := st.Exec(ctx, args)
+
+		return err
+	}
+
+	span := tracing.SetTrace(ctx)
+	defer span.End()
+
+	err := st.withStmt(span.Context(), args, func(stmt *sqlx.Stmt, params []any) error {
+		rows, err := stmt.QueryxContext(span.Context(), params...)
+		if err != nil {
+			return err
+		}
+
+		return decodeRows(rows, st.query, result)
+	})
+	if err != nil {
+		span, err = span.WithError(wrapMySQlErr(err, storage.OpQuery, st.query, "query statement"))
+
+		return err
+	}
+
+	return nil
+}
+
+// QueryRow - имплементация storage.Statement
+func (st *mysqlStatement) QueryRow(ctx context.Context, args any, dest ...any) error {
+	span := tracing.SetTrace(ctx)
+	defer span.End()
+
+	err := st.withStmt(span.Context(), args, func(stmt *sqlx.Stmt, params []any) error {
+		return stmt.QueryRowxContext(span.Context(), params...).Scan(dest...)
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.ErrEmptyResult
+		}
+
+		span, err = span.WithError(wrapMySQlErr(err, storage.OpQueryRow, st.query, "scan statement row"))
+
+		return err
+	}
+
+	return nil
+}
+
+// withStmt - выполняет fn с запросом пула или, в транзакции контекста, с запросом транзакции
+func (st *mysqlStatement) withStmt(ctx context.Context, args any, fn func(stmt *sqlx.Stmt, params []any) error) error {
+	if st.closed.Load() {
+		return errors.Ctx().Stringer("query", st.query).Just(storage.ErrStatementClosed)
+	}
+
+	params, err := storage.StatementArgs(st.named, args)
+	if err != nil {
+		return err
+	}
+
+	tx, ok := ctx.Value(transactionKey{}).(*mysqlTransaction)
+	if !ok {
+		return fn(st.stmt, params)
+	}
+
+	stmt := tx.tx.StmtxContext(ctx, st.stmt)
+	defer stmt.Close()
+
+	return fn(stmt, params)
+}
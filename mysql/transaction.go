@@ -3,59 +3,239 @@ package mysql
 import (
 	"context"
 	"database/sql"
+	"fmt"
 
 	"github.com/jmoiron/sqlx"
 	"gopkg.in/gomisc/errors.v1"
 	"gopkg.in/gomisc/tracing.v1"
+
+	"gopkg.in/gomisc/storage.v1"
 )
 
+// defaultIsolation - уровень изоляции транзакций InnoDB по умолчанию
+const defaultIsolation = storage.LevelRepeatableRead
+
 type (
 	transactionKey struct{}
 
 	mysqlTransaction struct {
-		tx  *sqlx.Tx
-		ctx context.Context
+		storage.TxHooks
+
+		tx     *sqlx.Tx
+		ctx    context.Context
+		cancel context.CancelFunc
+		label  string
+		// opts - опции корневой транзакции, вложенные транзакции наследуют их; для транзакции,
+		// открытой с sql.TxOptions, восстанавливаются из них
+		opts *storage.TxOptions
+		// parent - внешняя транзакция, savepoint - имя точки сохранения вложенной транзакции
+		parent     *mysqlTransaction
+		savepoint  string
+		savepoints int
 	}
 )
 
 func (tx *mysqlTransaction) Context() context.Context {
-	return context.WithValue(tx.ctx, transactionKey{}, tx)
+	return storage.ContextWithTransaction(context.WithValue(tx.ctx, transactionKey{}, tx), tx)
 }
 
 func (tx *mysqlTransaction) Commit(ctx context.Context) error {
 	span := tracing.SetTrace(ctx)
 	defer span.End()
+	defer tx.release()
+
+	if tx.parent != nil {
+		if err := tx.execSavepoint(ctx, "RELEASE SAVEPOINT "); err != nil {
+			span.WithError(err, "release savepoint failed")
+
+			err = errors.Ctx().Str("tx-label", tx.label).Just(wrapMySQlErr(err, storage.OpSavepoint, nil, "release savepoint"))
+			tx.RunRollbackHooks(ctx, err)
+
+			return err
+		}
+
+		tx.MoveTo(&tx.parent.TxHooks)
+
+		return nil
+	}
 
 	if err := tx.tx.Commit(); err != nil {
 		span.WithError(err, "commit transaction failed")
 
-		return errors.Wrap(err, "commit transaction")
+		err = errors.Ctx().Str("tx-label", tx.label).Just(wrapMySQlErr(err, storage.OpCommit, nil, "commit transaction"))
+		tx.RunRollbackHooks(ctx, err)
+
+		return err
 	}
 
+	tx.RunCommitHooks(ctx)
+
 	return nil
 }
 
 func (tx *mysqlTransaction) Rollback(ctx context.Context) error {
 	span := tracing.SetTrace(ctx)
 	defer span.End()
+	defer tx.release()
+
+	if tx.parent != nil {
+		if err := tx.execSavepoint(ctx, "ROLLBACK TO SAVEPOINT "); err != nil {
+			span.WithError(err, "rollback to savepoint failed")
+
+			err = errors.Ctx().Str("tx-label", tx.label).Just(wrapMySQlErr(err, storage.OpSavepoint, nil, "rollback to savepoint"))
+			tx.RunRollbackHooks(ctx, err)
+
+			return err
+		}
+
+		tx.RunRollbackHooks(ctx, nil)
+
+		return nil
+	}
 
 	if err := tx.tx.Rollback(); err != nil {
 		span.WithError(err, "rollback transaction failed")
 
-		return errors.Wrap(err, "rollback transaction")
+		err = errors.Ctx().Str("tx-label", tx.label).Just(wrapMySQlErr(err, storage.OpRollback, nil, "rollback transaction"))
+		tx.RunRollbackHooks(ctx, err)
+
+		return err
 	}
 
+	tx.RunRollbackHooks(ctx, nil)
+
 	return nil
 }
 
-func getSQLTxOptions(in ...any) *sql.TxOptions {
+// beginNested - открывает вложенную транзакцию на точке сохранения внешней транзакции,
+// Commit вложенной транзакции освобождает точку сохранения, Rollback - откатывает к ней
+func (tx *mysqlTransaction) beginNested(ctx context.Context, options ...any) (*mysqlTransaction, error) {
+	opts, err := storage.NestedTxOptions(tx.opts, defaultIsolation, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	root := tx
+	for root.parent != nil {
+		root = root.parent
+	}
+
+	root.savepoints++
+
+	nested := &mysqlTransaction{
+		tx:        tx.tx,
+		ctx:       ctx,
+		label:     tx.label,
+		opts:      tx.opts,
+		parent:    tx,
+		savepoint: fmt.Sprintf("sp_%d", root.savepoints),
+	}
+
+	if opts != nil {
+		if opts.Label != "" {
+			nested.label = opts.Label
+		}
+
+		if opts.Timeout > 0 {
+			nested.ctx, nested.cancel = context.WithTimeout(ctx, opts.Timeout)
+		}
+	}
+
+	if err = nested.execSavepoint(ctx, "SAVEPOINT "); err != nil {
+		nested.release()
+
+		return nil, wrapMySQlErr(err, storage.OpSavepoint, nil, "create savepoint")
+	}
+
+	return nested, nil
+}
+
+func (tx *mysqlTransaction) execSavepoint(ctx context.Context, statement string) error {
+	_, err := tx.tx.ExecContext(ctx, statement+tx.savepoint)
+
+	return err
+}
+
+func (tx *mysqlTransaction) release() {
+	if tx.cancel != nil {
+		tx.cancel()
+	}
+}
+
+func getSQLTxOptions(in ...any) (*sql.TxOptions, *storage.TxOptions, error) {
 	if len(in) == 0 {
-		return nil
+		return nil, nil, nil
+	}
+
+	if opts, ok := storage.TxOptionsFrom(in...); ok {
+		sqlOpts, err := convertTxOptions(opts)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return sqlOpts, opts, nil
 	}
 
-	if opts, ok := in[0].(*sql.TxOptions); ok {
-		return opts
+	switch opts := in[0].(type) {
+	case nil:
+		return nil, nil, nil
+	case *sql.TxOptions:
+		if opts == nil {
+			return nil, nil, nil
+		}
+
+		return opts, fromSQLTxOptions(opts), nil
+	case sql.TxOptions:
+		return &opts, fromSQLTxOptions(&opts), nil
+	case *storage.TxOptions:
+		return nil, nil, nil
+	default:
+		return nil, nil, errors.Ctx().
+			Str("type", fmt.Sprintf("%T", opts)).
+			Just(storage.ErrUnsupportedTxOptions)
 	}
+}
 
-	return nil
+// fromSQLTxOptions - переносимые опции транзакции, открытой с sql.TxOptions: по ним проверяются
+// опции вложенных транзакций
+func fromSQLTxOptions(opts *sql.TxOptions) *storage.TxOptions {
+	txOpts := &storage.TxOptions{ReadOnly: opts.ReadOnly}
+
+	switch opts.Isolation {
+	case sql.LevelReadUncommitted:
+		txOpts.Isolation = storage.LevelReadUncommitted
+	case sql.LevelReadCommitted:
+		txOpts.Isolation = storage.LevelReadCommitted
+	case sql.LevelRepeatableRead:
+		txOpts.Isolation = storage.LevelRepeatableRead
+	case sql.LevelSerializable:
+		txOpts.Isolation = storage.LevelSerializable
+	}
+
+	return txOpts
+}
+
+func convertTxOptions(opts *storage.TxOptions) (*sql.TxOptions, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	if opts.Deferrable {
+		return nil, errors.Wrap(storage.ErrUnsupportedTxOptions, "deferrable mode is not supported by mysql")
+	}
+
+	sqlOpts := &sql.TxOptions{ReadOnly: opts.ReadOnly}
+
+	switch opts.Isolation {
+	case storage.LevelReadUncommitted:
+		sqlOpts.Isolation = sql.LevelReadUncommitted
+	case storage.LevelReadCommitted:
+		sqlOpts.Isolation = sql.LevelReadCommitted
+	case storage.LevelRepeatableRead:
+		sqlOpts.Isolation = sql.LevelRepeatableRead
+	case storage.LevelSerializable:
+		sqlOpts.Isolation = sql.LevelSerializable
+	}
+
+	return sqlOpts, nil
 }
@@ -0,0 +1,451 @@
+package storage
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/georgysavva/scany/dbscan"
+	"gopkg.in/gomisc/errors.v1"
+)
+
+const (
+	// PlaceholderDollar - позиционные параметры вида $1, $2 (PostgreSQL)
+	PlaceholderDollar PlaceholderStyle = iota + 1
+	// PlaceholderQuestion - позиционные параметры вида ? (MySQL)
+	PlaceholderQuestion
+)
+
+const (
+	ErrNamedArgType    = errors.Const("named arguments must be map with string keys or struct")
+	ErrNamedArgMissing = errors.Const("named argument not found")
+	ErrUnterminatedSQL = errors.Const("unterminated literal or comment in query")
+
+	namedArgsTag       = "db"
+	namedArgsSeparator = "."
+)
+
+var _ NamedQuery = (*bindQuery)(nil)
+
+type (
+	// PlaceholderStyle - стиль позиционных параметров, которые ожидает драйвер
+	PlaceholderStyle int
+
+	// NamedSQL - запрос, именованные параметры которого переписаны в позиционные
+	NamedSQL struct {
+		// SQL - текст запроса с позиционными параметрами
+		SQL string
+		// Names - имена параметров в порядке позиционных параметров
+		Names []string
+	}
+
+	bindQuery struct {
+		name string
+		sql  string
+		arg  any
+	}
+
+	sqlLexer struct {
+		style PlaceholderStyle
+		src   string
+		pos   int
+		out   strings.Builder
+		names []string
+	}
+)
+
+// NewBindQuery - конструктор запроса с именованными параметрами вида :name или @name (@name только
+// для PostgreSQL, в MySQL это пользовательские переменные), значения которых берутся из map[string]any
+// или структуры с тегами db
+func NewBindQuery(sql string, arg any) Query {
+	return &bindQuery{sql: sql, arg: arg}
+}
+
+// NewNamedBindQuery - конструктор именованного запроса с именованными параметрами
+func NewNamedBindQuery(name, sql string, arg any) NamedQuery {
+	return &bindQuery{name: name, sql: sql, arg: arg}
+}
+
+// Name - имплементация NamedQuery
+func (q *bindQuery) Name() string {
+	return q.name
+}
+
+// Query - имплементация Query
+func (q *bindQuery) Query() interface{} {
+	return q.sql
+}
+
+// Params - имплементация Query
+func (q *bindQuery) Params() interface{} {
+	return q.arg
+}
+
+// String - имплементация fmt.Stringer
+func (q *bindQuery) String() string {
+	if q.name != "" {
+		return q.name + ": " + q.sql
+	}
+
+	return q.sql
+}
+
+// IsNamedArgs - проверяет, что значение может служить источником именованных параметров
+func IsNamedArgs(arg any) bool {
+	val := reflect.Indirect(reflect.ValueOf(arg))
+
+	switch val.Kind() {
+	case reflect.Map:
+		return val.Type().Key().Kind() == reflect.String
+	case reflect.Struct:
+		return true
+	default:
+		return false
+	}
+}
+
+// BindNamed - переписывает именованные параметры запроса в позиционные параметры указанного стиля
+// и возвращает значения параметров в порядке их следования. Строковые литералы, идентификаторы
+// в кавычках, комментарии, приведения типов :: и dollar-quoting при разборе пропускаются
+func BindNamed(style PlaceholderStyle, sql string, arg any) (string, []any, error) {
+	if !IsNamedArgs(arg) {
+		return "", nil, errors.Ctx().Any("arg", arg).Just(ErrNamedArgType)
+	}
+
+	named, err := ParseNamed(style, sql)
+	if err != nil {
+		return "", nil, err
+	}
+
+	params, err := named.Args(arg)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return named.SQL, params, nil
+}
+
+// ParseNamed - переписывает именованные параметры запроса в позиционные, как BindNamed, без привязки
+// значений: подготовленный запрос разбирается один раз, а значения привязываются при каждом выполнении
+func ParseNamed(style PlaceholderStyle, sql string) (*NamedSQL, error) {
+	lex := &sqlLexer{style: style, src: sql}
+
+	if err := lex.run(); err != nil {
+		return nil, err
+	}
+
+	return &NamedSQL{SQL: lex.out.String(), Names: lex.names}, nil
+}
+
+// Args - значения позиционных параметров запроса из источника именованных параметров
+func (n *NamedSQL) Args(arg any) ([]any, error) {
+	if !IsNamedArgs(arg) {
+		return nil, errors.Ctx().Any("arg", arg).Just(ErrNamedArgType)
+	}
+
+	values := namedArgsValues(arg)
+	params := make([]any, 0, len(n.Names))
+
+	for _, name := range n.Names {
+		value, ok := values[name]
+		if !ok {
+			return nil, errors.Ctx().Str("name", name).Just(ErrNamedArgMissing)
+		}
+
+		params = append(params, value)
+	}
+
+	return params, nil
+}
+
+func namedArgsValues(arg any) map[string]any {
+	val := reflect.Indirect(reflect.ValueOf(arg))
+	values := make(map[string]any)
+
+	if val.Kind() == reflect.Map {
+		iter := val.MapRange()
+		for iter.Next() {
+			values[iter.Key().String()] = iter.Value().Interface()
+		}
+
+		return values
+	}
+
+	collectStructArgs(val, "", values)
+
+	return values
+}
+
+func collectStructArgs(val reflect.Value, prefix string, values map[string]any) {
+	typ := val.Type()
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+
+		tag, tagged := field.Tag.Lookup(namedArgsTag)
+		if tagged {
+			tag = strings.Split(tag, ",")[0]
+		}
+
+		if tag == "-" {
+			continue
+		}
+
+		if !tagged {
+			tag = dbscan.SnakeCaseMapper(field.Name)
+		}
+
+		name := joinArgName(prefix, tag)
+		fieldVal := val.Field(i)
+
+		if !field.Anonymous {
+			if _, exists := values[name]; !exists {
+				values[name] = fieldVal.Interface()
+			}
+		}
+
+		if field.Type.Kind() == reflect.Ptr {
+			if fieldVal.IsNil() {
+				continue
+			}
+
+			fieldVal = fieldVal.Elem()
+		}
+
+		if fieldVal.Kind() != reflect.Struct {
+			continue
+		}
+
+		if field.Anonymous && !tagged {
+			name = prefix
+		}
+
+		collectStructArgs(fieldVal, name, values)
+	}
+}
+
+func joinArgName(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+
+	return prefix + namedArgsSeparator + name
+}
+
+func (lex *sqlLexer) run() error {
+	for lex.pos < len(lex.src) {
+		var err error
+
+		switch ch := lex.src[lex.pos]; {
+		case ch == '\'':
+			escapes := lex.style == PlaceholderQuestion || lex.isEscapeString()
+			err = lex.copyQuoted('\'', escapes)
+		case ch == '"':
+			err = lex.copyQuoted('"', lex.style == PlaceholderQuestion)
+		case ch == '`':
+			err = lex.copyQuoted('`', false)
+		case ch == '-' && lex.peek(1) == '-' && lex.isLineComment(),
+			ch == '#' && lex.style == PlaceholderQuestion:
+			lex.copyLineComment()
+		case ch == '/' && lex.peek(1) == '*':
+			err = lex.copyBlockComment()
+		case ch == '$' && !lex.afterIdent():
+			err = lex.copyDollarQuoted()
+		case ch == ':' && lex.peek(1) == ':':
+			lex.copyN(2)
+		case ch == '@' && lex.peek(1) == '@':
+			lex.copyN(2)
+			lex.copyIdent()
+		case ch == '@' && lex.style == PlaceholderQuestion:
+			// пользовательская переменная MySQL
+			lex.copyN(1)
+			lex.copyIdent()
+		case (ch == ':' || ch == '@') && isIdentStart(lex.peek(1)) && !lex.afterIdent():
+			lex.pos++
+			lex.bind(lex.readIdent())
+		default:
+			lex.copyN(1)
+		}
+
+		if err != nil {
+			return errors.Ctx().Int("sql-position", lex.pos).Just(err)
+		}
+	}
+
+	return nil
+}
+
+func (lex *sqlLexer) bind(name string) {
+	if lex.style == PlaceholderDollar {
+		for i, bound := range lex.names {
+			if bound == name {
+				lex.out.WriteString("$" + strconv.Itoa(i+1))
+
+				return
+			}
+		}
+
+		lex.names = append(lex.names, name)
+		lex.out.WriteString("$" + strconv.Itoa(len(lex.names)))
+
+		return
+	}
+
+	lex.names = append(lex.names, name)
+	lex.out.WriteByte('?')
+}
+
+func (lex *sqlLexer) peek(offset int) byte {
+	if lex.pos+offset < len(lex.src) {
+		return lex.src[lex.pos+offset]
+	}
+
+	return 0
+}
+
+func (lex *sqlLexer) afterIdent() bool {
+	return lex.pos > 0 && isIdentPart(lex.src[lex.pos-1])
+}
+
+// isLineComment - в MySQL "--" начинает комментарий, только если за ним следует пробельный
+// или управляющий символ: 1--1 означает 1 - (-1)
+func (lex *sqlLexer) isLineComment() bool {
+	return lex.style != PlaceholderQuestion || lex.peek(2) <= ' '
+}
+
+func (lex *sqlLexer) isEscapeString() bool {
+	if lex.pos == 0 || (lex.src[lex.pos-1] != 'E' && lex.src[lex.pos-1] != 'e') {
+		return false
+	}
+
+	return lex.pos == 1 || !isIdentPart(lex.src[lex.pos-2])
+}
+
+func (lex *sqlLexer) copyN(n int) {
+	if lex.pos+n > len(lex.src) {
+		n = len(lex.src) - lex.pos
+	}
+
+	lex.out.WriteString(lex.src[lex.pos : lex.pos+n])
+	lex.pos += n
+}
+
+func (lex *sqlLexer) copyQuoted(quote byte, escapes bool) error {
+	start := lex.pos
+
+	for i := lex.pos + 1; i < len(lex.src); i++ {
+		switch lex.src[i] {
+		case '\\':
+			if escapes {
+				i++
+			}
+		case quote:
+			if i+1 < len(lex.src) && lex.src[i+1] == quote {
+				i++
+
+				continue
+			}
+
+			lex.copyN(i + 1 - start)
+
+			return nil
+		}
+	}
+
+	return ErrUnterminatedSQL
+}
+
+func (lex *sqlLexer) copyLineComment() {
+	end := strings.IndexByte(lex.src[lex.pos:], '\n')
+	if end < 0 {
+		end = len(lex.src) - lex.pos
+	}
+
+	lex.copyN(end)
+}
+
+func (lex *sqlLexer) copyBlockComment() error {
+	depth := 0
+
+	for i := lex.pos; i < len(lex.src)-1; i++ {
+		switch {
+		case lex.src[i] == '/' && lex.src[i+1] == '*':
+			depth++
+			i++
+		case lex.src[i] == '*' && lex.src[i+1] == '/':
+			depth--
+			i++
+
+			if depth == 0 || lex.style == PlaceholderQuestion {
+				lex.copyN(i + 1 - lex.pos)
+
+				return nil
+			}
+		}
+	}
+
+	return ErrUnterminatedSQL
+}
+
+func (lex *sqlLexer) copyDollarQuoted() error {
+	end := lex.pos + 1
+	for end < len(lex.src) && lex.src[end] != '$' && isIdentPart(lex.src[end]) {
+		end++
+	}
+
+	if end >= len(lex.src) || lex.src[end] != '$' || (end > lex.pos+1 && !isIdentStart(lex.src[lex.pos+1])) {
+		// не dollar-quoting: позиционный параметр $1 или часть выражения
+		lex.copyN(end - lex.pos)
+
+		return nil
+	}
+
+	tag := lex.src[lex.pos : end+1]
+
+	closing := strings.Index(lex.src[end+1:], tag)
+	if closing < 0 {
+		return ErrUnterminatedSQL
+	}
+
+	lex.copyN(end + 1 + closing + len(tag) - lex.pos)
+
+	return nil
+}
+
+func (lex *sqlLexer) copyIdent() {
+	lex.out.WriteString(lex.readIdent())
+}
+
+func (lex *sqlLexer) readIdent() string {
+	start := lex.pos
+
+	for lex.pos < len(lex.src) {
+		ch := lex.src[lex.pos]
+
+		if ch == '.' && isIdentStart(lex.peek(1)) {
+			lex.pos++
+
+			continue
+		}
+
+		if !isIdentPart(ch) {
+			break
+		}
+
+		lex.pos++
+	}
+
+	return lex.src[start:lex.pos]
+}
+
+func isIdentStart(ch byte) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func isIdentPart(ch byte) bool {
+	return isIdentStart(ch) || (ch >= '0' && ch <= '9') || ch == '$'
+}
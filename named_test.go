@@ -0,0 +1,213 @@
+package storage_test
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+func TestParseNamed(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		style storage.PlaceholderStyle
+		sql   string
+		want  string
+		names []string
+		err   error
+	}{
+		{
+			name:  "dollar",
+			style: storage.PlaceholderDollar,
+			sql:   "SELECT * FROM users WHERE id = :id AND name = :name OR parent = :id",
+			want:  "SELECT * FROM users WHERE id = $1 AND name = $2 OR parent = $1",
+			names: []string{"id", "name"},
+		},
+		{
+			name:  "question",
+			style: storage.PlaceholderQuestion,
+			sql:   "SELECT * FROM users WHERE id = :id AND name = :name OR parent = :id",
+			want:  "SELECT * FROM users WHERE id = ? AND name = ? OR parent = ?",
+			names: []string{"id", "name", "id"},
+		},
+		{
+			name:  "nested name",
+			style: storage.PlaceholderDollar,
+			sql:   "INSERT INTO users VALUES (:user.id, :user.name)",
+			want:  "INSERT INTO users VALUES ($1, $2)",
+			names: []string{"user.id", "user.name"},
+		},
+		{
+			name:  "quotes",
+			style: storage.PlaceholderDollar,
+			sql:   `SELECT ':skip', 'it''s :skip', "col:skip" FROM users WHERE a = :a`,
+			want:  `SELECT ':skip', 'it''s :skip', "col:skip" FROM users WHERE a = $1`,
+			names: []string{"a"},
+		},
+		{
+			name:  "backslash in standard string",
+			style: storage.PlaceholderDollar,
+			sql:   `SELECT 'C:\', :a`,
+			want:  `SELECT 'C:\', $1`,
+			names: []string{"a"},
+		},
+		{
+			name:  "escape string",
+			style: storage.PlaceholderDollar,
+			sql:   `SELECT E'it\'s :skip', e'\\', :a`,
+			want:  `SELECT E'it\'s :skip', e'\\', $1`,
+			names: []string{"a"},
+		},
+		{
+			name:  "casts",
+			style: storage.PlaceholderDollar,
+			sql:   "SELECT :a::int, name::text, arr[1:2] FROM users",
+			want:  "SELECT $1::int, name::text, arr[1:2] FROM users",
+			names: []string{"a"},
+		},
+		{
+			name:  "dollar quoting",
+			style: storage.PlaceholderDollar,
+			sql:   "SELECT $$ :skip $$, $fn$ ':skip' $fn$, :a",
+			want:  "SELECT $$ :skip $$, $fn$ ':skip' $fn$, $1",
+			names: []string{"a"},
+		},
+		{
+			name:  "comments",
+			style: storage.PlaceholderDollar,
+			sql:   "-- :skip\nSELECT /* :skip /* nested :skip */ :skip */ :a",
+			want:  "-- :skip\nSELECT /* :skip /* nested :skip */ :skip */ $1",
+			names: []string{"a"},
+		},
+		{
+			name:  "at sign in postgres",
+			style: storage.PlaceholderDollar,
+			sql:   "SELECT * FROM users WHERE id = @id AND email = 'x@y'",
+			want:  "SELECT * FROM users WHERE id = $1 AND email = 'x@y'",
+			names: []string{"id"},
+		},
+		{
+			name:  "mysql comments",
+			style: storage.PlaceholderQuestion,
+			sql:   "SELECT 1 # :skip\n, 2 -- :skip\n, 3 /* :skip */, :a",
+			want:  "SELECT 1 # :skip\n, 2 -- :skip\n, 3 /* :skip */, ?",
+			names: []string{"a"},
+		},
+		{
+			name:  "mysql double dash without space",
+			style: storage.PlaceholderQuestion,
+			sql:   "SELECT 1--1, :a",
+			want:  "SELECT 1--1, ?",
+			names: []string{"a"},
+		},
+		{
+			name:  "mysql quotes",
+			style: storage.PlaceholderQuestion,
+			sql:   "SELECT 'it\\'s :skip', \":skip\", `col:skip` FROM users WHERE a = :a",
+			want:  "SELECT 'it\\'s :skip', \":skip\", `col:skip` FROM users WHERE a = ?",
+			names: []string{"a"},
+		},
+		{
+			name:  "mysql user variables",
+			style: storage.PlaceholderQuestion,
+			sql:   "SET @n := :start; SELECT @n, @`quoted`, :a",
+			want:  "SET @n := ?; SELECT @n, @`quoted`, ?",
+			names: []string{"start", "a"},
+		},
+		{
+			name:  "system variables",
+			style: storage.PlaceholderQuestion,
+			sql:   "SELECT @@global.read_only, @@session.sql_mode, :a",
+			want:  "SELECT @@global.read_only, @@session.sql_mode, ?",
+			names: []string{"a"},
+		},
+		{name: "unterminated string", style: storage.PlaceholderDollar, sql: "SELECT 'abc", err: storage.ErrUnterminatedSQL},
+		{name: "unterminated comment", style: storage.PlaceholderDollar, sql: "SELECT /* :a", err: storage.ErrUnterminatedSQL},
+		{name: "unterminated dollar", style: storage.PlaceholderDollar, sql: "SELECT $$ :a", err: storage.ErrUnterminatedSQL},
+		{name: "unterminated identifier", style: storage.PlaceholderQuestion, sql: "SELECT `a", err: storage.ErrUnterminatedSQL},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			named, err := storage.ParseNamed(test.style, test.sql)
+
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("parse: got %v, want %v", err, test.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parse: %s", errors.Formatted(err))
+			}
+
+			if named.SQL != test.want {
+				t.Fatalf("sql: got %q, want %q", named.SQL, test.want)
+			}
+
+			if !reflect.DeepEqual(named.Names, test.names) {
+				t.Fatalf("names: got %q, want %q", named.Names, test.names)
+			}
+		})
+	}
+}
+
+func TestBindNamed(t *testing.T) {
+	type (
+		Audit struct {
+			CreatedBy string
+		}
+
+		profile struct {
+			Email string `db:"email"`
+		}
+
+		user struct {
+			Audit
+			ID      int64    `db:"id"`
+			Name    string   `db:"name"`
+			Secret  string   `db:"-"`
+			Profile *profile `db:"profile"`
+		}
+	)
+
+	arg := user{Audit: Audit{CreatedBy: "admin"}, ID: 7, Name: "alpha", Profile: &profile{Email: "a@b"}}
+
+	for _, test := range []struct {
+		name string
+		sql  string
+		arg  any
+		want []any
+		err  error
+	}{
+		{name: "map", sql: ":id, :name", arg: map[string]any{"id": 1, "name": "beta"}, want: []any{1, "beta"}},
+		{name: "struct", sql: ":id, :name, :created_by, :profile.email", arg: arg, want: []any{int64(7), "alpha", "admin", "a@b"}},
+		{name: "struct pointer", sql: ":id", arg: &arg, want: []any{int64(7)}},
+		{name: "missing", sql: ":id, :missing", arg: map[string]any{"id": 1}, err: storage.ErrNamedArgMissing},
+		{name: "skipped field", sql: ":secret", arg: arg, err: storage.ErrNamedArgMissing},
+		{name: "positional arg", sql: ":id", arg: 1, err: storage.ErrNamedArgType},
+		{name: "map with int keys", sql: ":id", arg: map[int]any{1: 1}, err: storage.ErrNamedArgType},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, params, err := storage.BindNamed(storage.PlaceholderQuestion, test.sql, test.arg)
+
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("bind: got %v, want %v", err, test.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("bind: %s", errors.Formatted(err))
+			}
+
+			if !reflect.DeepEqual(params, test.want) {
+				t.Fatalf("params: got %v, want %v", params, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package pg
+
+import (
+	"context"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"gopkg.in/gomisc/errors.v1"
+	"gopkg.in/gomisc/tracing.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+var _ storage.Batcher = (*databaseClient)(nil)
+
+// SendBatch - имплементация storage.Batcher, запросы пакета отправляются одним pgx.Batch.
+// Вне транзакции сервер выполняет пакет в неявной транзакции: ошибка сервера в любом запросе
+// отменяет весь пакет. Атомарный пакет выполняется в транзакции (в транзакции контекста -
+// в точке сохранения), которая откатывается и при ошибках декодирования результатов
+func (cli *databaseClient) SendBatch(ctx context.Context, batch *storage.Batch) error {
+	span := tracing.SetTrace(ctx)
+	defer span.End()
+
+	batch.Reset()
+
+	if !batch.Atomic {
+		if err := cli.sendBatch(span.Context(), batch); err != nil {
+			span, err = span.WithError(err)
+
+			return err
+		}
+
+		return nil
+	}
+
+	err := storage.RunInTx(span.Context(), cli, nil, func(ctx context.Context) error {
+		return cli.sendBatch(ctx, batch)
+	}, storage.RetryPolicy{MaxAttempts: 1})
+	if err != nil {
+		batch.Abort()
+
+		if batchErr := batch.Err(); batchErr != nil {
+			err = batchErr
+		}
+
+		span, err = span.WithError(err, "send atomic batch")
+
+		return err
+	}
+
+	return nil
+}
+
+func (cli *databaseClient) sendBatch(ctx context.Context, batch *storage.Batch) error {
+	pgBatch := &pgx.Batch{}
+
+	for _, item := range batch.Items() {
+		pq, err := cli.prepare(item.Query)
+		if err != nil {
+			item.Err = wrapPgErr(err, storage.OpPrepare, item.Query, "prepare query data")
+			batch.Abort()
+
+			return batch.Err()
+		}
+
+		pgBatch.Queue(pq.sql, pq.params...)
+	}
+
+	results := cli.getExecutor(ctx).SendBatch(ctx, pgBatch)
+
+	for _, item := range batch.Items() {
+		if item.Err = readBatchResult(results, item); item.Err == nil {
+			continue
+		}
+
+		cli.failover.observe(item.Err)
+
+		if abortsBatch(item.Err) {
+			batch.Abort()
+
+			break
+		}
+	}
+
+	if err := results.Close(); err != nil && batch.Err() == nil {
+		err = wrapPgErr(err, storage.OpBatch, nil, "close batch results")
+		cli.failover.observe(err)
+
+		return err
+	}
+
+	return batch.Err()
+}
+
+// readBatchResult - читает результат очередного запроса пакета в приемники запроса
+func readBatchResult(results pgx.BatchResults, item *storage.BatchItem) error {
+	switch item.Kind {
+	case storage.BatchExec:
+		tag, err := results.Exec()
+		if err != nil {
+			return wrapPgErr(err, storage.OpExec, item.Query, "execute query")
+		}
+
+		item.ExecResult = &execResult{tag: tag}
+	case storage.BatchQuery:
+		if item.Result == nil {
+			if _, err := results.Exec(); err != nil {
+				return wrapPgErr(err, storage.OpExec, item.Query, "execute query")
+			}
+
+			return nil
+		}
+
+		rows, err := results.Query()
+		if err != nil {
+			return wrapPgErr(err, storage.OpQuery, item.Query, "execute query")
+		}
+
+		return decodeRows(rows, item.Query, item.Result)
+	case storage.BatchQueryRow:
+		if err := results.QueryRow().Scan(item.Dest...); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return storage.ErrEmptyResult
+			}
+
+			return wrapPgErr(err, storage.OpQueryRow, item.Query, "scan query row")
+		}
+	default:
+		return errors.Ctx().Int("kind", int(item.Kind)).Just(storage.ErrNotSupported)
+	}
+
+	return nil
+}
+
+// abortsBatch - ошибка сервера или соединения, после которой сервер отменяет неявную транзакцию
+// пакета и не выполняет остальные запросы. Ошибки декодирования и пустой результат QueryRow
+// выполнение пакета не прерывают
+func abortsBatch(err error) bool {
+	var pgErr *pgconn.PgError
+
+	return errors.As(err, &pgErr) || errors.Is(err, storage.ErrConnectionLost)
+}
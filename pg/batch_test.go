@@ -0,0 +1,155 @@
+package pg_test
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+	"gopkg.in/gomisc/storage.v1/pg"
+	"gopkg.in/gomisc/storage.v1/pg/pgtest"
+)
+
+var errDuplicate = &pgconn.PgError{
+	Severity:       "ERROR",
+	Code:           "23505",
+	Message:        `duplicate key value violates unique constraint "users_name_key"`,
+	ConstraintName: "users_name_key",
+}
+
+// usersBatch - пакет из вставки alpha, вставки beta и выборки пользователей
+func usersBatch(atomic bool) (*storage.Batch, *[]user) {
+	var users []user
+
+	batch := &storage.Batch{Atomic: atomic}
+	batch.Exec(storage.NewQuery("INSERT INTO users (name) VALUES ('alpha')"))
+	batch.Exec(storage.NewQuery("INSERT INTO users (name) VALUES ('beta')"))
+	batch.Query(storage.NewQuery("SELECT id, name FROM users"), &users)
+
+	return batch, &users
+}
+
+func TestSendBatch(t *testing.T) {
+	for _, protocol := range []string{"", string(pg.ProtocolExtended)} {
+		t.Run("protocol="+protocol, func(t *testing.T) {
+			s, srv := newStorage(t, protocol)
+			ctx := context.Background()
+
+			srv.Expect(`^INSERT INTO users \(name\) VALUES \('alpha'\)$`).WillReturnResult(1)
+			srv.Expect(`^INSERT INTO users \(name\) VALUES \('beta'\)$`).WillReturnResult(1)
+			srv.Expect(`^SELECT id, name FROM users$`).WillReturnRows(usersTable)
+			srv.Expect(`^SELECT name FROM users WHERE id = 3$`).WillReturnRows(storage.Table{Headers: []string{"name"}})
+
+			batch, users := usersBatch(false)
+
+			var name string
+			missing := batch.QueryRow(storage.NewQuery("SELECT name FROM users WHERE id = 3"), &name)
+
+			// пустой результат QueryRow - ошибка запроса, не прерывающая пакет
+			if err := storage.SendBatch(ctx, s, batch); !errors.Is(err, storage.ErrEmptyResult) {
+				t.Fatalf("send batch: got %v, want %v", err, storage.ErrEmptyResult)
+			}
+
+			if !errors.Is(missing.Err, storage.ErrEmptyResult) {
+				t.Fatalf("query row: got %v, want %v", missing.Err, storage.ErrEmptyResult)
+			}
+
+			for i, item := range batch.Items()[:2] {
+				if item.Err != nil {
+					t.Fatalf("item %d: %s", i, errors.Formatted(item.Err))
+				}
+
+				if affected, _ := item.ExecResult.RowsAffected(); affected != 1 {
+					t.Fatalf("item %d: got %d rows affected, want 1", i, affected)
+				}
+			}
+
+			if want := []user{{ID: 1, Name: "alpha"}, {ID: 2, Name: "beta"}}; !reflect.DeepEqual(*users, want) {
+				t.Fatalf("query: got %v, want %v", *users, want)
+			}
+		})
+	}
+}
+
+func TestSendBatchErrors(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		protocol string
+		atomic   bool
+		inTx     bool
+		calls    []string
+	}{
+		{name: "implicit transaction", calls: []string{"INSERT", "INSERT"}},
+		{name: "implicit transaction extended", protocol: string(pg.ProtocolExtended), calls: []string{"INSERT", "INSERT"}},
+		{name: "atomic", atomic: true, calls: []string{"BEGIN", "INSERT", "INSERT", "ROLLBACK"}},
+		{
+			name:   "atomic in transaction",
+			atomic: true,
+			inTx:   true,
+			calls:  []string{"BEGIN", "SAVEPOINT", "INSERT", "INSERT", "ROLLBACK", "ROLLBACK"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s, srv := newStorage(t, test.protocol)
+			ctx := context.Background()
+
+			srv.Expect(`^INSERT INTO users \(name\) VALUES \('alpha'\)$`).WillReturnResult(1)
+			srv.Expect(`^INSERT INTO users \(name\) VALUES \('beta'\)$`).WillReturnError(errDuplicate)
+
+			var tx storage.Transaction
+
+			if test.inTx {
+				var err error
+
+				if tx, err = s.Begin(ctx); err != nil {
+					t.Fatalf("begin: %s", errors.Formatted(err))
+				}
+
+				ctx = tx.Context()
+			}
+
+			batch, _ := usersBatch(test.atomic)
+
+			err := storage.SendBatch(ctx, s, batch)
+			if !errors.Is(err, storage.ErrUniqueViolation) || errors.Is(err, storage.ErrBatchAborted) {
+				t.Fatalf("send batch: got %v, want %v", err, storage.ErrUniqueViolation)
+			}
+
+			// вставка alpha отменена вместе с транзакцией пакета, выборка не выполнена
+			items := batch.Items()
+			if !errors.Is(items[0].Err, storage.ErrBatchAborted) || !errors.Is(items[2].Err, storage.ErrBatchAborted) {
+				t.Fatalf("aborted items: got %v, %v; want %v", items[0].Err, items[2].Err, storage.ErrBatchAborted)
+			}
+
+			if drvErr, ok := storage.AsDriverError(items[1].Err); !ok || drvErr.Constraint != "users_name_key" {
+				t.Fatalf("failed item: got %v", items[1].Err)
+			}
+
+			if tx != nil {
+				// откат к точке сохранения оставляет внешнюю транзакцию рабочей
+				if err = tx.Rollback(context.Background()); err != nil {
+					t.Fatalf("rollback: %s", errors.Formatted(err))
+				}
+			}
+
+			if calls := callVerbs(srv); !reflect.DeepEqual(calls, test.calls) {
+				t.Fatalf("calls: got %q, want %q", calls, test.calls)
+			}
+		})
+	}
+}
+
+// callVerbs - первые слова выполненных сервером инструкций
+func callVerbs(srv *pgtest.Server) []string {
+	var verbs []string
+
+	for _, call := range srv.Calls() {
+		verbs = append(verbs, strings.ToUpper(strings.Fields(call.SQL)[0]))
+	}
+
+	return verbs
+}
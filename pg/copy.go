@@ -0,0 +1,100 @@
+package pg
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"gopkg.in/gomisc/errors.v1"
+	"gopkg.in/gomisc/tracing.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+var _ storage.BulkLoader = (*databaseClient)(nil)
+
+// CopyFrom - имплементация storage.BulkLoader по протоколу COPY: строки источника передаются
+// pgx CopyFrom в бинарном формате, CSV из io.Reader передается серверу как есть инструкцией
+// COPY ... FROM STDIN (FORMAT csv), в которой пустое поле без кавычек означает NULL
+func (cli *databaseClient) CopyFrom(ctx context.Context, table string, columns []string, source any) (int64, error) {
+	span := tracing.SetTrace(ctx)
+	defer span.End()
+
+	var (
+		count int64
+		err   error
+	)
+
+	reader, isCSV := source.(io.Reader)
+
+	switch {
+	case len(columns) == 0:
+		err = storage.ErrCopyColumns
+	case isCSV:
+		count, err = cli.copyCSV(span.Context(), table, columns, reader)
+	default:
+		count, err = cli.copyRows(span.Context(), table, columns, source)
+	}
+
+	if err != nil {
+		err = errors.Ctx().Str("table", table).Just(wrapPgErr(err, storage.OpCopy, nil, "copy rows"))
+		cli.failover.observe(err)
+		span, err = span.WithError(err)
+
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (cli *databaseClient) copyRows(ctx context.Context, table string, columns []string, source any) (int64, error) {
+	rows, err := storage.NewRowSource(ctx, columns, source)
+	if err != nil {
+		return 0, err
+	}
+
+	return cli.getExecutor(ctx).CopyFrom(ctx, identifier(table), columns, rows)
+}
+
+func (cli *databaseClient) copyCSV(ctx context.Context, table string, columns []string, reader io.Reader) (int64, error) {
+	quoted := make([]string, len(columns))
+
+	for i, column := range columns {
+		quoted[i] = pgx.Identifier{column}.Sanitize()
+	}
+
+	sql := "COPY " + identifier(table).Sanitize() + " (" + strings.Join(quoted, ", ") + ") FROM STDIN (FORMAT csv)"
+
+	var tag pgconn.CommandTag
+
+	err := cli.withPgConn(ctx, func(conn *pgconn.PgConn) (err error) {
+		tag, err = conn.CopyFrom(ctx, reader, sql)
+
+		return err
+	})
+
+	return tag.RowsAffected(), err
+}
+
+// withPgConn - выполняет fn на соединении транзакции контекста или на соединении из пула
+func (cli *databaseClient) withPgConn(ctx context.Context, fn func(conn *pgconn.PgConn) error) error {
+	if tx, ok := ctx.Value(transactionKey{}).(*pgTransaction); ok {
+		return fn(tx.tx.Conn().PgConn())
+	}
+
+	conn, err := cli.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Release()
+
+	return fn(conn.Conn().PgConn())
+}
+
+// identifier - имя таблицы, имя со схемой разделяется по точке
+func identifier(table string) pgx.Identifier {
+	return strings.Split(table, ".")
+}
@@ -0,0 +1,195 @@
+package pg
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+// cursorPrefix - префикс имен курсоров итераторов с размером выборки
+const cursorPrefix = "storage_cursor_"
+
+var (
+	_ storage.BatchIterator = (*cursorIterator)(nil)
+
+	cursorSeq atomic.Uint64
+
+	// simpleProtocol - инструкции курсора с уникальными именами выполняются простым протоколом,
+	// чтобы не вытеснять запросы из кеша подготовленных запросов соединения
+	simpleProtocol = pgx.QuerySimpleProtocol(true)
+)
+
+// cursorIterator - итератор по курсору на сервере, строки выбираются частями по fetch строк.
+// Курсор существует только в транзакции: вне транзакции контекста итератор открывает
+// собственную транзакцию и фиксирует ее при закрытии
+type cursorIterator struct {
+	cli   *databaseClient
+	ctx   context.Context
+	tx    pgx.Tx
+	ownTx bool
+	name  string
+	fetch int
+	query storage.Query
+
+	rows    pgx.Rows
+	scanner *pgxscan.RowScanner
+	fetched int
+	done    bool
+	err     error
+}
+
+// iterateCursor - объявляет курсор запроса и возвращает итератор по нему
+func (cli *databaseClient) iterateCursor(ctx context.Context, query storage.Query, fetch int) (*cursorIterator, error) {
+	pq, err := cli.prepare(query)
+	if err != nil {
+		return nil, wrapPgErr(err, storage.OpPrepare, query, "prepare query data")
+	}
+
+	iter := &cursorIterator{
+		cli:   cli,
+		ctx:   ctx,
+		name:  cursorPrefix + strconv.FormatUint(cursorSeq.Add(1), 10),
+		fetch: fetch,
+		query: query,
+	}
+
+	if tx, ok := ctx.Value(transactionKey{}).(*pgTransaction); ok {
+		iter.tx = tx.tx
+	} else {
+		if iter.tx, err = cli.pool.Begin(ctx); err != nil {
+			err = wrapPgErr(err, storage.OpBegin, query, "begin cursor transaction")
+			cli.failover.observe(err)
+
+			return nil, err
+		}
+
+		iter.ownTx = true
+	}
+
+	args := append([]any{simpleProtocol}, pq.params...)
+
+	if _, err = iter.tx.Exec(ctx, "DECLARE "+iter.name+" NO SCROLL CURSOR FOR "+pq.sql, args...); err != nil {
+		err = wrapPgErr(err, storage.OpQuery, query, "declare cursor")
+		cli.failover.observe(err)
+
+		if iter.ownTx {
+			_ = iter.tx.Rollback(ctx)
+		}
+
+		return nil, err
+	}
+
+	return iter, nil
+}
+
+// Close - закрывает курсор и фиксирует собственную транзакцию итератора
+func (iter *cursorIterator) Close() error {
+	if iter.tx == nil {
+		return nil
+	}
+
+	if iter.rows != nil {
+		iter.rows.Close()
+	}
+
+	_, err := iter.tx.Exec(iter.ctx, "CLOSE "+iter.name, simpleProtocol)
+
+	if iter.ownTx {
+		if err == nil {
+			err = iter.tx.Commit(iter.ctx)
+		} else {
+			_ = iter.tx.Rollback(iter.ctx)
+		}
+	}
+
+	iter.tx = nil
+
+	if err != nil {
+		err = wrapPgErr(err, storage.OpClose, iter.query, "close cursor")
+		iter.cli.failover.observe(err)
+
+		return err
+	}
+
+	return nil
+}
+
+// Next - переходит к следующей строке, выбирая следующую часть результата по исчерпании текущей
+func (iter *cursorIterator) Next(ctx context.Context) bool {
+	for {
+		if iter.rows != nil {
+			if iter.rows.Next() {
+				iter.fetched++
+
+				return true
+			}
+
+			iter.rows.Close()
+
+			if err := iter.rows.Err(); err != nil {
+				iter.err = err
+				iter.cli.failover.observe(err)
+			}
+
+			// неполная часть - последняя
+			iter.done = iter.done || iter.fetched < iter.fetch
+			iter.rows = nil
+		}
+
+		if iter.done || iter.err != nil || iter.tx == nil {
+			return false
+		}
+
+		iter.fetched = 0
+
+		iter.rows, iter.err = iter.tx.Query(ctx, "FETCH FORWARD "+strconv.Itoa(iter.fetch)+" FROM "+iter.name, simpleProtocol)
+		if iter.err != nil {
+			iter.rows = nil
+			iter.cli.failover.observe(iter.err)
+
+			return false
+		}
+
+		iter.scanner = pgxscan.NewRowScanner(iter.rows)
+	}
+}
+
+func (iter *cursorIterator) Err() error {
+	if iter.err != nil {
+		return wrapPgErr(iter.err, storage.OpIterate, iter.query, "fetch cursor rows")
+	}
+
+	return nil
+}
+
+func (iter *cursorIterator) Decode(result any) error {
+	if iter.scanner == nil {
+		return errors.Ctx().Stringer("query", iter.query).Just(storage.ErrEmptyResult)
+	}
+
+	if err := iter.scanner.Scan(result); err != nil {
+		return wrapPgErr(err, storage.OpDecode, iter.query, "decode item result")
+	}
+
+	return nil
+}
+
+// NextBatch - имплементация storage.BatchIterator, при n, равном размеру выборки, одна часть
+// результата декодируется за вызов
+func (iter *cursorIterator) NextBatch(ctx context.Context, dest any, n int) (int, error) {
+	next := func() bool { return iter.Next(ctx) }
+	scan := func(result any) error { return iter.scanner.Scan(result) }
+
+	count, err := storage.DecodeBatch(dest, n, next, scan)
+	if err != nil {
+		return count, wrapPgErr(err, storage.OpDecode, iter.query, "decode batch result")
+	}
+
+	return count, iter.Err()
+}
@@ -0,0 +1,257 @@
+package pg_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+	"gopkg.in/gomisc/storage.v1/pg/pgtest"
+)
+
+const (
+	declareUsers = `^DECLARE storage_cursor_\d+ NO SCROLL CURSOR FOR SELECT id, name FROM users$`
+	fetchUsers   = `^FETCH FORWARD 2 FROM storage_cursor_\d+$`
+	closeCursor  = `^CLOSE storage_cursor_\d+$`
+)
+
+var selectUsers = storage.NewQuery("SELECT id, name FROM users")
+
+// expectCursor - ожидания объявления курсора, выборок частями chunks и закрытия курсора
+func expectCursor(srv *pgtest.Server, chunks ...[][]any) {
+	srv.Expect(declareUsers).WillReturnTag("DECLARE CURSOR")
+
+	for _, rows := range chunks {
+		srv.Expect(fetchUsers).WillReturnRows(storage.Table{Headers: usersTable.Headers, Rows: rows})
+	}
+
+	srv.Expect(closeCursor).WillReturnTag("CLOSE CURSOR")
+}
+
+func TestIterateCursor(t *testing.T) {
+	third := [][]any{{int64(3), "gamma"}}
+	fourth := [][]any{{int64(3), "gamma"}, {int64(4), "delta"}}
+
+	for _, test := range []struct {
+		name   string
+		chunks [][][]any
+		inTx   bool
+		want   int
+		calls  []string
+	}{
+		{
+			// неполная часть - последняя, следующая выборка не выполняется
+			name:   "last partial chunk",
+			chunks: [][][]any{usersTable.Rows, third},
+			want:   3,
+			calls:  []string{"BEGIN", "DECLARE", "FETCH", "FETCH", "CLOSE", "COMMIT"},
+		},
+		{
+			name:   "chunks of fetch size",
+			chunks: [][][]any{usersTable.Rows, fourth, nil},
+			want:   4,
+			calls:  []string{"BEGIN", "DECLARE", "FETCH", "FETCH", "FETCH", "CLOSE", "COMMIT"},
+		},
+		{
+			name:   "empty result",
+			chunks: [][][]any{nil},
+			calls:  []string{"BEGIN", "DECLARE", "FETCH", "CLOSE", "COMMIT"},
+		},
+		{
+			// в транзакции контекста итератор не открывает и не фиксирует собственную
+			name:   "context transaction",
+			chunks: [][][]any{usersTable.Rows, third},
+			inTx:   true,
+			want:   3,
+			calls:  []string{"BEGIN", "DECLARE", "FETCH", "FETCH", "CLOSE", "ROLLBACK"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s, srv := newStorage(t, "")
+			ctx := context.Background()
+
+			expectCursor(srv, test.chunks...)
+
+			var tx storage.Transaction
+
+			if test.inTx {
+				var err error
+
+				if tx, err = s.Begin(ctx); err != nil {
+					t.Fatalf("begin: %s", errors.Formatted(err))
+				}
+
+				ctx = tx.Context()
+			}
+
+			iter, err := s.Iterate(storage.WithFetchSize(ctx, 2), selectUsers)
+			if err != nil {
+				t.Fatalf("iterate: %s", errors.Formatted(err))
+			}
+
+			var users []user
+
+			for iter.Next(ctx) {
+				var u user
+
+				if err = iter.Decode(&u); err != nil {
+					t.Fatalf("decode: %s", errors.Formatted(err))
+				}
+
+				users = append(users, u)
+			}
+
+			if err = iter.Err(); err != nil {
+				t.Fatalf("iterate: %s", errors.Formatted(err))
+			}
+
+			if err = iter.Close(); err != nil {
+				t.Fatalf("close: %s", errors.Formatted(err))
+			}
+
+			if tx != nil {
+				if err = tx.Rollback(context.Background()); err != nil {
+					t.Fatalf("rollback: %s", errors.Formatted(err))
+				}
+			}
+
+			if len(users) != test.want {
+				t.Fatalf("users: got %v, want %d", users, test.want)
+			}
+
+			if calls := callVerbs(srv); !reflect.DeepEqual(calls, test.calls) {
+				t.Fatalf("calls: got %q, want %q", calls, test.calls)
+			}
+		})
+	}
+}
+
+func TestIterateCursorClose(t *testing.T) {
+	s, srv := newStorage(t, "")
+	ctx := context.Background()
+
+	expectCursor(srv, usersTable.Rows)
+
+	iter, err := s.Iterate(storage.WithFetchSize(ctx, 2), selectUsers)
+	if err != nil {
+		t.Fatalf("iterate: %s", errors.Formatted(err))
+	}
+
+	// закрытие до конца результата закрывает курсор и фиксирует транзакцию итератора
+	if !iter.Next(ctx) {
+		t.Fatalf("next: %v", iter.Err())
+	}
+
+	if err = iter.Close(); err != nil {
+		t.Fatalf("close: %s", errors.Formatted(err))
+	}
+
+	if err = iter.Close(); err != nil {
+		t.Fatalf("second close: %s", errors.Formatted(err))
+	}
+
+	if iter.Next(ctx) {
+		t.Fatalf("next after close: got row")
+	}
+
+	if want := []string{"BEGIN", "DECLARE", "FETCH", "CLOSE", "COMMIT"}; !reflect.DeepEqual(callVerbs(srv), want) {
+		t.Fatalf("calls: got %q, want %q", callVerbs(srv), want)
+	}
+}
+
+func TestIterateCursorDeclareError(t *testing.T) {
+	s, srv := newStorage(t, "")
+
+	srv.Expect(declareUsers).WillReturnError(&pgconn.PgError{Severity: "ERROR", Code: "42P01", Message: `relation "users" does not exist`})
+
+	if _, err := s.Iterate(storage.WithFetchSize(context.Background(), 2), selectUsers); err == nil {
+		t.Fatalf("iterate: got no error")
+	}
+
+	if want := []string{"BEGIN", "DECLARE", "ROLLBACK"}; !reflect.DeepEqual(callVerbs(srv), want) {
+		t.Fatalf("calls: got %q, want %q", callVerbs(srv), want)
+	}
+}
+
+func TestNextBatchCursor(t *testing.T) {
+	s, srv := newStorage(t, "")
+	ctx := context.Background()
+
+	expectCursor(srv, usersTable.Rows, [][]any{{int64(3), "gamma"}})
+
+	iter, err := s.Iterate(storage.WithFetchSize(ctx, 2), selectUsers)
+	if err != nil {
+		t.Fatalf("iterate: %s", errors.Formatted(err))
+	}
+
+	defer iter.Close()
+
+	var users []user
+
+	if n, err := storage.NextBatch(ctx, iter, &users, 2); err != nil || n != 2 {
+		t.Fatalf("first batch: got %d, %v", n, err)
+	}
+
+	first := &users[0]
+
+	if n, err := storage.NextBatch(ctx, iter, &users, 2); err != nil || n != 1 {
+		t.Fatalf("second batch: got %d, %v", n, err)
+	}
+
+	// емкость слайса используется повторно
+	if &users[0] != first || !reflect.DeepEqual(users, []user{{ID: 3, Name: "gamma"}}) {
+		t.Fatalf("second batch: got %v, reused %t", users, &users[0] == first)
+	}
+
+	if n, err := storage.NextBatch(ctx, iter, &users, 2); err != nil || n != 0 || len(users) != 0 {
+		t.Fatalf("last batch: got %d, %v, %v", n, err, users)
+	}
+}
+
+func TestNextBatchPointers(t *testing.T) {
+	s, srv := newStorage(t, "")
+	ctx := context.Background()
+
+	expectCursor(srv, usersTable.Rows, nil)
+
+	iter, err := s.Iterate(storage.WithFetchSize(ctx, 2), selectUsers)
+	if err != nil {
+		t.Fatalf("iterate: %s", errors.Formatted(err))
+	}
+
+	defer iter.Close()
+
+	var users []*user
+
+	if n, err := storage.NextBatch(ctx, iter, &users, 1); err != nil || n != 1 {
+		t.Fatalf("first batch: got %d, %v", n, err)
+	}
+
+	first := users[0]
+
+	if n, err := storage.NextBatch(ctx, iter, &users, 1); err != nil || n != 1 {
+		t.Fatalf("second batch: got %d, %v", n, err)
+	}
+
+	// элементы-указатели создаются заново: сохраненные строки прежних частей не перезаписываются
+	if users[0] == first || *first != (user{ID: 1, Name: "alpha"}) || *users[0] != (user{ID: 2, Name: "beta"}) {
+		t.Fatalf("batches: got first %v, second %v", *first, *users[0])
+	}
+
+	if n, err := storage.NextBatch(ctx, iter, &users, 1); err != nil || n != 0 {
+		t.Fatalf("last batch: got %d, %v", n, err)
+	}
+
+	var wrong []user
+
+	if _, err = storage.NextBatch(ctx, iter, wrong, 1); !errors.Is(err, storage.ErrBatchDest) {
+		t.Fatalf("non-pointer destination: got %v, want %v", err, storage.ErrBatchDest)
+	}
+
+	if _, err = storage.NextBatch(ctx, iter, &wrong, 0); !errors.Is(err, storage.ErrBatchSize) {
+		t.Fatalf("zero size: got %v, want %v", err, storage.ErrBatchSize)
+	}
+}
@@ -0,0 +1,121 @@
+package pg
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+// SQLSTATE коды ошибок PostgreSQL
+const (
+	codeNotNullViolation     = "23502"
+	codeForeignKeyViolation  = "23503"
+	codeUniqueViolation      = "23505"
+	codeCheckViolation       = "23514"
+	codeReadOnlyTransaction  = "25006"
+	codeSerializationFailure = "40001"
+	codeDeadlockDetected     = "40P01"
+	codeLockNotAvailable     = "55P03"
+	codeQueryCanceled        = "57014"
+	codeAdminShutdown        = "57P01"
+	codeCrashShutdown        = "57P02"
+	codeCannotConnectNow     = "57P03"
+
+	classConnectionException = "08"
+)
+
+// wrapPgErr - единая точка обогащения ошибок драйвера: операция, имя и отпечаток запроса,
+// код ошибки сервера, позиция в тексте запроса и класс ошибки
+func wrapPgErr(err error, op storage.Operation, query storage.Query, message string) error {
+	errCtx := storage.ErrorContext(op, query).Pos(2)
+
+	var pgErr *pgconn.PgError
+
+	if errors.As(err, &pgErr) {
+		errCtx = errCtx.
+			Str("code", pgErr.Code).
+			Int32("sql-position", pgErr.Position)
+	}
+
+	return errCtx.Wrap(classifyErr(err), message)
+}
+
+// classifyErr - относит ошибку драйвера к классу. Ошибку нужно классифицировать до первой обертки
+// errors.Wrap: обертка находит вложенную ошибку с контекстом через DriverError.Unwrap, дополняет ее
+// на месте и возвращает без DriverError
+func classifyErr(err error) error {
+	if _, ok := storage.AsDriverError(err); ok {
+		return err
+	}
+
+	var pgErr *pgconn.PgError
+
+	if errors.As(err, &pgErr) {
+		return classifyPgErr(err, pgErr)
+	}
+
+	return storage.Classify(err, classifyConnErr(err), "")
+}
+
+func classifyPgErr(err error, pgErr *pgconn.PgError) error {
+	var class error
+
+	switch pgErr.Code {
+	case codeUniqueViolation:
+		class = storage.ErrUniqueViolation
+	case codeForeignKeyViolation:
+		class = storage.ErrForeignKeyViolation
+	case codeNotNullViolation:
+		class = storage.ErrNotNullViolation
+	case codeCheckViolation:
+		class = storage.ErrCheckViolation
+	case codeReadOnlyTransaction:
+		class = storage.ErrReadOnlyViolation
+	case codeSerializationFailure:
+		class = storage.ErrSerializationFailure
+	case codeDeadlockDetected:
+		class = storage.ErrDeadlock
+	case codeLockNotAvailable:
+		class = storage.ErrLockTimeout
+	case codeQueryCanceled:
+		class = storage.ErrQueryCanceled
+	case codeAdminShutdown, codeCrashShutdown, codeCannotConnectNow:
+		class = storage.ErrConnectionLost
+	default:
+		if strings.HasPrefix(pgErr.Code, classConnectionException) {
+			class = storage.ErrConnectionLost
+		}
+	}
+
+	if class == nil {
+		return err
+	}
+
+	return &storage.DriverError{
+		Class:      class,
+		Code:       pgErr.Code,
+		Constraint: pgErr.ConstraintName,
+		Table:      pgErr.TableName,
+		Column:     pgErr.ColumnName,
+		Err:        err,
+	}
+}
+
+func classifyConnErr(err error) error {
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return storage.ErrQueryCanceled
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.As(err, &netErr):
+		return storage.ErrConnectionLost
+	default:
+		return nil
+	}
+}
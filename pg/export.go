@@ -0,0 +1,295 @@
+package pg
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgtype"
+	"gopkg.in/gomisc/errors.v1"
+	"gopkg.in/gomisc/tracing.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+const (
+	errParamIndex       = errors.Const("query parameter index out of range")
+	errNonStandardQuote = errors.Const("string literals require standard_conforming_strings = on")
+
+	// standardStrings - параметр сервера, при включении которого обратная косая черта
+	// в обычных строковых литералах не экранирует символы
+	standardStrings = "standard_conforming_strings"
+)
+
+var _ storage.BulkExporter = (*databaseClient)(nil)
+
+// CopyTo - имплементация storage.BulkExporter инструкцией COPY (query) TO STDOUT, данные в формате
+// сервера передаются в w по мере получения. COPY не принимает параметров, поэтому параметры запроса
+// подставляются в его текст литералами
+func (cli *databaseClient) CopyTo(ctx context.Context, query storage.Query, w io.Writer, format storage.CopyFormat) (int64, error) {
+	span := tracing.SetTrace(ctx)
+	defer span.End()
+
+	count, err := cli.copyTo(span.Context(), query, w, format)
+	if err != nil {
+		err = wrapPgErr(err, storage.OpCopy, query, "copy query result")
+		cli.failover.observe(err)
+		span, err = span.WithError(err)
+
+		return count, err
+	}
+
+	return count, nil
+}
+
+func (cli *databaseClient) copyTo(ctx context.Context, query storage.Query, w io.Writer, format storage.CopyFormat) (int64, error) {
+	options, err := copyOptions(format)
+	if err != nil {
+		return 0, err
+	}
+
+	pq, err := cli.prepare(query)
+	if err != nil {
+		return 0, err
+	}
+
+	sql, err := inlineParams(pq.sql, pq.params)
+	if err != nil {
+		return 0, err
+	}
+
+	var tag pgconn.CommandTag
+
+	err = cli.withPgConn(ctx, func(conn *pgconn.PgConn) error {
+		if value := conn.ParameterStatus(standardStrings); value != "on" {
+			return errors.Ctx().Str(standardStrings, value).Just(errNonStandardQuote)
+		}
+
+		// перевод строки закрывает комментарий -- в конце запроса
+		tag, err = conn.CopyTo(ctx, w, "COPY ("+sql+"\n) TO STDOUT "+options)
+
+		return err
+	})
+
+	return tag.RowsAffected(), err
+}
+
+// copyOptions - параметры инструкции COPY для формата выгрузки
+func copyOptions(format storage.CopyFormat) (string, error) {
+	if err := format.Validate(); err != nil {
+		return "", err
+	}
+
+	switch format.Kind {
+	case storage.CopyBinary:
+		return "(FORMAT binary)", nil
+	case storage.CopyTSV:
+		return textCopyOptions("text", format)
+	default:
+		return textCopyOptions("csv", format)
+	}
+}
+
+func textCopyOptions(kind string, format storage.CopyFormat) (string, error) {
+	delimiter := string(format.FieldDelimiter())
+	if len(delimiter) != 1 {
+		return "", errors.Ctx().Str("delimiter", delimiter).Str("reason", "delimiter must be a single-byte character").
+			Just(storage.ErrCopyFormat)
+	}
+
+	options := []string{
+		"FORMAT " + kind,
+		"DELIMITER " + quoteLiteral(delimiter),
+		"NULL " + quoteLiteral(format.NullString()),
+	}
+
+	if format.Header {
+		options = append(options, "HEADER true")
+	}
+
+	return "(" + strings.Join(options, ", ") + ")", nil
+}
+
+// inlineParams - подставляет параметры $n литералами, строки, идентификаторы в кавычках,
+// комментарии и dollar-quoting пропускаются
+func inlineParams(sql string, params []any) (string, error) {
+	if len(params) == 0 {
+		return sql, nil
+	}
+
+	var out strings.Builder
+
+	for i := 0; i < len(sql); i++ {
+		start := i
+
+		switch ch := sql[i]; {
+		case ch == '\'':
+			i = skipQuoted(sql, i, '\'', i > 0 && (sql[i-1] == 'E' || sql[i-1] == 'e'))
+		case ch == '"':
+			i = skipQuoted(sql, i, '"', false)
+		case ch == '-' && strings.HasPrefix(sql[i:], "--"):
+			if end := strings.IndexByte(sql[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(sql) - 1
+			}
+		case ch == '/' && strings.HasPrefix(sql[i:], "/*"):
+			if end := strings.Index(sql[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(sql) - 1
+			}
+		case ch == '$' && i+1 < len(sql) && isDigit(sql[i+1]):
+			end := i + 1
+			for end < len(sql) && isDigit(sql[end]) {
+				end++
+			}
+
+			n, _ := strconv.Atoi(sql[i+1 : end])
+			if n < 1 || n > len(params) {
+				return "", errors.Ctx().Int("index", n).Int("params", len(params)).Just(errParamIndex)
+			}
+
+			literal, err := quoteParam(params[n-1])
+			if err != nil {
+				return "", errors.Ctx().Int("index", n).Wrap(err, "quote parameter")
+			}
+
+			out.WriteString(literal)
+			i = end - 1
+
+			continue
+		case ch == '$':
+			i = skipDollarQuoted(sql, i)
+		}
+
+		out.WriteString(sql[start : i+1])
+	}
+
+	return out.String(), nil
+}
+
+// skipQuoted - индекс закрывающей кавычки, удвоенная кавычка и экранирование в E-строках пропускаются
+func skipQuoted(sql string, start int, quote byte, escapes bool) int {
+	for i := start + 1; i < len(sql); i++ {
+		switch {
+		case escapes && sql[i] == '\\':
+			i++
+		case sql[i] == quote && i+1 < len(sql) && sql[i+1] == quote:
+			i++
+		case sql[i] == quote:
+			return i
+		}
+	}
+
+	return len(sql) - 1
+}
+
+// skipDollarQuoted - индекс конца строки $tag$...$tag$, для одиночного $ - его индекс
+func skipDollarQuoted(sql string, start int) int {
+	end := strings.IndexByte(sql[start+1:], '$')
+	if end < 0 {
+		return start
+	}
+
+	tag := sql[start : start+end+2]
+
+	for _, ch := range tag[1 : len(tag)-1] {
+		if !(ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9') {
+			return start
+		}
+	}
+
+	if closing := strings.Index(sql[start+len(tag):], tag); closing >= 0 {
+		return start + len(tag) + closing + len(tag) - 1
+	}
+
+	return len(sql) - 1
+}
+
+// quoteParam - литерал значения параметра: числа и логические значения как есть, строки в кавычках,
+// остальные типы - текстовое представление pgtype с приведением к типу
+func quoteParam(param any) (string, error) {
+	if valuer, ok := param.(driver.Valuer); ok {
+		if val := reflect.ValueOf(param); val.Kind() == reflect.Ptr && val.IsNil() {
+			return "NULL", nil
+		}
+
+		value, err := valuer.Value()
+		if err != nil {
+			return "", err
+		}
+
+		param = value
+	}
+
+	val := reflect.ValueOf(param)
+
+	for val.Kind() == reflect.Ptr && !val.IsNil() {
+		val = val.Elem()
+	}
+
+	switch val.Kind() {
+	case reflect.Invalid:
+		return "NULL", nil
+	case reflect.Ptr:
+		return "NULL", nil
+	case reflect.String:
+		return quoteLiteral(val.String()), nil
+	case reflect.Bool:
+		return strconv.FormatBool(val.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(val.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(val.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		if f := val.Float(); math.IsNaN(f) || math.IsInf(f, 0) {
+			return quoteLiteral(strconv.FormatFloat(f, 'g', -1, 64)) + "::float8", nil
+		}
+
+		return strconv.FormatFloat(val.Float(), 'g', -1, 64), nil
+	}
+
+	connInfo := pgtype.NewConnInfo()
+
+	dataType, ok := connInfo.DataTypeForValue(val.Interface())
+	if !ok {
+		return "", errors.Ctx().Any("param", param).Just(errWrongParameters)
+	}
+
+	value := pgtype.NewValue(dataType.Value)
+	if err := value.Set(val.Interface()); err != nil {
+		return "", err
+	}
+
+	encoder, ok := value.(pgtype.TextEncoder)
+	if !ok {
+		return "", errors.Ctx().Str("type", dataType.Name).Just(errWrongParameters)
+	}
+
+	text, err := encoder.EncodeText(connInfo, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if text == nil {
+		return "NULL", nil
+	}
+
+	return quoteLiteral(string(text)) + "::" + dataType.Name, nil
+}
+
+// quoteLiteral - строковый литерал SQL, CopyTo проверяет, что на сервере включен
+// standard_conforming_strings
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
@@ -0,0 +1,32 @@
+package pg_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+func TestCopyTo(t *testing.T) {
+	s, srv := newStorage(t, "")
+
+	srv.Expect(`(?s)^COPY \(SELECT id, name FROM users WHERE name <> 'o''hara' -- users\n\) TO STDOUT \(FORMAT csv`).
+		WillCopyOut([]byte("1,alpha\n2,beta\n")).
+		WillReturnResult(2)
+
+	var buf bytes.Buffer
+
+	count, err := storage.CopyTo(context.Background(), s, storage.NewQuery(
+		"SELECT id, name FROM users WHERE name <> $1 -- users", "o'hara",
+	), &buf, storage.CopyFormat{})
+	if err != nil {
+		t.Fatalf("copy to: %s", errors.Formatted(err))
+	}
+
+	if count != 2 || buf.String() != "1,alpha\n2,beta\n" {
+		t.Fatalf("copy to: got %d rows %q", count, buf.String())
+	}
+}
@@ -0,0 +1,267 @@
+package pg
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1/internal/failover"
+)
+
+// defaultPort - порт узла PostgreSQL, если он не указан в списке узлов
+const defaultPort = "5432"
+
+// failoverState - выбор узла для DSN с несколькими узлами или с target_session_attrs.
+// Перебор узлов и проверку их роли при подключении выполняет pgconn, здесь узлы упорядочиваются
+// по состоянию, а после потери узла или смены его роли пул закрывает соединения прошлого поколения
+type failoverState struct {
+	target failover.Target
+	health *failover.Health
+
+	// hostsByIP - имя узла по адресу, в который оно было разрешено, для учета отказов по имени
+	hostsByIP sync.Map
+
+	mu          sync.Mutex
+	generations map[*pgx.Conn]uint64
+}
+
+// newFailover - включает выбор узла, если DSN содержит несколько узлов или требуемую роль узла
+func newFailover(dsn string, cfg *pgxpool.Config) (*failoverState, error) {
+	target, err := failover.ParseTarget(targetSessionAttrs(dsn))
+	if err != nil {
+		return nil, err
+	}
+
+	if target == failover.TargetAny && len(configHosts(&cfg.ConnConfig.Config)) < 2 {
+		return nil, nil
+	}
+
+	state := &failoverState{
+		target:      target,
+		health:      failover.NewHealth(failover.Cooldown),
+		generations: make(map[*pgx.Conn]uint64),
+	}
+
+	state.install(cfg)
+
+	return state, nil
+}
+
+// normalizeHosts - дополняет узлы DSN портом по умолчанию: pgconn разбирает DSN через url.Parse,
+// который не принимает список узлов с портом только у части из них
+func normalizeHosts(uri *url.URL) error {
+	if !strings.Contains(uri.Host, ",") {
+		return nil
+	}
+
+	hosts, err := failover.SplitHosts(uri.Host, defaultPort)
+	if err != nil {
+		return err
+	}
+
+	uri.Host = strings.Join(hosts, ",")
+
+	return nil
+}
+
+// targetSessionAttrs - требуемая роль узла из DSN в виде URL или ключ=значение, либо из окружения
+func targetSessionAttrs(dsn string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		if uri, err := url.Parse(dsn); err == nil && uri.Query().Has(failover.Param) {
+			return uri.Query().Get(failover.Param)
+		}
+	} else {
+		for _, field := range strings.Fields(dsn) {
+			if value, ok := strings.CutPrefix(field, failover.Param+"="); ok {
+				return strings.Trim(value, "'")
+			}
+		}
+	}
+
+	return os.Getenv("PGTARGETSESSIONATTRS")
+}
+
+// configHosts - различные узлы конфигурации подключения, включая запасные
+func configHosts(cfg *pgconn.Config) []string {
+	seen := map[string]struct{}{hostKey(cfg.Host, cfg.Port): {}}
+	hosts := []string{hostKey(cfg.Host, cfg.Port)}
+
+	for _, fallback := range cfg.Fallbacks {
+		key := hostKey(fallback.Host, fallback.Port)
+
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			hosts = append(hosts, key)
+		}
+	}
+
+	return hosts
+}
+
+func hostKey(host string, port uint16) string {
+	return net.JoinHostPort(host, strconv.Itoa(int(port)))
+}
+
+// install - устанавливает перехватчики подключения и выдачи соединений пула
+func (f *failoverState) install(cfg *pgxpool.Config) {
+	connCfg := &cfg.ConnConfig.Config
+
+	lookup := connCfg.LookupFunc
+	connCfg.LookupFunc = func(ctx context.Context, host string) ([]string, error) {
+		addrs, err := lookup(ctx, host)
+
+		for _, addr := range addrs {
+			f.hostsByIP.Store(addr, host)
+		}
+
+		return addrs, err
+	}
+
+	dial := connCfg.DialFunc
+	connCfg.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			f.health.Failure(f.addrKey(addr))
+		}
+
+		return conn, err
+	}
+
+	validate := connCfg.ValidateConnect
+	connCfg.ValidateConnect = func(ctx context.Context, pgConn *pgconn.PgConn) error {
+		var err error
+
+		if validate != nil {
+			err = validate(ctx, pgConn)
+		}
+
+		var notPreferred *pgconn.NotPreferredError
+
+		switch key := f.addrKey(pgConn.Conn().RemoteAddr().String()); {
+		case err == nil:
+			f.health.Success(key)
+		case !errors.As(err, &notPreferred):
+			f.health.Failure(key)
+		}
+
+		return err
+	}
+
+	cfg.BeforeConnect = func(_ context.Context, connCfg *pgx.ConnConfig) error {
+		f.reorder(&connCfg.Config)
+
+		return nil
+	}
+
+	cfg.AfterConnect = func(_ context.Context, conn *pgx.Conn) error {
+		f.track(conn)
+
+		return nil
+	}
+
+	cfg.BeforeAcquire = func(_ context.Context, conn *pgx.Conn) bool {
+		return f.usable(conn)
+	}
+}
+
+// addrKey - узел по адресу подключения, если адрес был получен разрешением имени узла
+func (f *failoverState) addrKey(addr string) string {
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	if host, ok := f.hostsByIP.Load(ip); ok {
+		return net.JoinHostPort(host.(string), port)
+	}
+
+	return addr
+}
+
+// reorder - упорядочивает основной и запасные узлы конфигурации по их состоянию,
+// варианты подключения одного узла (с TLS и без) остаются рядом и в исходном порядке
+func (f *failoverState) reorder(cfg *pgconn.Config) {
+	entries := append([]*pgconn.FallbackConfig{{
+		Host:      cfg.Host,
+		Port:      cfg.Port,
+		TLSConfig: cfg.TLSConfig,
+	}}, cfg.Fallbacks...)
+
+	hosts := configHosts(cfg)
+	rank := make(map[string]int, len(hosts))
+
+	for r, i := range f.health.Order(hosts) {
+		rank[hosts[i]] = r
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return rank[hostKey(entries[i].Host, entries[i].Port)] < rank[hostKey(entries[j].Host, entries[j].Port)]
+	})
+
+	cfg.Host, cfg.Port, cfg.TLSConfig = entries[0].Host, entries[0].Port, entries[0].TLSConfig
+	cfg.Fallbacks = entries[1:]
+}
+
+// track - запоминает поколение нового соединения, заодно забывая закрытые пулом соединения
+func (f *failoverState) track(conn *pgx.Conn) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for tracked := range f.generations {
+		if tracked.IsClosed() {
+			delete(f.generations, tracked)
+		}
+	}
+
+	f.generations[conn] = f.health.Generation()
+}
+
+// usable - соединение текущего поколения к узлу подходящей роли, иначе пул закрывает его
+func (f *failoverState) usable(conn *pgx.Conn) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	generation, ok := f.generations[conn]
+	if ok && generation == f.health.Generation() {
+		if role, known := roleOf(conn.PgConn()); !known || f.target.Accepts(role) {
+			return true
+		}
+	}
+
+	delete(f.generations, conn)
+
+	return false
+}
+
+// observe - начинает новое поколение соединений после потери узла или смены его роли
+func (f *failoverState) observe(err error) {
+	if f == nil || err == nil {
+		return
+	}
+
+	f.health.Observe(err)
+}
+
+// roleOf - роль узла по параметрам, о которых сервер сообщает при их изменении (PostgreSQL 14+),
+// для более старых версий роль неизвестна
+func roleOf(conn *pgconn.PgConn) (failover.Role, bool) {
+	standby := conn.ParameterStatus("in_hot_standby")
+	if standby == "" {
+		return failover.Role{}, false
+	}
+
+	return failover.Role{
+		Standby:  standby == "on",
+		ReadOnly: conn.ParameterStatus("default_transaction_read_only") == "on",
+	}, true
+}
@@ -0,0 +1,106 @@
+package pg_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+	"gopkg.in/gomisc/storage.v1/pg"
+	"gopkg.in/gomisc/storage.v1/pg/pgtest"
+)
+
+// readOnlyCheck - инструкция, которой pgconn проверяет узел для target_session_attrs=read-write
+const readOnlyCheck = "show transaction_read_only"
+
+// newNode - узел кластера, сообщающий свою роль при подключении и на проверку read-write
+func newNode(t *testing.T, standby bool) *pgtest.Server {
+	t.Helper()
+
+	srv := pgtest.NewServer(t)
+	value := map[bool]string{true: "on", false: "off"}[standby]
+
+	srv.SetParameter("in_hot_standby", value)
+	srv.SetParameter("default_transaction_read_only", value)
+
+	return srv
+}
+
+func expectReadOnlyCheck(srv *pgtest.Server, readOnly bool) {
+	value := map[bool]string{true: "on", false: "off"}[readOnly]
+
+	srv.Expect("^" + readOnlyCheck + "$").WillReturnRows(storage.Table{
+		Headers: []string{"transaction_read_only"},
+		Rows:    [][]any{{value}},
+	})
+}
+
+func TestFailoverReadWrite(t *testing.T) {
+	standby, primary := newNode(t, true), newNode(t, false)
+	ctx := context.Background()
+
+	expectReadOnlyCheck(standby, true)
+	expectReadOnlyCheck(primary, false)
+	primary.Expect(`^UPDATE users`).WillReturnResult(1)
+
+	// реплика указана первой, но не проходит проверку роли
+	s, err := pg.New(ctx, fmt.Sprintf(
+		"postgres://postgres@%s,%s/postgres?sslmode=disable&target_session_attrs=read-write",
+		standby.Addr(), primary.Addr(),
+	))
+	if err != nil {
+		t.Fatalf("connect: %s", errors.Formatted(err))
+	}
+
+	t.Cleanup(func() { _ = s.Close() })
+
+	if _, err = s.Exec(ctx, storage.NewQuery("UPDATE users SET name = 'alpha'")); err != nil {
+		t.Fatalf("exec: %s", errors.Formatted(err))
+	}
+
+	assertCalls(t, standby, readOnlyCheck)
+	assertCalls(t, primary, readOnlyCheck, "UPDATE users SET name = 'alpha'")
+}
+
+func TestFailoverReadOnlyViolation(t *testing.T) {
+	standby, primary := newNode(t, true), newNode(t, false)
+	ctx := context.Background()
+
+	expectReadOnlyCheck(standby, true)
+	// после отказа в записи пул открывает новое соединение и заново проверяет роль узла
+	expectReadOnlyCheck(primary, false)
+	primary.Expect(`^UPDATE users`).WillReturnError(&pgconn.PgError{
+		Severity: "ERROR",
+		Code:     "25006",
+		Message:  "cannot execute UPDATE in a read-only transaction",
+	})
+	expectReadOnlyCheck(primary, false)
+	primary.Expect(`^UPDATE users`).WillReturnResult(1)
+
+	s, err := pg.New(ctx, fmt.Sprintf(
+		"postgres://postgres@%s,%s/postgres?sslmode=disable&target_session_attrs=read-write&pool_max_conns=1",
+		standby.Addr(), primary.Addr(),
+	))
+	if err != nil {
+		t.Fatalf("connect: %s", errors.Formatted(err))
+	}
+
+	t.Cleanup(func() { _ = s.Close() })
+
+	query := storage.NewQuery("UPDATE users SET name = 'alpha'")
+
+	if _, err = s.Exec(ctx, query); !errors.Is(err, storage.ErrReadOnlyViolation) {
+		t.Fatalf("exec: got %v, want %v", err, storage.ErrReadOnlyViolation)
+	}
+
+	if _, err = s.Exec(ctx, query); err != nil {
+		t.Fatalf("exec after failover: %s", errors.Formatted(err))
+	}
+
+	// реплика недавно отказала и перебирается последней, поэтому повторно не проверяется
+	assertCalls(t, standby, readOnlyCheck)
+	assertCalls(t, primary, readOnlyCheck, query.String(), readOnlyCheck, query.String())
+}
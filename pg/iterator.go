@@ -9,16 +9,18 @@ import (
 	"gopkg.in/gomisc/storage.v1"
 )
 
-var _ storage.Iterator = (*postgresIterator)(nil)
+var _ storage.BatchIterator = (*postgresIterator)(nil)
 
 type postgresIterator struct {
 	rows    pgx.Rows
+	query   storage.Query
 	scanner *pgxscan.RowScanner
 }
 
-func newIterator(rows pgx.Rows) *postgresIterator {
+func newIterator(rows pgx.Rows, query storage.Query) *postgresIterator {
 	return &postgresIterator{
 		rows:    rows,
+		query:   query,
 		scanner: pgxscan.NewRowScanner(rows),
 	}
 }
@@ -34,13 +36,27 @@ func (iter *postgresIterator) Next(_ context.Context) bool {
 }
 
 func (iter *postgresIterator) Err() error {
-	return iter.rows.Err()
+	if err := iter.rows.Err(); err != nil {
+		return wrapPgErr(err, storage.OpIterate, iter.query, "iterate query result")
+	}
+
+	return nil
 }
 
 func (iter *postgresIterator) Decode(result any) error {
 	if err := iter.scanner.Scan(result); err != nil {
-		return wrapPgErr(err, "decode item result")
+		return wrapPgErr(err, storage.OpDecode, iter.query, "decode item result")
 	}
 
 	return nil
 }
+
+// NextBatch - имплементация storage.BatchIterator, строки декодируются сканером итератора напрямую
+func (iter *postgresIterator) NextBatch(_ context.Context, dest any, n int) (int, error) {
+	count, err := storage.DecodeBatch(dest, n, iter.rows.Next, iter.scanner.Scan)
+	if err != nil {
+		return count, wrapPgErr(err, storage.OpDecode, iter.query, "decode batch result")
+	}
+
+	return count, iter.Err()
+}
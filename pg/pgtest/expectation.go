@@ -0,0 +1,233 @@
+package pgtest
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgtype"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+type (
+	// Expectation - ожидаемая сервером инструкция и ответ на нее
+	Expectation struct {
+		pattern  *regexp.Regexp
+		args     []any
+		hasArgs  bool
+		table    *storage.Table
+		copyOut  []byte
+		oids     []uint32
+		tag      string
+		affected int64
+		err      *pgconn.PgError
+		delay    time.Duration
+		times    int
+		called   int
+		optional bool
+	}
+
+	// AnyArg - значение, совпадающее с любым параметром в WithArgs
+	AnyArg struct{}
+)
+
+// WithArgs - ожидает параметры инструкции расширенного протокола, AnyArg{} совпадает с любым значением.
+// Параметры приводятся к типам Go по OID и сравниваются с args после приведения чисел к int64/float64.
+// В простом протоколе параметры подставляются в текст запроса и проверяются шаблоном Expect
+func (exp *Expectation) WithArgs(args ...any) *Expectation {
+	exp.args = args
+	exp.hasArgs = true
+
+	return exp
+}
+
+// WillReturnRows - задает строки результата, OID колонок определяются по типам значений первой строки
+func (exp *Expectation) WillReturnRows(table storage.Table) *Expectation {
+	exp.table = &table
+
+	return exp
+}
+
+// WillCopyOut - задает данные, которые сервер передает в ответ на COPY ... TO STDOUT как есть,
+// количество выгруженных строк в теге завершения задает WillReturnResult
+func (exp *Expectation) WillCopyOut(data []byte) *Expectation {
+	exp.copyOut = data
+
+	return exp
+}
+
+// WithTypes - задает OID колонок результата явно
+func (exp *Expectation) WithTypes(oids ...uint32) *Expectation {
+	exp.oids = oids
+
+	return exp
+}
+
+// WillReturnResult - задает количество затронутых строк в теге завершения команды
+func (exp *Expectation) WillReturnResult(rowsAffected int64) *Expectation {
+	exp.affected = rowsAffected
+
+	return exp
+}
+
+// WillReturnTag - задает тег завершения команды как есть, например "INSERT 0 3"
+func (exp *Expectation) WillReturnTag(tag string) *Expectation {
+	exp.tag = tag
+
+	return exp
+}
+
+// WillReturnError - задает ошибку сервера: SQLSTATE, сообщение, имена ограничения, таблицы и колонки
+func (exp *Expectation) WillReturnError(pgErr *pgconn.PgError) *Expectation {
+	exp.err = pgErr
+
+	return exp
+}
+
+// WillDelay - задерживает ответ сервера, используется для проверки отмены запросов по контексту
+func (exp *Expectation) WillDelay(delay time.Duration) *Expectation {
+	exp.delay = delay
+
+	return exp
+}
+
+// Times - задает количество инструкций, которым соответствует ожидание
+func (exp *Expectation) Times(n int) *Expectation {
+	exp.times = n
+
+	return exp
+}
+
+// Maybe - помечает ожидание необязательным: невызванное, оно не считается невыполненным
+func (exp *Expectation) Maybe() *Expectation {
+	exp.optional = true
+
+	return exp
+}
+
+func (exp *Expectation) String() string {
+	return fmt.Sprintf("%q called %d of %d times", exp.pattern.String(), exp.called, exp.times)
+}
+
+func (exp *Expectation) matches(sql string, args []any, extended bool) bool {
+	if !exp.pattern.MatchString(sql) {
+		return false
+	}
+
+	if !exp.hasArgs {
+		return true
+	}
+
+	if !extended || len(args) != len(exp.args) {
+		return false
+	}
+
+	for i := range args {
+		if _, isAny := exp.args[i].(AnyArg); isAny {
+			continue
+		}
+
+		if !reflect.DeepEqual(normalizeArg(args[i]), normalizeArg(exp.args[i])) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// paramOID - OID параметра, ожидаемого на позиции i, по типу значения в WithArgs
+func (exp *Expectation) paramOID(i int) uint32 {
+	if i < len(exp.args) {
+		if _, isAny := exp.args[i].(AnyArg); !isAny && exp.args[i] != nil {
+			return oidOf(exp.args[i])
+		}
+	}
+
+	return pgtype.TextOID
+}
+
+// columnOIDs - OID колонок результата: заданные явно или определенные по первому непустому значению
+func (exp *Expectation) columnOIDs() []uint32 {
+	oids := make([]uint32, len(exp.table.Headers))
+
+	for i := range oids {
+		if i < len(exp.oids) {
+			oids[i] = exp.oids[i]
+
+			continue
+		}
+
+		oids[i] = pgtype.TextOID
+
+		for _, row := range exp.table.Rows {
+			if i < len(row) && row[i] != nil {
+				oids[i] = oidOf(row[i])
+
+				break
+			}
+		}
+	}
+
+	return oids
+}
+
+func (exp *Expectation) commandTag(sql string) string {
+	if exp.tag != "" {
+		return exp.tag
+	}
+
+	affected := exp.affected
+	if exp.table != nil {
+		affected = int64(len(exp.table.Rows))
+	}
+
+	return commandTag(sql, affected)
+}
+
+// commandTag - тег завершения команды по первому слову инструкции
+func commandTag(sql string, affected int64) string {
+	verb := strings.ToUpper(firstWord(sql))
+	count := strconv.FormatInt(affected, 10)
+
+	switch verb {
+	case "INSERT":
+		return verb + " 0 " + count
+	case "SELECT", "UPDATE", "DELETE", "MERGE", "FETCH", "MOVE", "COPY":
+		return verb + " " + count
+	default:
+		return verb
+	}
+}
+
+func firstWord(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	return strings.TrimRight(fields[0], ";")
+}
+
+func normalizeArg(arg any) any {
+	val := reflect.ValueOf(arg)
+
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return val.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(val.Uint())
+	case reflect.Float32, reflect.Float64:
+		return val.Float()
+	default:
+		if t, ok := arg.(time.Time); ok {
+			return t.UTC()
+		}
+
+		return arg
+	}
+}
@@ -0,0 +1,312 @@
+// Package pgtest - сервер, говорящий на протоколе PostgreSQL (простой и расширенный запрос,
+// COPY FROM STDIN и TO STDOUT), для тестирования драйвера pg без установленного PostgreSQL.
+// Ответы на инструкции задаются ожиданиями: описание и строки результата, тег завершения
+// или ошибка с кодом SQLSTATE
+package pgtest
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgproto3/v2"
+	"gopkg.in/gomisc/errors.v1"
+)
+
+const (
+	ErrUnmetExpectations  = errors.Const("pgtest expectations were not met")
+	ErrUnsupportedFormat  = errors.Const("unsupported value format")
+	ErrUnsupportedMessage = errors.Const("unsupported frontend message")
+
+	// codeUnexpected - SQLSTATE ответа на инструкцию без ожидания (internal_error)
+	codeUnexpected = "XX000"
+	// codeTxAborted - SQLSTATE инструкции в прерванной транзакции (in_failed_sql_transaction)
+	codeTxAborted = "25P02"
+	// codeQueryCanceled - SQLSTATE прерванной клиентом передачи COPY (query_canceled)
+	codeQueryCanceled = "57014"
+
+	listenAddr = "127.0.0.1:0"
+)
+
+type (
+	// TestingT - подмножество testing.TB, используемое сервером
+	TestingT interface {
+		Helper()
+		Errorf(format string, args ...any)
+		Cleanup(fn func())
+	}
+
+	// Server - сервер протокола PostgreSQL, отвечающий по ожиданиям
+	Server struct {
+		listener net.Listener
+
+		mu           sync.Mutex
+		wg           sync.WaitGroup
+		expectations []*Expectation
+		calls        []Call
+		unexpected   []Call
+		failures     []error
+		conns        map[net.Conn]struct{}
+		params       map[string]string
+		pid          uint32
+	}
+
+	// Call - инструкция, полученная сервером
+	Call struct {
+		// SQL - текст инструкции
+		SQL string
+		// Args - параметры инструкции расширенного протокола
+		Args []any
+		// Extended - инструкция получена по расширенному протоколу
+		Extended bool
+		// Statement - имя подготовленного запроса расширенного протокола, пустое для безымянного
+		Statement string
+		// Data - данные, переданные клиентом инструкции COPY FROM STDIN
+		Data []byte
+		// Err - ошибка, которую вернул сервер
+		Err *pgconn.PgError
+	}
+)
+
+// NewServer - запускает сервер на локальном порту, по завершении теста останавливает его
+// и сообщает о невыполненных ожиданиях, неожиданных инструкциях и ошибках протокола
+func NewServer(t TestingT) *Server {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		t.Errorf("%s", errors.Formatted(errors.Wrap(err, "listen pgtest server")))
+
+		return &Server{}
+	}
+
+	s := &Server{
+		listener: listener,
+		conns:    make(map[net.Conn]struct{}),
+	}
+
+	s.wg.Add(1)
+
+	go s.serve()
+
+	t.Cleanup(func() {
+		t.Helper()
+
+		s.Close()
+
+		if err := s.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s", errors.Formatted(err))
+		}
+	})
+
+	return s
+}
+
+// Addr - адрес, на котором слушает сервер
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// DSN - строка подключения к серверу для pg.New
+func (s *Server) DSN() string {
+	return fmt.Sprintf("postgres://postgres@%s/postgres?sslmode=disable", s.Addr())
+}
+
+// Expect - ожидает инструкцию, текст которой совпадает с регулярным выражением.
+// Инструкции управления транзакциями без ожиданий обрабатываются сервером самостоятельно
+func (s *Server) Expect(pattern string) *Expectation {
+	exp := &Expectation{pattern: regexp.MustCompile(pattern), times: 1}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expectations = append(s.expectations, exp)
+
+	return exp
+}
+
+// SetParameter - задает параметр сервера, о котором сообщается клиенту при подключении,
+// например in_hot_standby для проверки выбора узла по роли. Действует на новые соединения
+func (s *Server) SetParameter(name, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.params == nil {
+		s.params = make(map[string]string)
+	}
+
+	s.params[name] = value
+}
+
+// Calls - возвращает полученные инструкции в порядке поступления
+func (s *Server) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Call(nil), s.calls...)
+}
+
+// ExpectationsWereMet - проверяет, что все ожидания выполнены, неожиданных инструкций
+// и ошибок протокола не было
+func (s *Server) ExpectationsWereMet() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var unmet, unexpected, failures []string
+
+	for _, exp := range s.expectations {
+		if !exp.optional && exp.called < exp.times {
+			unmet = append(unmet, exp.String())
+		}
+	}
+
+	for _, call := range s.unexpected {
+		unexpected = append(unexpected, fmt.Sprintf("%q %v", call.SQL, call.Args))
+	}
+
+	for _, err := range s.failures {
+		failures = append(failures, err.Error())
+	}
+
+	if len(unmet) == 0 && len(unexpected) == 0 && len(failures) == 0 {
+		return nil
+	}
+
+	return errors.Ctx().
+		Strings("unmet", unmet).
+		Strings("unexpected", unexpected).
+		Strings("failures", failures).
+		Just(ErrUnmetExpectations)
+}
+
+// Close - останавливает сервер и закрывает открытые соединения
+func (s *Server) Close() {
+	if s.listener == nil {
+		return
+	}
+
+	_ = s.listener.Close()
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// parameters - параметры сервера по умолчанию с учетом заданных SetParameter
+func (s *Server) parameters() []pgproto3.ParameterStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	params := make([]pgproto3.ParameterStatus, 0, len(serverParams)+len(s.params))
+	defaults := make(map[string]struct{}, len(serverParams))
+	names := make([]string, 0, len(s.params))
+
+	for _, param := range serverParams {
+		if value, ok := s.params[param.Name]; ok {
+			param.Value = value
+		}
+
+		defaults[param.Name] = struct{}{}
+		params = append(params, param)
+	}
+
+	for name := range s.params {
+		if _, ok := defaults[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		params = append(params, pgproto3.ParameterStatus{Name: name, Value: s.params[name]})
+	}
+
+	return params
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.pid++
+		pid := s.pid
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+
+		go func() {
+			defer s.wg.Done()
+
+			newSession(s, conn, pid).run()
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+
+			_ = conn.Close()
+		}()
+	}
+}
+
+// peek - находит ожидание для описания инструкции, не отмечая его вызов
+func (s *Server) peek(sql string) *Expectation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, exp := range s.expectations {
+		if exp.called < exp.times && exp.pattern.MatchString(sql) {
+			return exp
+		}
+	}
+
+	return nil
+}
+
+// match - находит первое ожидание, которому соответствует инструкция, и отмечает его вызов
+func (s *Server) match(sql string, args []any, extended bool) *Expectation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, exp := range s.expectations {
+		if exp.called < exp.times && exp.matches(sql, args, extended) {
+			exp.called++
+
+			return exp
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) record(call Call, unexpected bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls = append(s.calls, call)
+
+	if unexpected {
+		s.unexpected = append(s.unexpected, call)
+	}
+}
+
+func (s *Server) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = append(s.failures, err)
+}
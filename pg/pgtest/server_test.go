@@ -0,0 +1,197 @@
+package pgtest_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgtype"
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+	"gopkg.in/gomisc/storage.v1/pg/pgtest"
+)
+
+// recorder - TestingT, собирающий ошибки сервера для проверки
+type recorder struct {
+	errors   []string
+	cleanups []func()
+}
+
+var usersTable = storage.Table{
+	Headers: []string{"id", "name"},
+	Rows:    [][]any{{int64(1), "alpha"}, {int64(2), "beta"}},
+}
+
+func connect(t *testing.T, srv *pgtest.Server) *pgconn.PgConn {
+	t.Helper()
+
+	conn, err := pgconn.Connect(context.Background(), srv.DSN())
+	if err != nil {
+		t.Fatalf("connect: %s", errors.Formatted(err))
+	}
+
+	t.Cleanup(func() { _ = conn.Close(context.Background()) })
+
+	return conn
+}
+
+func TestSimpleProtocol(t *testing.T) {
+	srv := pgtest.NewServer(t)
+	conn := connect(t, srv)
+	ctx := context.Background()
+
+	srv.Expect(`^select id, name from users$`).WillReturnRows(usersTable)
+	srv.Expect(`^update users`).WillReturnResult(3)
+
+	results, err := conn.Exec(ctx, "select id, name from users; update users set name = ''").ReadAll()
+	if err != nil {
+		t.Fatalf("exec: %s", errors.Formatted(err))
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("results: got %d, want 2", len(results))
+	}
+
+	if got, want := textRows(results[0].Rows), [][]string{{"1", "alpha"}, {"2", "beta"}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("rows: got %v, want %v", got, want)
+	}
+
+	if tag := results[0].CommandTag.String(); tag != "SELECT 2" {
+		t.Fatalf("select tag: got %q, want %q", tag, "SELECT 2")
+	}
+
+	if affected := results[1].CommandTag.RowsAffected(); affected != 3 {
+		t.Fatalf("rows affected: got %d, want 3", affected)
+	}
+
+	for _, call := range srv.Calls() {
+		if call.Extended {
+			t.Fatalf("call %q: got extended protocol, want simple", call.SQL)
+		}
+	}
+}
+
+func TestExtendedProtocol(t *testing.T) {
+	srv := pgtest.NewServer(t)
+	conn := connect(t, srv)
+	ctx := context.Background()
+
+	srv.Expect(`^select name from users where id = \$1$`).
+		WithArgs(int64(2)).
+		WillReturnRows(storage.Table{Headers: []string{"name"}, Rows: [][]any{{"beta"}}})
+
+	res := conn.ExecParams(ctx,
+		"select name from users where id = $1",
+		[][]byte{[]byte("2")}, []uint32{pgtype.Int8OID}, nil, nil,
+	).Read()
+	if res.Err != nil {
+		t.Fatalf("exec params: %s", errors.Formatted(res.Err))
+	}
+
+	if got, want := textRows(res.Rows), [][]string{{"beta"}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("rows: got %v, want %v", got, want)
+	}
+
+	calls := srv.Calls()
+	if len(calls) != 1 || !calls[0].Extended || !reflect.DeepEqual(calls[0].Args, []any{int64(2)}) {
+		t.Fatalf("calls: got %+v, want one extended call with args [2]", calls)
+	}
+}
+
+func TestTransactionStatus(t *testing.T) {
+	srv := pgtest.NewServer(t)
+	conn := connect(t, srv)
+	ctx := context.Background()
+
+	srv.Expect(`^insert`).WillReturnError(&pgconn.PgError{Code: "23505", Message: "duplicate key"})
+
+	steps := []struct {
+		sql    string
+		code   string
+		status byte
+	}{
+		{sql: "begin", status: 'T'},
+		{sql: "insert into users values (1)", code: "23505", status: 'E'},
+		{sql: "select 1", code: "25P02", status: 'E'},
+		{sql: "rollback", status: 'I'},
+	}
+
+	for _, step := range steps {
+		_, err := conn.Exec(ctx, step.sql).ReadAll()
+
+		var pgErr *pgconn.PgError
+
+		switch {
+		case step.code == "" && err != nil:
+			t.Fatalf("%s: %s", step.sql, errors.Formatted(err))
+		case step.code != "" && (!errors.As(err, &pgErr) || pgErr.Code != step.code):
+			t.Fatalf("%s: got %v, want SQLSTATE %s", step.sql, err, step.code)
+		}
+
+		if status := conn.TxStatus(); status != step.status {
+			t.Fatalf("%s: got transaction status %c, want %c", step.sql, status, step.status)
+		}
+	}
+}
+
+func TestUnmetExpectations(t *testing.T) {
+	rec := &recorder{}
+	srv := pgtest.NewServer(rec)
+	conn := connect(t, srv)
+
+	srv.Expect(`^select 1$`)
+	srv.Expect(`^select 2$`).Maybe()
+
+	// параметр не совпадает с ожиданием: инструкция неожиданная
+	srv.Expect(`^select \$1::int8$`).WithArgs(int64(1))
+
+	res := conn.ExecParams(context.Background(), "select $1::int8", [][]byte{[]byte("5")}, []uint32{pgtype.Int8OID}, nil, nil).Read()
+	if res.Err == nil {
+		t.Fatalf("unexpected statement: want error")
+	}
+
+	rec.cleanup()
+
+	if len(rec.errors) != 1 {
+		t.Fatalf("errors: got %q, want one unmet expectations error", rec.errors)
+	}
+
+	if err := srv.ExpectationsWereMet(); !errors.Is(err, pgtest.ErrUnmetExpectations) {
+		t.Fatalf("expectations: got %v, want %v", err, pgtest.ErrUnmetExpectations)
+	}
+}
+
+func (rec *recorder) Helper() {}
+
+func (rec *recorder) Errorf(format string, args ...any) {
+	rec.errors = append(rec.errors, fmt.Sprintf(format, args...))
+}
+
+func (rec *recorder) Cleanup(fn func()) {
+	rec.cleanups = append(rec.cleanups, fn)
+}
+
+func (rec *recorder) cleanup() {
+	for i := len(rec.cleanups) - 1; i >= 0; i-- {
+		rec.cleanups[i]()
+	}
+}
+
+func textRows(rows [][][]byte) [][]string {
+	out := make([][]string, 0, len(rows))
+
+	for _, row := range rows {
+		values := make([]string, 0, len(row))
+
+		for _, value := range row {
+			values = append(values, string(value))
+		}
+
+		out = append(out, values)
+	}
+
+	return out
+}
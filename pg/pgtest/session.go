@@ -0,0 +1,601 @@
+package pgtest
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgproto3/v2"
+	"github.com/jackc/pgtype"
+	"gopkg.in/gomisc/errors.v1"
+)
+
+// Статусы транзакции в сообщении ReadyForQuery
+const (
+	txIdle   byte = 'I'
+	txActive byte = 'T'
+	txFailed byte = 'E'
+)
+
+const errConnClosed = errors.Const("client connection closed")
+
+var (
+	// serverParams - параметры сервера, сообщаемые клиенту после аутентификации
+	serverParams = []pgproto3.ParameterStatus{
+		{Name: "server_version", Value: "14.0"},
+		{Name: "server_encoding", Value: "UTF8"},
+		{Name: "client_encoding", Value: "UTF8"},
+		{Name: "DateStyle", Value: "ISO, MDY"},
+		{Name: "TimeZone", Value: "UTC"},
+		{Name: "integer_datetimes", Value: "on"},
+		{Name: "standard_conforming_strings", Value: "on"},
+	}
+
+	placeholderRe = regexp.MustCompile(`\$(\d+)`)
+	copyInRe      = regexp.MustCompile(`(?is)^\s*COPY\b.*\bFROM\s+STDIN\b`)
+)
+
+type (
+	// session - обслуживание одного клиентского соединения
+	session struct {
+		server  *Server
+		conn    net.Conn
+		backend *pgproto3.Backend
+		pid     uint32
+		status  byte
+		stmts   map[string]*statement
+		portals map[string]*portal
+		// skip - после ошибки в расширенном протоколе сообщения пропускаются до Sync
+		skip bool
+		// copyData - данные COPY FROM STDIN обрабатываемой инструкции
+		copyData []byte
+	}
+
+	statement struct {
+		name      string
+		sql       string
+		paramOIDs []uint32
+	}
+
+	portal struct {
+		stmt    *statement
+		args    []any
+		formats []int16
+	}
+)
+
+func newSession(s *Server, conn net.Conn, pid uint32) *session {
+	return &session{
+		server:  s,
+		conn:    conn,
+		backend: pgproto3.NewBackend(pgproto3.NewChunkReader(conn), conn),
+		pid:     pid,
+		status:  txIdle,
+		stmts:   make(map[string]*statement),
+		portals: make(map[string]*portal),
+	}
+}
+
+func (sess *session) run() {
+	if !sess.startup() {
+		return
+	}
+
+	for {
+		msg, err := sess.backend.Receive()
+		if err != nil {
+			return
+		}
+
+		if _, ok := msg.(*pgproto3.Terminate); ok {
+			return
+		}
+
+		if err = sess.handle(msg); err != nil {
+			if !errors.Is(err, errConnClosed) {
+				sess.server.fail(err)
+			}
+
+			return
+		}
+	}
+}
+
+// startup - обрабатывает согласование шифрования и стартовое сообщение, аутентификация не требуется
+func (sess *session) startup() bool {
+	for {
+		msg, err := sess.backend.ReceiveStartupMessage()
+		if err != nil {
+			return false
+		}
+
+		switch msg.(type) {
+		case *pgproto3.SSLRequest, *pgproto3.GSSEncRequest:
+			if _, err = sess.conn.Write([]byte{'N'}); err != nil {
+				return false
+			}
+		case *pgproto3.StartupMessage:
+			msgs := []pgproto3.BackendMessage{&pgproto3.AuthenticationOk{}}
+
+			params := sess.server.parameters()
+
+			for i := range params {
+				msgs = append(msgs, &params[i])
+			}
+
+			msgs = append(msgs,
+				&pgproto3.BackendKeyData{ProcessID: sess.pid, SecretKey: sess.pid},
+				&pgproto3.ReadyForQuery{TxStatus: txIdle},
+			)
+
+			return sess.send(msgs...) == nil
+		default:
+			// CancelRequest: ответы сервера не прерываются, соединение просто закрывается
+			return false
+		}
+	}
+}
+
+func (sess *session) handle(msg pgproto3.FrontendMessage) error {
+	if _, isSync := msg.(*pgproto3.Sync); !isSync && sess.skip {
+		return nil
+	}
+
+	switch msg := msg.(type) {
+	case *pgproto3.Query:
+		return sess.simpleQuery(msg.String)
+	case *pgproto3.Parse:
+		return sess.parse(msg)
+	case *pgproto3.Bind:
+		return sess.bind(msg)
+	case *pgproto3.Describe:
+		return sess.describe(msg)
+	case *pgproto3.Execute:
+		return sess.execute(msg)
+	case *pgproto3.Close:
+		if msg.ObjectType == 'S' {
+			delete(sess.stmts, msg.Name)
+		} else {
+			delete(sess.portals, msg.Name)
+		}
+
+		return sess.send(&pgproto3.CloseComplete{})
+	case *pgproto3.Sync:
+		sess.skip = false
+		delete(sess.portals, "")
+
+		return sess.send(&pgproto3.ReadyForQuery{TxStatus: sess.status})
+	case *pgproto3.Flush:
+		return nil
+	default:
+		return errors.Ctx().Str("message", fmt.Sprintf("%T", msg)).Just(ErrUnsupportedMessage)
+	}
+}
+
+func (sess *session) simpleQuery(sql string) error {
+	if strings.TrimSpace(sql) == "" {
+		return sess.send(&pgproto3.EmptyQueryResponse{}, &pgproto3.ReadyForQuery{TxStatus: sess.status})
+	}
+
+	var msgs []pgproto3.BackendMessage
+
+	// инструкции многооператорного запроса выполняются по очереди до первой ошибки
+	for _, stmt := range splitStatements(sql) {
+		if isCopyIn(stmt) {
+			data, failMsgs, err := sess.receiveCopy(stmt)
+			if err != nil {
+				return err
+			}
+
+			if failMsgs != nil {
+				msgs = append(msgs, failMsgs...)
+
+				break
+			}
+
+			sess.copyData = data
+		}
+
+		stmtMsgs, err := sess.respond(Call{SQL: stmt}, nil, true)
+		sess.copyData = nil
+
+		if err != nil {
+			return err
+		}
+
+		msgs = append(msgs, stmtMsgs...)
+
+		if _, failed := stmtMsgs[len(stmtMsgs)-1].(*pgproto3.ErrorResponse); failed {
+			break
+		}
+	}
+
+	return sess.send(append(msgs, &pgproto3.ReadyForQuery{TxStatus: sess.status})...)
+}
+
+// receiveCopy - принимает данные COPY FROM STDIN до CopyDone. Если клиент прервал передачу
+// сообщением CopyFail, возвращает сообщения ответа об ошибке
+func (sess *session) receiveCopy(sql string) ([]byte, []pgproto3.BackendMessage, error) {
+	var format byte
+
+	if strings.Contains(strings.ToUpper(sql), "BINARY") {
+		format = 1
+	}
+
+	if err := sess.send(&pgproto3.CopyInResponse{OverallFormat: format}); err != nil {
+		return nil, nil, err
+	}
+
+	var data []byte
+
+	for {
+		msg, err := sess.backend.Receive()
+		if err != nil {
+			return nil, nil, errConnClosed
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto3.CopyData:
+			data = append(data, msg.Data...)
+		case *pgproto3.CopyDone:
+			return data, nil, nil
+		case *pgproto3.CopyFail:
+			call := Call{SQL: sql, Data: data, Err: &pgconn.PgError{
+				Code:    codeQueryCanceled,
+				Message: "COPY from stdin failed: " + msg.Message,
+			}}
+			sess.server.record(call, false)
+
+			return nil, sess.errorMessages(call.Err), nil
+		case *pgproto3.Flush, *pgproto3.Sync:
+		default:
+			return nil, nil, errors.Ctx().Str("message", fmt.Sprintf("%T", msg)).Just(ErrUnsupportedMessage)
+		}
+	}
+}
+
+func (sess *session) parse(msg *pgproto3.Parse) error {
+	stmt := &statement{name: msg.Name, sql: msg.Query, paramOIDs: make([]uint32, countParams(msg.Query))}
+	exp := sess.server.peek(msg.Query)
+
+	for i := range stmt.paramOIDs {
+		switch {
+		case i < len(msg.ParameterOIDs) && msg.ParameterOIDs[i] != 0:
+			stmt.paramOIDs[i] = msg.ParameterOIDs[i]
+		case exp != nil:
+			stmt.paramOIDs[i] = exp.paramOID(i)
+		default:
+			stmt.paramOIDs[i] = pgtype.TextOID
+		}
+	}
+
+	sess.stmts[msg.Name] = stmt
+
+	return sess.send(&pgproto3.ParseComplete{})
+}
+
+func (sess *session) bind(msg *pgproto3.Bind) error {
+	stmt, ok := sess.stmts[msg.PreparedStatement]
+	if !ok {
+		return sess.fail(&pgconn.PgError{Code: "26000", Message: "prepared statement does not exist"})
+	}
+
+	args := make([]any, len(msg.Parameters))
+
+	for i, param := range msg.Parameters {
+		oid := uint32(0)
+		if i < len(stmt.paramOIDs) {
+			oid = stmt.paramOIDs[i]
+		}
+
+		arg, err := decodeValue(oid, formatFor(msg.ParameterFormatCodes, i), param)
+		if err != nil {
+			return sess.fail(&pgconn.PgError{Code: "22P02", Message: err.Error()})
+		}
+
+		args[i] = arg
+	}
+
+	sess.portals[msg.DestinationPortal] = &portal{stmt: stmt, args: args, formats: msg.ResultFormatCodes}
+
+	return sess.send(&pgproto3.BindComplete{})
+}
+
+func (sess *session) describe(msg *pgproto3.Describe) error {
+	var (
+		sql     string
+		formats []int16
+		msgs    []pgproto3.BackendMessage
+	)
+
+	if msg.ObjectType == 'S' {
+		stmt, ok := sess.stmts[msg.Name]
+		if !ok {
+			return sess.fail(&pgconn.PgError{Code: "26000", Message: "prepared statement does not exist"})
+		}
+
+		sql = stmt.sql
+		msgs = append(msgs, &pgproto3.ParameterDescription{ParameterOIDs: stmt.paramOIDs})
+	} else {
+		p, ok := sess.portals[msg.Name]
+		if !ok {
+			return sess.fail(&pgconn.PgError{Code: "34000", Message: "portal does not exist"})
+		}
+
+		sql, formats = p.stmt.sql, p.formats
+	}
+
+	if exp := sess.server.peek(sql); exp != nil && exp.table != nil {
+		msgs = append(msgs, rowDescription(exp, formats))
+	} else {
+		msgs = append(msgs, &pgproto3.NoData{})
+	}
+
+	return sess.send(msgs...)
+}
+
+func (sess *session) execute(msg *pgproto3.Execute) error {
+	p, ok := sess.portals[msg.Portal]
+	if !ok {
+		return sess.fail(&pgconn.PgError{Code: "34000", Message: "portal does not exist"})
+	}
+
+	msgs, err := sess.respond(Call{SQL: p.stmt.sql, Args: p.args, Extended: true, Statement: p.stmt.name}, p.formats, false)
+	if err != nil {
+		return err
+	}
+
+	if _, failed := msgs[len(msgs)-1].(*pgproto3.ErrorResponse); failed {
+		sess.skip = true
+	}
+
+	return sess.send(msgs...)
+}
+
+// respond - формирует ответ на инструкцию по ожиданию или встроенной обработке транзакций
+func (sess *session) respond(call Call, formats []int16, describe bool) ([]pgproto3.BackendMessage, error) {
+	sql := call.SQL
+	call.Data = sess.copyData
+	exp := sess.server.match(sql, call.Args, call.Extended)
+
+	switch {
+	case exp != nil:
+		if exp.delay > 0 {
+			time.Sleep(exp.delay)
+		}
+	case sess.status == txFailed && !isTxEnd(sql):
+		call.Err = &pgconn.PgError{
+			Code:    codeTxAborted,
+			Message: "current transaction is aborted, commands ignored until end of transaction block",
+		}
+	case isTxControl(sql):
+		tag := sess.txControl(sql)
+		sess.server.record(call, false)
+
+		return []pgproto3.BackendMessage{&pgproto3.CommandComplete{CommandTag: []byte(tag)}}, nil
+	case isDeallocate(sql):
+		sess.deallocate(sql)
+		sess.server.record(call, false)
+
+		return []pgproto3.BackendMessage{&pgproto3.CommandComplete{CommandTag: []byte("DEALLOCATE")}}, nil
+	default:
+		call.Err = &pgconn.PgError{Code: codeUnexpected, Message: "pgtest: unexpected statement: " + sql}
+		sess.server.record(call, true)
+
+		return sess.errorMessages(call.Err), nil
+	}
+
+	if exp != nil && exp.err != nil {
+		call.Err = exp.err
+	}
+
+	sess.server.record(call, false)
+
+	if call.Err != nil && isTxEnd(sql) {
+		// завершение транзакции с ошибкой все равно закрывает транзакцию
+		sess.status = txIdle
+
+		return []pgproto3.BackendMessage{errorResponse(call.Err)}, nil
+	}
+
+	if call.Err != nil {
+		return sess.errorMessages(call.Err), nil
+	}
+
+	var msgs []pgproto3.BackendMessage
+
+	if exp.copyOut != nil {
+		msgs = append(msgs, copyOutMessages(exp.copyOut)...)
+	}
+
+	if exp.table != nil {
+		if describe {
+			msgs = append(msgs, rowDescription(exp, formats))
+		}
+
+		rows, err := dataRows(exp, formats)
+		if err != nil {
+			return nil, errors.Ctx().Str("sql", sql).Wrap(err, "encode data rows")
+		}
+
+		for _, row := range rows {
+			msgs = append(msgs, row)
+		}
+	}
+
+	if isTxControl(sql) {
+		sess.txControl(sql)
+	}
+
+	return append(msgs, &pgproto3.CommandComplete{CommandTag: []byte(exp.commandTag(sql))}), nil
+}
+
+// txControl - изменяет статус транзакции по инструкции управления и возвращает тег завершения
+func (sess *session) txControl(sql string) string {
+	words := strings.Fields(strings.ToUpper(sql))
+	verb := strings.TrimRight(words[0], ";")
+
+	switch verb {
+	case "BEGIN", "START":
+		sess.status = txActive
+
+		return "BEGIN"
+	case "COMMIT", "END":
+		failed := sess.status == txFailed
+		sess.status = txIdle
+
+		if failed {
+			return "ROLLBACK"
+		}
+
+		return "COMMIT"
+	case "ROLLBACK", "ABORT":
+		if len(words) > 1 && words[1] == "TO" {
+			sess.status = txActive
+		} else {
+			sess.status = txIdle
+		}
+
+		return "ROLLBACK"
+	default:
+		return verb
+	}
+}
+
+func (sess *session) errorMessages(pgErr *pgconn.PgError) []pgproto3.BackendMessage {
+	if sess.status == txActive {
+		sess.status = txFailed
+	}
+
+	return []pgproto3.BackendMessage{errorResponse(pgErr)}
+}
+
+// fail - отвечает ошибкой на сообщение расширенного протокола и пропускает сообщения до Sync
+func (sess *session) fail(pgErr *pgconn.PgError) error {
+	sess.skip = true
+
+	return sess.send(sess.errorMessages(pgErr)...)
+}
+
+func (sess *session) send(msgs ...pgproto3.BackendMessage) error {
+	var buf []byte
+
+	for _, msg := range msgs {
+		buf = msg.Encode(buf)
+	}
+
+	if _, err := sess.conn.Write(buf); err != nil {
+		// клиент закрыл соединение, например при отмене запроса по контексту
+		return errConnClosed
+	}
+
+	return nil
+}
+
+// splitStatements - разбивает текст простого запроса на инструкции по точкам с запятой
+// вне строковых литералов и идентификаторов в кавычках
+func splitStatements(sql string) []string {
+	var (
+		stmts []string
+		start int
+		quote byte
+	)
+
+	for i := 0; i < len(sql); i++ {
+		switch ch := sql[i]; {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+		case ch == ';':
+			stmts = appendStatement(stmts, sql[start:i])
+			start = i + 1
+		}
+	}
+
+	return appendStatement(stmts, sql[start:])
+}
+
+func appendStatement(stmts []string, stmt string) []string {
+	if stmt = strings.TrimSpace(stmt); stmt != "" {
+		stmts = append(stmts, stmt)
+	}
+
+	return stmts
+}
+
+// copyOutMessages - ответ COPY TO STDOUT: данные в формате PGCOPY передаются как бинарные
+func copyOutMessages(data []byte) []pgproto3.BackendMessage {
+	var format byte
+
+	if bytes.HasPrefix(data, []byte("PGCOPY\n")) {
+		format = 1
+	}
+
+	return []pgproto3.BackendMessage{
+		&pgproto3.CopyOutResponse{OverallFormat: format},
+		&pgproto3.CopyData{Data: data},
+		&pgproto3.CopyDone{},
+	}
+}
+
+// isCopyIn - инструкция COPY ... FROM STDIN
+func isCopyIn(sql string) bool {
+	return copyInRe.MatchString(sql)
+}
+
+func isTxControl(sql string) bool {
+	switch strings.ToUpper(firstWord(sql)) {
+	case "BEGIN", "START", "COMMIT", "END", "ROLLBACK", "ABORT", "SAVEPOINT", "RELEASE":
+		return true
+	default:
+		return false
+	}
+}
+
+// deallocate - удаляет подготовленную инструкцию по DEALLOCATE [PREPARE] name | ALL
+func (sess *session) deallocate(sql string) {
+	words := strings.Fields(strings.TrimRight(sql, "; "))
+	name := strings.Trim(words[len(words)-1], `"`)
+
+	if strings.EqualFold(name, "ALL") {
+		sess.stmts = make(map[string]*statement)
+
+		return
+	}
+
+	delete(sess.stmts, name)
+}
+
+func isDeallocate(sql string) bool {
+	return strings.EqualFold(firstWord(sql), "DEALLOCATE")
+}
+
+func isTxEnd(sql string) bool {
+	switch strings.ToUpper(firstWord(sql)) {
+	case "COMMIT", "END", "ROLLBACK", "ABORT":
+		return true
+	default:
+		return false
+	}
+}
+
+// countParams - количество параметров $n в тексте инструкции
+func countParams(sql string) int {
+	var count int
+
+	for _, match := range placeholderRe.FindAllStringSubmatch(sql, -1) {
+		if n, err := strconv.Atoi(match[1]); err == nil && n > count {
+			count = n
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,223 @@
+package pgtest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgproto3/v2"
+	"github.com/jackc/pgtype"
+	"gopkg.in/gomisc/errors.v1"
+)
+
+// Форматы значений протокола
+const (
+	formatText   int16 = 0
+	formatBinary int16 = 1
+)
+
+var connInfo = pgtype.NewConnInfo()
+
+// oidOf - OID типа PostgreSQL, соответствующего типу значения Go
+func oidOf(value any) uint32 {
+	switch value.(type) {
+	case bool:
+		return pgtype.BoolOID
+	case int16, int8, uint8:
+		return pgtype.Int2OID
+	case int32, uint16:
+		return pgtype.Int4OID
+	case int, int64, uint32, uint, uint64:
+		return pgtype.Int8OID
+	case float32:
+		return pgtype.Float4OID
+	case float64:
+		return pgtype.Float8OID
+	case []byte:
+		return pgtype.ByteaOID
+	case time.Time:
+		return pgtype.TimestamptzOID
+	case time.Duration:
+		return pgtype.IntervalOID
+	case [16]byte:
+		return pgtype.UUIDOID
+	default:
+		return pgtype.TextOID
+	}
+}
+
+// newValue - новое значение pgtype для OID, для неизвестных OID используется text
+func newValue(oid uint32) pgtype.Value {
+	if dt, ok := connInfo.DataTypeForOID(oid); ok {
+		return pgtype.NewValue(dt.Value)
+	}
+
+	return &pgtype.Text{}
+}
+
+// formatFor - формат значения на позиции i по кодам форматов сообщения Bind
+func formatFor(codes []int16, i int) int16 {
+	switch {
+	case len(codes) == 0:
+		return formatText
+	case len(codes) == 1:
+		return codes[0]
+	case i < len(codes):
+		return codes[i]
+	default:
+		return formatText
+	}
+}
+
+// encodeValue - кодирует значение Go в формате протокола, nil кодируется как NULL
+func encodeValue(oid uint32, format int16, value any) ([]byte, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	val := newValue(oid)
+
+	if err := val.Set(value); err != nil {
+		if _, isText := val.(*pgtype.Text); !isText {
+			return nil, errors.Ctx().
+				Int("oid", int(oid)).
+				Str("type", fmt.Sprintf("%T", value)).
+				Wrap(err, "set column value")
+		}
+
+		if err = val.Set(fmt.Sprint(value)); err != nil {
+			return nil, errors.Wrap(err, "set text column value")
+		}
+	}
+
+	var (
+		buf []byte
+		err error
+	)
+
+	if format == formatBinary {
+		encoder, ok := val.(pgtype.BinaryEncoder)
+		if !ok {
+			return nil, errors.Ctx().Int("oid", int(oid)).Just(ErrUnsupportedFormat)
+		}
+
+		buf, err = encoder.EncodeBinary(connInfo, nil)
+	} else {
+		encoder, ok := val.(pgtype.TextEncoder)
+		if !ok {
+			return nil, errors.Ctx().Int("oid", int(oid)).Just(ErrUnsupportedFormat)
+		}
+
+		buf, err = encoder.EncodeText(connInfo, nil)
+	}
+
+	if err != nil {
+		return nil, errors.Ctx().Int("oid", int(oid)).Wrap(err, "encode column value")
+	}
+
+	if buf == nil {
+		buf = []byte{}
+	}
+
+	return buf, nil
+}
+
+// decodeValue - декодирует параметр сообщения Bind в значение Go
+func decodeValue(oid uint32, format int16, src []byte) (any, error) {
+	if src == nil {
+		return nil, nil
+	}
+
+	val := newValue(oid)
+
+	var err error
+
+	if format == formatBinary {
+		decoder, ok := val.(pgtype.BinaryDecoder)
+		if !ok {
+			return nil, errors.Ctx().Int("oid", int(oid)).Just(ErrUnsupportedFormat)
+		}
+
+		err = decoder.DecodeBinary(connInfo, src)
+	} else {
+		decoder, ok := val.(pgtype.TextDecoder)
+		if !ok {
+			return nil, errors.Ctx().Int("oid", int(oid)).Just(ErrUnsupportedFormat)
+		}
+
+		err = decoder.DecodeText(connInfo, src)
+	}
+
+	if err != nil {
+		return nil, errors.Ctx().Int("oid", int(oid)).Wrap(err, "decode parameter")
+	}
+
+	return val.Get(), nil
+}
+
+// rowDescription - описание колонок результата ожидания в указанных форматах
+func rowDescription(exp *Expectation, formats []int16) *pgproto3.RowDescription {
+	oids := exp.columnOIDs()
+	desc := &pgproto3.RowDescription{Fields: make([]pgproto3.FieldDescription, 0, len(oids))}
+
+	for i, header := range exp.table.Headers {
+		desc.Fields = append(desc.Fields, pgproto3.FieldDescription{
+			Name:         []byte(header),
+			DataTypeOID:  oids[i],
+			DataTypeSize: -1,
+			TypeModifier: -1,
+			Format:       formatFor(formats, i),
+		})
+	}
+
+	return desc
+}
+
+// dataRows - строки результата ожидания в указанных форматах
+func dataRows(exp *Expectation, formats []int16) ([]*pgproto3.DataRow, error) {
+	oids := exp.columnOIDs()
+	rows := make([]*pgproto3.DataRow, 0, len(exp.table.Rows))
+
+	for _, row := range exp.table.Rows {
+		values := make([][]byte, len(oids))
+
+		for i := range oids {
+			if i >= len(row) {
+				continue
+			}
+
+			buf, err := encodeValue(oids[i], formatFor(formats, i), row[i])
+			if err != nil {
+				return nil, errors.Ctx().Str("column", exp.table.Headers[i]).Just(err)
+			}
+
+			values[i] = buf
+		}
+
+		rows = append(rows, &pgproto3.DataRow{Values: values})
+	}
+
+	return rows, nil
+}
+
+// errorResponse - сообщение об ошибке сервера
+func errorResponse(pgErr *pgconn.PgError) *pgproto3.ErrorResponse {
+	severity := pgErr.Severity
+	if severity == "" {
+		severity = "ERROR"
+	}
+
+	return &pgproto3.ErrorResponse{
+		Severity:       severity,
+		Code:           pgErr.Code,
+		Message:        pgErr.Message,
+		Detail:         pgErr.Detail,
+		Hint:           pgErr.Hint,
+		Position:       pgErr.Position,
+		SchemaName:     pgErr.SchemaName,
+		TableName:      pgErr.TableName,
+		ColumnName:     pgErr.ColumnName,
+		DataTypeName:   pgErr.DataTypeName,
+		ConstraintName: pgErr.ConstraintName,
+	}
+}
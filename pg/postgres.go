@@ -3,12 +3,14 @@ package pg
 import (
 	"context"
 	"database/sql"
+	"net/url"
 
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgtype/pgxtype"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"gopkg.in/gomisc/errors.v1"
+	"gopkg.in/gomisc/fields.v1"
 	"gopkg.in/gomisc/tracing.v1"
 
 	"gopkg.in/gomisc/storage.v1"
@@ -23,7 +25,7 @@ const (
 
 const (
 	errWrongQueryType  = errors.Const("query.Query must be string type")
-	errWrongParameters = errors.Const("parameters must be []interface{} type")
+	errWrongParameters = errors.Const("parameters must be []interface{}, map with string keys or struct type")
 )
 
 var _ storage.Storage = (*databaseClient)(nil)
@@ -34,27 +36,73 @@ type (
 		err error
 	}
 
+	// executor - пул или транзакция, выполняющие запросы
+	executor interface {
+		pgxtype.Querier
+		SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+		CopyFrom(ctx context.Context, table pgx.Identifier, columns []string, rows pgx.CopyFromSource) (int64, error)
+	}
+
 	databaseClient struct {
-		pool *pgxpool.Pool
+		pool     *pgxpool.Pool
+		protocol Protocol
+		failover *failoverState
+		// closedStmts - подготовленные запросы, удаляемые на соединениях при их возврате в пул
+		closedStmts *closedStatements
 	}
 )
 
+func init() {
+	storage.RegisterDriver(DefaultScheme, open, ShortScheme, PsqlScheme)
+}
+
+// New - конструктор хранилища PostgreSQL. DSN может содержать несколько узлов (postgres://h1,h2:5433/db)
+// и требуемую роль узла target_session_attrs: read-write, read-only, primary, standby, prefer-standby
+// или any (по умолчанию). Узлы перебираются в порядке DSN, недавно отказавшие - последними.
+// После потери соединения с узлом или отказа в записи на узле, ставшем репликой, пул закрывает
+// прежние соединения и подключается заново к узлу требуемой роли. Режим протокола запросов задает
+// параметр protocol: simple (по умолчанию), extended или describe
 func New(ctx context.Context, dsn string) (storage.Storage, error) {
 	poolConfig, err := pgxpool.ParseConfig(dsn)
 	if err != nil {
-		return nil, errors.Wrap(err, "configure database client")
+		return nil, wrapPgErr(err, storage.OpConnect, nil, "configure database client")
+	}
+
+	var protocol Protocol
+
+	if protocol, err = configureProtocol(poolConfig.ConnConfig); err != nil {
+		return nil, wrapPgErr(err, storage.OpConnect, nil, "configure query protocol")
+	}
+
+	var state *failoverState
+
+	if state, err = newFailover(dsn, poolConfig); err != nil {
+		return nil, wrapPgErr(err, storage.OpConnect, nil, "configure host failover")
 	}
 
-	poolConfig.ConnConfig.PreferSimpleProtocol = true
+	closedStmts := newClosedStatements()
+	poolConfig.AfterRelease = closedStmts.afterRelease
 
 	var pool *pgxpool.Pool
 
 	pool, err = pgxpool.ConnectConfig(ctx, poolConfig)
 	if err != nil {
-		return nil, errors.Wrap(err, "connect to postgresql database")
+		return nil, wrapPgErr(err, storage.OpConnect, nil, "connect to postgresql database")
 	}
 
-	return &databaseClient{pool: pool}, nil
+	return &databaseClient{pool: pool, protocol: protocol, failover: state, closedStmts: closedStmts}, nil
+}
+
+// open - конструктор хранилища для реестра драйверов, схемы-псевдонимы приводятся к DefaultScheme
+func open(ctx context.Context, uri *url.URL) (storage.Storage, error) {
+	dsn := *uri
+	dsn.Scheme = DefaultScheme
+
+	if err := normalizeHosts(&dsn); err != nil {
+		return nil, wrapPgErr(err, storage.OpConnect, nil, "parse host list")
+	}
+
+	return New(ctx, dsn.String())
 }
 
 // Close реализация io.Closer
@@ -64,17 +112,38 @@ func (cli *databaseClient) Close() error {
 	return nil
 }
 
-// Begin - открывает и возвращает транзакцию
+// Begin - открывает и возвращает транзакцию, опции принимаются в виде storage.TxOptions или *pgx.TxOptions.
+// Если контекст уже несет транзакцию, открывается вложенная транзакция на точке сохранения
 func (cli *databaseClient) Begin(ctx context.Context, options ...any) (tx storage.Transaction, err error) {
 	span := tracing.SetTrace(ctx)
 	defer span.End()
 
+	if outer, ok := ctx.Value(transactionKey{}).(*pgTransaction); ok {
+		var nested *pgTransaction
+
+		if nested, err = outer.beginNested(span.Context(), options...); err != nil {
+			span, err = span.WithError(err, "begin nested transaction")
+
+			return nil, err
+		}
+
+		return nested, nil
+	}
+
+	opts, txOpts, err := getPgTxOptions(options...)
+	if err != nil {
+		span, err = span.WithError(err, "get transaction options")
+
+		return nil, err
+	}
+
 	var pgTx pgx.Tx
 
-	if opts := getPgTxOptions(options...); opts != nil {
+	if opts != nil {
 		pgTx, err = cli.pool.BeginTx(span.Context(), *opts)
 		if err != nil {
-			err = errors.Ctx().Any("options", opts).Wrap(err, "begin transaction with opts")
+			err = errors.Ctx().Any("options", opts).Just(wrapPgErr(err, storage.OpBegin, nil, "begin transaction with opts"))
+			cli.failover.observe(err)
 			span, err = span.WithError(err)
 
 			return nil, err
@@ -82,13 +151,26 @@ func (cli *databaseClient) Begin(ctx context.Context, options ...any) (tx storag
 	} else {
 		pgTx, err = cli.pool.Begin(span.Context())
 		if err != nil {
-			span, err = span.WithError(err, "begin transaction")
+			err = wrapPgErr(err, storage.OpBegin, nil, "begin transaction")
+			cli.failover.observe(err)
+			span, err = span.WithError(err)
 
 			return nil, err
 		}
 	}
 
-	return &pgTransaction{tx: pgTx, ctx: span.Context()}, nil
+	transaction := &pgTransaction{tx: pgTx, ctx: span.Context()}
+
+	if txOpts != nil {
+		transaction.label, transaction.opts = txOpts.Label, txOpts
+		span.WithFields(fields.Str("tx-label", txOpts.Label))
+
+		if txOpts.Timeout > 0 {
+			transaction.ctx, transaction.cancel = context.WithTimeout(transaction.ctx, txOpts.Timeout)
+		}
+	}
+
+	return transaction, nil
 }
 
 // Query - выполняет запрос производящий действия в базе, с возможностью вернуть произвольный результат
@@ -107,46 +189,61 @@ func (cli *databaseClient) Query(ctx context.Context, query storage.Query, resul
 
 	var rows pgx.Rows
 
-	if rows, err = cli.query(ctx, query); err != nil {
+	if rows, err = cli.query(span.Context(), query); err != nil {
 		span, err = span.WithError(err)
 		return err
 	}
 
-	scanner := newScanner(rows)
+	if err = decodeRows(rows, query, result); err != nil {
+		span, err = span.WithError(err)
+		return err
+	}
 
-	if res, ok := result.(*storage.Result); ok {
-		if err = scanner.scanStorageResult(res); err != nil {
-			span, err = span.WithError(err, "decode query result")
-			return err
-		}
+	return nil
+}
 
-		result = res
-		return nil
+// QueryRow - выполняет запрос и сканирует единственную строку результата в dest
+func (cli *databaseClient) QueryRow(ctx context.Context, query storage.Query, dest ...any) error {
+	span := tracing.SetTrace(ctx)
+	defer span.End()
+
+	row, err := cli.queryRow(span.Context(), query)
+	if err != nil {
+		span, err = span.WithError(err)
+		return err
 	}
 
-	if res, ok := result.(*storage.Table); ok {
-		if err = scanner.scanStorageTable(res); err != nil {
-			span, err = span.WithError(err, "decode query result")
-			return err
+	if err = row.Scan(dest...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return storage.ErrEmptyResult
 		}
 
-		result = res
-		return nil
-	}
-
-	if err = scanner.scan(result); err != nil {
-		span, err = span.WithError(err, "decode query result")
+		err = wrapPgErr(err, storage.OpQueryRow, query, "scan query row")
+		cli.failover.observe(err)
+		span, err = span.WithError(err)
 		return err
 	}
 
 	return nil
 }
 
-// Iterate - выполняет запрос и возвращает итератор по результатам произвольного типа из базы
+// Iterate - выполняет запрос и возвращает итератор по результатам произвольного типа из базы.
+// С размером выборки storage.WithFetchSize результат читается курсором на сервере частями
 func (cli *databaseClient) Iterate(ctx context.Context, query storage.Query) (storage.Iterator, error) {
 	span := tracing.SetTrace(ctx)
 	defer span.End()
 
+	if size, ok := storage.FetchSize(ctx); ok {
+		iter, err := cli.iterateCursor(span.Context(), query, size)
+		if err != nil {
+			span, err = span.WithError(err, "get cursor query result")
+
+			return nil, err
+		}
+
+		return iter, nil
+	}
+
 	rows, err := cli.query(span.Context(), query)
 	if err != nil {
 		span, err = span.WithError(err, "get iterable query result")
@@ -154,7 +251,7 @@ func (cli *databaseClient) Iterate(ctx context.Context, query storage.Query) (st
 		return nil, err
 	}
 
-	return newIterator(rows), nil
+	return newIterator(rows, query), nil
 }
 
 // Exec Выполняет запрос который ничего не возвращает
@@ -172,7 +269,7 @@ func (cli *databaseClient) Exec(ctx context.Context, query storage.Query) (sql.R
 	return msg, nil
 }
 
-func (cli *databaseClient) getExecutor(ctx context.Context) pgxtype.Querier {
+func (cli *databaseClient) getExecutor(ctx context.Context) executor {
 	if tx, ok := ctx.Value(transactionKey{}).(*pgTransaction); ok {
 		return tx.tx
 	}
@@ -180,24 +277,38 @@ func (cli *databaseClient) getExecutor(ctx context.Context) pgxtype.Querier {
 	return cli.pool
 }
 
-func (res *execResult) LastInsertId() (int64, error) {
-	return res.tag.RowsAffected(), res.err
-}
+// decodeRows - декодирует строки результата запроса в result, аналогичный аргументу Storage.Query
+func decodeRows(rows pgx.Rows, query storage.Query, result any) error {
+	var (
+		scanner = newScanner(rows)
+		err     error
+	)
+
+	switch res := result.(type) {
+	case *storage.Result:
+		err = scanner.scanStorageResult(res)
+	case *storage.Table:
+		err = scanner.scanStorageTable(res)
+	default:
+		err = scanner.scan(result)
+	}
 
-func (res *execResult) RowsAffected() (int64, error) {
-	return res.tag.RowsAffected(), res.err
-}
+	if err != nil {
+		return wrapPgErr(err, storage.OpScan, query, "decode query result")
+	}
 
-func wrapPgErr(err error, message string) error {
-	var pgErr *pgconn.PgError
+	return nil
+}
 
-	if errors.As(err, &pgErr) {
-		return errors.Ctx().
-			Pos(2).
-			Str("code", pgErr.Code).
-			Int32("sql-position", pgErr.Position).
-			Wrap(err, message)
+// LastInsertId - PostgreSQL не сообщает идентификатор вставленной строки, используйте RETURNING
+func (res *execResult) LastInsertId() (int64, error) {
+	if res.err != nil {
+		return 0, res.err
 	}
 
-	return errors.Wrap(err, message)
+	return 0, errors.Ctx().Str("method", "LastInsertId").Just(storage.ErrNotSupported)
+}
+
+func (res *execResult) RowsAffected() (int64, error) {
+	return res.tag.RowsAffected(), res.err
 }
@@ -0,0 +1,64 @@
+package pg
+
+import (
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgconn/stmtcache"
+	"github.com/jackc/pgx/v4"
+	"gopkg.in/gomisc/errors.v1"
+)
+
+// ProtocolParam - параметр DSN, задающий режим протокола запросов
+const ProtocolParam = "protocol"
+
+// Режимы протокола запросов
+const (
+	// ProtocolSimple - простой протокол, параметры подставляются в текст запроса на клиенте.
+	// Совместим с пулерами соединений в режиме транзакций (PgBouncer), режим по умолчанию
+	ProtocolSimple Protocol = "simple"
+	// ProtocolExtended - расширенный протокол с кешем подготовленных на сервере запросов
+	// и бинарной передачей значений. Размер и режим кеша задают параметры pgx
+	// statement_cache_capacity и statement_cache_mode
+	ProtocolExtended Protocol = "extended"
+	// ProtocolDescribe - расширенный протокол, кешируется только описание запроса, полученное
+	// безымянным подготовленным запросом: бинарная передача значений без подготовленных на сервере
+	// запросов, совместима с пулерами соединений
+	ProtocolDescribe Protocol = "describe"
+)
+
+const (
+	ErrUnknownProtocol = errors.Const("unknown query protocol")
+)
+
+// Protocol - режим протокола запросов PostgreSQL
+type Protocol string
+
+// configureProtocol - настраивает протокол запросов по параметру DSN protocol, параметр
+// удаляется из параметров сессии, передаваемых серверу
+func configureProtocol(cfg *pgx.ConnConfig) (Protocol, error) {
+	protocol := ProtocolSimple
+
+	if value, ok := cfg.RuntimeParams[ProtocolParam]; ok {
+		delete(cfg.RuntimeParams, ProtocolParam)
+		protocol = Protocol(value)
+	}
+
+	switch protocol {
+	case ProtocolSimple:
+		cfg.PreferSimpleProtocol = true
+	case ProtocolExtended:
+		cfg.PreferSimpleProtocol = false
+	case ProtocolDescribe:
+		cfg.PreferSimpleProtocol = false
+
+		// емкость кеша, заданную statement_cache_capacity, знает только построитель кеша pgx
+		if build := cfg.BuildStatementCache; build != nil {
+			cfg.BuildStatementCache = func(conn *pgconn.PgConn) stmtcache.Cache {
+				return stmtcache.New(conn, stmtcache.ModeDescribe, build(conn).Cap())
+			}
+		}
+	default:
+		return "", errors.Ctx().Str("protocol", string(protocol)).Just(ErrUnknownProtocol)
+	}
+
+	return protocol, nil
+}
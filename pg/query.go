@@ -24,7 +24,15 @@ func (cli *databaseClient) prepare(query storage.Query) (*pgQuery, error) {
 
 	params, ok := query.Params().([]any)
 	if !ok {
-		return nil, errors.Ctx().Any("params", query.Params()).Just(errWrongParameters)
+		if !storage.IsNamedArgs(query.Params()) {
+			return nil, errors.Ctx().Any("params", query.Params()).Just(errWrongParameters)
+		}
+
+		var err error
+
+		if sql, params, err = storage.BindNamed(storage.PlaceholderDollar, sql, query.Params()); err != nil {
+			return nil, errors.Ctx().Stringer("query", query).Wrap(err, "bind named parameters")
+		}
 	}
 
 	return &pgQuery{sql: sql, params: params}, nil
@@ -33,7 +41,7 @@ func (cli *databaseClient) prepare(query storage.Query) (*pgQuery, error) {
 func (cli *databaseClient) exec(ctx context.Context, query storage.Query) (sql.Result, error) {
 	pq, err := cli.prepare(query)
 	if err != nil {
-		return nil, errors.Wrap(err, "prepare query data")
+		return nil, wrapPgErr(err, storage.OpPrepare, query, "prepare query data")
 	}
 
 	var (
@@ -41,7 +49,10 @@ func (cli *databaseClient) exec(ctx context.Context, query storage.Query) (sql.R
 	)
 
 	if tag, err = cli.getExecutor(ctx).Exec(ctx, pq.sql, pq.params...); err != nil {
-		return nil, wrapPgErr(err, "execute query")
+		err = wrapPgErr(err, storage.OpExec, query, "execute query")
+		cli.failover.observe(err)
+
+		return nil, err
 	}
 
 	return &execResult{tag: tag}, nil
@@ -50,7 +61,7 @@ func (cli *databaseClient) exec(ctx context.Context, query storage.Query) (sql.R
 func (cli *databaseClient) queryRow(ctx context.Context, query storage.Query) (pgx.Row, error) {
 	pq, err := cli.prepare(query)
 	if err != nil {
-		return nil, errors.Wrap(err, "prepare query data")
+		return nil, wrapPgErr(err, storage.OpPrepare, query, "prepare query data")
 	}
 
 	return cli.getExecutor(ctx).QueryRow(ctx, pq.sql, pq.params...), nil
@@ -59,14 +70,17 @@ func (cli *databaseClient) queryRow(ctx context.Context, query storage.Query) (p
 func (cli *databaseClient) query(ctx context.Context, query storage.Query) (pgx.Rows, error) {
 	pq, err := cli.prepare(query)
 	if err != nil {
-		return nil, errors.Wrap(err, "prepare query data")
+		return nil, wrapPgErr(err, storage.OpPrepare, query, "prepare query data")
 	}
 
 	var rows pgx.Rows
 
 	rows, err = cli.getExecutor(ctx).Query(ctx, pq.sql, pq.params...)
 	if err != nil {
-		return nil, wrapPgErr(err, "execute query")
+		err = wrapPgErr(err, storage.OpQuery, query, "execute query")
+		cli.failover.observe(err)
+
+		return nil, err
 	}
 
 	return rows, nil
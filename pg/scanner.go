@@ -29,7 +29,7 @@ func (cs *customScanner) scan(result any) error {
 				return storage.ErrEmptyResult
 			}
 
-			return errors.Wrap(err, "scan to slice")
+			return errors.Wrap(classifyErr(err), "scan to slice")
 		}
 
 		return nil
@@ -40,13 +40,15 @@ func (cs *customScanner) scan(result any) error {
 			return storage.ErrEmptyResult
 		}
 
-		return errors.Wrap(err, "scan to object")
+		return errors.Wrap(classifyErr(err), "scan to object")
 	}
 
 	return nil
 }
 
 func (cs *customScanner) scanStorageTable(result *storage.Table) error {
+	defer cs.rows.Close()
+
 	fields := cs.rows.FieldDescriptions()
 
 	for h := 0; h < len(fields); h++ {
@@ -56,22 +58,28 @@ func (cs *customScanner) scanStorageTable(result *storage.Table) error {
 	for cs.rows.Next() {
 		values, err := cs.rows.Values()
 		if err != nil {
-			return errors.Wrap(err, "get row values")
+			return errors.Wrap(classifyErr(err), "get row values")
 		}
 
 		result.Rows = append(result.Rows, values)
 	}
 
+	if err := cs.rows.Err(); err != nil {
+		return errors.Wrap(classifyErr(err), "read table rows")
+	}
+
 	return nil
 }
 
 func (cs *customScanner) scanStorageResult(result *storage.Result) error {
+	defer cs.rows.Close()
+
 	fields := cs.rows.FieldDescriptions()
 
 	for cs.rows.Next() {
 		values, err := cs.rows.Values()
 		if err != nil {
-			return errors.Wrap(err, "get row values")
+			return errors.Wrap(classifyErr(err), "get row values")
 		}
 
 		row := make(map[string]any)
@@ -82,5 +90,9 @@ func (cs *customScanner) scanStorageResult(result *storage.Result) error {
 		*result = append(*result, row)
 	}
 
+	if err := cs.rows.Err(); err != nil {
+		return errors.Wrap(classifyErr(err), "read result rows")
+	}
+
 	return nil
 }
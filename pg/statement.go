@@ -0,0 +1,372 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"gopkg.in/gomisc/errors.v1"
+	"gopkg.in/gomisc/tracing.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+const (
+	// statementPrefix - префикс имен подготовленных на сервере запросов
+	statementPrefix = "storage_stmt_"
+	// deallocateTimeout - ограничение времени удаления закрытых запросов на возвращенном соединении
+	deallocateTimeout = 5 * time.Second
+)
+
+var (
+	_ storage.Preparer  = (*databaseClient)(nil)
+	_ storage.Statement = (*pgStatement)(nil)
+
+	statementSeq atomic.Uint64
+)
+
+// pgStatement - именованный подготовленный запрос. Запрос готовится на каждом соединении пула
+// при первом выполнении на нем, далее выполняется без разбора на сервере
+type pgStatement struct {
+	cli   *databaseClient
+	query storage.Query
+	name  string
+	sql   string
+	named *storage.NamedSQL
+
+	mu     sync.Mutex
+	conns  map[*pgx.Conn]struct{}
+	closed bool
+}
+
+// closedStatements - запросы, закрытые, пока подготовившие их соединения были заняты.
+// Запросы удаляются на сервере, когда соединение возвращается в пул
+type closedStatements struct {
+	mu    sync.Mutex
+	names map[*pgx.Conn][]string
+}
+
+// Prepare - имплементация storage.Preparer. В режиме протокола extended запрос готовится
+// на соединении сразу, чтобы ошибки разбора возвращались из Prepare. Режимы simple и describe
+// совместимы с пулерами соединений в режиме транзакций, где именованный запрос, подготовленный
+// на одном соединении сервера, недоступен на другом, поэтому в них запрос выполняется методами
+// хранилища при каждом вызове, как у хранилищ без Preparer
+func (cli *databaseClient) Prepare(ctx context.Context, query storage.Query) (storage.Statement, error) {
+	span := tracing.SetTrace(ctx)
+	defer span.End()
+
+	if cli.protocol != ProtocolExtended {
+		stmt, err := storage.NewQueryStatement(cli, query)
+		if err != nil {
+			span, err = span.WithError(wrapPgErr(err, storage.OpPrepare, query, "prepare statement"))
+
+			return nil, err
+		}
+
+		return stmt, nil
+	}
+
+	stmt, err := cli.prepareStatement(span.Context(), query)
+	if err != nil {
+		err = wrapPgErr(err, storage.OpPrepare, query, "prepare statement")
+		cli.failover.observe(err)
+		span, err = span.WithError(err)
+
+		return nil, err
+	}
+
+	return stmt, nil
+}
+
+func (cli *databaseClient) prepareStatement(ctx context.Context, query storage.Query) (*pgStatement, error) {
+	sql, isString := query.Query().(string)
+	if !isString {
+		return nil, errors.Ctx().Stringer("query", query).Just(errWrongQueryType)
+	}
+
+	stmt := &pgStatement{
+		cli:   cli,
+		query: query,
+		name:  statementPrefix + strconv.FormatUint(statementSeq.Add(1), 10),
+		sql:   sql,
+		conns: make(map[*pgx.Conn]struct{}),
+	}
+
+	if _, positional := query.Params().([]any); !positional {
+		named, err := storage.ParseNamed(storage.PlaceholderDollar, sql)
+		if err != nil {
+			return nil, errors.Ctx().Stringer("query", query).Wrap(err, "parse named parameters")
+		}
+
+		stmt.named, stmt.sql = named, named.SQL
+	}
+
+	if err := stmt.withConn(ctx, func(*pgx.Conn) error { return nil }); err != nil {
+		return nil, err
+	}
+
+	return stmt, nil
+}
+
+// Close - имплементация io.Closer. Запрос удаляется на свободных соединениях пула сразу,
+// на занятых - при их возврате в пул
+func (st *pgStatement) Close() error {
+	st.mu.Lock()
+
+	if st.closed {
+		st.mu.Unlock()
+
+		return nil
+	}
+
+	conns := st.conns
+	st.closed, st.conns = true, nil
+	st.mu.Unlock()
+
+	ctx := context.Background()
+
+	var err error
+
+	for _, conn := range st.cli.pool.AcquireAllIdle(ctx) {
+		if _, prepared := conns[conn.Conn()]; prepared {
+			delete(conns, conn.Conn())
+
+			if deallocErr := conn.Conn().Deallocate(ctx, st.name); deallocErr != nil {
+				err = errors.And(err, deallocErr)
+			}
+		}
+
+		conn.Release()
+	}
+
+	for conn := range conns {
+		st.cli.closedStmts.add(conn, st.name)
+	}
+
+	if err != nil {
+		return wrapPgErr(err, storage.OpClose, st.query, "deallocate statement")
+	}
+
+	return nil
+}
+
+// Exec - имплементация storage.Statement
+func (st *pgStatement) Exec(ctx context.Context, args any) (sql.Result, error) {
+	span := tracing.SetTrace(ctx)
+	defer span.End()
+
+	tag, err := st.exec(span.Context(), args)
+	if err != nil {
+		span, err = span.WithError(err)
+
+		return nil, err
+	}
+
+	return &execResult{tag: tag}, nil
+}
+
+// Query - имплементация storage.Statement
+func (st *pgStatement) Query(ctx context.Context, args any, result any) error {
+	span := tracing.SetTrace(ctx)
+	defer span.End()
+
+	if result == nil {
+		if _, err := st.exec(span.Context(), args); err != nil {
+			span, err = span.WithError(err)
+
+			return err
+		}
+
+		return nil
+	}
+
+	params, err := st.params(args)
+	if err != nil {
+		span, err = span.WithError(err)
+
+		return err
+	}
+
+	err = st.withConn(span.Context(), func(conn *pgx.Conn) error {
+		rows, err := conn.Query(span.Context(), st.name, params...)
+		if err != nil {
+			return err
+		}
+
+		return decodeRows(rows, st.query, result)
+	})
+	if err != nil {
+		err = wrapPgErr(err, storage.OpQuery, st.query, "query statement")
+		st.cli.failover.observe(err)
+		span, err = span.WithError(err)
+
+		return err
+	}
+
+	return nil
+}
+
+// QueryRow - имплементация storage.Statement
+func (st *pgStatement) QueryRow(ctx context.Context, args any, dest ...any) error {
+	span := tracing.SetTrace(ctx)
+	defer span.End()
+
+	params, err := st.params(args)
+	if err != nil {
+		span, err = span.WithError(err)
+
+		return err
+	}
+
+	err = st.withConn(span.Context(), func(conn *pgx.Conn) error {
+		return conn.QueryRow(span.Context(), st.name, params...).Scan(dest...)
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return storage.ErrEmptyResult
+		}
+
+		err = wrapPgErr(err, storage.OpQueryRow, st.query, "scan statement row")
+		st.cli.failover.observe(err)
+		span, err = span.WithError(err)
+
+		return err
+	}
+
+	return nil
+}
+
+func (st *pgStatement) exec(ctx context.Context, args any) (pgconn.CommandTag, error) {
+	params, err := st.params(args)
+	if err != nil {
+		return nil, err
+	}
+
+	var tag pgconn.CommandTag
+
+	err = st.withConn(ctx, func(conn *pgx.Conn) (err error) {
+		tag, err = conn.Exec(ctx, st.name, params...)
+
+		return err
+	})
+	if err != nil {
+		err = wrapPgErr(err, storage.OpExec, st.query, "execute statement")
+		st.cli.failover.observe(err)
+
+		return nil, err
+	}
+
+	return tag, nil
+}
+
+func (st *pgStatement) params(args any) ([]any, error) {
+	params, err := storage.StatementArgs(st.named, args)
+	if err != nil {
+		return nil, wrapPgErr(err, storage.OpPrepare, st.query, "bind statement arguments")
+	}
+
+	return params, nil
+}
+
+// withConn - выполняет fn на соединении транзакции контекста или на соединении из пула,
+// предварительно подготовив на нем запрос
+func (st *pgStatement) withConn(ctx context.Context, fn func(conn *pgx.Conn) error) error {
+	if tx, ok := ctx.Value(transactionKey{}).(*pgTransaction); ok {
+		return st.run(ctx, tx.tx.Conn(), fn)
+	}
+
+	conn, err := st.cli.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Release()
+
+	return st.run(ctx, conn.Conn(), fn)
+}
+
+func (st *pgStatement) run(ctx context.Context, conn *pgx.Conn, fn func(conn *pgx.Conn) error) error {
+	st.mu.Lock()
+	closed := st.closed
+	st.mu.Unlock()
+
+	if closed {
+		return errors.Ctx().Stringer("query", st.query).Just(storage.ErrStatementClosed)
+	}
+
+	// повторная подготовка с тем же именем и текстом не обращается к серверу
+	if _, err := conn.Prepare(ctx, st.name, st.sql); err != nil {
+		return err
+	}
+
+	st.mu.Lock()
+
+	if st.conns != nil {
+		st.conns[conn] = struct{}{}
+
+		// соединения, закрытые пулом, больше не нуждаются в удалении запроса
+		if len(st.conns) > int(st.cli.pool.Config().MaxConns) {
+			for prepared := range st.conns {
+				if prepared.IsClosed() {
+					delete(st.conns, prepared)
+				}
+			}
+		}
+	}
+
+	st.mu.Unlock()
+
+	return fn(conn)
+}
+
+func newClosedStatements() *closedStatements {
+	return &closedStatements{names: make(map[*pgx.Conn][]string)}
+}
+
+// add - откладывает удаление запроса name на соединении conn до возврата соединения в пул
+func (cs *closedStatements) add(conn *pgx.Conn, name string) {
+	if conn.IsClosed() {
+		return
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	// соединения, закрытые пулом, больше не нуждаются в удалении запросов
+	for pending := range cs.names {
+		if pending.IsClosed() {
+			delete(cs.names, pending)
+		}
+	}
+
+	cs.names[conn] = append(cs.names[conn], name)
+}
+
+// afterRelease - хук pgxpool.Config.AfterRelease: удаляет отложенные запросы соединения,
+// соединение, на котором удалить их не удалось, пул закрывает
+func (cs *closedStatements) afterRelease(conn *pgx.Conn) bool {
+	cs.mu.Lock()
+	names := cs.names[conn]
+	delete(cs.names, conn)
+	cs.mu.Unlock()
+
+	if len(names) == 0 {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), deallocateTimeout)
+	defer cancel()
+
+	for _, name := range names {
+		if err := conn.Deallocate(ctx, name); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,133 @@
+package pg_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+	"gopkg.in/gomisc/storage.v1/pg"
+	"gopkg.in/gomisc/storage.v1/pg/pgtest"
+)
+
+func TestStatementCloseOnBusyConn(t *testing.T) {
+	srv := pgtest.NewServer(t)
+	ctx := context.Background()
+
+	// единственное соединение пула занято транзакцией во время Close
+	s, err := pg.New(ctx, srv.DSN()+"&pool_max_conns=1&"+pg.ProtocolParam+"="+string(pg.ProtocolExtended))
+	if err != nil {
+		t.Fatalf("connect: %s", errors.Formatted(err))
+	}
+
+	defer s.Close()
+
+	srv.Expect(`^SELECT name FROM users WHERE id = \$1$`).
+		WithArgs(1).
+		WithTypes(pgtype.TextOID).
+		WillReturnRows(storage.Table{Headers: []string{"name"}, Rows: [][]any{{"alpha"}}})
+
+	stmt, err := storage.Prepare(ctx, s, storage.NewQuery("SELECT name FROM users WHERE id = $1"))
+	if err != nil {
+		t.Fatalf("prepare: %s", errors.Formatted(err))
+	}
+
+	tx, err := s.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %s", errors.Formatted(err))
+	}
+
+	var name string
+
+	if err = stmt.QueryRow(tx.Context(), []any{1}, &name); err != nil || name != "alpha" {
+		t.Fatalf("query row: got %q, %v", name, err)
+	}
+
+	if err = stmt.Close(); err != nil {
+		t.Fatalf("close: %s", errors.Formatted(err))
+	}
+
+	if deallocated(srv) {
+		t.Fatalf("statement deallocated on a busy connection")
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		t.Fatalf("commit: %s", errors.Formatted(err))
+	}
+
+	// соединение удаляет запрос после возврата в пул, асинхронно
+	for deadline := time.Now().Add(time.Second); !deallocated(srv); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("statement was not deallocated after the connection was released")
+		}
+	}
+}
+
+func TestPrepareProtocol(t *testing.T) {
+	for _, test := range []struct {
+		protocol pg.Protocol
+		extended bool
+		named    bool
+	}{
+		{protocol: pg.ProtocolSimple},
+		{protocol: pg.ProtocolDescribe, extended: true},
+		{protocol: pg.ProtocolExtended, extended: true, named: true},
+	} {
+		t.Run(string(test.protocol), func(t *testing.T) {
+			s, srv := newStorage(t, string(test.protocol))
+			ctx := context.Background()
+
+			srv.Expect(`^SELECT name FROM users WHERE name = (\$1|'alpha')$`).
+				WillReturnRows(storage.Table{Headers: []string{"name"}, Rows: [][]any{{"alpha"}}}).
+				Times(2)
+
+			stmt, err := storage.Prepare(ctx, s, storage.NewBindQuery("SELECT name FROM users WHERE name = :name", nil))
+			if err != nil {
+				t.Fatalf("prepare: %s", errors.Formatted(err))
+			}
+
+			for i := 0; i < 2; i++ {
+				var name string
+
+				if err = stmt.QueryRow(ctx, map[string]any{"name": "alpha"}, &name); err != nil || name != "alpha" {
+					t.Fatalf("query row: got %q, %v", name, err)
+				}
+			}
+
+			if err = stmt.Close(); err != nil {
+				t.Fatalf("close: %s", errors.Formatted(err))
+			}
+
+			var name string
+
+			if err = stmt.QueryRow(ctx, map[string]any{"name": "alpha"}, &name); !errors.Is(err, storage.ErrStatementClosed) {
+				t.Fatalf("query row after close: got %v, want %v", err, storage.ErrStatementClosed)
+			}
+
+			for _, call := range srv.Calls() {
+				if !strings.HasPrefix(call.SQL, "SELECT") {
+					continue
+				}
+
+				// без подготовленных на сервере запросов совместимо с пулерами соединений в режиме транзакций
+				if call.Extended != test.extended || (call.Statement != "") != test.named {
+					t.Fatalf("call %q: got extended %t, statement %q", call.SQL, call.Extended, call.Statement)
+				}
+			}
+		})
+	}
+}
+
+func deallocated(srv *pgtest.Server) bool {
+	for _, call := range srv.Calls() {
+		if strings.HasPrefix(strings.ToLower(call.SQL), "deallocate") {
+			return true
+		}
+	}
+
+	return false
+}
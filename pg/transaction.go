@@ -2,46 +2,194 @@ package pg
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/jackc/pgx/v4"
 	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
 )
 
+// defaultIsolation - уровень изоляции транзакций PostgreSQL по умолчанию
+const defaultIsolation = storage.LevelReadCommitted
+
 type transactionKey struct{}
 
 type pgTransaction struct {
-	ctx context.Context
-	tx  pgx.Tx
+	storage.TxHooks
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	label  string
+	tx     pgx.Tx
+	// opts - опции корневой транзакции, вложенные транзакции наследуют их; для транзакции,
+	// открытой с pgx.TxOptions, восстанавливаются из них
+	opts *storage.TxOptions
+	// parent - внешняя транзакция, для вложенной транзакции на точке сохранения
+	parent *pgTransaction
 }
 
 func (tx *pgTransaction) Context() context.Context {
-	return context.WithValue(tx.ctx, transactionKey{}, tx)
+	return storage.ContextWithTransaction(context.WithValue(tx.ctx, transactionKey{}, tx), tx)
 }
 
 func (tx *pgTransaction) Commit(ctx context.Context) error {
+	defer tx.release()
+
 	if err := tx.tx.Commit(ctx); err != nil {
-		return errors.Wrap(err, "commit transaction")
+		err = errors.Ctx().Str("tx-label", tx.label).Just(wrapPgErr(err, storage.OpCommit, nil, "commit transaction"))
+		tx.RunRollbackHooks(ctx, err)
+
+		return err
+	}
+
+	if tx.parent != nil {
+		tx.MoveTo(&tx.parent.TxHooks)
+	} else {
+		tx.RunCommitHooks(ctx)
 	}
 
 	return nil
 }
 
 func (tx *pgTransaction) Rollback(ctx context.Context) error {
+	defer tx.release()
+
 	if err := tx.tx.Rollback(ctx); err != nil {
-		return errors.Wrap(err, "rollback transaction")
+		err = errors.Ctx().Str("tx-label", tx.label).Just(wrapPgErr(err, storage.OpRollback, nil, "rollback transaction"))
+		tx.RunRollbackHooks(ctx, err)
+
+		return err
 	}
 
+	tx.RunRollbackHooks(ctx, nil)
+
 	return nil
 }
 
-func getPgTxOptions(in ...any) *pgx.TxOptions {
+// beginNested - открывает вложенную транзакцию на точке сохранения внешней транзакции,
+// Commit вложенной транзакции освобождает точку сохранения, Rollback - откатывает к ней
+func (tx *pgTransaction) beginNested(ctx context.Context, options ...any) (*pgTransaction, error) {
+	opts, err := storage.NestedTxOptions(tx.opts, defaultIsolation, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	nested := &pgTransaction{ctx: ctx, parent: tx, label: tx.label, opts: tx.opts}
+
+	if opts != nil {
+		if opts.Label != "" {
+			nested.label = opts.Label
+		}
+
+		if opts.Timeout > 0 {
+			nested.ctx, nested.cancel = context.WithTimeout(ctx, opts.Timeout)
+		}
+	}
+
+	if nested.tx, err = tx.tx.Begin(ctx); err != nil {
+		nested.release()
+
+		return nil, wrapPgErr(err, storage.OpSavepoint, nil, "create savepoint")
+	}
+
+	return nested, nil
+}
+
+func (tx *pgTransaction) release() {
+	if tx.cancel != nil {
+		tx.cancel()
+	}
+}
+
+func getPgTxOptions(in ...any) (*pgx.TxOptions, *storage.TxOptions, error) {
 	if len(in) == 0 {
-		return nil
+		return nil, nil, nil
 	}
 
-	if opts, ok := in[0].(*pgx.TxOptions); ok {
-		return opts
+	if opts, ok := storage.TxOptionsFrom(in...); ok {
+		pgOpts, err := convertTxOptions(opts)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return pgOpts, opts, nil
 	}
 
-	return nil
+	switch opts := in[0].(type) {
+	case nil:
+		return nil, nil, nil
+	case *pgx.TxOptions:
+		if opts == nil {
+			return nil, nil, nil
+		}
+
+		return opts, fromPgTxOptions(opts), nil
+	case pgx.TxOptions:
+		return &opts, fromPgTxOptions(&opts), nil
+	case *storage.TxOptions:
+		return nil, nil, nil
+	default:
+		return nil, nil, errors.Ctx().
+			Str("type", fmt.Sprintf("%T", opts)).
+			Just(storage.ErrUnsupportedTxOptions)
+	}
+}
+
+// fromPgTxOptions - переносимые опции транзакции, открытой с pgx.TxOptions: по ним проверяются
+// опции вложенных транзакций
+func fromPgTxOptions(opts *pgx.TxOptions) *storage.TxOptions {
+	txOpts := &storage.TxOptions{
+		ReadOnly:   opts.AccessMode == pgx.ReadOnly,
+		Deferrable: opts.DeferrableMode == pgx.Deferrable,
+	}
+
+	switch opts.IsoLevel {
+	case pgx.ReadUncommitted:
+		txOpts.Isolation = storage.LevelReadUncommitted
+	case pgx.ReadCommitted:
+		txOpts.Isolation = storage.LevelReadCommitted
+	case pgx.RepeatableRead:
+		txOpts.Isolation = storage.LevelRepeatableRead
+	case pgx.Serializable:
+		txOpts.Isolation = storage.LevelSerializable
+	}
+
+	return txOpts
+}
+
+func convertTxOptions(opts *storage.TxOptions) (*pgx.TxOptions, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	pgOpts := &pgx.TxOptions{}
+
+	switch opts.Isolation {
+	case storage.LevelReadUncommitted:
+		pgOpts.IsoLevel = pgx.ReadUncommitted
+	case storage.LevelReadCommitted:
+		pgOpts.IsoLevel = pgx.ReadCommitted
+	case storage.LevelRepeatableRead:
+		pgOpts.IsoLevel = pgx.RepeatableRead
+	case storage.LevelSerializable:
+		pgOpts.IsoLevel = pgx.Serializable
+	}
+
+	if opts.ReadOnly {
+		pgOpts.AccessMode = pgx.ReadOnly
+	}
+
+	if opts.Deferrable {
+		if !opts.ReadOnly || opts.Isolation != storage.LevelSerializable {
+			return nil, errors.Ctx().
+				Stringer("isolation", opts.Isolation).
+				Bool("read-only", opts.ReadOnly).
+				Wrap(storage.ErrUnsupportedTxOptions, "deferrable mode requires serializable read only transaction")
+		}
+
+		pgOpts.DeferrableMode = pgx.Deferrable
+	}
+
+	return pgOpts, nil
 }
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"sync/atomic"
+
+	"gopkg.in/gomisc/errors.v1"
+)
+
+const (
+	ErrStatementClosed = errors.Const("statement is closed")
+	ErrStatementArgs   = errors.Const("positional statement arguments must be []any")
+)
+
+var _ Statement = (*queryStatement)(nil)
+
+type (
+	// Preparer - хранилище с явной подготовкой запросов на сервере
+	Preparer interface {
+		// Prepare - готовит запрос к повторному выполнению, параметры query не используются.
+		// Запрос с именованными параметрами (NewBindQuery) выполняется с источником именованных
+		// параметров, остальные - с []any позиционных параметров
+		Prepare(ctx context.Context, query Query) (Statement, error)
+	}
+
+	// Statement - подготовленный запрос. Параметры выполнения args задаются как Query.Params:
+	// []any позиционных параметров или map, структура для запроса с именованными параметрами.
+	// В транзакции контекста запрос выполняется в ней
+	Statement interface {
+		io.Closer
+		// Exec - выполняет запрос, который ничего не возвращает
+		Exec(ctx context.Context, args any) (sql.Result, error)
+		// Query - выполняет запрос с результатом произвольного типа, аналогично Storage.Query
+		Query(ctx context.Context, args any, result any) error
+		// QueryRow - выполняет запрос и сканирует колонки единственной строки результата в dest,
+		// при отсутствии строк возвращает ErrEmptyResult
+		QueryRow(ctx context.Context, args any, dest ...any) error
+	}
+
+	// queryStatement - запрос хранилища без подготовки, выполняемый методами Storage
+	queryStatement struct {
+		storage Storage
+		query   Query
+		closed  atomic.Bool
+	}
+)
+
+// Prepare - готовит запрос средствами хранилища, если оно реализует Preparer, иначе возвращает
+// запрос, который при каждом выполнении передается методам Storage с параметрами выполнения.
+// Хранилища маршрутизации и шардирования выбирают узел при каждом выполнении
+func Prepare(ctx context.Context, s Storage, query Query) (Statement, error) {
+	if preparer, ok := s.(Preparer); ok {
+		return preparer.Prepare(ctx, query)
+	}
+
+	return NewQueryStatement(s, query)
+}
+
+// NewQueryStatement - запрос без подготовки на сервере, который при каждом выполнении передается
+// методам Storage с параметрами выполнения. Драйверы возвращают его из Prepare, когда подготовленные
+// на сервере запросы недоступны
+func NewQueryStatement(s Storage, query Query) (Statement, error) {
+	if _, ok := query.Query().(string); !ok {
+		return nil, errors.Ctx().Stringer("query", query).Just(ErrNotSupported)
+	}
+
+	return &queryStatement{storage: s, query: query}, nil
+}
+
+// StatementQuery - запрос подготовленного запроса query с параметрами выполнения args:
+// с именованными параметрами, если они были у query, иначе с позиционными
+func StatementQuery(query Query, args any) (Query, error) {
+	sql, _ := query.Query().(string)
+
+	if _, positional := query.Params().([]any); positional {
+		params, err := StatementArgs(nil, args)
+		if err != nil {
+			return nil, errors.Ctx().Stringer("query", query).Just(err)
+		}
+
+		return NewNamedQuery(QueryName(query), sql, params...), nil
+	}
+
+	if !IsNamedArgs(args) {
+		return nil, errors.Ctx().Stringer("query", query).Any("args", args).Just(ErrNamedArgType)
+	}
+
+	return NewNamedBindQuery(QueryName(query), sql, args), nil
+}
+
+// StatementArgs - позиционные параметры выполнения подготовленного запроса: значения именованных
+// параметров запроса named из args или args как []any, если named не задан
+func StatementArgs(named *NamedSQL, args any) ([]any, error) {
+	if named != nil {
+		return named.Args(args)
+	}
+
+	if args == nil {
+		return nil, nil
+	}
+
+	params, ok := args.([]any)
+	if !ok {
+		return nil, errors.Ctx().Any("args", args).Just(ErrStatementArgs)
+	}
+
+	return params, nil
+}
+
+// Close - имплементация io.Closer
+func (st *queryStatement) Close() error {
+	st.closed.Store(true)
+
+	return nil
+}
+
+// Exec - имплементация Statement
+func (st *queryStatement) Exec(ctx context.Context, args any) (sql.Result, error) {
+	query, err := st.bind(args)
+	if err != nil {
+		return nil, err
+	}
+
+	return st.storage.Exec(ctx, query)
+}
+
+// Query - имплементация Statement
+func (st *queryStatement) Query(ctx context.Context, args any, result any) error {
+	query, err := st.bind(args)
+	if err != nil {
+		return err
+	}
+
+	return st.storage.Query(ctx, query, result)
+}
+
+// QueryRow - имплементация Statement
+func (st *queryStatement) QueryRow(ctx context.Context, args any, dest ...any) error {
+	query, err := st.bind(args)
+	if err != nil {
+		return err
+	}
+
+	return st.storage.QueryRow(ctx, query, dest...)
+}
+
+func (st *queryStatement) bind(args any) (Query, error) {
+	if st.closed.Load() {
+		return nil, errors.Ctx().Stringer("query", st.query).Just(ErrStatementClosed)
+	}
+
+	return StatementQuery(st.query, args)
+}
@@ -0,0 +1,72 @@
+package storage
+
+var (
+	_ Query      = (*sqlQuery)(nil)
+	_ NamedQuery = (*sqlQuery)(nil)
+)
+
+type (
+	// NamedQuery - запрос аннотированный именем, используемым в трейсинге и контексте ошибок
+	NamedQuery interface {
+		Query
+		// Name - возвращает имя запроса
+		Name() string
+	}
+
+	sqlQuery struct {
+		name   string
+		sql    string
+		params []any
+	}
+)
+
+// NewQuery - конструктор запроса с позиционными параметрами
+func NewQuery(sql string, params ...any) Query {
+	return newSQLQuery("", sql, params)
+}
+
+// NewNamedQuery - конструктор именованного запроса с позиционными параметрами
+func NewNamedQuery(name, sql string, params ...any) NamedQuery {
+	return newSQLQuery(name, sql, params)
+}
+
+// QueryName - возвращает имя запроса, если запрос его поддерживает
+func QueryName(query Query) string {
+	if named, ok := query.(NamedQuery); ok {
+		return named.Name()
+	}
+
+	return ""
+}
+
+func newSQLQuery(name, sql string, params []any) *sqlQuery {
+	if params == nil {
+		params = []any{}
+	}
+
+	return &sqlQuery{name: name, sql: sql, params: params}
+}
+
+// Name - имплементация NamedQuery
+func (q *sqlQuery) Name() string {
+	return q.name
+}
+
+// Query - имплементация Query
+func (q *sqlQuery) Query() interface{} {
+	return q.sql
+}
+
+// Params - имплементация Query
+func (q *sqlQuery) Params() interface{} {
+	return q.params
+}
+
+// String - имплементация fmt.Stringer
+func (q *sqlQuery) String() string {
+	if q.name != "" {
+		return q.name + ": " + q.sql
+	}
+
+	return q.sql
+}
@@ -0,0 +1,259 @@
+package storage
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/gomisc/errors.v1"
+)
+
+const (
+	ErrUnsupportedDriver = errors.Const("unsupported database driver")
+	ErrDriverRegistered  = errors.Const("database driver already registered")
+	ErrInvalidDriver     = errors.Const("invalid database driver registration")
+)
+
+var registry = &driverRegistry{
+	drivers: make(map[string]*registeredDriver),
+	aliases: make(map[string]string),
+}
+
+type (
+	// DriverFunc - конструктор хранилища по DSN, разобранному в URL
+	DriverFunc func(ctx context.Context, uri *url.URL) (Storage, error)
+
+	// DSNRewriter - функция, изменяющая DSN перед открытием хранилища: подстановка секретов,
+	// параметров по умолчанию, замена схемы на инструментированный вариант драйвера
+	DSNRewriter func(uri *url.URL) error
+
+	// DriverInfo - описание зарегистрированного драйвера
+	DriverInfo struct {
+		// Scheme - основная схема DSN драйвера
+		Scheme string
+		// Aliases - дополнительные схемы DSN, открываемые тем же драйвером
+		Aliases []string
+	}
+
+	registeredDriver struct {
+		open      DriverFunc
+		aliases   []string
+		rewriters []DSNRewriter
+	}
+
+	driverRegistry struct {
+		sync.RWMutex
+		drivers   map[string]*registeredDriver
+		aliases   map[string]string
+		rewriters []DSNRewriter
+	}
+)
+
+// RegisterDriver - регистрирует драйвер для схемы DSN и ее псевдонимов. Предназначена для вызова
+// из init пакета драйвера, при повторной регистрации схемы паникует так же, как sql.Register
+func RegisterDriver(scheme string, open DriverFunc, aliases ...string) {
+	scheme = normalizeScheme(scheme)
+
+	if scheme == "" || open == nil {
+		panic(errors.Ctx().Str("scheme", scheme).Just(ErrInvalidDriver))
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	if registry.registered(scheme) {
+		panic(errors.Ctx().Str("scheme", scheme).Just(ErrDriverRegistered))
+	}
+
+	registry.drivers[scheme] = &registeredDriver{open: open}
+
+	for _, alias := range aliases {
+		registry.alias(alias, scheme)
+	}
+}
+
+// RegisterAlias - регистрирует дополнительную схему DSN для уже зарегистрированного драйвера
+func RegisterAlias(alias, scheme string) {
+	scheme = normalizeScheme(scheme)
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	if _, ok := registry.drivers[scheme]; !ok {
+		panic(errors.Ctx().Str("scheme", scheme).Just(ErrUnsupportedDriver))
+	}
+
+	registry.alias(alias, scheme)
+}
+
+// RegisterDSNRewriter - регистрирует функцию изменения DSN. С пустой схемой функция применяется
+// ко всем DSN до выбора драйвера и может изменить схему, иначе - только к DSN драйвера scheme
+// после разрешения псевдонимов. Функции применяются в порядке регистрации
+func RegisterDSNRewriter(scheme string, rewrite DSNRewriter) {
+	scheme = normalizeScheme(scheme)
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	if scheme == "" {
+		registry.rewriters = append(registry.rewriters, rewrite)
+
+		return
+	}
+
+	driver, ok := registry.drivers[scheme]
+	if !ok {
+		panic(errors.Ctx().Str("scheme", scheme).Just(ErrUnsupportedDriver))
+	}
+
+	driver.rewriters = append(driver.rewriters, rewrite)
+}
+
+// Drivers - возвращает зарегистрированные драйверы, упорядоченные по схеме
+func Drivers() []DriverInfo {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	drivers := make([]DriverInfo, 0, len(registry.drivers))
+
+	for scheme, driver := range registry.drivers {
+		drivers = append(drivers, DriverInfo{
+			Scheme:  scheme,
+			Aliases: append([]string(nil), driver.aliases...),
+		})
+	}
+
+	sort.Slice(drivers, func(i, j int) bool {
+		return drivers[i].Scheme < drivers[j].Scheme
+	})
+
+	return drivers
+}
+
+// Open - открывает хранилище по DSN драйвером, зарегистрированным для его схемы
+func Open(ctx context.Context, dsn string) (Storage, error) {
+	uri, err := ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return OpenURL(ctx, uri)
+}
+
+// ParseDSN - разбирает DSN в URL. В отличие от url.Parse допускает список узлов через запятую,
+// в котором порт указан не у последнего узла: postgres://user@h1:5432,h2/db
+func ParseDSN(dsn string) (*url.URL, error) {
+	uri, err := url.Parse(dsn)
+	if err == nil {
+		return uri, nil
+	}
+
+	scheme, rest, found := strings.Cut(dsn, "://")
+	if !found {
+		return nil, errors.Wrap(err, "parse dsn")
+	}
+
+	end := strings.IndexAny(rest, "/?#")
+	if end < 0 {
+		end = len(rest)
+	}
+
+	userinfo, hosts := "", rest[:end]
+	if at := strings.LastIndex(hosts, "@"); at >= 0 {
+		userinfo, hosts = hosts[:at+1], hosts[at+1:]
+	}
+
+	if !strings.Contains(hosts, ",") {
+		return nil, errors.Wrap(err, "parse dsn")
+	}
+
+	// список узлов разбирается драйвером, url.Parse проверяет остальные части DSN
+	if uri, err = url.Parse(scheme + "://" + userinfo + "hosts" + rest[end:]); err != nil {
+		return nil, errors.Wrap(err, "parse dsn")
+	}
+
+	uri.Host = hosts
+
+	return uri, nil
+}
+
+// OpenURL - открывает хранилище по разобранному DSN. Перед выбором драйвера применяются
+// общие функции изменения DSN, после - функции драйвера. Исходный URL не изменяется
+func OpenURL(ctx context.Context, uri *url.URL) (Storage, error) {
+	target := *uri
+
+	if uri.User != nil {
+		user := *uri.User
+		target.User = &user
+	}
+
+	registry.RLock()
+	rewriters := append([]DSNRewriter(nil), registry.rewriters...)
+	registry.RUnlock()
+
+	for _, rewrite := range rewriters {
+		if err := rewrite(&target); err != nil {
+			return nil, errors.Ctx().Str("uri", uri.Redacted()).Wrap(err, "rewrite dsn")
+		}
+	}
+
+	scheme := normalizeScheme(target.Scheme)
+
+	registry.RLock()
+
+	if canonical, ok := registry.aliases[scheme]; ok {
+		scheme = canonical
+	}
+
+	driver, ok := registry.drivers[scheme]
+	if ok {
+		rewriters = append(rewriters[:0], driver.rewriters...)
+	}
+
+	registry.RUnlock()
+
+	if !ok {
+		return nil, errors.Ctx().Str("scheme", target.Scheme).Just(ErrUnsupportedDriver)
+	}
+
+	for _, rewrite := range rewriters {
+		if err := rewrite(&target); err != nil {
+			return nil, errors.Ctx().Str("uri", uri.Redacted()).Wrap(err, "rewrite driver dsn")
+		}
+	}
+
+	s, err := driver.open(ctx, &target)
+	if err != nil {
+		return nil, errors.Ctx().Str("scheme", scheme).Wrap(err, "open storage")
+	}
+
+	return s, nil
+}
+
+func (r *driverRegistry) registered(scheme string) bool {
+	_, isDriver := r.drivers[scheme]
+	_, isAlias := r.aliases[scheme]
+
+	return isDriver || isAlias
+}
+
+func (r *driverRegistry) alias(alias, scheme string) {
+	alias = normalizeScheme(alias)
+
+	if alias == "" {
+		panic(errors.Ctx().Str("scheme", scheme).Just(ErrInvalidDriver))
+	}
+
+	if r.registered(alias) {
+		panic(errors.Ctx().Str("scheme", alias).Just(ErrDriverRegistered))
+	}
+
+	r.aliases[alias] = scheme
+	r.drivers[scheme].aliases = append(r.drivers[scheme].aliases, alias)
+}
+
+func normalizeScheme(scheme string) string {
+	return strings.ToLower(strings.TrimSpace(scheme))
+}
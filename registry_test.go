@@ -0,0 +1,220 @@
+package storage_test
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+	"gopkg.in/gomisc/storage.v1/storagetest/fake"
+)
+
+// openURL - драйвер, сохраняющий переданный ему DSN
+func openURL(opened **url.URL) storage.DriverFunc {
+	return func(ctx context.Context, uri *url.URL) (storage.Storage, error) {
+		*opened = uri
+
+		return &fake.Storage{}, nil
+	}
+}
+
+func TestRegisterDriver(t *testing.T) {
+	var opened *url.URL
+
+	storage.RegisterDriver("Registry-Test", openURL(&opened), "registry-alias", " REGISTRY-SHORT ")
+	storage.RegisterAlias("registry-late", "registry-test")
+
+	var info storage.DriverInfo
+	for _, driver := range storage.Drivers() {
+		if driver.Scheme == "registry-test" {
+			info = driver
+		}
+	}
+
+	if want := []string{"registry-alias", "registry-short", "registry-late"}; !reflect.DeepEqual(info.Aliases, want) {
+		t.Fatalf("aliases: got %q, want %q", info.Aliases, want)
+	}
+
+	for _, dsn := range []string{
+		"registry-test://db.local/app",
+		"REGISTRY-ALIAS://db.local/app",
+		"registry-short://db.local/app",
+		"registry-late://db.local/app",
+	} {
+		opened = nil
+
+		if _, err := storage.Open(context.Background(), dsn); err != nil {
+			t.Fatalf("open %s: %s", dsn, errors.Formatted(err))
+		}
+
+		// драйвер получает DSN со схемой псевдонима
+		if opened == nil || opened.String() != strings.ToLower(dsn) {
+			t.Fatalf("open %s: driver got %v", dsn, opened)
+		}
+	}
+}
+
+func TestRegisterDriverInvalid(t *testing.T) {
+	var opened *url.URL
+
+	storage.RegisterDriver("registry-dup", openURL(&opened), "registry-dup-alias")
+
+	for _, test := range []struct {
+		name     string
+		register func()
+		err      error
+	}{
+		{
+			name:     "duplicate scheme",
+			register: func() { storage.RegisterDriver("REGISTRY-DUP", openURL(&opened)) },
+			err:      storage.ErrDriverRegistered,
+		},
+		{
+			name:     "scheme taken by alias",
+			register: func() { storage.RegisterDriver("registry-dup-alias", openURL(&opened)) },
+			err:      storage.ErrDriverRegistered,
+		},
+		{
+			name:     "alias taken by scheme",
+			register: func() { storage.RegisterDriver("registry-other", openURL(&opened), "registry-dup") },
+			err:      storage.ErrDriverRegistered,
+		},
+		{
+			name:     "duplicate alias",
+			register: func() { storage.RegisterAlias("registry-dup-alias", "registry-dup") },
+			err:      storage.ErrDriverRegistered,
+		},
+		{
+			name:     "alias of unknown driver",
+			register: func() { storage.RegisterAlias("registry-alias-x", "registry-unknown") },
+			err:      storage.ErrUnsupportedDriver,
+		},
+		{
+			name:     "empty scheme",
+			register: func() { storage.RegisterDriver(" ", openURL(&opened)) },
+			err:      storage.ErrInvalidDriver,
+		},
+		{
+			name:     "empty alias",
+			register: func() { storage.RegisterDriver("registry-empty-alias", openURL(&opened), "") },
+			err:      storage.ErrInvalidDriver,
+		},
+		{
+			name:     "nil constructor",
+			register: func() { storage.RegisterDriver("registry-nil", nil) },
+			err:      storage.ErrInvalidDriver,
+		},
+		{
+			name:     "rewriter of unknown driver",
+			register: func() { storage.RegisterDSNRewriter("registry-unknown", func(*url.URL) error { return nil }) },
+			err:      storage.ErrUnsupportedDriver,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				err, _ := recover().(error)
+				if !errors.Is(err, test.err) {
+					t.Fatalf("register: got panic %v, want %v", err, test.err)
+				}
+			}()
+
+			test.register()
+		})
+	}
+}
+
+func TestParseDSN(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		dsn  string
+		host string
+		path string
+		user string
+		fail bool
+	}{
+		{name: "single host", dsn: "postgres://app@db.local:5432/shop", host: "db.local:5432", path: "/shop", user: "app"},
+		{name: "host list", dsn: "postgres://app:secret@h1:5432,h2/shop?sslmode=disable", host: "h1:5432,h2", path: "/shop", user: "app"},
+		{name: "host list without path", dsn: "postgres://h1:5432,h2", host: "h1:5432,h2"},
+		{name: "invalid port", dsn: "postgres://db.local:port/shop", fail: true},
+		{name: "invalid escape in host list dsn", dsn: "postgres://h1:5432,h2/shop?%zz=1#%zz", fail: true},
+		{name: "invalid escape without scheme", dsn: "db.local/%zz", fail: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			uri, err := storage.ParseDSN(test.dsn)
+
+			if test.fail {
+				if err == nil {
+					t.Fatalf("parse: got %s, want error", uri)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parse: %s", errors.Formatted(err))
+			}
+
+			if uri.Host != test.host || uri.Path != test.path || uri.User.Username() != test.user {
+				t.Fatalf("parse: got host %q, path %q, user %q", uri.Host, uri.Path, uri.User.Username())
+			}
+		})
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	const errOpen = errors.Const("connection refused")
+
+	storage.RegisterDriver("registry-fail", func(context.Context, *url.URL) (storage.Storage, error) {
+		return nil, errOpen
+	})
+
+	var opened *url.URL
+
+	storage.RegisterDriver("registry-rewrite", openURL(&opened), "registry-rewrite-alias")
+	storage.RegisterDSNRewriter("registry-rewrite", func(uri *url.URL) error {
+		if uri.Query().Has("fail") {
+			return errFailed
+		}
+
+		uri.User = url.UserPassword(uri.User.Username(), "secret")
+
+		return nil
+	})
+
+	for _, test := range []struct {
+		name string
+		dsn  string
+		err  error
+	}{
+		{name: "unsupported scheme", dsn: "registry-unknown://db.local/app", err: storage.ErrUnsupportedDriver},
+		{name: "malformed dsn", dsn: "registry-fail://db.local:port/app"},
+		{name: "driver error", dsn: "registry-fail://db.local/app", err: errOpen},
+		{name: "rewriter error", dsn: "registry-rewrite-alias://db.local/app?fail=1", err: errFailed},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := storage.Open(context.Background(), test.dsn)
+			if err == nil || test.err != nil && !errors.Is(err, test.err) {
+				t.Fatalf("open: got %v, %v; want %v", s, err, test.err)
+			}
+		})
+	}
+
+	// функции драйвера применяются к DSN его псевдонимов и не изменяют исходный URL
+	uri, _ := url.Parse("registry-rewrite-alias://app@db.local/app")
+
+	if _, err := storage.OpenURL(context.Background(), uri); err != nil {
+		t.Fatalf("open: %s", errors.Formatted(err))
+	}
+
+	if password, _ := opened.User.Password(); password != "secret" {
+		t.Fatalf("rewritten dsn: got %s", opened.Redacted())
+	}
+
+	if _, ok := uri.User.Password(); ok {
+		t.Fatalf("source dsn changed: got %s", uri.Redacted())
+	}
+}
@@ -0,0 +1,48 @@
+package router
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+)
+
+type (
+	// Balancer - выбор реплики для чтения
+	Balancer interface {
+		// Pick - возвращает индекс реплики из n
+		Pick(ctx context.Context, n int) int
+	}
+
+	// BalancerFunc - функция, реализующая Balancer
+	BalancerFunc func(ctx context.Context, n int) int
+
+	roundRobin struct {
+		next atomic.Uint64
+	}
+
+	random struct{}
+)
+
+// RoundRobin - балансировщик, выбирающий реплики по кругу
+func RoundRobin() Balancer {
+	return &roundRobin{}
+}
+
+// Random - балансировщик, выбирающий реплику случайно
+func Random() Balancer {
+	return random{}
+}
+
+// Pick - имплементация Balancer
+func (fn BalancerFunc) Pick(ctx context.Context, n int) int {
+	return fn(ctx, n)
+}
+
+func (b *roundRobin) Pick(_ context.Context, n int) int {
+	return int((b.next.Add(1) - 1) % uint64(n))
+}
+
+func (random) Pick(_ context.Context, n int) int {
+	// nolint: gosec
+	return rand.Intn(n)
+}
@@ -0,0 +1,278 @@
+// Package router - хранилище, распределяющее запросы между ведущим узлом и репликами:
+// изменения и транзакции выполняются на ведущем узле, чтение - на репликах. Вызывающий,
+// который недавно писал, читает с ведущего узла и видит собственные изменения
+package router
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"sync"
+	"time"
+
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+var (
+	_ storage.Storage      = (*router)(nil)
+	_ storage.Batcher      = (*router)(nil)
+	_ storage.BulkLoader   = (*router)(nil)
+	_ storage.BulkExporter = (*router)(nil)
+)
+
+type (
+	// Option - опция маршрутизатора
+	Option func(r *router)
+
+	// CallerKeyFunc - возвращает ключ вызывающего для закрепления за ведущим узлом после записи
+	CallerKeyFunc func(ctx context.Context) (string, bool)
+
+	forcePrimaryKey struct{}
+	callerKey       struct{}
+
+	router struct {
+		primary  storage.Storage
+		replicas []storage.Storage
+		balancer Balancer
+		caller   CallerKeyFunc
+		// window - время после записи, в течение которого вызывающий читает с ведущего узла
+		window time.Duration
+
+		mu     sync.Mutex
+		writes map[string]time.Time
+		pruned time.Time
+	}
+)
+
+// New - конструктор маршрутизатора. Exec, Begin, QueryRow и Query без результата выполняются на primary,
+// Query с результатом и Iterate - на реплике, выбранной балансировщиком (по умолчанию по кругу).
+// Без реплик, внутри транзакции и в контексте ForcePrimary все запросы идут на primary.
+// QueryRow считается записью: через него обычно выполняются INSERT ... RETURNING и SELECT ... FOR UPDATE.
+// Query с результатом и Iterate считаются чтением, изменяющие запросы с результатом через них нужно
+// выполнять в контексте ForcePrimary
+func New(primary storage.Storage, replicas []storage.Storage, opts ...Option) storage.Storage {
+	r := &router{
+		primary:  primary,
+		replicas: append([]storage.Storage(nil), replicas...),
+		balancer: RoundRobin(),
+		caller:   CallerFromContext,
+		writes:   make(map[string]time.Time),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// WithBalancer - балансировщик чтения между репликами
+func WithBalancer(balancer Balancer) Option {
+	return func(r *router) {
+		r.balancer = balancer
+	}
+}
+
+// WithStickiness - после записи вызывающий читает с ведущего узла в течение window,
+// чтобы видеть собственные изменения несмотря на задержку репликации
+func WithStickiness(window time.Duration) Option {
+	return func(r *router) {
+		r.window = window
+	}
+}
+
+// WithCallerKey - способ определения вызывающего по контексту, по умолчанию ключ задается WithCaller
+func WithCallerKey(fn CallerKeyFunc) Option {
+	return func(r *router) {
+		r.caller = fn
+	}
+}
+
+// ForcePrimary - возвращает контекст, запросы в котором выполняются на ведущем узле
+func ForcePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forcePrimaryKey{}, true)
+}
+
+// IsPrimaryForced - требует ли контекст выполнения запросов на ведущем узле
+func IsPrimaryForced(ctx context.Context) bool {
+	forced, _ := ctx.Value(forcePrimaryKey{}).(bool)
+
+	return forced
+}
+
+// WithCaller - возвращает контекст с ключом вызывающего (пользователь, сессия), за которым
+// закрепляется ведущий узел после записи
+func WithCaller(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, callerKey{}, key)
+}
+
+// CallerFromContext - ключ вызывающего, заданный WithCaller
+func CallerFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(callerKey{}).(string)
+
+	return key, ok
+}
+
+// Close - закрывает ведущий узел и реплики
+func (r *router) Close() error {
+	err := r.primary.Close()
+
+	for _, replica := range r.replicas {
+		err = errors.And(err, replica.Close())
+	}
+
+	return err
+}
+
+// Begin - открывает транзакцию на ведущем узле, после ее фиксации вызывающий закрепляется за ведущим узлом
+func (r *router) Begin(ctx context.Context, opts ...any) (storage.Transaction, error) {
+	tx, err := r.primary.Begin(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, ok := r.callerKey(ctx); ok {
+		tx.OnCommit(func(context.Context) {
+			r.markWrite(key)
+		})
+	}
+
+	return tx, nil
+}
+
+// Exec - выполняет запрос на ведущем узле
+func (r *router) Exec(ctx context.Context, query storage.Query) (sql.Result, error) {
+	defer r.written(ctx)
+
+	return r.primary.Exec(ctx, query)
+}
+
+// Query - выполняет запрос без результата на ведущем узле, с результатом - на реплике
+func (r *router) Query(ctx context.Context, query storage.Query, result any) error {
+	if result == nil {
+		defer r.written(ctx)
+
+		return r.primary.Query(ctx, query, nil)
+	}
+
+	return r.reader(ctx).Query(ctx, query, result)
+}
+
+// QueryRow - выполняет запрос на ведущем узле
+func (r *router) QueryRow(ctx context.Context, query storage.Query, dest ...any) error {
+	defer r.written(ctx)
+
+	return r.primary.QueryRow(ctx, query, dest...)
+}
+
+// Iterate - выполняет запрос на реплике
+func (r *router) Iterate(ctx context.Context, query storage.Query) (storage.Iterator, error) {
+	return r.reader(ctx).Iterate(ctx, query)
+}
+
+// SendBatch - имплементация storage.Batcher: пакет с запросами без результата или QueryRow
+// выполняется на ведущем узле, пакет только из запросов Query с результатом - на реплике
+func (r *router) SendBatch(ctx context.Context, batch *storage.Batch) error {
+	for _, item := range batch.Items() {
+		if item.Kind != storage.BatchQuery || item.Result == nil {
+			defer r.written(ctx)
+
+			return storage.SendBatch(ctx, r.primary, batch)
+		}
+	}
+
+	return storage.SendBatch(ctx, r.reader(ctx), batch)
+}
+
+// CopyFrom - имплементация storage.BulkLoader, загрузка выполняется на ведущем узле
+func (r *router) CopyFrom(ctx context.Context, table string, columns []string, source any) (int64, error) {
+	defer r.written(ctx)
+
+	return storage.CopyFrom(ctx, r.primary, table, columns, source)
+}
+
+// CopyTo - имплементация storage.BulkExporter, выгрузка выполняется на узле для чтения
+func (r *router) CopyTo(ctx context.Context, query storage.Query, w io.Writer, format storage.CopyFormat) (int64, error) {
+	return storage.CopyTo(ctx, r.reader(ctx), query, w, format)
+}
+
+// reader - хранилище для чтения: ведущий узел, если этого требует контекст или вызывающий
+// недавно писал, иначе реплика, выбранная балансировщиком
+func (r *router) reader(ctx context.Context) storage.Storage {
+	if len(r.replicas) == 0 || IsPrimaryForced(ctx) {
+		return r.primary
+	}
+
+	// транзакция открыта на ведущем узле, запросы в ней выполняются там же
+	if _, inTx := storage.TransactionFromContext(ctx); inTx {
+		return r.primary
+	}
+
+	if r.sticky(ctx) {
+		return r.primary
+	}
+
+	i := r.balancer.Pick(ctx, len(r.replicas))
+	if i < 0 || i >= len(r.replicas) {
+		i = 0
+	}
+
+	return r.replicas[i]
+}
+
+func (r *router) callerKey(ctx context.Context) (string, bool) {
+	if r.window <= 0 || r.caller == nil {
+		return "", false
+	}
+
+	return r.caller(ctx)
+}
+
+// written - отмечает запись вызывающего вне транзакции, запись в транзакции отмечается при ее фиксации
+func (r *router) written(ctx context.Context) {
+	if _, inTx := storage.TransactionFromContext(ctx); inTx {
+		return
+	}
+
+	if key, ok := r.callerKey(ctx); ok {
+		r.markWrite(key)
+	}
+}
+
+func (r *router) markWrite(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.writes[key] = now
+
+	// записи старше окна не влияют на маршрутизацию, они удаляются не чаще раза в окно
+	if now.Sub(r.pruned) < r.window {
+		return
+	}
+
+	for caller, at := range r.writes {
+		if now.Sub(at) >= r.window {
+			delete(r.writes, caller)
+		}
+	}
+
+	r.pruned = now
+}
+
+// sticky - писал ли вызывающий в течение окна закрепления
+func (r *router) sticky(ctx context.Context) bool {
+	key, ok := r.callerKey(ctx)
+	if !ok {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	at, ok := r.writes[key]
+
+	return ok && time.Since(at) < r.window
+}
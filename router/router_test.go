@@ -0,0 +1,265 @@
+package router_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+	"gopkg.in/gomisc/storage.v1/router"
+	"gopkg.in/gomisc/storage.v1/storagetest/fake"
+)
+
+var (
+	selectQuery = storage.NewQuery("SELECT id, name FROM users")
+	updateQuery = storage.NewQuery("UPDATE users SET name = 'alpha'")
+)
+
+type cluster struct {
+	primary  *fake.Storage
+	replicas []*fake.Storage
+	storage  storage.Storage
+}
+
+func newCluster(t *testing.T, replicas int, opts ...router.Option) *cluster {
+	t.Helper()
+
+	c := &cluster{primary: fake.New(t)}
+	nodes := make([]storage.Storage, 0, replicas)
+
+	for i := 0; i < replicas; i++ {
+		replica := fake.New(t)
+
+		c.replicas = append(c.replicas, replica)
+		nodes = append(nodes, replica)
+	}
+
+	c.storage = router.New(c.primary, nodes, opts...)
+
+	return c
+}
+
+// read - выполняет чтение и возвращает номер узла, на котором оно выполнено: -1 для ведущего
+func (c *cluster) read(t *testing.T, ctx context.Context) int {
+	t.Helper()
+
+	nodes := append([]*fake.Storage{c.primary}, c.replicas...)
+	before := make([]int, len(nodes))
+
+	for i, node := range nodes {
+		before[i] = len(node.Calls())
+		node.ExpectQuery(`^SELECT`).Maybe()
+	}
+
+	var table storage.Table
+
+	if err := c.storage.Query(ctx, selectQuery, &table); err != nil {
+		t.Fatalf("query: %s", errors.Formatted(err))
+	}
+
+	for i, node := range nodes {
+		if len(node.Calls()) > before[i] {
+			return i - 1
+		}
+	}
+
+	t.Fatalf("query was not executed")
+
+	return 0
+}
+
+func TestRoundRobin(t *testing.T) {
+	c := newCluster(t, 3)
+	ctx := context.Background()
+
+	var got []int
+
+	for i := 0; i < 6; i++ {
+		got = append(got, c.read(t, ctx))
+	}
+
+	want := []int{0, 1, 2, 0, 1, 2}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("reads: got nodes %v, want %v", got, want)
+		}
+	}
+}
+
+func TestRouting(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		replicas int
+		ctx      func(ctx context.Context) context.Context
+		want     int
+	}{
+		// балансировщик возвращает индекс вне диапазона, используется первая реплика
+		{name: "replica", replicas: 2, want: 0},
+		{name: "no replicas", want: -1},
+		{name: "force primary", replicas: 2, ctx: router.ForcePrimary, want: -1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := newCluster(t, test.replicas, router.WithBalancer(router.BalancerFunc(func(context.Context, int) int {
+				return -1
+			})))
+
+			ctx := context.Background()
+			if test.ctx != nil {
+				ctx = test.ctx(ctx)
+			}
+
+			if node := c.read(t, ctx); node != test.want {
+				t.Fatalf("read: got node %d, want %d", node, test.want)
+			}
+		})
+	}
+}
+
+func TestWritesOnPrimary(t *testing.T) {
+	c := newCluster(t, 2)
+	ctx := context.Background()
+
+	c.primary.ExpectExec(`^UPDATE`).Times(2)
+	c.primary.ExpectQuery(`^INSERT`).WillReturnRows(storage.Table{Headers: []string{"id"}, Rows: [][]any{{int64(7)}}})
+
+	if _, err := c.storage.Exec(ctx, updateQuery); err != nil {
+		t.Fatalf("exec: %s", errors.Formatted(err))
+	}
+
+	if err := c.storage.Query(ctx, updateQuery, nil); err != nil {
+		t.Fatalf("query without result: %s", errors.Formatted(err))
+	}
+
+	var id int64
+
+	if err := c.storage.QueryRow(ctx, storage.NewQuery("INSERT INTO users (name) VALUES ('alpha') RETURNING id"), &id); err != nil {
+		t.Fatalf("query row: %s", errors.Formatted(err))
+	}
+
+	if id != 7 {
+		t.Fatalf("query row: got id %d, want 7", id)
+	}
+}
+
+func TestTransactionPinsPrimary(t *testing.T) {
+	c := newCluster(t, 2)
+	ctx := context.Background()
+
+	c.primary.ExpectBegin()
+	c.primary.ExpectCommit()
+
+	tx, err := c.storage.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %s", errors.Formatted(err))
+	}
+
+	if node := c.read(t, tx.Context()); node != -1 {
+		t.Fatalf("read in transaction: got node %d, want primary", node)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		t.Fatalf("commit: %s", errors.Formatted(err))
+	}
+
+	// без WithStickiness фиксация не закрепляет вызывающего за ведущим узлом
+	if node := c.read(t, router.WithCaller(ctx, "alice")); node == -1 {
+		t.Fatalf("read after commit: got primary, want replica")
+	}
+}
+
+func TestStickiness(t *testing.T) {
+	t.Run("exec", func(t *testing.T) {
+		c := newCluster(t, 2, router.WithStickiness(time.Minute))
+		alice, bob := router.WithCaller(context.Background(), "alice"), router.WithCaller(context.Background(), "bob")
+
+		c.primary.ExpectExec(`^UPDATE`)
+
+		if _, err := c.storage.Exec(alice, updateQuery); err != nil {
+			t.Fatalf("exec: %s", errors.Formatted(err))
+		}
+
+		if node := c.read(t, alice); node != -1 {
+			t.Fatalf("read of writer: got node %d, want primary", node)
+		}
+
+		if node := c.read(t, bob); node == -1 {
+			t.Fatalf("read of other caller: got primary, want replica")
+		}
+
+		if node := c.read(t, context.Background()); node == -1 {
+			t.Fatalf("read without caller: got primary, want replica")
+		}
+	})
+
+	t.Run("commit", func(t *testing.T) {
+		c := newCluster(t, 2, router.WithStickiness(time.Minute))
+		alice := router.WithCaller(context.Background(), "alice")
+
+		c.primary.ExpectBegin().Times(2)
+		c.primary.ExpectExec(`^UPDATE`).Times(2)
+		c.primary.ExpectCommit()
+		c.primary.ExpectRollback()
+
+		run := func(commit bool) {
+			tx, err := c.storage.Begin(alice)
+			if err != nil {
+				t.Fatalf("begin: %s", errors.Formatted(err))
+			}
+
+			if _, err = c.storage.Exec(tx.Context(), updateQuery); err != nil {
+				t.Fatalf("exec: %s", errors.Formatted(err))
+			}
+
+			// запись в транзакции закрепляет вызывающего только после фиксации
+			if node := c.read(t, alice); node == -1 {
+				t.Fatalf("read before transaction end: got primary, want replica")
+			}
+
+			if commit {
+				err = tx.Commit(alice)
+			} else {
+				err = tx.Rollback(alice)
+			}
+
+			if err != nil {
+				t.Fatalf("finish transaction: %s", errors.Formatted(err))
+			}
+		}
+
+		run(false)
+
+		if node := c.read(t, alice); node == -1 {
+			t.Fatalf("read after rollback: got primary, want replica")
+		}
+
+		run(true)
+
+		if node := c.read(t, alice); node != -1 {
+			t.Fatalf("read after commit: got node %d, want primary", node)
+		}
+	})
+
+	t.Run("window", func(t *testing.T) {
+		c := newCluster(t, 1, router.WithStickiness(20*time.Millisecond))
+		alice := router.WithCaller(context.Background(), "alice")
+
+		c.primary.ExpectExec(`^UPDATE`)
+
+		if _, err := c.storage.Exec(alice, updateQuery); err != nil {
+			t.Fatalf("exec: %s", errors.Formatted(err))
+		}
+
+		if node := c.read(t, alice); node != -1 {
+			t.Fatalf("read within window: got node %d, want primary", node)
+		}
+
+		time.Sleep(30 * time.Millisecond)
+
+		if node := c.read(t, alice); node != 0 {
+			t.Fatalf("read after window: got node %d, want replica", node)
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"gopkg.in/gomisc/errors.v1"
+)
+
+const (
+	defaultTxAttempts   = 3
+	defaultTxBackoff    = 10 * time.Millisecond
+	defaultTxMaxBackoff = time.Second
+)
+
+type (
+	// TxFunc - функция, выполняемая в транзакции, получает контекст транзакции
+	TxFunc func(ctx context.Context) error
+
+	// RetryPolicy - политика повторного выполнения транзакции в RunInTx
+	RetryPolicy struct {
+		// MaxAttempts - максимальное количество попыток, значение меньше 1 означает одну попытку
+		MaxAttempts int
+		// Backoff - возвращает паузу перед повтором после attempt неудачных попыток
+		Backoff func(attempt int) time.Duration
+		// Retryable - определяет, можно ли повторить транзакцию после ошибки
+		Retryable func(err error) bool
+	}
+)
+
+// DefaultRetryPolicy - политика повторов по умолчанию: три попытки с экспоненциальной паузой
+// при ошибках сериализации, дедлоках и таймаутах блокировок
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: defaultTxAttempts,
+	Backoff:     ExponentialBackoff(defaultTxBackoff, defaultTxMaxBackoff),
+	Retryable:   IsRetryable,
+}
+
+// ExponentialBackoff - возвращает экспоненциальную паузу со случайным разбросом,
+// ограниченную значением maximum. Отрицательные base и maximum считаются нулевыми
+func ExponentialBackoff(base, maximum time.Duration) func(attempt int) time.Duration {
+	if base < 0 {
+		base = 0
+	}
+
+	if maximum < 0 {
+		maximum = 0
+	}
+
+	return func(attempt int) time.Duration {
+		delay := base
+
+		for i := 1; i < attempt && delay < maximum; i++ {
+			if delay > maximum/2 {
+				delay = maximum
+
+				break
+			}
+
+			delay *= 2
+		}
+
+		if delay > maximum {
+			delay = maximum
+		}
+
+		if delay == 0 {
+			return 0
+		}
+
+		// nolint: gosec
+		return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	}
+}
+
+// RunInTx - выполняет fn в транзакции: фиксирует ее при успехе, откатывает при ошибке или панике
+// (панику после отката пробрасывает дальше) и повторяет целиком при ошибках, которые политика
+// повторов считает временными. Если политика не указана, используется DefaultRetryPolicy.
+// Внутри внешней транзакции fn выполняется в точке сохранения без повторов: ошибка
+// сериализации или дедлок делают невалидной всю внешнюю транзакцию, повторять нужно ее
+func RunInTx(ctx context.Context, s Storage, opts *TxOptions, fn TxFunc, policy ...RetryPolicy) error {
+	retry := DefaultRetryPolicy
+	if len(policy) != 0 {
+		retry = policy[0]
+	}
+
+	if _, nested := TransactionFromContext(ctx); nested {
+		retry.MaxAttempts = 1
+	}
+
+	if retry.Retryable == nil {
+		retry.Retryable = IsRetryable
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := runTxAttempt(ctx, s, opts, fn)
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= retry.MaxAttempts || !retry.Retryable(err) {
+			return errors.Ctx().Int("attempts", attempt).Just(err)
+		}
+
+		var delay time.Duration
+		if retry.Backoff != nil {
+			delay = retry.Backoff(attempt)
+		}
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return errors.And(errors.Ctx().Int("attempts", attempt).Just(err), ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+func runTxAttempt(ctx context.Context, s Storage, opts *TxOptions, fn TxFunc) (err error) {
+	var tx Transaction
+
+	if opts != nil {
+		tx, err = s.Begin(ctx, opts)
+	} else {
+		tx, err = s.Begin(ctx)
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+
+	defer func() {
+		if reason := recover(); reason != nil {
+			_ = tx.Rollback(ctx)
+
+			panic(reason)
+		}
+	}()
+
+	if err = fn(tx.Context()); err != nil {
+		if rbErr := tx.Rollback(ctx); rbErr != nil {
+			return errors.Ctx().Str("rollback-error", rbErr.Error()).Just(err)
+		}
+
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return errors.Wrap(err, "commit transaction")
+	}
+
+	return nil
+}
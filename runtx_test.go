@@ -0,0 +1,209 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+	"gopkg.in/gomisc/storage.v1/storagetest/fake"
+)
+
+const errFailed = errors.Const("failed")
+
+var (
+	errSerialization = &storage.DriverError{
+		Class: storage.ErrSerializationFailure,
+		Code:  "40001",
+		Err:   errors.Const("could not serialize access due to concurrent update"),
+	}
+
+	// noDelay - политика повторов без пауз между попытками
+	noDelay = storage.RetryPolicy{MaxAttempts: 3}
+)
+
+func TestRunInTxRetry(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		expect   func(s *fake.Storage)
+		results  []error
+		attempts int
+		err      error
+	}{
+		{
+			name:     "success",
+			expect:   func(s *fake.Storage) { s.ExpectBegin(); s.ExpectCommit() },
+			results:  []error{nil},
+			attempts: 1,
+		},
+		{
+			name: "retry on serialization failure",
+			expect: func(s *fake.Storage) {
+				s.ExpectBegin().Times(2)
+				s.ExpectRollback()
+				s.ExpectCommit()
+			},
+			results:  []error{errSerialization, nil},
+			attempts: 2,
+		},
+		{
+			name: "retry on commit failure",
+			expect: func(s *fake.Storage) {
+				s.ExpectBegin().Times(2)
+				s.ExpectCommit().WillReturnError(errSerialization)
+				s.ExpectCommit()
+			},
+			results:  []error{nil, nil},
+			attempts: 2,
+		},
+		{
+			name: "attempts exhausted",
+			expect: func(s *fake.Storage) {
+				s.ExpectBegin().Times(3)
+				s.ExpectRollback().Times(3)
+			},
+			results:  []error{errSerialization, errSerialization, errSerialization},
+			attempts: 3,
+			err:      storage.ErrSerializationFailure,
+		},
+		{
+			name:     "no retry on other errors",
+			expect:   func(s *fake.Storage) { s.ExpectBegin(); s.ExpectRollback() },
+			results:  []error{errFailed},
+			attempts: 1,
+			err:      errFailed,
+		},
+		{
+			name:     "begin failure",
+			expect:   func(s *fake.Storage) { s.ExpectBegin().WillReturnError(errFailed) },
+			attempts: 0,
+			err:      errFailed,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := fake.New(t)
+			test.expect(s)
+
+			attempts := 0
+
+			err := storage.RunInTx(context.Background(), s, nil, func(ctx context.Context) error {
+				attempts++
+
+				return test.results[attempts-1]
+			}, noDelay)
+
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("run in transaction: got %v, want %v", err, test.err)
+				}
+			} else if err != nil {
+				t.Fatalf("run in transaction: %s", errors.Formatted(err))
+			}
+
+			if attempts != test.attempts {
+				t.Fatalf("attempts: got %d, want %d", attempts, test.attempts)
+			}
+		})
+	}
+}
+
+func TestRunInTxNested(t *testing.T) {
+	s := fake.New(t)
+	ctx := context.Background()
+
+	s.ExpectBegin().Times(2)
+	s.ExpectRollback().Times(2)
+
+	attempts := 0
+
+	err := storage.RunInTx(ctx, s, nil, func(ctx context.Context) error {
+		// вложенная транзакция не повторяется: ошибка сериализации делает невалидной внешнюю
+		return storage.RunInTx(ctx, s, nil, func(context.Context) error {
+			attempts++
+
+			return errSerialization
+		}, noDelay)
+	}, storage.RetryPolicy{MaxAttempts: 1})
+	if !errors.Is(err, storage.ErrSerializationFailure) {
+		t.Fatalf("run in transaction: got %v, want %v", err, storage.ErrSerializationFailure)
+	}
+
+	if attempts != 1 {
+		t.Fatalf("nested attempts: got %d, want 1", attempts)
+	}
+}
+
+func TestRunInTxPanic(t *testing.T) {
+	s := fake.New(t)
+
+	s.ExpectBegin()
+	s.ExpectRollback()
+
+	defer func() {
+		if reason := recover(); reason != "boom" {
+			t.Fatalf("panic: got %v, want boom", reason)
+		}
+
+		calls := s.Calls()
+		if last := calls[len(calls)-1]; last.Method != fake.MethodRollback {
+			t.Fatalf("last call: got %s, want %s", last.Method, fake.MethodRollback)
+		}
+	}()
+
+	_ = storage.RunInTx(context.Background(), s, nil, func(context.Context) error {
+		panic("boom")
+	})
+
+	t.Fatalf("panic was not propagated")
+}
+
+func TestRunInTxCanceledBackoff(t *testing.T) {
+	s := fake.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s.ExpectBegin()
+	s.ExpectRollback()
+
+	policy := storage.RetryPolicy{
+		MaxAttempts: 3,
+		Backoff: func(int) time.Duration {
+			cancel()
+
+			return time.Minute
+		},
+	}
+
+	err := storage.RunInTx(ctx, s, nil, func(context.Context) error { return errSerialization }, policy)
+
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, storage.ErrSerializationFailure) {
+		t.Fatalf("run in transaction: got %v, want canceled serialization failure", err)
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		base, maximum time.Duration
+		attempt       int
+		min, max      time.Duration
+	}{
+		{name: "first attempt", base: 10 * time.Millisecond, maximum: time.Second, attempt: 1, min: 5 * time.Millisecond, max: 10 * time.Millisecond},
+		{name: "doubles", base: 10 * time.Millisecond, maximum: time.Second, attempt: 3, min: 20 * time.Millisecond, max: 40 * time.Millisecond},
+		{name: "clamped", base: 10 * time.Millisecond, maximum: time.Second, attempt: 10, min: 500 * time.Millisecond, max: time.Second},
+		{name: "no overflow", base: time.Hour, maximum: 1 << 62, attempt: 1000, min: 1 << 61, max: 1 << 62},
+		{name: "base above maximum", base: time.Minute, maximum: time.Second, attempt: 1, min: 500 * time.Millisecond, max: time.Second},
+		{name: "negative", base: -time.Second, maximum: -time.Second, attempt: 2},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			backoff := storage.ExponentialBackoff(test.base, test.maximum)
+
+			for i := 0; i < 100; i++ {
+				if delay := backoff(test.attempt); delay < test.min || delay > test.max {
+					t.Fatalf("backoff(%d): got %s, want within [%s, %s]", test.attempt, delay, test.min, test.max)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package shard
+
+import (
+	"context"
+	"sync"
+
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+const (
+	ErrCrossShardBatch = errors.Const("atomic batch spans several shards")
+)
+
+var _ storage.Batcher = (*Storage)(nil)
+
+// shardBatch - часть пакета для одного шарда и соответствие ее запросов запросам исходного пакета
+type shardBatch struct {
+	storage storage.Storage
+	batch   storage.Batch
+	origin  []*storage.BatchItem
+}
+
+// SendBatch - имплементация storage.Batcher: запросы пакета группируются по шардам ключей,
+// части пакета выполняются на шардах параллельно. Каждый запрос пакета требует ключа шардирования,
+// атомарный пакет должен целиком принадлежать одному шарду
+func (s *Storage) SendBatch(ctx context.Context, batch *storage.Batch) error {
+	batch.Reset()
+
+	parts, err := s.splitBatch(ctx, batch)
+	if err != nil {
+		batch.Abort()
+
+		return err
+	}
+
+	var wg sync.WaitGroup
+
+	for _, part := range parts {
+		part := part
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_ = storage.SendBatch(ctx, part.storage, &part.batch)
+
+			for i, item := range part.batch.Items() {
+				part.origin[i].ExecResult, part.origin[i].Err = item.ExecResult, item.Err
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return batch.Err()
+}
+
+// splitBatch - разбивает пакет на части по шардам
+func (s *Storage) splitBatch(ctx context.Context, batch *storage.Batch) (map[string]*shardBatch, error) {
+	parts := make(map[string]*shardBatch)
+
+	for _, item := range batch.Items() {
+		id, shard, err := s.target(ctx, item.Query)
+		if err == nil && shard == nil {
+			err = errors.Ctx().Stringer("query", item.Query).Just(ErrNoShardKey)
+		}
+
+		if err != nil {
+			item.Err = err
+
+			return nil, batch.Err()
+		}
+
+		part, ok := parts[id]
+		if !ok {
+			part = &shardBatch{storage: shard, batch: storage.Batch{Atomic: batch.Atomic}}
+			parts[id] = part
+		}
+
+		switch item.Kind {
+		case storage.BatchExec:
+			part.batch.Exec(item.Query)
+		case storage.BatchQuery:
+			part.batch.Query(item.Query, item.Result)
+		case storage.BatchQueryRow:
+			part.batch.QueryRow(item.Query, item.Dest...)
+		default:
+			item.Err = errors.Ctx().Int("kind", int(item.Kind)).Just(storage.ErrNotSupported)
+
+			return nil, batch.Err()
+		}
+
+		part.origin = append(part.origin, item)
+	}
+
+	if batch.Atomic && len(parts) > 1 {
+		return nil, errors.Ctx().Int("shards", len(parts)).Just(ErrCrossShardBatch)
+	}
+
+	return parts, nil
+}
@@ -0,0 +1,163 @@
+package shard
+
+import (
+	"context"
+
+	"gopkg.in/gomisc/errors.v1"
+	"gopkg.in/gomisc/errors.v1/errgroup"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+var _ storage.Iterator = (*fanoutIterator)(nil)
+
+type (
+	namedShard struct {
+		id      string
+		storage storage.Storage
+	}
+
+	// fanoutIterator - итератор, последовательно проходящий результаты запроса на всех шардах,
+	// запрос на следующем шарде выполняется после исчерпания предыдущего
+	fanoutIterator struct {
+		ctx     context.Context
+		query   storage.Query
+		shards  []namedShard
+		current storage.Iterator
+		err     error
+	}
+)
+
+// gather - выполняет запрос на всех шардах параллельно и объединяет таблицы результатов в порядке шардов
+func (s *Storage) gather(ctx context.Context, query storage.Query) (storage.Table, error) {
+	shards := s.snapshot()
+	tables := make([]storage.Table, len(shards))
+	group := errgroup.WithCancelOnErr(ctx)
+
+	for i := range shards {
+		i := i
+
+		group.Go(func() error {
+			if err := shards[i].storage.Query(group.Context(), query, &tables[i]); err != nil {
+				return errors.Ctx().Str("shard", shards[i].id).Wrap(err, "query shard")
+			}
+
+			return nil
+		})
+	}
+
+	if err := group.Wait(); err != nil {
+		return storage.Table{}, err
+	}
+
+	return mergeTables(tables)
+}
+
+// mergeTables - объединяет строки таблиц с одинаковыми колонками, таблицы без колонок
+// (шард без строк у драйвера, не сообщающего колонки пустого результата) пропускаются
+func mergeTables(tables []storage.Table) (storage.Table, error) {
+	var merged storage.Table
+
+	for _, table := range tables {
+		if len(table.Headers) == 0 {
+			continue
+		}
+
+		if merged.Headers == nil {
+			merged.Headers = table.Headers
+		} else if !sameHeaders(merged.Headers, table.Headers) {
+			return storage.Table{}, errors.Ctx().
+				Strings("headers", merged.Headers).
+				Strings("shard-headers", table.Headers).
+				Just(ErrHeadersMismatch)
+		}
+
+		merged.Rows = append(merged.Rows, table.Rows...)
+	}
+
+	return merged, nil
+}
+
+func sameHeaders(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newFanoutIterator(ctx context.Context, shards []namedShard, query storage.Query) *fanoutIterator {
+	return &fanoutIterator{ctx: ctx, query: query, shards: shards}
+}
+
+// Close - имплементация storage.Iterator
+func (it *fanoutIterator) Close() error {
+	it.shards = nil
+
+	if it.current == nil {
+		return nil
+	}
+
+	err := it.current.Close()
+	it.current = nil
+
+	return err
+}
+
+// Next - имплементация storage.Iterator
+func (it *fanoutIterator) Next(ctx context.Context) bool {
+	for it.err == nil {
+		if it.current != nil {
+			if it.current.Next(ctx) {
+				return true
+			}
+
+			if err := it.current.Err(); err != nil {
+				it.err = err
+			}
+
+			_ = it.current.Close()
+			it.current = nil
+
+			continue
+		}
+
+		if len(it.shards) == 0 {
+			return false
+		}
+
+		shard := it.shards[0]
+		it.shards = it.shards[1:]
+
+		current, err := shard.storage.Iterate(it.ctx, it.query)
+		if err != nil {
+			it.err = errors.Ctx().Str("shard", shard.id).Wrap(err, "iterate shard")
+
+			return false
+		}
+
+		it.current = current
+	}
+
+	return false
+}
+
+// Err - имплементация storage.Iterator
+func (it *fanoutIterator) Err() error {
+	return it.err
+}
+
+// Decode - имплементация storage.Iterator
+func (it *fanoutIterator) Decode(result any) error {
+	if it.current == nil {
+		return storage.ErrEmptyResult
+	}
+
+	return it.current.Decode(result)
+}
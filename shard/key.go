@@ -0,0 +1,80 @@
+package shard
+
+import (
+	"context"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+var _ storage.NamedQuery = (*keyedQuery)(nil)
+
+type (
+	// KeyFunc - извлекает ключ шардирования запроса из контекста или метаданных запроса
+	KeyFunc func(ctx context.Context, query storage.Query) (string, bool)
+
+	// KeyedQuery - запрос, несущий ключ шардирования
+	KeyedQuery interface {
+		storage.Query
+		// ShardKey - возвращает ключ шардирования запроса
+		ShardKey() string
+	}
+
+	keyKey struct{}
+
+	keyedQuery struct {
+		query storage.Query
+		key   string
+	}
+)
+
+// WithKey - возвращает контекст с ключом шардирования (идентификатор арендатора, клиента),
+// запросы в нем выполняются на шарде ключа
+func WithKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, keyKey{}, key)
+}
+
+// KeyFromContext - ключ шардирования, заданный WithKey
+func KeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(keyKey{}).(string)
+
+	return key, ok
+}
+
+// Keyed - аннотирует запрос ключом шардирования, ключ запроса приоритетнее ключа контекста
+func Keyed(query storage.Query, key string) KeyedQuery {
+	return &keyedQuery{query: query, key: key}
+}
+
+// DefaultKey - ключ из запроса, аннотированного Keyed, иначе из контекста
+func DefaultKey(ctx context.Context, query storage.Query) (string, bool) {
+	if keyed, ok := query.(KeyedQuery); ok {
+		return keyed.ShardKey(), true
+	}
+
+	return KeyFromContext(ctx)
+}
+
+// ShardKey - имплементация KeyedQuery
+func (q *keyedQuery) ShardKey() string {
+	return q.key
+}
+
+// Name - имплементация storage.NamedQuery, возвращает имя исходного запроса
+func (q *keyedQuery) Name() string {
+	return storage.QueryName(q.query)
+}
+
+// Query - имплементация storage.Query
+func (q *keyedQuery) Query() interface{} {
+	return q.query.Query()
+}
+
+// Params - имплементация storage.Query
+func (q *keyedQuery) Params() interface{} {
+	return q.query.Params()
+}
+
+// String - имплементация fmt.Stringer
+func (q *keyedQuery) String() string {
+	return q.query.String()
+}
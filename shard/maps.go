@@ -0,0 +1,289 @@
+package shard
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"gopkg.in/gomisc/errors.v1"
+)
+
+// DefaultVirtualNodes - число точек шарда на кольце по умолчанию
+const DefaultVirtualNodes = 128
+
+const (
+	ErrEmptyMap   = errors.Const("shard map is empty")
+	ErrInvalidKey = errors.Const("invalid shard key")
+)
+
+var (
+	_ Map = (*HashRing)(nil)
+	_ Map = (*RangeMap)(nil)
+)
+
+type (
+	// Map - отображение ключей шардирования на идентификаторы шардов. Реализации должны допускать
+	// изменение отображения во время работы: изменение применяется атомарно для новых запросов
+	Map interface {
+		// Shard - возвращает идентификатор шарда ключа
+		Shard(key string) (string, error)
+	}
+
+	// HashRing - консистентное хеширование: ключ принадлежит ближайшей по часовой стрелке точке
+	// шарда на кольце. При добавлении или удалении шарда переезжает только доля ключей этого шарда.
+	// Нулевое значение - пустое кольцо с DefaultVirtualNodes точками на шард
+	HashRing struct {
+		vnodes int
+
+		mu    sync.Mutex
+		state atomic.Pointer[ringState]
+	}
+
+	ringState struct {
+		shards []string
+		points []uint64
+		owners []string
+	}
+
+	// Range - диапазон числовых ключей от From включительно до From следующего диапазона
+	Range struct {
+		// From - нижняя граница диапазона
+		From uint64
+		// Shard - идентификатор шарда диапазона
+		Shard string
+	}
+
+	// RangeMap - отображение диапазонов числовых ключей на шарды. Перенос диапазона
+	// на новый шард выполняется разделением (Assign) без изменения остальных диапазонов.
+	// Нулевое значение - отображение без диапазонов
+	RangeMap struct {
+		mu     sync.Mutex
+		ranges atomic.Pointer[[]Range]
+	}
+)
+
+// NewHashRing - кольцо с vnodes точками на шард, при vnodes <= 0 используется DefaultVirtualNodes
+func NewHashRing(vnodes int, shards ...string) *HashRing {
+	if vnodes <= 0 {
+		vnodes = DefaultVirtualNodes
+	}
+
+	ring := &HashRing{vnodes: vnodes}
+	ring.state.Store(ring.build(shards))
+
+	return ring
+}
+
+// Shard - имплементация Map
+func (r *HashRing) Shard(key string) (string, error) {
+	state := r.load()
+	if len(state.points) == 0 {
+		return "", ErrEmptyMap
+	}
+
+	hash := hashKey(key)
+	i := sort.Search(len(state.points), func(i int) bool { return state.points[i] >= hash })
+
+	if i == len(state.points) {
+		i = 0
+	}
+
+	return state.owners[i], nil
+}
+
+// Add - добавляет шарды на кольцо
+func (r *HashRing) Add(shards ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current := r.load().shards
+	next := append([]string(nil), current...)
+
+	for _, shard := range shards {
+		if !contains(next, shard) {
+			next = append(next, shard)
+		}
+	}
+
+	r.state.Store(r.build(next))
+}
+
+// Remove - удаляет шарды с кольца, их ключи переходят к соседним точкам
+func (r *HashRing) Remove(shards ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current := r.load().shards
+	next := make([]string, 0, len(current))
+
+	for _, shard := range current {
+		if !contains(shards, shard) {
+			next = append(next, shard)
+		}
+	}
+
+	r.state.Store(r.build(next))
+}
+
+// Shards - шарды на кольце
+func (r *HashRing) Shards() []string {
+	return append([]string(nil), r.load().shards...)
+}
+
+// load - текущее состояние кольца, до первого изменения нулевого значения кольцо пусто
+func (r *HashRing) load() *ringState {
+	if state := r.state.Load(); state != nil {
+		return state
+	}
+
+	return &ringState{}
+}
+
+func (r *HashRing) build(shards []string) *ringState {
+	state := &ringState{shards: shards}
+
+	vnodes := r.vnodes
+	if vnodes <= 0 {
+		vnodes = DefaultVirtualNodes
+	}
+
+	type point struct {
+		hash  uint64
+		owner string
+	}
+
+	points := make([]point, 0, len(shards)*vnodes)
+
+	for _, shard := range shards {
+		for i := 0; i < vnodes; i++ {
+			points = append(points, point{hash: hashKey(shard + "#" + strconv.Itoa(i)), owner: shard})
+		}
+	}
+
+	// при совпадении хешей владелец определяется по имени, чтобы кольцо не зависело от порядка шардов
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash == points[j].hash {
+			return points[i].owner < points[j].owner
+		}
+
+		return points[i].hash < points[j].hash
+	})
+
+	for _, p := range points {
+		state.points = append(state.points, p.hash)
+		state.owners = append(state.owners, p.owner)
+	}
+
+	return state
+}
+
+// NewRangeMap - отображение диапазонов, ключи меньше нижней границы первого диапазона не принадлежат шардам
+func NewRangeMap(ranges ...Range) *RangeMap {
+	sorted := append([]Range(nil), ranges...)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].From < sorted[j].From
+	})
+
+	m := &RangeMap{}
+	m.ranges.Store(&sorted)
+
+	return m
+}
+
+// Shard - имплементация Map, ключ должен быть десятичным беззнаковым числом
+func (m *RangeMap) Shard(key string) (string, error) {
+	id, err := strconv.ParseUint(key, 10, 64)
+	if err != nil {
+		return "", errors.Ctx().Str("key", key).Just(ErrInvalidKey)
+	}
+
+	ranges := m.load()
+	i := sort.Search(len(ranges), func(i int) bool { return ranges[i].From > id })
+
+	if i == 0 {
+		return "", errors.Ctx().Str("key", key).Just(ErrEmptyMap)
+	}
+
+	return ranges[i-1].Shard, nil
+}
+
+// Assign - переносит ключи от from до следующей границы на шард: существующий диапазон
+// с нижней границей from меняет шард, иначе диапазон, содержащий from, разделяется
+func (m *RangeMap) Assign(from uint64, shard string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := m.load()
+	next := make([]Range, 0, len(current)+1)
+	i := sort.Search(len(current), func(i int) bool { return current[i].From >= from })
+
+	next = append(next, current[:i]...)
+	next = append(next, Range{From: from, Shard: shard})
+
+	if i < len(current) && current[i].From == from {
+		i++
+	}
+
+	next = append(next, current[i:]...)
+
+	m.ranges.Store(&next)
+}
+
+// Merge - удаляет границу from, ее диапазон присоединяется к предыдущему
+func (m *RangeMap) Merge(from uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := m.load()
+	next := make([]Range, 0, len(current))
+
+	for _, r := range current {
+		if r.From != from {
+			next = append(next, r)
+		}
+	}
+
+	m.ranges.Store(&next)
+}
+
+// Ranges - текущие диапазоны в порядке возрастания границ
+func (m *RangeMap) Ranges() []Range {
+	return append([]Range(nil), m.load()...)
+}
+
+// load - текущие диапазоны, у нулевого значения диапазонов нет
+func (m *RangeMap) load() []Range {
+	if ranges := m.ranges.Load(); ranges != nil {
+		return *ranges
+	}
+
+	return nil
+}
+
+// hashKey - FNV-1a с перемешиванием splitmix64, которое выравнивает распределение похожих ключей
+func hashKey(key string) uint64 {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(key))
+
+	x := hash.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+
+	return x
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
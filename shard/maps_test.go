@@ -0,0 +1,181 @@
+package shard_test
+
+import (
+	"strconv"
+	"testing"
+
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1/shard"
+)
+
+const ringKeys = 10000
+
+func ringOwners(t *testing.T, ring *shard.HashRing) map[string]string {
+	t.Helper()
+
+	owners := make(map[string]string, ringKeys)
+
+	for i := 0; i < ringKeys; i++ {
+		key := "tenant-" + strconv.Itoa(i)
+
+		id, err := ring.Shard(key)
+		if err != nil {
+			t.Fatalf("shard %q: %s", key, errors.Formatted(err))
+		}
+
+		owners[key] = id
+	}
+
+	return owners
+}
+
+func TestHashRingStability(t *testing.T) {
+	ring := shard.NewHashRing(0, "s1", "s2", "s3", "s4")
+	before := ringOwners(t, ring)
+
+	if reordered := ringOwners(t, shard.NewHashRing(0, "s4", "s3", "s2", "s1")); !equalOwners(before, reordered) {
+		t.Fatalf("ring depends on shard order")
+	}
+
+	ring.Add("s5")
+	added := ringOwners(t, ring)
+	moved := 0
+
+	for key, id := range added {
+		if id == before[key] {
+			continue
+		}
+
+		if id != "s5" {
+			t.Fatalf("add: key %q moved from %s to %s, want s5", key, before[key], id)
+		}
+
+		moved++
+	}
+
+	// новому шарду достается около пятой части ключей
+	if share := float64(moved) / ringKeys; share < 0.1 || share > 0.3 {
+		t.Fatalf("add: %.2f of keys moved to new shard, want about 0.2", share)
+	}
+
+	ring.Remove("s5")
+
+	if restored := ringOwners(t, ring); !equalOwners(before, restored) {
+		t.Fatalf("remove: mapping differs from the mapping before add")
+	}
+
+	ring.Remove("s2")
+
+	for key, id := range ringOwners(t, ring) {
+		if before[key] != "s2" && id != before[key] {
+			t.Fatalf("remove: key %q of %s moved to %s", key, before[key], id)
+		}
+
+		if id == "s2" {
+			t.Fatalf("remove: key %q still maps to removed shard", key)
+		}
+	}
+}
+
+func TestHashRingEmpty(t *testing.T) {
+	for name, ring := range map[string]*shard.HashRing{
+		"constructor": shard.NewHashRing(0),
+		"zero value":  {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ring.Shard("tenant"); !errors.Is(err, shard.ErrEmptyMap) {
+				t.Fatalf("shard: got %v, want %v", err, shard.ErrEmptyMap)
+			}
+
+			ring.Add("s1")
+
+			if id, err := ring.Shard("tenant"); err != nil || id != "s1" {
+				t.Fatalf("shard after add: got %q, %v, want s1", id, err)
+			}
+		})
+	}
+}
+
+func TestRangeMap(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		ranges []shard.Range
+		change func(m *shard.RangeMap)
+		want   map[string]string
+		err    map[string]error
+	}{
+		{
+			name:   "lookup",
+			ranges: []shard.Range{{From: 100, Shard: "s2"}, {From: 0, Shard: "s1"}},
+			want:   map[string]string{"0": "s1", "99": "s1", "100": "s2", "18446744073709551615": "s2"},
+			err:    map[string]error{"tenant": shard.ErrInvalidKey, "-1": shard.ErrInvalidKey},
+		},
+		{
+			name:   "below first range",
+			ranges: []shard.Range{{From: 10, Shard: "s1"}},
+			want:   map[string]string{"10": "s1"},
+			err:    map[string]error{"9": shard.ErrEmptyMap},
+		},
+		{
+			name:   "assign splits range",
+			ranges: []shard.Range{{From: 0, Shard: "s1"}, {From: 100, Shard: "s2"}},
+			change: func(m *shard.RangeMap) { m.Assign(50, "s3") },
+			want:   map[string]string{"49": "s1", "50": "s3", "99": "s3", "100": "s2"},
+		},
+		{
+			name:   "assign replaces boundary",
+			ranges: []shard.Range{{From: 0, Shard: "s1"}, {From: 100, Shard: "s2"}},
+			change: func(m *shard.RangeMap) { m.Assign(100, "s3") },
+			want:   map[string]string{"99": "s1", "100": "s3"},
+		},
+		{
+			name:   "merge joins previous range",
+			ranges: []shard.Range{{From: 0, Shard: "s1"}, {From: 50, Shard: "s3"}, {From: 100, Shard: "s2"}},
+			change: func(m *shard.RangeMap) { m.Merge(50) },
+			want:   map[string]string{"50": "s1", "99": "s1", "100": "s2"},
+		},
+		{
+			name:   "zero value",
+			change: func(m *shard.RangeMap) { m.Assign(0, "s1") },
+			want:   map[string]string{"7": "s1"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			m := &shard.RangeMap{}
+			if test.ranges != nil {
+				m = shard.NewRangeMap(test.ranges...)
+			}
+
+			if test.change != nil {
+				test.change(m)
+			}
+
+			for key, want := range test.want {
+				if id, err := m.Shard(key); err != nil || id != want {
+					t.Errorf("shard %q: got %q, %v, want %q", key, id, err, want)
+				}
+			}
+
+			for key, want := range test.err {
+				if _, err := m.Shard(key); !errors.Is(err, want) {
+					t.Errorf("shard %q: got %v, want %v", key, err, want)
+				}
+			}
+		})
+	}
+}
+
+func equalOwners(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for key, id := range a {
+		if b[key] != id {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,340 @@
+// Package shard - хранилище над набором шардов: запросы с ключом шардирования выполняются
+// на шарде ключа, запросы чтения без ключа выполняются на всех шардах с объединением результата
+package shard
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"sort"
+	"sync"
+
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+const (
+	ErrNoShardKey      = errors.Const("query has no shard key")
+	ErrUnknownShard    = errors.Const("shard is not registered")
+	ErrAmbiguousRow    = errors.Const("shard-less query row matched rows on several shards")
+	ErrHeadersMismatch = errors.Const("shards returned different result columns")
+)
+
+var (
+	_ storage.Storage      = (*Storage)(nil)
+	_ storage.BulkLoader   = (*Storage)(nil)
+	_ storage.BulkExporter = (*Storage)(nil)
+)
+
+type (
+	// Option - опция хранилища шардов
+	Option func(s *Storage)
+
+	shardTxKey struct{}
+
+	// Storage - хранилище над набором шардов
+	Storage struct {
+		keyFunc KeyFunc
+
+		mu     sync.RWMutex
+		shards map[string]storage.Storage
+		ids    []string
+		smap   Map
+	}
+
+	// shardTx - транзакция шарда, контекст которой направляет запросы на тот же шард
+	shardTx struct {
+		storage.Transaction
+		shard string
+	}
+)
+
+// New - конструктор хранилища шардов, shards - хранилища по идентификаторам шардов Map.
+// Exec, Begin и Query без результата требуют ключа шардирования. Query с результатом, QueryRow
+// и Iterate без ключа выполняются на всех шардах в порядке их идентификаторов: результаты
+// объединяются, сортировка и LIMIT запроса действуют в пределах каждого шарда
+func New(shards map[string]storage.Storage, smap Map, opts ...Option) *Storage {
+	s := &Storage{
+		keyFunc: DefaultKey,
+		shards:  make(map[string]storage.Storage, len(shards)),
+		smap:    smap,
+	}
+
+	for id, shard := range shards {
+		s.shards[id] = shard
+	}
+
+	s.ids = sortedIDs(s.shards)
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// WithKeyFunc - способ извлечения ключа шардирования, по умолчанию DefaultKey
+func WithKeyFunc(fn KeyFunc) Option {
+	return func(s *Storage) {
+		s.keyFunc = fn
+	}
+}
+
+// SetMap - заменяет отображение ключей на шарды, новые запросы используют новое отображение
+func (s *Storage) SetMap(smap Map) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.smap = smap
+}
+
+// AddShard - регистрирует шард, например перед переносом на него диапазона ключей
+func (s *Storage) AddShard(id string, shard storage.Storage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.shards[id] = shard
+	s.ids = sortedIDs(s.shards)
+}
+
+// RemoveShard - исключает шард из хранилища и возвращает его, шард не закрывается
+func (s *Storage) RemoveShard(id string) (storage.Storage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shard, ok := s.shards[id]
+	if ok {
+		delete(s.shards, id)
+		s.ids = sortedIDs(s.shards)
+	}
+
+	return shard, ok
+}
+
+// Shard - хранилище шарда по идентификатору
+func (s *Storage) Shard(id string) (storage.Storage, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	shard, ok := s.shards[id]
+
+	return shard, ok
+}
+
+// ShardFor - идентификатор и хранилище шарда ключа
+func (s *Storage) ShardFor(key string) (string, storage.Storage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, err := s.smap.Shard(key)
+	if err != nil {
+		return "", nil, errors.Ctx().Str("shard-key", key).Wrap(err, "map shard key")
+	}
+
+	shard, ok := s.shards[id]
+	if !ok {
+		return "", nil, errors.Ctx().Str("shard-key", key).Str("shard", id).Just(ErrUnknownShard)
+	}
+
+	return id, shard, nil
+}
+
+// Close - закрывает все шарды
+func (s *Storage) Close() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var err error
+
+	for _, id := range s.ids {
+		if closeErr := s.shards[id].Close(); closeErr != nil {
+			err = errors.And(err, errors.Ctx().Str("shard", id).Wrap(closeErr, "close shard"))
+		}
+	}
+
+	return err
+}
+
+// Begin - открывает транзакцию на шарде ключа контекста, запросы в контексте транзакции
+// выполняются на том же шарде
+func (s *Storage) Begin(ctx context.Context, opts ...any) (storage.Transaction, error) {
+	id, shard, err := s.target(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if shard == nil {
+		return nil, ErrNoShardKey
+	}
+
+	tx, err := shard.Begin(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, nested := ctx.Value(shardTxKey{}).(string); nested {
+		return tx, nil
+	}
+
+	return &shardTx{Transaction: tx, shard: id}, nil
+}
+
+// Exec - выполняет запрос на шарде ключа
+func (s *Storage) Exec(ctx context.Context, query storage.Query) (sql.Result, error) {
+	shard, err := s.single(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return shard.Exec(ctx, query)
+}
+
+// Query - выполняет запрос на шарде ключа, запрос с результатом без ключа - на всех шардах
+func (s *Storage) Query(ctx context.Context, query storage.Query, result any) error {
+	_, shard, err := s.target(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case shard != nil:
+		return shard.Query(ctx, query, result)
+	case result == nil:
+		return errors.Ctx().Stringer("query", query).Just(ErrNoShardKey)
+	}
+
+	table, err := s.gather(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	return table.Decode(result)
+}
+
+// QueryRow - выполняет запрос на шарде ключа, без ключа - на всех шардах, строка результата
+// должна найтись не более чем на одном шарде
+func (s *Storage) QueryRow(ctx context.Context, query storage.Query, dest ...any) error {
+	_, shard, err := s.target(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	if shard != nil {
+		return shard.QueryRow(ctx, query, dest...)
+	}
+
+	table, err := s.gather(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	if len(table.Rows) > 1 {
+		return errors.Ctx().Stringer("query", query).Int("rows", len(table.Rows)).Just(ErrAmbiguousRow)
+	}
+
+	return table.ScanRow(dest...)
+}
+
+// Iterate - выполняет запрос на шарде ключа, без ключа - последовательно на всех шардах
+func (s *Storage) Iterate(ctx context.Context, query storage.Query) (storage.Iterator, error) {
+	_, shard, err := s.target(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	if shard != nil {
+		return shard.Iterate(ctx, query)
+	}
+
+	return newFanoutIterator(ctx, s.snapshot(), query), nil
+}
+
+// CopyFrom - имплементация storage.BulkLoader, загрузка выполняется на шарде ключа контекста
+func (s *Storage) CopyFrom(ctx context.Context, table string, columns []string, source any) (int64, error) {
+	_, shard, err := s.target(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	if shard == nil {
+		return 0, errors.Ctx().Str("table", table).Just(ErrNoShardKey)
+	}
+
+	return storage.CopyFrom(ctx, shard, table, columns, source)
+}
+
+// CopyTo - имплементация storage.BulkExporter, выгрузка выполняется на шарде ключа запроса:
+// потоковая выгрузка нескольких шардов в один поток не поддерживается
+func (s *Storage) CopyTo(ctx context.Context, query storage.Query, w io.Writer, format storage.CopyFormat) (int64, error) {
+	shard, err := s.single(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return storage.CopyTo(ctx, shard, query, w, format)
+}
+
+// target - шард запроса: шард транзакции контекста, шард ключа шардирования или nil без ключа
+func (s *Storage) target(ctx context.Context, query storage.Query) (string, storage.Storage, error) {
+	if id, inTx := ctx.Value(shardTxKey{}).(string); inTx {
+		shard, ok := s.Shard(id)
+		if !ok {
+			return "", nil, errors.Ctx().Str("shard", id).Just(ErrUnknownShard)
+		}
+
+		return id, shard, nil
+	}
+
+	key, ok := s.keyFunc(ctx, query)
+	if !ok {
+		return "", nil, nil
+	}
+
+	return s.ShardFor(key)
+}
+
+// single - шард запроса, для которого ключ шардирования обязателен
+func (s *Storage) single(ctx context.Context, query storage.Query) (storage.Storage, error) {
+	_, shard, err := s.target(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	if shard == nil {
+		return nil, errors.Ctx().Stringer("query", query).Just(ErrNoShardKey)
+	}
+
+	return shard, nil
+}
+
+// snapshot - шарды в порядке идентификаторов на момент запроса
+func (s *Storage) snapshot() []namedShard {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	shards := make([]namedShard, 0, len(s.ids))
+
+	for _, id := range s.ids {
+		shards = append(shards, namedShard{id: id, storage: s.shards[id]})
+	}
+
+	return shards
+}
+
+// Context - контекст транзакции, направляющий запросы на шард транзакции
+func (tx *shardTx) Context() context.Context {
+	return context.WithValue(tx.Transaction.Context(), shardTxKey{}, tx.shard)
+}
+
+func sortedIDs(shards map[string]storage.Storage) []string {
+	ids := make([]string, 0, len(shards))
+
+	for id := range shards {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	return ids
+}
@@ -0,0 +1,360 @@
+package shard_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+	"gopkg.in/gomisc/storage.v1/shard"
+	"gopkg.in/gomisc/storage.v1/storagetest/fake"
+)
+
+// Ключи шардов newStorage: ключи от 0 до 99 принадлежат s1, от 100 - s2
+const (
+	keyS1 = "1"
+	keyS2 = "100"
+)
+
+type user struct {
+	ID   int64  `db:"id"`
+	Name string `db:"name"`
+}
+
+func newStorage(t *testing.T) (*shard.Storage, *fake.Storage, *fake.Storage) {
+	t.Helper()
+
+	s1, s2 := fake.New(t), fake.New(t)
+
+	s := shard.New(
+		map[string]storage.Storage{"s1": s1, "s2": s2},
+		shard.NewRangeMap(shard.Range{From: 0, Shard: "s1"}, shard.Range{From: 100, Shard: "s2"}),
+	)
+
+	return s, s1, s2
+}
+
+func usersTable(rows ...[]any) storage.Table {
+	return storage.Table{Headers: []string{"id", "name"}, Rows: rows}
+}
+
+func TestKeyedRouting(t *testing.T) {
+	s, s1, s2 := newStorage(t)
+	ctx := context.Background()
+
+	s1.ExpectExec(`^UPDATE users`)
+	s2.ExpectQuery(`^SELECT`).WillReturnRows(usersTable([]any{int64(100), "beta"}))
+
+	if _, err := s.Exec(shard.WithKey(ctx, keyS1), storage.NewQuery("UPDATE users SET name = 'alpha'")); err != nil {
+		t.Fatalf("exec: %s", errors.Formatted(err))
+	}
+
+	// ключ запроса приоритетнее ключа контекста
+	var users []user
+
+	query := shard.Keyed(storage.NewQuery("SELECT id, name FROM users"), keyS2)
+	if err := s.Query(shard.WithKey(ctx, keyS1), query, &users); err != nil {
+		t.Fatalf("query: %s", errors.Formatted(err))
+	}
+
+	if want := []user{{100, "beta"}}; !reflect.DeepEqual(users, want) {
+		t.Fatalf("query: got %v, want %v", users, want)
+	}
+}
+
+func TestNoShardKey(t *testing.T) {
+	s, _, _ := newStorage(t)
+	ctx := context.Background()
+	query := storage.NewQuery("UPDATE users SET name = 'alpha'")
+
+	for name, run := range map[string]func() error{
+		"exec": func() error {
+			_, err := s.Exec(ctx, query)
+
+			return err
+		},
+		"query without result": func() error {
+			return s.Query(ctx, query, nil)
+		},
+		"begin": func() error {
+			_, err := s.Begin(ctx)
+
+			return err
+		},
+	} {
+		if err := run(); !errors.Is(err, shard.ErrNoShardKey) {
+			t.Errorf("%s: got %v, want %v", name, err, shard.ErrNoShardKey)
+		}
+	}
+}
+
+func TestFanout(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		setup func(s1, s2 *fake.Storage)
+		query func(s *shard.Storage) (any, error)
+		want  any
+		err   error
+	}{
+		{
+			name: "query merges shards in id order",
+			setup: func(s1, s2 *fake.Storage) {
+				s2.ExpectQuery(`^SELECT`).WillReturnRows(usersTable([]any{int64(100), "beta"}))
+				s1.ExpectQuery(`^SELECT`).WillReturnRows(usersTable([]any{int64(1), "alpha"}, []any{int64(2), "gamma"}))
+			},
+			query: func(s *shard.Storage) (any, error) {
+				var users []user
+
+				err := s.Query(context.Background(), storage.NewQuery("SELECT id, name FROM users"), &users)
+
+				return users, err
+			},
+			want: []user{{1, "alpha"}, {2, "gamma"}, {100, "beta"}},
+		},
+		{
+			name: "query skips shards without columns",
+			setup: func(s1, s2 *fake.Storage) {
+				s1.ExpectQuery(`^SELECT`)
+				s2.ExpectQuery(`^SELECT`).WillReturnRows(usersTable([]any{int64(100), "beta"}))
+			},
+			query: func(s *shard.Storage) (any, error) {
+				var users []user
+
+				err := s.Query(context.Background(), storage.NewQuery("SELECT id, name FROM users"), &users)
+
+				return users, err
+			},
+			want: []user{{100, "beta"}},
+		},
+		{
+			name: "query headers mismatch",
+			setup: func(s1, s2 *fake.Storage) {
+				s1.ExpectQuery(`^SELECT`).WillReturnRows(usersTable([]any{int64(1), "alpha"}))
+				s2.ExpectQuery(`^SELECT`).WillReturnRows(storage.Table{Headers: []string{"id"}, Rows: [][]any{{int64(100)}}})
+			},
+			query: func(s *shard.Storage) (any, error) {
+				var table storage.Table
+
+				err := s.Query(context.Background(), storage.NewQuery("SELECT * FROM users"), &table)
+
+				return nil, err
+			},
+			err: shard.ErrHeadersMismatch,
+		},
+		{
+			name: "query shard error",
+			setup: func(s1, s2 *fake.Storage) {
+				s1.ExpectQuery(`^SELECT`).WillReturnRows(usersTable()).Maybe()
+				s2.ExpectQuery(`^SELECT`).WillReturnError(storage.Classify(
+					errors.Const("connection reset"), storage.ErrConnectionLost, "",
+				))
+			},
+			query: func(s *shard.Storage) (any, error) {
+				var users []user
+
+				err := s.Query(context.Background(), storage.NewQuery("SELECT id, name FROM users"), &users)
+
+				return nil, err
+			},
+			err: storage.ErrConnectionLost,
+		},
+		{
+			name: "query row on one shard",
+			setup: func(s1, s2 *fake.Storage) {
+				s1.ExpectQuery(`^SELECT`).WillReturnRows(usersTable())
+				s2.ExpectQuery(`^SELECT`).WillReturnRows(usersTable([]any{int64(100), "beta"}))
+			},
+			query: func(s *shard.Storage) (any, error) {
+				var name string
+
+				err := s.QueryRow(context.Background(), storage.NewQuery("SELECT id, name FROM users WHERE name = 'beta'"), new(int64), &name)
+
+				return name, err
+			},
+			want: "beta",
+		},
+		{
+			name: "query row on several shards",
+			setup: func(s1, s2 *fake.Storage) {
+				s1.ExpectQuery(`^SELECT`).WillReturnRows(usersTable([]any{int64(1), "alpha"}))
+				s2.ExpectQuery(`^SELECT`).WillReturnRows(usersTable([]any{int64(100), "alpha"}))
+			},
+			query: func(s *shard.Storage) (any, error) {
+				var name string
+
+				err := s.QueryRow(context.Background(), storage.NewQuery("SELECT id, name FROM users WHERE name = 'alpha'"), new(int64), &name)
+
+				return nil, err
+			},
+			err: shard.ErrAmbiguousRow,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s, s1, s2 := newStorage(t)
+			test.setup(s1, s2)
+
+			got, err := test.query(s)
+
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("got %v, want %v", err, test.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("query: %s", errors.Formatted(err))
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFanoutIterator(t *testing.T) {
+	s, s1, s2 := newStorage(t)
+	ctx := context.Background()
+
+	s1.ExpectQuery(`^SELECT`).WillReturnRows(usersTable([]any{int64(1), "alpha"}))
+	s2.ExpectQuery(`^SELECT`).WillReturnError(storage.ErrQueryCanceled)
+
+	iter, err := s.Iterate(ctx, storage.NewQuery("SELECT id, name FROM users"))
+	if err != nil {
+		t.Fatalf("iterate: %s", errors.Formatted(err))
+	}
+
+	defer iter.Close()
+
+	var users []user
+
+	for iter.Next(ctx) {
+		var item user
+
+		if err = iter.Decode(&item); err != nil {
+			t.Fatalf("decode: %s", errors.Formatted(err))
+		}
+
+		users = append(users, item)
+	}
+
+	if want := []user{{1, "alpha"}}; !reflect.DeepEqual(users, want) {
+		t.Fatalf("iterate: got %v, want %v", users, want)
+	}
+
+	if err = iter.Err(); !errors.Is(err, storage.ErrQueryCanceled) {
+		t.Fatalf("iterate error: got %v, want %v", err, storage.ErrQueryCanceled)
+	}
+
+	if iter.Next(ctx) {
+		t.Fatalf("next after error: got true, want false")
+	}
+}
+
+func TestBatch(t *testing.T) {
+	t.Run("split by shard", func(t *testing.T) {
+		s, s1, s2 := newStorage(t)
+
+		s1.ExpectExec(`^UPDATE users SET name = 'alpha'`)
+		s2.ExpectExec(`^UPDATE users SET name = 'beta'`)
+
+		var batch storage.Batch
+
+		first := batch.Exec(shard.Keyed(storage.NewQuery("UPDATE users SET name = 'alpha'"), keyS1))
+		second := batch.Exec(shard.Keyed(storage.NewQuery("UPDATE users SET name = 'beta'"), keyS2))
+
+		if err := storage.SendBatch(context.Background(), s, &batch); err != nil {
+			t.Fatalf("send batch: %s", errors.Formatted(err))
+		}
+
+		if first.ExecResult == nil || second.ExecResult == nil {
+			t.Fatalf("exec results: got %v, %v", first.ExecResult, second.ExecResult)
+		}
+	})
+
+	t.Run("atomic across shards", func(t *testing.T) {
+		s, s1, s2 := newStorage(t)
+
+		batch := storage.Batch{Atomic: true}
+
+		batch.Exec(shard.Keyed(storage.NewQuery("UPDATE users SET name = 'alpha'"), keyS1))
+		batch.Exec(shard.Keyed(storage.NewQuery("UPDATE users SET name = 'beta'"), keyS2))
+
+		if err := storage.SendBatch(context.Background(), s, &batch); !errors.Is(err, shard.ErrCrossShardBatch) {
+			t.Fatalf("send batch: got %v, want %v", err, shard.ErrCrossShardBatch)
+		}
+
+		for i, item := range batch.Items() {
+			if !errors.Is(item.Err, storage.ErrBatchAborted) {
+				t.Fatalf("item %d: got %v, want %v", i, item.Err, storage.ErrBatchAborted)
+			}
+		}
+
+		if calls := append(s1.Calls(), s2.Calls()...); len(calls) != 0 {
+			t.Fatalf("calls: got %+v, want none", calls)
+		}
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		s, _, _ := newStorage(t)
+
+		var batch storage.Batch
+
+		batch.Exec(shard.Keyed(storage.NewQuery("UPDATE users SET name = 'alpha'"), keyS1))
+		missing := batch.Exec(storage.NewQuery("UPDATE users SET name = 'beta'"))
+
+		if err := storage.SendBatch(context.Background(), s, &batch); !errors.Is(err, shard.ErrNoShardKey) {
+			t.Fatalf("send batch: got %v, want %v", err, shard.ErrNoShardKey)
+		}
+
+		if !errors.Is(missing.Err, shard.ErrNoShardKey) {
+			t.Fatalf("missing key item: got %v, want %v", missing.Err, shard.ErrNoShardKey)
+		}
+	})
+}
+
+func TestTransactionPinsShard(t *testing.T) {
+	s, s1, _ := newStorage(t)
+	ctx := context.Background()
+
+	s1.ExpectBegin()
+	s1.ExpectExec(`^UPDATE users`).Times(2)
+	s1.ExpectQuery(`^SELECT`).WillReturnRows(usersTable([]any{int64(1), "alpha"}))
+	s1.ExpectCommit()
+
+	tx, err := s.Begin(shard.WithKey(ctx, keyS1))
+	if err != nil {
+		t.Fatalf("begin: %s", errors.Formatted(err))
+	}
+
+	txCtx := tx.Context()
+
+	// запросы в контексте транзакции выполняются на ее шарде без ключа и даже с ключом другого шарда
+	if _, err = s.Exec(txCtx, storage.NewQuery("UPDATE users SET name = 'alpha'")); err != nil {
+		t.Fatalf("exec without key: %s", errors.Formatted(err))
+	}
+
+	if _, err = s.Exec(txCtx, shard.Keyed(storage.NewQuery("UPDATE users SET name = 'beta'"), keyS2)); err != nil {
+		t.Fatalf("exec with other key: %s", errors.Formatted(err))
+	}
+
+	var users []user
+
+	if err = s.Query(txCtx, storage.NewQuery("SELECT id, name FROM users"), &users); err != nil {
+		t.Fatalf("query: %s", errors.Formatted(err))
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		t.Fatalf("commit: %s", errors.Formatted(err))
+	}
+
+	for _, call := range s1.Calls() {
+		if call.Method != fake.MethodBegin && call.TxID != 1 {
+			t.Fatalf("call %s %q: got transaction %d, want 1", call.Method, call.SQL, call.TxID)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package sqlite
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/gomisc/errors.v1"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+const primaryCodeMask = 0xff
+
+var (
+	constraintColumnsRe = regexp.MustCompile(`(?:UNIQUE|NOT NULL) constraint failed: ([^.\s]+)\.([^,\s]+)`)
+	checkConstraintRe   = regexp.MustCompile(`CHECK constraint failed: (\S+)`)
+)
+
+// wrapSQLiteErr - единая точка обогащения ошибок драйвера: операция, имя и отпечаток запроса,
+// расширенный код ошибки SQLite и класс ошибки
+func wrapSQLiteErr(err error, op storage.Operation, query storage.Query, message string) error {
+	errCtx := storage.ErrorContext(op, query).Pos(2)
+
+	var sqliteErr *sqlite.Error
+
+	if errors.As(err, &sqliteErr) {
+		errCtx = errCtx.Str("code", strconv.Itoa(sqliteErr.Code()))
+	}
+
+	return errCtx.Wrap(classifyErr(err), message)
+}
+
+// classifyErr - относит ошибку драйвера к классу. Ошибку нужно классифицировать до первой обертки
+// errors.Wrap: обертка находит вложенную ошибку с контекстом через DriverError.Unwrap, дополняет ее
+// на месте и возвращает без DriverError
+func classifyErr(err error) error {
+	if _, ok := storage.AsDriverError(err); ok {
+		return err
+	}
+
+	var sqliteErr *sqlite.Error
+
+	if errors.As(err, &sqliteErr) {
+		return classifySQLiteErr(err, sqliteErr)
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return storage.Classify(err, storage.ErrQueryCanceled, "")
+	}
+
+	return err
+}
+
+func classifySQLiteErr(err error, sqliteErr *sqlite.Error) error {
+	drvErr := &storage.DriverError{
+		Code: strconv.Itoa(sqliteErr.Code()),
+		Err:  err,
+	}
+
+	switch code := sqliteErr.Code(); {
+	case code == sqlite3.SQLITE_CONSTRAINT_UNIQUE, code == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+		drvErr.Class = storage.ErrUniqueViolation
+
+		if match := constraintColumnsRe.FindStringSubmatch(sqliteErr.Error()); match != nil {
+			drvErr.Table, drvErr.Column = match[1], match[2]
+		}
+	case code == sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY:
+		drvErr.Class = storage.ErrForeignKeyViolation
+	case code == sqlite3.SQLITE_CONSTRAINT_NOTNULL:
+		drvErr.Class = storage.ErrNotNullViolation
+
+		if match := constraintColumnsRe.FindStringSubmatch(sqliteErr.Error()); match != nil {
+			drvErr.Table, drvErr.Column = match[1], match[2]
+		}
+	case code == sqlite3.SQLITE_CONSTRAINT_CHECK:
+		drvErr.Class = storage.ErrCheckViolation
+
+		if match := checkConstraintRe.FindStringSubmatch(sqliteErr.Error()); match != nil {
+			drvErr.Constraint = strings.TrimRight(match[1], ")")
+		}
+	case code&primaryCodeMask == sqlite3.SQLITE_BUSY, code&primaryCodeMask == sqlite3.SQLITE_LOCKED:
+		drvErr.Class = storage.ErrLockTimeout
+	case code&primaryCodeMask == sqlite3.SQLITE_INTERRUPT:
+		drvErr.Class = storage.ErrQueryCanceled
+	default:
+		return err
+	}
+
+	return drvErr
+}
@@ -0,0 +1,53 @@
+package sqlite
+
+import (
+	"context"
+	"io"
+
+	"gopkg.in/gomisc/errors.v1"
+	"gopkg.in/gomisc/tracing.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+var _ storage.BulkExporter = (*databaseClient)(nil)
+
+// CopyTo - имплементация storage.BulkExporter, строки результата записываются в w по мере
+// чтения (storage.WriteRows), бинарный формат не поддерживается
+func (cli *databaseClient) CopyTo(ctx context.Context, query storage.Query, w io.Writer, format storage.CopyFormat) (int64, error) {
+	span := tracing.SetTrace(ctx)
+	defer span.End()
+
+	count, err := cli.copyTo(span.Context(), query, w, format)
+	if err != nil {
+		span, err = span.WithError(err)
+
+		return count, err
+	}
+
+	return count, nil
+}
+
+func (cli *databaseClient) copyTo(ctx context.Context, query storage.Query, w io.Writer, format storage.CopyFormat) (int64, error) {
+	if err := format.Validate(); err != nil {
+		return 0, err
+	}
+
+	if format.Kind == storage.CopyBinary {
+		return 0, errors.Ctx().Stringer("format", format.Kind).Just(storage.ErrNotSupported)
+	}
+
+	rows, err := cli.query(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	defer rows.Close()
+
+	count, err := storage.WriteRows(w, rows, format)
+	if err != nil {
+		return count, wrapSQLiteErr(err, storage.OpCopy, query, "write query result")
+	}
+
+	return count, nil
+}
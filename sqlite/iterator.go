@@ -0,0 +1,64 @@
+package sqlite
+
+import (
+	"context"
+
+	"github.com/georgysavva/scany/sqlscan"
+	"github.com/jmoiron/sqlx"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+var _ storage.BatchIterator = (*sqlIterator)(nil)
+
+type sqlIterator struct {
+	rows    *sqlx.Rows
+	query   storage.Query
+	scanner *sqlscan.RowScanner
+}
+
+func newIterator(rows *sqlx.Rows, query storage.Query) *sqlIterator {
+	return &sqlIterator{
+		rows:    rows,
+		query:   query,
+		scanner: sqlscan.NewRowScanner(rows.Rows),
+	}
+}
+
+func (it *sqlIterator) Close() error {
+	if err := it.rows.Close(); err != nil {
+		return wrapSQLiteErr(err, storage.OpClose, it.query, "close iterator rows")
+	}
+
+	return nil
+}
+
+func (it *sqlIterator) Next(_ context.Context) bool {
+	return it.rows.Next()
+}
+
+func (it *sqlIterator) Err() error {
+	if err := it.rows.Err(); err != nil {
+		return wrapSQLiteErr(err, storage.OpIterate, it.query, "iterate query result")
+	}
+
+	return nil
+}
+
+func (it *sqlIterator) Decode(result any) error {
+	if err := it.scanner.Scan(result); err != nil {
+		return wrapSQLiteErr(err, storage.OpDecode, it.query, "decode item result")
+	}
+
+	return nil
+}
+
+// NextBatch - имплементация storage.BatchIterator, строки декодируются сканером итератора напрямую
+func (it *sqlIterator) NextBatch(_ context.Context, dest any, n int) (int, error) {
+	count, err := storage.DecodeBatch(dest, n, it.rows.Next, it.scanner.Scan)
+	if err != nil {
+		return count, wrapSQLiteErr(err, storage.OpDecode, it.query, "decode batch result")
+	}
+
+	return count, it.Err()
+}
@@ -0,0 +1,92 @@
+package sqlite
+
+import (
+	"database/sql"
+	"reflect"
+
+	"github.com/georgysavva/scany/sqlscan"
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+type customScanner struct {
+	rows *sqlx.Rows
+}
+
+func newScanner(rows *sqlx.Rows) storage.Scanner {
+	return &customScanner{rows: rows}
+}
+
+func (scan *customScanner) Scan(result any) error {
+	val := reflect.ValueOf(result)
+
+	if val.Type().Elem().Kind() == reflect.Slice {
+		if err := sqlscan.ScanAll(result, scan.rows.Rows); err != nil {
+			return errors.Wrap(classifyErr(err), "scan objects to slice")
+		}
+
+		return nil
+	}
+
+	if err := sqlscan.ScanOne(result, scan.rows.Rows); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.ErrEmptyResult
+		}
+
+		return errors.Wrap(classifyErr(err), "scan row to object")
+	}
+
+	return nil
+}
+
+func (scan *customScanner) ScanResult(result *storage.Result) error {
+	defer scan.rows.Close()
+
+	for scan.rows.Next() {
+		row := make(map[string]any)
+
+		if err := scan.rows.MapScan(row); err != nil {
+			return errors.Wrap(classifyErr(err), "scan result row")
+		}
+
+		*result = append(*result, row)
+	}
+
+	if err := scan.rows.Err(); err != nil {
+		return errors.Wrap(classifyErr(err), "read result rows")
+	}
+
+	return nil
+}
+
+func (scan *customScanner) ScanTable(table *storage.Table) error {
+	defer scan.rows.Close()
+
+	fields, err := scan.rows.Columns()
+	if err != nil {
+		return errors.Wrap(classifyErr(err), "scan result table row")
+	}
+
+	for h := 0; h < len(fields); h++ {
+		table.Headers = append(table.Headers, fields[h])
+	}
+
+	for scan.rows.Next() {
+		var values []any
+
+		values, err = scan.rows.SliceScan()
+		if err != nil {
+			return errors.Wrap(classifyErr(err), "get row values")
+		}
+
+		table.Rows = append(table.Rows, values)
+	}
+
+	if err = scan.rows.Err(); err != nil {
+		return errors.Wrap(classifyErr(err), "read table rows")
+	}
+
+	return nil
+}
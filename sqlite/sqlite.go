@@ -0,0 +1,325 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/gomisc/errors.v1"
+	"gopkg.in/gomisc/fields.v1"
+	"gopkg.in/gomisc/tracing.v1"
+	_ "modernc.org/sqlite"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+// DSN schemes
+const (
+	DefaultScheme = "sqlite"
+	FileScheme    = "file"
+
+	driverName   = "sqlite"
+	memoryDB     = ":memory:"
+	memoryPrefix = "file:/storage_memory_"
+)
+
+const (
+	errWrongQueryType  = errors.Const("query.Query must be string type")
+	errWrongParameters = errors.Const("parameters must be []interface{}, map with string keys or struct type")
+)
+
+var (
+	_ storage.Storage = (*databaseClient)(nil)
+
+	memorySeq atomic.Uint64
+)
+
+type (
+	databaseClient struct {
+		pool *sqlx.DB
+	}
+)
+
+func init() {
+	storage.RegisterDriver(DefaultScheme, open, FileScheme)
+}
+
+// New - конструктор клиента встроенной базы данных SQLite, dsn передается драйверу
+// modernc.org/sqlite: путь к файлу, ":memory:" (база в памяти, общая для соединений клиента)
+// или URI вида file:path?params
+func New(ctx context.Context, dsn string) (storage.Storage, error) {
+	span := tracing.SetTrace(ctx)
+	defer span.End()
+
+	pool, err := sqlx.Open(driverName, withDefaultPragmas(sharedMemoryDSN(dsn)))
+	if err != nil {
+		span, err = span.WithError(wrapSQLiteErr(err, storage.OpConnect, nil, "open sqlite database"))
+
+		return nil, err
+	}
+
+	if err = pool.PingContext(span.Context()); err != nil {
+		_ = pool.Close()
+
+		span, err = span.WithError(wrapSQLiteErr(err, storage.OpConnect, nil, "connect to sqlite database"))
+
+		return nil, err
+	}
+
+	return &databaseClient{pool: pool}, nil
+}
+
+// DSN - преобразует url вида sqlite:///path/to.db, sqlite://relative.db, sqlite::memory:
+// или file:path?params в dsn драйвера
+func DSN(uri *url.URL) string {
+	if uri.Scheme == FileScheme {
+		return uri.String()
+	}
+
+	path := uri.Opaque
+	if path == "" {
+		path = uri.Host + uri.Path
+	}
+
+	if strings.TrimPrefix(path, "/") == memoryDB {
+		path = memoryDB
+	}
+
+	if uri.RawQuery != "" {
+		return path + "?" + uri.RawQuery
+	}
+
+	return path
+}
+
+// open - конструктор хранилища для реестра драйверов
+func open(ctx context.Context, uri *url.URL) (storage.Storage, error) {
+	return New(ctx, DSN(uri))
+}
+
+// Close реализация io.Closer
+func (cli *databaseClient) Close() error {
+	if err := cli.pool.Close(); err != nil {
+		return wrapSQLiteErr(err, storage.OpClose, nil, "close database connections")
+	}
+
+	return nil
+}
+
+// Begin - открывает и возвращает транзакцию, опции принимаются в виде storage.TxOptions или *sql.TxOptions.
+// Если контекст уже несет транзакцию, открывается вложенная транзакция на точке сохранения
+func (cli *databaseClient) Begin(ctx context.Context, options ...any) (transaction storage.Transaction, err error) {
+	span := tracing.SetTrace(ctx)
+	defer span.End()
+
+	if outer, ok := ctx.Value(transactionKey{}).(*sqliteTransaction); ok {
+		var nested *sqliteTransaction
+
+		if nested, err = outer.beginNested(span.Context(), options...); err != nil {
+			span, err = span.WithError(err, "begin nested transaction")
+			return nil, err
+		}
+
+		return nested, nil
+	}
+
+	opts, txOpts, err := getSQLTxOptions(options...)
+	if err != nil {
+		span, err = span.WithError(err, "get transaction options")
+		return nil, err
+	}
+
+	tx := &sqliteTransaction{ctx: span.Context()}
+
+	if txOpts != nil {
+		tx.label, tx.opts = txOpts.Label, txOpts
+		span.WithFields(fields.Str("tx-label", txOpts.Label))
+
+		if txOpts.Timeout > 0 {
+			tx.ctx, tx.cancel = context.WithTimeout(tx.ctx, txOpts.Timeout)
+		}
+	}
+
+	if tx.tx, err = cli.pool.BeginTxx(tx.ctx, opts); err != nil {
+		tx.release()
+
+		err = errors.Ctx().Any("options", opts).Just(wrapSQLiteErr(err, storage.OpBegin, nil, "begin transaction"))
+		span, err = span.WithError(err)
+		return nil, err
+	}
+
+	if opts != nil && opts.ReadOnly {
+		if err = tx.setQueryOnly(true); err != nil {
+			_ = tx.tx.Rollback()
+			tx.release()
+
+			span, err = span.WithError(wrapSQLiteErr(err, storage.OpBegin, nil, "begin read only transaction"))
+			return nil, err
+		}
+	}
+
+	return tx, nil
+}
+
+// Exec Выполняет запрос который ничего не возвращает
+func (cli *databaseClient) Exec(ctx context.Context, query storage.Query) (sql.Result, error) {
+	span := tracing.SetTrace(ctx)
+	defer span.End()
+
+	res, err := cli.exec(span.Context(), query)
+	if err != nil {
+		span, err = span.WithError(err, "execution error")
+
+		return res, err
+	}
+
+	return res, nil
+}
+
+// Query - выполняет запрос производящий действия в базе, с возможностью вернуть произвольный результат
+func (cli *databaseClient) Query(ctx context.Context, query storage.Query, result any) (err error) {
+	span := tracing.SetTrace(ctx)
+	defer span.End()
+
+	if result == nil {
+		if _, err = cli.exec(span.Context(), query); err != nil {
+			span, err = span.WithError(err)
+			return err
+		}
+
+		return nil
+	}
+
+	var rows *sqlx.Rows
+
+	if rows, err = cli.query(span.Context(), query); err != nil {
+		span, err = span.WithError(err)
+		return err
+	}
+
+	defer rows.Close()
+
+	scanner := newScanner(rows)
+
+	if res, ok := result.(*storage.Result); ok {
+		if err = scanner.ScanResult(res); err != nil {
+			span, err = span.WithError(wrapSQLiteErr(err, storage.OpScan, query, "decode to storage result"))
+			return err
+		}
+
+		return nil
+	}
+
+	if res, ok := result.(*storage.Table); ok {
+		if err = scanner.ScanTable(res); err != nil {
+			span, err = span.WithError(wrapSQLiteErr(err, storage.OpScan, query, "decode to storage table"))
+			return err
+		}
+
+		return nil
+	}
+
+	if err = scanner.Scan(result); err != nil {
+		span, err = span.WithError(wrapSQLiteErr(err, storage.OpScan, query, "decode to custom result"))
+		return err
+	}
+
+	return nil
+}
+
+// QueryRow - выполняет запрос и сканирует единственную строку результата в dest
+func (cli *databaseClient) QueryRow(ctx context.Context, query storage.Query, dest ...any) error {
+	span := tracing.SetTrace(ctx)
+	defer span.End()
+
+	row, err := cli.queryRow(span.Context(), query)
+	if err != nil {
+		span, err = span.WithError(err)
+		return err
+	}
+
+	if err = row.Scan(dest...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.ErrEmptyResult
+		}
+
+		span, err = span.WithError(wrapSQLiteErr(err, storage.OpQueryRow, query, "scan query row"))
+		return err
+	}
+
+	return nil
+}
+
+// Iterate - выполняет запрос и возвращает итератор по результатам произвольного типа из базы
+func (cli *databaseClient) Iterate(ctx context.Context, query storage.Query) (storage.Iterator, error) {
+	span := tracing.SetTrace(ctx)
+	defer span.End()
+
+	rows, err := cli.query(span.Context(), query)
+	if err != nil {
+		span, err = span.WithError(err, "get iterable query result")
+
+		return nil, err
+	}
+
+	return newIterator(rows, query), nil
+}
+
+func (cli *databaseClient) getExecutor(ctx context.Context) sqlx.ExtContext {
+	if tx, ok := ctx.Value(transactionKey{}).(*sqliteTransaction); ok {
+		return tx.tx
+	}
+
+	return cli.pool
+}
+
+// sharedMemoryDSN - каждое соединение с :memory: открывает собственную пустую базу, поэтому
+// такой dsn заменяется базой с уникальным именем в VFS memdb, общей для соединений пула.
+// Пишущая транзакция блокирует чтение на других соединениях до фиксации не дольше busy_timeout,
+// тогда как с cache=shared чтение ждет ее без ограничения. База существует, пока открыто
+// хотя бы одно соединение пула
+func sharedMemoryDSN(dsn string) string {
+	path, params, _ := strings.Cut(dsn, "?")
+
+	if path != memoryDB && path != "file:"+memoryDB {
+		// именованная база mode=memory общая для соединений только с общим кешем
+		if strings.Contains(params, "mode=memory") && !strings.Contains(params, "cache=") {
+			return dsn + "&cache=shared"
+		}
+
+		return dsn
+	}
+
+	path = memoryPrefix + strconv.FormatUint(memorySeq.Add(1), 10)
+
+	return path + "?" + strings.TrimPrefix(params+"&vfs=memdb", "&")
+}
+
+// withDefaultPragmas - включает проверку внешних ключей и ожидание блокировок,
+// если dsn не задает их явно, чтобы поведение было ближе к серверным базам
+func withDefaultPragmas(dsn string) string {
+	var pragmas []string
+
+	if !strings.Contains(dsn, "foreign_keys") {
+		pragmas = append(pragmas, "_pragma=foreign_keys(1)")
+	}
+
+	if !strings.Contains(dsn, "busy_timeout") {
+		pragmas = append(pragmas, "_pragma=busy_timeout(5000)")
+	}
+
+	if len(pragmas) == 0 {
+		return dsn
+	}
+
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+
+	return dsn + separator + strings.Join(pragmas, "&")
+}
@@ -0,0 +1,142 @@
+package sqlite_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+	"gopkg.in/gomisc/storage.v1/sqlite"
+	"gopkg.in/gomisc/storage.v1/storagetest"
+)
+
+func TestConformance(t *testing.T) {
+	t.Run("file", func(t *testing.T) {
+		storagetest.RunConformance(t, func(t *testing.T) storage.Storage {
+			return open(t, filepath.Join(t.TempDir(), "conformance.db"))
+		})
+	})
+
+	t.Run("memory", func(t *testing.T) {
+		storagetest.RunConformance(t, func(t *testing.T) storage.Storage {
+			return open(t, ":memory:")
+		}, storagetest.Skip(
+			"in-memory database blocks reads on other connections until the write transaction ends",
+			"Transaction/Isolation",
+		))
+	})
+}
+
+// cancelQuery - отменяет контекст запроса TestQueryCanceled при сканировании первой строки
+var cancelQuery context.CancelFunc
+
+// cancelOnScan - колонка, отменяющая контекст запроса посреди чтения строк
+type cancelOnScan int64
+
+type canceledRow struct {
+	N cancelOnScan `db:"n"`
+}
+
+func TestQueryCanceled(t *testing.T) {
+	s := open(t, filepath.Join(t.TempDir(), "cancel.db"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	cancelQuery = cancel
+
+	var rows []canceledRow
+
+	err := s.Query(ctx, storage.NewQuery(
+		"WITH RECURSIVE seq(n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM seq WHERE n < 1000000) SELECT n FROM seq",
+	), &rows)
+	if !errors.Is(err, storage.ErrQueryCanceled) {
+		t.Fatalf("query: got %v, want %v", err, storage.ErrQueryCanceled)
+	}
+}
+
+func TestNestedTxOptions(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		outer  any
+		nested storage.TxOptions
+		err    error
+	}{
+		// транзакции SQLite сериализуемы при любом запрошенном уровне
+		{name: "default", outer: nil, nested: storage.TxOptions{Isolation: storage.LevelSerializable}},
+		{
+			name:   "read committed",
+			outer:  storage.TxOptions{Isolation: storage.LevelReadCommitted},
+			nested: storage.TxOptions{Isolation: storage.LevelSerializable},
+		},
+		{
+			name:   "sql read only",
+			outer:  &sql.TxOptions{ReadOnly: true},
+			nested: storage.TxOptions{ReadOnly: true},
+		},
+		{
+			name:   "read only in read write",
+			outer:  &sql.TxOptions{},
+			nested: storage.TxOptions{ReadOnly: true},
+			err:    storage.ErrUnsupportedTxOptions,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := open(t, filepath.Join(t.TempDir(), "nested.db"))
+			ctx := context.Background()
+
+			tx, err := s.Begin(ctx, test.outer)
+			if err != nil {
+				t.Fatalf("begin: %s", errors.Formatted(err))
+			}
+
+			defer func() { _ = tx.Rollback(ctx) }()
+
+			nested, err := s.Begin(tx.Context(), test.nested)
+
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("begin nested: got %v, want %v", err, test.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("begin nested: %s", errors.Formatted(err))
+			}
+
+			if err = nested.Commit(ctx); err != nil {
+				t.Fatalf("commit nested: %s", errors.Formatted(err))
+			}
+		})
+	}
+}
+
+func open(t *testing.T, dsn string) storage.Storage {
+	t.Helper()
+
+	s, err := sqlite.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatalf("open %q: %s", dsn, errors.Formatted(err))
+	}
+
+	t.Cleanup(func() { _ = s.Close() })
+
+	return s
+}
+
+func (v *cancelOnScan) Scan(src any) error {
+	n, _ := src.(int64)
+	if n == 1 {
+		cancelQuery()
+	}
+
+	*v = cancelOnScan(n)
+
+	return nil
+}
@@ -0,0 +1,244 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/gomisc/errors.v1"
+	"gopkg.in/gomisc/tracing.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+type (
+	transactionKey struct{}
+
+	sqliteTransaction struct {
+		storage.TxHooks
+
+		tx     *sqlx.Tx
+		ctx    context.Context
+		cancel context.CancelFunc
+		label  string
+		// opts - опции корневой транзакции, вложенные транзакции наследуют их; для транзакции,
+		// открытой с sql.TxOptions, восстанавливаются из них
+		opts *storage.TxOptions
+		// parent - внешняя транзакция, savepoint - имя точки сохранения вложенной транзакции
+		parent     *sqliteTransaction
+		savepoint  string
+		savepoints int
+		queryOnly  bool
+	}
+)
+
+func (tx *sqliteTransaction) Context() context.Context {
+	return storage.ContextWithTransaction(context.WithValue(tx.ctx, transactionKey{}, tx), tx)
+}
+
+func (tx *sqliteTransaction) Commit(ctx context.Context) error {
+	span := tracing.SetTrace(ctx)
+	defer span.End()
+	defer tx.release()
+
+	if tx.parent != nil {
+		if err := tx.execSavepoint(ctx, "RELEASE SAVEPOINT "); err != nil {
+			span.WithError(err, "release savepoint failed")
+
+			err = errors.Ctx().Str("tx-label", tx.label).Just(wrapSQLiteErr(err, storage.OpSavepoint, nil, "release savepoint"))
+			tx.RunRollbackHooks(ctx, err)
+
+			return err
+		}
+
+		tx.MoveTo(&tx.parent.TxHooks)
+
+		return nil
+	}
+
+	tx.resetQueryOnly()
+
+	if err := tx.tx.Commit(); err != nil {
+		span.WithError(err, "commit transaction failed")
+
+		err = errors.Ctx().Str("tx-label", tx.label).Just(wrapSQLiteErr(err, storage.OpCommit, nil, "commit transaction"))
+		tx.RunRollbackHooks(ctx, err)
+
+		return err
+	}
+
+	tx.RunCommitHooks(ctx)
+
+	return nil
+}
+
+func (tx *sqliteTransaction) Rollback(ctx context.Context) error {
+	span := tracing.SetTrace(ctx)
+	defer span.End()
+	defer tx.release()
+
+	if tx.parent != nil {
+		if err := tx.execSavepoint(ctx, "ROLLBACK TO SAVEPOINT "); err != nil {
+			span.WithError(err, "rollback to savepoint failed")
+
+			err = errors.Ctx().Str("tx-label", tx.label).Just(wrapSQLiteErr(err, storage.OpSavepoint, nil, "rollback to savepoint"))
+			tx.RunRollbackHooks(ctx, err)
+
+			return err
+		}
+
+		tx.RunRollbackHooks(ctx, nil)
+
+		return nil
+	}
+
+	tx.resetQueryOnly()
+
+	if err := tx.tx.Rollback(); err != nil {
+		span.WithError(err, "rollback transaction failed")
+
+		err = errors.Ctx().Str("tx-label", tx.label).Just(wrapSQLiteErr(err, storage.OpRollback, nil, "rollback transaction"))
+		tx.RunRollbackHooks(ctx, err)
+
+		return err
+	}
+
+	tx.RunRollbackHooks(ctx, nil)
+
+	return nil
+}
+
+// beginNested - открывает вложенную транзакцию на точке сохранения внешней транзакции,
+// Commit вложенной транзакции освобождает точку сохранения, Rollback - откатывает к ней
+func (tx *sqliteTransaction) beginNested(ctx context.Context, options ...any) (*sqliteTransaction, error) {
+	var outer storage.TxOptions
+	if tx.opts != nil {
+		outer = *tx.opts
+	}
+
+	// транзакции SQLite сериализуемы при любом запрошенном уровне изоляции
+	outer.Isolation = storage.LevelDefault
+
+	opts, err := storage.NestedTxOptions(&outer, storage.LevelSerializable, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	root := tx
+	for root.parent != nil {
+		root = root.parent
+	}
+
+	root.savepoints++
+
+	nested := &sqliteTransaction{
+		tx:        tx.tx,
+		ctx:       ctx,
+		label:     tx.label,
+		opts:      tx.opts,
+		parent:    tx,
+		savepoint: fmt.Sprintf("sp_%d", root.savepoints),
+	}
+
+	if opts != nil {
+		if opts.Label != "" {
+			nested.label = opts.Label
+		}
+
+		if opts.Timeout > 0 {
+			nested.ctx, nested.cancel = context.WithTimeout(ctx, opts.Timeout)
+		}
+	}
+
+	if err = nested.execSavepoint(ctx, "SAVEPOINT "); err != nil {
+		nested.release()
+
+		return nil, wrapSQLiteErr(err, storage.OpSavepoint, nil, "create savepoint")
+	}
+
+	return nested, nil
+}
+
+func (tx *sqliteTransaction) execSavepoint(ctx context.Context, statement string) error {
+	_, err := tx.tx.ExecContext(ctx, statement+tx.savepoint)
+
+	return err
+}
+
+// setQueryOnly - переключает соединение транзакции в режим только для чтения
+func (tx *sqliteTransaction) setQueryOnly(enabled bool) error {
+	statement := "PRAGMA query_only = OFF"
+	if enabled {
+		statement = "PRAGMA query_only = ON"
+	}
+
+	if _, err := tx.tx.ExecContext(tx.ctx, statement); err != nil {
+		return err
+	}
+
+	tx.queryOnly = enabled
+
+	return nil
+}
+
+// resetQueryOnly - возвращает соединению режим записи до его возврата в пул
+func (tx *sqliteTransaction) resetQueryOnly() {
+	if tx.queryOnly {
+		_ = tx.setQueryOnly(false)
+	}
+}
+
+func (tx *sqliteTransaction) release() {
+	if tx.cancel != nil {
+		tx.cancel()
+	}
+}
+
+func getSQLTxOptions(in ...any) (*sql.TxOptions, *storage.TxOptions, error) {
+	if len(in) == 0 {
+		return nil, nil, nil
+	}
+
+	if opts, ok := storage.TxOptionsFrom(in...); ok {
+		sqlOpts, err := convertTxOptions(opts)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return sqlOpts, opts, nil
+	}
+
+	switch opts := in[0].(type) {
+	case nil:
+		return nil, nil, nil
+	case *sql.TxOptions:
+		if opts == nil {
+			return nil, nil, nil
+		}
+
+		return opts, &storage.TxOptions{ReadOnly: opts.ReadOnly}, nil
+	case sql.TxOptions:
+		return &opts, &storage.TxOptions{ReadOnly: opts.ReadOnly}, nil
+	case *storage.TxOptions:
+		return nil, nil, nil
+	default:
+		return nil, nil, errors.Ctx().
+			Str("type", fmt.Sprintf("%T", opts)).
+			Just(storage.ErrUnsupportedTxOptions)
+	}
+}
+
+// convertTxOptions - транзакции SQLite всегда сериализуемы, поэтому любой уровень изоляции
+// выполняется не слабее запрошенного; read only эмулируется через PRAGMA query_only
+func convertTxOptions(opts *storage.TxOptions) (*sql.TxOptions, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	if opts.Deferrable {
+		return nil, errors.Wrap(storage.ErrUnsupportedTxOptions, "deferrable mode is not supported by sqlite")
+	}
+
+	return &sql.TxOptions{ReadOnly: opts.ReadOnly}, nil
+}
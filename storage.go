@@ -24,6 +24,10 @@ type (
 		Commit(ctx context.Context) error
 		// Rollback Откатывает текущую транзакцию
 		Rollback(ctx context.Context) error
+		// OnCommit - регистрирует функцию, вызываемую после успешной фиксации транзакции
+		OnCommit(fn func(ctx context.Context))
+		// OnRollback - регистрирует функцию, вызываемую после отката транзакции с причиной отката
+		OnRollback(fn func(ctx context.Context, err error))
 	}
 
 	// Iterator интерфейс итератора по многоэлементному результату запроса
@@ -45,6 +49,9 @@ type (
 		Exec(ctx context.Context, query Query) (sql.Result, error)
 		// Query - выполняет запрос производящий действия в базе, с возможностью вернуть произвольный результат
 		Query(ctx context.Context, query Query, result any) error
+		// QueryRow - выполняет запрос и сканирует колонки единственной строки результата в dest,
+		// при отсутствии строк возвращает ErrEmptyResult
+		QueryRow(ctx context.Context, query Query, dest ...any) error
 		// Iterate возвращает итератор по результату запроса
 		Iterate(ctx context.Context, query Query) (Iterator, error)
 	}
@@ -0,0 +1,735 @@
+// Package storagetest - инструменты тестирования кода, работающего с storage.Storage:
+// набор проверок соответствия реализаций хранилища, фейковое хранилище (fake)
+// и запись с воспроизведением взаимодействия с базой данных (replay)
+package storagetest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+const conformanceTable = "storage_conformance"
+
+type (
+	// FactoryFunc - возвращает хранилище, подключенное к тестовой базе данных. Набор создает
+	// и удаляет собственную таблицу, закрытие хранилища остается за фабрикой
+	FactoryFunc func(t *testing.T) storage.Storage
+
+	// Option - параметр набора проверок соответствия
+	Option func(suite *conformance)
+
+	// conformanceRow - строка таблицы набора
+	conformanceRow struct {
+		ID    int64    `db:"id"`
+		Name  string   `db:"name"`
+		Score *float64 `db:"score"`
+	}
+
+	conformance struct {
+		storage storage.Storage
+		table   string
+		skip    map[string]string
+	}
+)
+
+// Skip - пропускает проверки с указанными именами (например "Transaction/Isolation")
+// для хранилищ, которые не могут их выполнить, с указанием причины
+func Skip(reason string, names ...string) Option {
+	return func(suite *conformance) {
+		for _, name := range names {
+			suite.skip[name] = reason
+		}
+	}
+}
+
+// RunConformance - проверяет, что реализация storage.Storage ведет себя так же, как встроенные
+// драйверы: результаты Exec, Query, QueryRow и Iterate, форма storage.Result и storage.Table,
+// пустые результаты, NULL, отмена по контексту, классификация ошибок, подготовленные запросы
+// и видимость изменений в транзакциях. Запросы используют именованные параметры и переносимый SQL
+func RunConformance(t *testing.T, factory FactoryFunc, opts ...Option) {
+	t.Helper()
+
+	suite := &conformance{
+		storage: factory(t),
+		// nolint: gosec
+		table: fmt.Sprintf("%s_%d", conformanceTable, rand.New(rand.NewSource(time.Now().UnixNano())).Intn(1e6)),
+		skip:  make(map[string]string),
+	}
+
+	for _, opt := range opts {
+		opt(suite)
+	}
+
+	ctx := context.Background()
+
+	suite.mustExec(t, ctx, fmt.Sprintf(
+		"CREATE TABLE %s (id BIGINT NOT NULL PRIMARY KEY, name VARCHAR(64) NOT NULL UNIQUE, score DOUBLE PRECISION NULL)",
+		suite.table,
+	))
+
+	t.Cleanup(func() {
+		if _, err := suite.storage.Exec(ctx, storage.NewQuery("DROP TABLE "+suite.table)); err != nil {
+			t.Errorf("drop conformance table: %s", errors.Formatted(err))
+		}
+	})
+
+	suite.run(t, "Exec/RowsAffected", suite.testExecRowsAffected)
+	suite.run(t, "Exec/LastInsertId", suite.testExecLastInsertID)
+	suite.run(t, "Query/Slice", suite.testQuerySlice)
+	suite.run(t, "Query/One", suite.testQueryOne)
+	suite.run(t, "Query/Empty", suite.testQueryEmpty)
+	suite.run(t, "Query/NilResult", suite.testQueryNilResult)
+	suite.run(t, "Query/Result", suite.testQueryResult)
+	suite.run(t, "Query/Table", suite.testQueryTable)
+	suite.run(t, "Query/Null", suite.testQueryNull)
+	suite.run(t, "QueryRow", suite.testQueryRow)
+	suite.run(t, "Iterate", suite.testIterate)
+	suite.run(t, "Iterate/Empty", suite.testIterateEmpty)
+	suite.run(t, "Context/Canceled", suite.testContextCanceled)
+	suite.run(t, "Errors/Classified", suite.testErrorsClassified)
+	suite.run(t, "Prepare", suite.testPrepare)
+	suite.run(t, "Prepare/Transaction", suite.testPrepareTx)
+	suite.run(t, "Transaction/Commit", suite.testTxCommit)
+	suite.run(t, "Transaction/Rollback", suite.testTxRollback)
+	suite.run(t, "Transaction/Isolation", suite.testTxIsolation)
+	suite.run(t, "Transaction/Nested", suite.testTxNested)
+	suite.run(t, "Transaction/Hooks", suite.testTxHooks)
+	suite.run(t, "Transaction/Done", suite.testTxDone)
+}
+
+func (suite *conformance) run(t *testing.T, name string, test func(t *testing.T, ctx context.Context)) {
+	t.Helper()
+
+	t.Run(name, func(t *testing.T) {
+		if reason, skipped := suite.skip[name]; skipped {
+			t.Skip(reason)
+		}
+
+		ctx := context.Background()
+
+		suite.mustExec(t, ctx, "DELETE FROM "+suite.table)
+		suite.insert(t, ctx, fixture()...)
+
+		test(t, ctx)
+	})
+}
+
+func (suite *conformance) testExecRowsAffected(t *testing.T, ctx context.Context) {
+	res, err := suite.storage.Exec(ctx, suite.query(
+		"UPDATE %s SET score = :score WHERE id <= :id", map[string]any{"score": 10.0, "id": 2},
+	))
+	if err != nil {
+		t.Fatalf("exec update: %s", errors.Formatted(err))
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil || affected != 2 {
+		t.Fatalf("rows affected: got %d, %v; want 2", affected, err)
+	}
+}
+
+func (suite *conformance) testExecLastInsertID(t *testing.T, ctx context.Context) {
+	res, err := suite.storage.Exec(ctx, suite.query(
+		"INSERT INTO %s (id, name) VALUES (:first, :first_name), (:second, :second_name)",
+		map[string]any{"first": 10, "first_name": "delta", "second": 11, "second_name": "epsilon"},
+	))
+	if err != nil {
+		t.Fatalf("exec insert: %s", errors.Formatted(err))
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil || affected != 2 {
+		t.Fatalf("rows affected: got %d, %v; want 2", affected, err)
+	}
+
+	// значение зависит от базы данных (rowid, AUTO_INCREMENT), но драйвер, не знающий
+	// идентификатора, должен вернуть ErrNotSupported, а не подменять его другим значением
+	id, err := res.LastInsertId()
+
+	switch {
+	case err != nil && !errors.Is(err, storage.ErrNotSupported):
+		t.Fatalf("last insert id: unexpected error %s", errors.Formatted(err))
+	case err == nil && id == affected:
+		t.Fatalf("last insert id: got rows affected %d instead of inserted id", id)
+	}
+}
+
+func (suite *conformance) testQuerySlice(t *testing.T, ctx context.Context) {
+	rows, err := storage.Select[conformanceRow](ctx, suite.storage, suite.query("SELECT id, name, score FROM %s ORDER BY id", nil))
+	if err != nil {
+		t.Fatalf("select rows: %s", errors.Formatted(err))
+	}
+
+	if !reflect.DeepEqual(rows, fixture()) {
+		t.Fatalf("select rows: got %+v, want %+v", rows, fixture())
+	}
+}
+
+func (suite *conformance) testQueryOne(t *testing.T, ctx context.Context) {
+	row, err := storage.Get[conformanceRow](ctx, suite.storage, suite.query(
+		"SELECT id, name, score FROM %s WHERE id = :id", map[string]any{"id": 3},
+	))
+	if err != nil {
+		t.Fatalf("get row: %s", errors.Formatted(err))
+	}
+
+	if want := fixture()[2]; !reflect.DeepEqual(row, want) {
+		t.Fatalf("get row: got %+v, want %+v", row, want)
+	}
+
+	name, err := storage.Get[string](ctx, suite.storage, suite.query(
+		"SELECT name FROM %s WHERE id = :id", map[string]any{"id": 1},
+	))
+	if err != nil || name != "alpha" {
+		t.Fatalf("get scalar: got %q, %v; want alpha", name, err)
+	}
+}
+
+func (suite *conformance) testQueryEmpty(t *testing.T, ctx context.Context) {
+	query := suite.query("SELECT id, name, score FROM %s WHERE id > :id", map[string]any{"id": 100})
+
+	if _, err := storage.Get[conformanceRow](ctx, suite.storage, query); !errors.Is(err, storage.ErrEmptyResult) {
+		t.Fatalf("get empty: got %v, want ErrEmptyResult", err)
+	}
+
+	rows, err := storage.Select[conformanceRow](ctx, suite.storage, query)
+	if err != nil || len(rows) != 0 {
+		t.Fatalf("select empty: got %+v, %v; want no rows", rows, err)
+	}
+
+	var table storage.Table
+
+	if err = suite.storage.Query(ctx, query, &table); err != nil || len(table.Rows) != 0 {
+		t.Fatalf("table empty: got %+v, %v; want no rows", table, err)
+	}
+
+	if !reflect.DeepEqual(table.Headers, []string{"id", "name", "score"}) {
+		t.Fatalf("table empty: got headers %v, want [id name score]", table.Headers)
+	}
+
+	var result storage.Result
+
+	if err = suite.storage.Query(ctx, query, &result); err != nil || len(result) != 0 {
+		t.Fatalf("result empty: got %+v, %v; want no rows", result, err)
+	}
+}
+
+func (suite *conformance) testQueryNilResult(t *testing.T, ctx context.Context) {
+	err := suite.storage.Query(ctx, suite.query("DELETE FROM %s WHERE id = :id", map[string]any{"id": 1}), nil)
+	if err != nil {
+		t.Fatalf("query without result: %s", errors.Formatted(err))
+	}
+
+	if count := suite.count(t, ctx); count != 2 {
+		t.Fatalf("query without result: got %d rows, want 2", count)
+	}
+}
+
+func (suite *conformance) testQueryResult(t *testing.T, ctx context.Context) {
+	var result storage.Result
+
+	if err := suite.storage.Query(ctx, suite.query("SELECT id, name, score FROM %s ORDER BY id", nil), &result); err != nil {
+		t.Fatalf("query result: %s", errors.Formatted(err))
+	}
+
+	want := make([]map[string]any, 0, len(fixture()))
+
+	for _, row := range fixture() {
+		want = append(want, map[string]any{"id": row.ID, "name": row.Name, "score": scoreValue(row.Score)})
+	}
+
+	if len(result) != len(want) {
+		t.Fatalf("query result: got %d rows, want %d", len(result), len(want))
+	}
+
+	for i := range want {
+		got := make(map[string]any, len(result[i]))
+
+		for key, val := range result[i] {
+			got[key] = normalize(val)
+		}
+
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Fatalf("query result row %d: got %#v, want %#v", i, got, want[i])
+		}
+	}
+}
+
+func (suite *conformance) testQueryTable(t *testing.T, ctx context.Context) {
+	var table storage.Table
+
+	if err := suite.storage.Query(ctx, suite.query("SELECT id, name, score FROM %s ORDER BY id", nil), &table); err != nil {
+		t.Fatalf("query table: %s", errors.Formatted(err))
+	}
+
+	if !reflect.DeepEqual(table.Headers, []string{"id", "name", "score"}) {
+		t.Fatalf("query table: got headers %v, want [id name score]", table.Headers)
+	}
+
+	if len(table.Rows) != len(fixture()) {
+		t.Fatalf("query table: got %d rows, want %d", len(table.Rows), len(fixture()))
+	}
+
+	for i, row := range fixture() {
+		got := make([]any, 0, len(table.Rows[i]))
+
+		for _, val := range table.Rows[i] {
+			got = append(got, normalize(val))
+		}
+
+		if want := []any{row.ID, row.Name, scoreValue(row.Score)}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("query table row %d: got %#v, want %#v", i, got, want)
+		}
+	}
+}
+
+func (suite *conformance) testQueryNull(t *testing.T, ctx context.Context) {
+	query := suite.query("SELECT score FROM %s WHERE id = :id", map[string]any{"id": 2})
+
+	score, err := storage.Get[*float64](ctx, suite.storage, query)
+	if err != nil || score != nil {
+		t.Fatalf("get null pointer: got %v, %v; want nil", score, err)
+	}
+
+	var nullable sql.NullFloat64
+
+	if err = suite.storage.QueryRow(ctx, query, &nullable); err != nil || nullable.Valid {
+		t.Fatalf("query row null: got %+v, %v; want invalid", nullable, err)
+	}
+
+	var table storage.Table
+
+	if err = suite.storage.Query(ctx, query, &table); err != nil || len(table.Rows) != 1 || table.Rows[0][0] != nil {
+		t.Fatalf("table null: got %+v, %v; want nil value", table, err)
+	}
+}
+
+func (suite *conformance) testQueryRow(t *testing.T, ctx context.Context) {
+	var (
+		id   int64
+		name string
+	)
+
+	err := suite.storage.QueryRow(ctx, suite.query(
+		"SELECT id, name FROM %s WHERE name = :name", map[string]any{"name": "beta"},
+	), &id, &name)
+	if err != nil || id != 2 || name != "beta" {
+		t.Fatalf("query row: got %d %q, %v; want 2 beta", id, name, err)
+	}
+
+	err = suite.storage.QueryRow(ctx, suite.query(
+		"SELECT id, name FROM %s WHERE name = :name", map[string]any{"name": "omega"},
+	), &id, &name)
+	if !errors.Is(err, storage.ErrEmptyResult) {
+		t.Fatalf("query row empty: got %v, want ErrEmptyResult", err)
+	}
+
+	count, err := storage.Scalar[int64](ctx, suite.storage, suite.query("SELECT COUNT(*) FROM %s", nil))
+	if err != nil || count != int64(len(fixture())) {
+		t.Fatalf("scalar count: got %d, %v; want %d", count, err, len(fixture()))
+	}
+}
+
+func (suite *conformance) testIterate(t *testing.T, ctx context.Context) {
+	iter, err := storage.IterateAs[conformanceRow](ctx, suite.storage, suite.query("SELECT id, name, score FROM %s ORDER BY id", nil))
+	if err != nil {
+		t.Fatalf("iterate: %s", errors.Formatted(err))
+	}
+
+	var rows []conformanceRow
+
+	for iter.Next(ctx) {
+		rows = append(rows, iter.Value())
+	}
+
+	if err = iter.Err(); err != nil {
+		t.Fatalf("iterate: %s", errors.Formatted(err))
+	}
+
+	if err = iter.Close(); err != nil {
+		t.Fatalf("close iterator: %s", errors.Formatted(err))
+	}
+
+	if !reflect.DeepEqual(rows, fixture()) {
+		t.Fatalf("iterate: got %+v, want %+v", rows, fixture())
+	}
+
+	// досрочное закрытие освобождает соединение: следующий запрос выполняется
+	if iter, err = storage.IterateAs[conformanceRow](ctx, suite.storage, suite.query("SELECT id, name, score FROM %s", nil)); err != nil {
+		t.Fatalf("iterate: %s", errors.Formatted(err))
+	}
+
+	if !iter.Next(ctx) {
+		t.Fatalf("iterate: no rows: %v", iter.Err())
+	}
+
+	if err = iter.Close(); err != nil {
+		t.Fatalf("close iterator early: %s", errors.Formatted(err))
+	}
+
+	if count := suite.count(t, ctx); count != int64(len(fixture())) {
+		t.Fatalf("count after early close: got %d, want %d", count, len(fixture()))
+	}
+}
+
+func (suite *conformance) testIterateEmpty(t *testing.T, ctx context.Context) {
+	iter, err := suite.storage.Iterate(ctx, suite.query("SELECT id, name, score FROM %s WHERE id < :id", map[string]any{"id": 0}))
+	if err != nil {
+		t.Fatalf("iterate empty: %s", errors.Formatted(err))
+	}
+
+	defer iter.Close()
+
+	if iter.Next(ctx) {
+		t.Fatalf("iterate empty: unexpected row")
+	}
+
+	if err = iter.Err(); err != nil {
+		t.Fatalf("iterate empty: %s", errors.Formatted(err))
+	}
+}
+
+func (suite *conformance) testContextCanceled(t *testing.T, ctx context.Context) {
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	_, err := suite.storage.Exec(canceled, suite.query("DELETE FROM %s", nil))
+	if !isCanceled(err) {
+		t.Fatalf("exec canceled: got %v, want cancellation error", err)
+	}
+
+	_, err = storage.Select[conformanceRow](canceled, suite.storage, suite.query("SELECT id, name, score FROM %s", nil))
+	if !isCanceled(err) {
+		t.Fatalf("query canceled: got %v, want cancellation error", err)
+	}
+
+	if _, err = suite.storage.Begin(canceled); !isCanceled(err) {
+		t.Fatalf("begin canceled: got %v, want cancellation error", err)
+	}
+
+	if count := suite.count(t, ctx); count != int64(len(fixture())) {
+		t.Fatalf("canceled exec changed data: got %d rows, want %d", count, len(fixture()))
+	}
+}
+
+func (suite *conformance) testErrorsClassified(t *testing.T, ctx context.Context) {
+	_, err := suite.storage.Exec(ctx, suite.query(
+		"INSERT INTO %s (id, name) VALUES (:id, :name)", map[string]any{"id": 20, "name": "alpha"},
+	))
+	if !errors.Is(err, storage.ErrUniqueViolation) {
+		t.Fatalf("duplicate insert: got %v, want ErrUniqueViolation", err)
+	}
+
+	_, err = suite.storage.Exec(ctx, suite.query(
+		"INSERT INTO %s (id, name) VALUES (:id, NULL)", map[string]any{"id": 21},
+	))
+	if !errors.Is(err, storage.ErrNotNullViolation) {
+		t.Fatalf("null insert: got %v, want ErrNotNullViolation", err)
+	}
+}
+
+func (suite *conformance) testPrepare(t *testing.T, ctx context.Context) {
+	stmt := suite.prepare(t, ctx, "SELECT id, name FROM %s WHERE id >= :id ORDER BY id")
+
+	for _, want := range fixture() {
+		var (
+			id   int64
+			name string
+		)
+
+		if err := stmt.QueryRow(ctx, map[string]any{"id": want.ID}, &id, &name); err != nil || id != want.ID || name != want.Name {
+			t.Fatalf("query row %d: got %d %q, %v", want.ID, id, name, err)
+		}
+	}
+
+	var rows []conformanceRow
+
+	if err := stmt.Query(ctx, conformanceRow{ID: 2}, &rows); err != nil || len(rows) != 2 || rows[0].Name != "beta" {
+		t.Fatalf("query: got %+v, %v; want beta, gamma", rows, err)
+	}
+
+	if err := stmt.Close(); err != nil {
+		t.Fatalf("close: %s", errors.Formatted(err))
+	}
+
+	var id int64
+
+	if err := stmt.QueryRow(ctx, map[string]any{"id": 1}, &id); !errors.Is(err, storage.ErrStatementClosed) {
+		t.Fatalf("query row after close: got %v, want ErrStatementClosed", err)
+	}
+}
+
+func (suite *conformance) testPrepareTx(t *testing.T, ctx context.Context) {
+	stmt := suite.prepare(t, ctx, "INSERT INTO %s (id, name) VALUES (:id, :name)")
+
+	defer func() { _ = stmt.Close() }()
+
+	tx, err := suite.storage.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %s", errors.Formatted(err))
+	}
+
+	if _, err = stmt.Exec(tx.Context(), conformanceRow{ID: 10, Name: "delta"}); err != nil {
+		t.Fatalf("exec in transaction: %s", errors.Formatted(err))
+	}
+
+	if count := suite.count(t, tx.Context()); count != 4 {
+		t.Fatalf("count in transaction: got %d, want 4", count)
+	}
+
+	if err = tx.Rollback(ctx); err != nil {
+		t.Fatalf("rollback: %s", errors.Formatted(err))
+	}
+
+	if count := suite.count(t, ctx); count != 3 {
+		t.Fatalf("count after rollback: got %d, want 3", count)
+	}
+
+	if _, err = stmt.Exec(ctx, map[string]any{"id": 10, "name": "delta"}); err != nil {
+		t.Fatalf("exec: %s", errors.Formatted(err))
+	}
+
+	if count := suite.count(t, ctx); count != 4 {
+		t.Fatalf("count after exec: got %d, want 4", count)
+	}
+}
+
+func (suite *conformance) testTxCommit(t *testing.T, ctx context.Context) {
+	tx, err := suite.storage.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %s", errors.Formatted(err))
+	}
+
+	suite.insertTx(t, tx.Context(), 10, "delta")
+
+	if count := suite.count(t, tx.Context()); count != 4 {
+		t.Fatalf("count in transaction: got %d, want 4", count)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		t.Fatalf("commit: %s", errors.Formatted(err))
+	}
+
+	if count := suite.count(t, ctx); count != 4 {
+		t.Fatalf("count after commit: got %d, want 4", count)
+	}
+}
+
+func (suite *conformance) testTxRollback(t *testing.T, ctx context.Context) {
+	tx, err := suite.storage.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %s", errors.Formatted(err))
+	}
+
+	suite.insertTx(t, tx.Context(), 10, "delta")
+
+	if err = tx.Rollback(ctx); err != nil {
+		t.Fatalf("rollback: %s", errors.Formatted(err))
+	}
+
+	if count := suite.count(t, ctx); count != 3 {
+		t.Fatalf("count after rollback: got %d, want 3", count)
+	}
+}
+
+func (suite *conformance) testTxIsolation(t *testing.T, ctx context.Context) {
+	tx, err := suite.storage.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %s", errors.Formatted(err))
+	}
+
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	suite.insertTx(t, tx.Context(), 10, "delta")
+
+	if count := suite.count(t, ctx); count != 3 {
+		t.Fatalf("uncommitted row is visible outside transaction: got %d rows, want 3", count)
+	}
+}
+
+func (suite *conformance) testTxNested(t *testing.T, ctx context.Context) {
+	tx, err := suite.storage.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %s", errors.Formatted(err))
+	}
+
+	suite.insertTx(t, tx.Context(), 10, "delta")
+
+	nested, err := suite.storage.Begin(tx.Context())
+	if err != nil {
+		t.Fatalf("begin nested: %s", errors.Formatted(err))
+	}
+
+	suite.insertTx(t, nested.Context(), 11, "epsilon")
+
+	if err = nested.Rollback(ctx); err != nil {
+		t.Fatalf("rollback nested: %s", errors.Formatted(err))
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		t.Fatalf("commit: %s", errors.Formatted(err))
+	}
+
+	names, err := storage.Select[string](ctx, suite.storage, suite.query("SELECT name FROM %s WHERE id >= :id ORDER BY id", map[string]any{"id": 10}))
+	if err != nil || !reflect.DeepEqual(names, []string{"delta"}) {
+		t.Fatalf("nested rollback: got %v, %v; want [delta]", names, err)
+	}
+}
+
+func (suite *conformance) testTxHooks(t *testing.T, ctx context.Context) {
+	var events []string
+
+	err := storage.RunInTx(ctx, suite.storage, nil, func(ctx context.Context) error {
+		storage.OnCommit(ctx, func(context.Context) { events = append(events, "outer commit") })
+
+		_ = storage.RunInTx(ctx, suite.storage, nil, func(ctx context.Context) error {
+			storage.OnCommit(ctx, func(context.Context) { events = append(events, "nested commit") })
+			storage.OnRollback(ctx, func(context.Context, error) { events = append(events, "nested rollback") })
+
+			return errors.New("abort nested")
+		})
+
+		storage.OnRollback(ctx, func(context.Context, error) { events = append(events, "outer rollback") })
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("run in transaction: %s", errors.Formatted(err))
+	}
+
+	if want := []string{"nested rollback", "outer commit"}; !reflect.DeepEqual(events, want) {
+		t.Fatalf("hooks: got %v, want %v", events, want)
+	}
+}
+
+func (suite *conformance) testTxDone(t *testing.T, ctx context.Context) {
+	tx, err := suite.storage.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %s", errors.Formatted(err))
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		t.Fatalf("commit: %s", errors.Formatted(err))
+	}
+
+	if err = tx.Commit(ctx); err == nil {
+		t.Fatalf("second commit: want error")
+	}
+
+	if err = tx.Rollback(ctx); err == nil {
+		t.Fatalf("rollback after commit: want error")
+	}
+}
+
+func (suite *conformance) query(format string, arg map[string]any) storage.Query {
+	text := fmt.Sprintf(format, suite.table)
+
+	if arg == nil {
+		return storage.NewQuery(text)
+	}
+
+	return storage.NewBindQuery(text, arg)
+}
+
+func (suite *conformance) prepare(t *testing.T, ctx context.Context, format string) storage.Statement {
+	t.Helper()
+
+	stmt, err := storage.Prepare(ctx, suite.storage, storage.NewBindQuery(fmt.Sprintf(format, suite.table), nil))
+	if err != nil {
+		t.Fatalf("prepare: %s", errors.Formatted(err))
+	}
+
+	return stmt
+}
+
+func (suite *conformance) mustExec(t *testing.T, ctx context.Context, statement string) {
+	t.Helper()
+
+	if _, err := suite.storage.Exec(ctx, storage.NewQuery(statement)); err != nil {
+		t.Fatalf("exec %q: %s", statement, errors.Formatted(err))
+	}
+}
+
+func (suite *conformance) insert(t *testing.T, ctx context.Context, rows ...conformanceRow) {
+	t.Helper()
+
+	for _, row := range rows {
+		_, err := suite.storage.Exec(ctx, suite.query(
+			"INSERT INTO %s (id, name, score) VALUES (:id, :name, :score)",
+			map[string]any{"id": row.ID, "name": row.Name, "score": row.Score},
+		))
+		if err != nil {
+			t.Fatalf("insert fixture: %s", errors.Formatted(err))
+		}
+	}
+}
+
+func (suite *conformance) insertTx(t *testing.T, ctx context.Context, id int64, name string) {
+	t.Helper()
+
+	suite.insert(t, ctx, conformanceRow{ID: id, Name: name})
+}
+
+func (suite *conformance) count(t *testing.T, ctx context.Context) int64 {
+	t.Helper()
+
+	count, err := storage.Scalar[int64](ctx, suite.storage, suite.query("SELECT COUNT(*) FROM %s", nil))
+	if err != nil {
+		t.Fatalf("count rows: %s", errors.Formatted(err))
+	}
+
+	return count
+}
+
+// fixture - строки, с которыми начинается каждая проверка
+func fixture() []conformanceRow {
+	first, third := 1.5, 3.25
+
+	return []conformanceRow{
+		{ID: 1, Name: "alpha", Score: &first},
+		{ID: 2, Name: "beta"},
+		{ID: 3, Name: "gamma", Score: &third},
+	}
+}
+
+func scoreValue(score *float64) any {
+	if score == nil {
+		return nil
+	}
+
+	return *score
+}
+
+// normalize - приводит значения Result и Table к общим типам: целые к int64,
+// вещественные к float64, []byte к string
+func normalize(value any) any {
+	val := reflect.ValueOf(value)
+
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return val.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(val.Uint())
+	case reflect.Float32, reflect.Float64:
+		return val.Float()
+	case reflect.Slice:
+		if raw, ok := value.([]byte); ok {
+			return string(raw)
+		}
+	}
+
+	return value
+}
+
+func isCanceled(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, storage.ErrQueryCanceled)
+}
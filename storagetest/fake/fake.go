@@ -0,0 +1,497 @@
+// Package fake - программируемая in-memory реализация storage.Storage для модульных тестов:
+// ответы на запросы задаются ожиданиями, все вызовы записываются, а невыполненные
+// ожидания сообщаются по завершении теста
+package fake
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sync"
+
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+// Методы Storage, указываемые в записанных вызовах
+const (
+	MethodExec     = "Exec"
+	MethodQuery    = "Query"
+	MethodQueryRow = "QueryRow"
+	MethodIterate  = "Iterate"
+	MethodBegin    = "Begin"
+	MethodCommit   = "Commit"
+	MethodRollback = "Rollback"
+)
+
+const (
+	ErrUnexpectedCall    = errors.Const("unexpected storage call")
+	ErrUnmetExpectations = errors.Const("storage expectations were not met")
+	ErrClosed            = errors.Const("storage is closed")
+)
+
+var (
+	_ storage.Storage     = (*Storage)(nil)
+	_ storage.Transaction = (*transaction)(nil)
+	_ sql.Result          = execResult{}
+)
+
+type (
+	// TestingT - подмножество testing.TB, используемое фейком
+	TestingT interface {
+		Helper()
+		Errorf(format string, args ...any)
+		Cleanup(fn func())
+	}
+
+	// Storage - фейковое хранилище, управляемое ожиданиями
+	Storage struct {
+		mu           sync.Mutex
+		expectations []*Expectation
+		calls        []Call
+		unexpected   []Call
+		txSeq        int
+		closed       bool
+	}
+
+	// Call - записанный вызов метода хранилища
+	Call struct {
+		// Method - имя метода Storage или Transaction
+		Method string
+		// SQL - текст запроса, пустой для методов транзакций
+		SQL string
+		// Params - параметры запроса как их вернул Query.Params
+		Params any
+		// TxID - номер транзакции, в которой выполнен вызов, 0 - вне транзакции
+		TxID int
+		// Err - ошибка, которую вернул вызов
+		Err error
+	}
+
+	// Expectation - ожидаемый вызов и ответ на него
+	Expectation struct {
+		method   string
+		pattern  *regexp.Regexp
+		params   func(params any) bool
+		table    storage.Table
+		result   execResult
+		err      error
+		times    int
+		called   int
+		optional bool
+	}
+
+	// AnyArg - значение, совпадающее с любым аргументом в WithArgs
+	AnyArg struct{}
+
+	txKey struct{}
+
+	transaction struct {
+		storage.TxHooks
+
+		storage *Storage
+		ctx     context.Context
+		id      int
+		parent  *transaction
+		done    bool
+	}
+
+	execResult struct {
+		lastInsertID int64
+		rowsAffected int64
+	}
+)
+
+// New - конструктор фейкового хранилища, при завершении теста сообщает о невыполненных ожиданиях
+// и неожиданных вызовах
+func New(t TestingT) *Storage {
+	s := &Storage{}
+
+	t.Cleanup(func() {
+		t.Helper()
+
+		if err := s.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s", errors.Formatted(err))
+		}
+	})
+
+	return s
+}
+
+// ExpectExec - ожидает вызов Exec (или Query без результата) с запросом, совпадающим с регулярным выражением
+func (s *Storage) ExpectExec(pattern string) *Expectation {
+	return s.expect(MethodExec, pattern)
+}
+
+// ExpectQuery - ожидает вызов Query, QueryRow или Iterate с запросом, совпадающим с регулярным выражением
+func (s *Storage) ExpectQuery(pattern string) *Expectation {
+	return s.expect(MethodQuery, pattern)
+}
+
+// ExpectBegin - ожидает открытие транзакции
+func (s *Storage) ExpectBegin() *Expectation {
+	return s.expect(MethodBegin, "")
+}
+
+// ExpectCommit - ожидает фиксацию транзакции, без ожидания фиксация всегда успешна
+func (s *Storage) ExpectCommit() *Expectation {
+	return s.expect(MethodCommit, "")
+}
+
+// ExpectRollback - ожидает откат транзакции, без ожидания откат всегда успешен
+func (s *Storage) ExpectRollback() *Expectation {
+	return s.expect(MethodRollback, "")
+}
+
+// Calls - возвращает все записанные вызовы в порядке их выполнения
+func (s *Storage) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Call(nil), s.calls...)
+}
+
+// ExpectationsWereMet - проверяет, что все ожидания выполнены и неожиданных вызовов не было
+func (s *Storage) ExpectationsWereMet() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var unmet []string
+
+	for _, exp := range s.expectations {
+		if !exp.optional && exp.called < exp.times {
+			unmet = append(unmet, exp.String())
+		}
+	}
+
+	unexpected := make([]string, 0, len(s.unexpected))
+	for _, call := range s.unexpected {
+		unexpected = append(unexpected, fmt.Sprintf("%s %q %v", call.Method, call.SQL, call.Params))
+	}
+
+	if len(unmet) == 0 && len(unexpected) == 0 {
+		return nil
+	}
+
+	return errors.Ctx().
+		Strings("unmet", unmet).
+		Strings("unexpected", unexpected).
+		Just(ErrUnmetExpectations)
+}
+
+// Close - имплементация io.Closer
+func (s *Storage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+
+	return nil
+}
+
+// Begin - имплементация storage.Storage, в контексте транзакции открывает вложенную транзакцию
+func (s *Storage) Begin(ctx context.Context, _ ...any) (storage.Transaction, error) {
+	parent, _ := ctx.Value(txKey{}).(*transaction)
+
+	exp, err := s.match(ctx, MethodBegin, nil, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if exp.err != nil {
+		return nil, exp.err
+	}
+
+	s.mu.Lock()
+	s.txSeq++
+	tx := &transaction{storage: s, ctx: ctx, id: s.txSeq, parent: parent}
+	s.mu.Unlock()
+
+	return tx, nil
+}
+
+// Exec - имплементация storage.Storage
+func (s *Storage) Exec(ctx context.Context, query storage.Query) (sql.Result, error) {
+	exp, err := s.match(ctx, MethodExec, query, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if exp.err != nil {
+		return nil, exp.err
+	}
+
+	return exp.result, nil
+}
+
+// Query - имплементация storage.Storage, строки ожидания декодируются в result
+// с той же семантикой, что и в драйверах
+func (s *Storage) Query(ctx context.Context, query storage.Query, result any) error {
+	if result == nil {
+		_, err := s.Exec(ctx, query)
+
+		return err
+	}
+
+	exp, err := s.match(ctx, MethodQuery, query, false)
+	if err != nil {
+		return err
+	}
+
+	if exp.err != nil {
+		return exp.err
+	}
+
+	return exp.table.Decode(result)
+}
+
+// QueryRow - имплементация storage.Storage
+func (s *Storage) QueryRow(ctx context.Context, query storage.Query, dest ...any) error {
+	exp, err := s.match(ctx, MethodQueryRow, query, false)
+	if err != nil {
+		return err
+	}
+
+	if exp.err != nil {
+		return exp.err
+	}
+
+	return exp.table.ScanRow(dest...)
+}
+
+// Iterate - имплементация storage.Storage
+func (s *Storage) Iterate(ctx context.Context, query storage.Query) (storage.Iterator, error) {
+	exp, err := s.match(ctx, MethodIterate, query, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if exp.err != nil {
+		return nil, exp.err
+	}
+
+	return storage.NewTableIterator(exp.table), nil
+}
+
+func (s *Storage) expect(method, pattern string) *Expectation {
+	exp := &Expectation{method: method, times: 1}
+
+	if pattern != "" {
+		exp.pattern = regexp.MustCompile(pattern)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expectations = append(s.expectations, exp)
+
+	return exp
+}
+
+// match - находит первое ожидание, которому соответствует вызов, и записывает вызов
+func (s *Storage) match(ctx context.Context, method string, query storage.Query, lenient bool) (*Expectation, error) {
+	call := Call{Method: method}
+
+	if tx, ok := ctx.Value(txKey{}).(*transaction); ok {
+		call.TxID = tx.id
+	}
+
+	if query != nil {
+		call.SQL = query.String()
+		call.Params = query.Params()
+
+		if sqlText, ok := query.Query().(string); ok {
+			call.SQL = sqlText
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		call.Err = ErrClosed
+		s.calls = append(s.calls, call)
+
+		return nil, ErrClosed
+	}
+
+	for _, exp := range s.expectations {
+		if exp.called >= exp.times || !exp.matches(call) {
+			continue
+		}
+
+		exp.called++
+		call.Err = exp.err
+		s.calls = append(s.calls, call)
+
+		return exp, nil
+	}
+
+	if lenient {
+		s.calls = append(s.calls, call)
+
+		return &Expectation{}, nil
+	}
+
+	call.Err = errors.Ctx().
+		Str("method", method).
+		Str("sql", call.SQL).
+		Any("params", call.Params).
+		Just(ErrUnexpectedCall)
+	s.calls = append(s.calls, call)
+	s.unexpected = append(s.unexpected, call)
+
+	return nil, call.Err
+}
+
+// WithArgs - ожидает позиционные параметры запроса, AnyArg{} совпадает с любым значением.
+// Для запросов с именованными параметрами единственный аргумент сравнивается с Query.Params
+func (exp *Expectation) WithArgs(args ...any) *Expectation {
+	exp.params = func(params any) bool {
+		positional, ok := params.([]any)
+		if !ok {
+			return len(args) == 1 && reflect.DeepEqual(params, args[0])
+		}
+
+		if len(positional) != len(args) {
+			return false
+		}
+
+		for i := range args {
+			if _, isAny := args[i].(AnyArg); !isAny && !reflect.DeepEqual(positional[i], args[i]) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return exp
+}
+
+// WithParams - ожидает параметры запроса, удовлетворяющие произвольному условию
+func (exp *Expectation) WithParams(matcher func(params any) bool) *Expectation {
+	exp.params = matcher
+
+	return exp
+}
+
+// WillReturnRows - задает строки результата запроса
+func (exp *Expectation) WillReturnRows(table storage.Table) *Expectation {
+	exp.table = table
+
+	return exp
+}
+
+// WillReturnResult - задает результат Exec
+func (exp *Expectation) WillReturnResult(lastInsertID, rowsAffected int64) *Expectation {
+	exp.result = execResult{lastInsertID: lastInsertID, rowsAffected: rowsAffected}
+
+	return exp
+}
+
+// WillReturnError - задает ошибку, которую вернет вызов
+func (exp *Expectation) WillReturnError(err error) *Expectation {
+	exp.err = err
+
+	return exp
+}
+
+// Times - задает количество вызовов, которым соответствует ожидание
+func (exp *Expectation) Times(n int) *Expectation {
+	exp.times = n
+
+	return exp
+}
+
+// Maybe - помечает ожидание необязательным: невызванное, оно не считается невыполненным
+func (exp *Expectation) Maybe() *Expectation {
+	exp.optional = true
+
+	return exp
+}
+
+func (exp *Expectation) String() string {
+	if exp.pattern != nil {
+		return fmt.Sprintf("%s %q called %d of %d times", exp.method, exp.pattern.String(), exp.called, exp.times)
+	}
+
+	return fmt.Sprintf("%s called %d of %d times", exp.method, exp.called, exp.times)
+}
+
+func (exp *Expectation) matches(call Call) bool {
+	method := call.Method
+	if method == MethodQueryRow || method == MethodIterate {
+		method = MethodQuery
+	}
+
+	if exp.method != method {
+		return false
+	}
+
+	if exp.pattern != nil && !exp.pattern.MatchString(call.SQL) {
+		return false
+	}
+
+	return exp.params == nil || exp.params(call.Params)
+}
+
+func (tx *transaction) Context() context.Context {
+	return storage.ContextWithTransaction(context.WithValue(tx.ctx, txKey{}, tx), tx)
+}
+
+func (tx *transaction) Commit(ctx context.Context) error {
+	exp, err := tx.finish(ctx, MethodCommit)
+	if err != nil {
+		return err
+	}
+
+	if exp.err != nil {
+		tx.RunRollbackHooks(ctx, exp.err)
+
+		return exp.err
+	}
+
+	if tx.parent != nil {
+		tx.MoveTo(&tx.parent.TxHooks)
+	} else {
+		tx.RunCommitHooks(ctx)
+	}
+
+	return nil
+}
+
+func (tx *transaction) Rollback(ctx context.Context) error {
+	exp, err := tx.finish(ctx, MethodRollback)
+	if err != nil {
+		return err
+	}
+
+	tx.RunRollbackHooks(ctx, exp.err)
+
+	return exp.err
+}
+
+func (tx *transaction) finish(ctx context.Context, method string) (*Expectation, error) {
+	tx.storage.mu.Lock()
+	done := tx.done
+	tx.done = true
+	tx.storage.mu.Unlock()
+
+	if done {
+		return nil, errors.Ctx().Int("tx", tx.id).Just(sql.ErrTxDone)
+	}
+
+	return tx.storage.match(tx.Context(), method, nil, true)
+}
+
+func (res execResult) LastInsertId() (int64, error) {
+	return res.lastInsertID, nil
+}
+
+func (res execResult) RowsAffected() (int64, error) {
+	return res.rowsAffected, nil
+}
@@ -0,0 +1,239 @@
+package fake_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+	"gopkg.in/gomisc/storage.v1/storagetest/fake"
+)
+
+var selectQuery = storage.NewQuery("SELECT value FROM settings")
+
+// recorder - TestingT, собирающий ошибки вместо завершения теста
+type recorder struct {
+	errors   []string
+	cleanups []func()
+}
+
+func TestQueryRowConversion(t *testing.T) {
+	var (
+		i8    int8
+		i64   int64
+		u16   uint16
+		f32   float32
+		str   string
+		bytes []byte
+		ptr   *int64
+		null  sql.NullString
+	)
+
+	for _, test := range []struct {
+		name  string
+		value any
+		dest  any
+		want  any
+		err   error
+	}{
+		{name: "int64 to int8", value: int64(100), dest: &i8, want: int8(100)},
+		{name: "int64 overflows int8", value: int64(300), dest: &i8, err: storage.ErrValueOutOfRange},
+		{name: "negative to uint", value: int64(-1), dest: &u16, err: storage.ErrValueOutOfRange},
+		{name: "uint64 overflows int64", value: uint64(1 << 63), dest: &i64, err: storage.ErrValueOutOfRange},
+		{name: "whole float to int", value: 2.0, dest: &i64, want: int64(2)},
+		{name: "float truncated to int", value: 1.5, dest: &i64, err: storage.ErrValueOutOfRange},
+		{name: "float overflows int64", value: 1e19, dest: &i64, err: storage.ErrValueOutOfRange},
+		{name: "float64 overflows float32", value: 1e300, dest: &f32, err: storage.ErrValueOutOfRange},
+		{name: "int to float32", value: int64(3), dest: &f32, want: float32(3)},
+		{name: "bytes to string", value: []byte("alpha"), dest: &str, want: "alpha"},
+		{name: "string to bytes", value: "beta", dest: &bytes, want: []byte("beta")},
+		{name: "value to pointer", value: int64(7), dest: &ptr, want: func() *int64 { v := int64(7); return &v }()},
+		{name: "null to pointer", value: nil, dest: &ptr, want: (*int64)(nil)},
+		{name: "scanner", value: "gamma", dest: &null, want: sql.NullString{String: "gamma", Valid: true}},
+		{name: "nil destination", value: int64(1), dest: nil, err: storage.ErrUnsupportedScan},
+		{name: "non pointer destination", value: int64(1), dest: i64, err: storage.ErrUnsupportedScan},
+		{name: "string to int", value: "1", dest: &i64, err: storage.ErrUnsupportedScan},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := fake.New(t)
+			s.ExpectQuery(`^SELECT`).WillReturnRows(storage.Table{Headers: []string{"value"}, Rows: [][]any{{test.value}}})
+
+			err := s.QueryRow(context.Background(), selectQuery, test.dest)
+
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("query row: got %v, want %v", err, test.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("query row: %s", errors.Formatted(err))
+			}
+
+			if got := reflect.ValueOf(test.dest).Elem().Interface(); !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("query row: got %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestIterateDecodeOutsideRows(t *testing.T) {
+	s := fake.New(t)
+	ctx := context.Background()
+
+	s.ExpectQuery(`^SELECT`).WillReturnRows(storage.Table{Headers: []string{"value"}, Rows: [][]any{{"alpha"}}})
+
+	iter, err := s.Iterate(ctx, selectQuery)
+	if err != nil {
+		t.Fatalf("iterate: %s", errors.Formatted(err))
+	}
+
+	defer iter.Close()
+
+	var value string
+
+	if err = iter.Decode(&value); !errors.Is(err, storage.ErrEmptyResult) {
+		t.Fatalf("decode before next: got %v, want %v", err, storage.ErrEmptyResult)
+	}
+
+	for iter.Next(ctx) {
+		if err = iter.Decode(&value); err != nil || value != "alpha" {
+			t.Fatalf("decode: got %q, %v", value, err)
+		}
+	}
+
+	if err = iter.Decode(&value); !errors.Is(err, storage.ErrEmptyResult) {
+		t.Fatalf("decode after rows: got %v, want %v", err, storage.ErrEmptyResult)
+	}
+}
+
+func TestExpectations(t *testing.T) {
+	rec := &recorder{}
+	s := fake.New(rec)
+	ctx := context.Background()
+
+	s.ExpectExec(`^UPDATE`).WithArgs(fake.AnyArg{}, "alpha").WillReturnResult(0, 2).Times(2)
+	s.ExpectQuery(`^SELECT`).WillReturnError(sql.ErrConnDone)
+	s.ExpectExec(`^DELETE`).Maybe()
+	s.ExpectExec(`^INSERT`)
+
+	for i := 0; i < 2; i++ {
+		res, err := s.Exec(ctx, storage.NewQuery("UPDATE users SET name = $2 WHERE id = $1", i, "alpha"))
+		if err != nil {
+			t.Fatalf("exec: %s", errors.Formatted(err))
+		}
+
+		if affected, _ := res.RowsAffected(); affected != 2 {
+			t.Fatalf("rows affected: got %d, want 2", affected)
+		}
+	}
+
+	if _, err := s.Exec(ctx, storage.NewQuery("UPDATE users SET name = $2 WHERE id = $1", 3, "beta")); !errors.Is(err, fake.ErrUnexpectedCall) {
+		t.Fatalf("exec with other args: got %v, want %v", err, fake.ErrUnexpectedCall)
+	}
+
+	var value string
+
+	if err := s.QueryRow(ctx, selectQuery, &value); !errors.Is(err, sql.ErrConnDone) {
+		t.Fatalf("query row: got %v, want %v", err, sql.ErrConnDone)
+	}
+
+	if err := s.ExpectationsWereMet(); !errors.Is(err, fake.ErrUnmetExpectations) {
+		t.Fatalf("expectations: got %v, want %v", err, fake.ErrUnmetExpectations)
+	}
+
+	methods := make([]string, 0, 4)
+	for _, call := range s.Calls() {
+		methods = append(methods, call.Method)
+	}
+
+	if want := []string{fake.MethodExec, fake.MethodExec, fake.MethodExec, fake.MethodQueryRow}; !reflect.DeepEqual(methods, want) {
+		t.Fatalf("calls: got %v, want %v", methods, want)
+	}
+
+	rec.cleanup()
+
+	if len(rec.errors) != 1 {
+		t.Fatalf("cleanup: got errors %q, want one report of unmet INSERT and unexpected UPDATE", rec.errors)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	s := fake.New(t)
+	ctx := context.Background()
+
+	s.ExpectBegin().Times(2)
+	s.ExpectExec(`^INSERT`)
+	s.ExpectCommit().Times(2)
+
+	var events []string
+
+	tx, err := s.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %s", errors.Formatted(err))
+	}
+
+	nested, err := s.Begin(tx.Context())
+	if err != nil {
+		t.Fatalf("begin nested: %s", errors.Formatted(err))
+	}
+
+	storage.OnCommit(nested.Context(), func(context.Context) { events = append(events, "nested commit") })
+
+	if _, err = s.Exec(nested.Context(), storage.NewQuery("INSERT INTO users (name) VALUES ('alpha')")); err != nil {
+		t.Fatalf("exec: %s", errors.Formatted(err))
+	}
+
+	if err = nested.Commit(ctx); err != nil {
+		t.Fatalf("commit nested: %s", errors.Formatted(err))
+	}
+
+	// хуки вложенной транзакции выполняются при фиксации внешней
+	if len(events) != 0 {
+		t.Fatalf("hooks after nested commit: got %v, want none", events)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		t.Fatalf("commit: %s", errors.Formatted(err))
+	}
+
+	if !reflect.DeepEqual(events, []string{"nested commit"}) {
+		t.Fatalf("hooks after commit: got %v", events)
+	}
+
+	if err = tx.Rollback(ctx); !errors.Is(err, sql.ErrTxDone) {
+		t.Fatalf("rollback after commit: got %v, want %v", err, sql.ErrTxDone)
+	}
+
+	var txIDs []int
+	for _, call := range s.Calls() {
+		txIDs = append(txIDs, call.TxID)
+	}
+
+	// Begin записывается в контексте внешней транзакции, Commit - в контексте своей
+	if want := []int{0, 1, 2, 2, 1}; !reflect.DeepEqual(txIDs, want) {
+		t.Fatalf("transaction ids: got %v, want %v", txIDs, want)
+	}
+}
+
+func (rec *recorder) Helper() {}
+
+func (rec *recorder) Errorf(format string, args ...any) {
+	rec.errors = append(rec.errors, fmt.Sprintf(format, args...))
+}
+
+func (rec *recorder) Cleanup(fn func()) {
+	rec.cleanups = append(rec.cleanups, fn)
+}
+
+func (rec *recorder) cleanup() {
+	for _, fn := range rec.cleanups {
+		fn()
+	}
+}
@@ -0,0 +1,426 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+const (
+	cassetteVersion = 1
+
+	valueNull = "null"
+	valueJSON = "json"
+)
+
+var (
+	// valueTypes - типы значений, которые сохраняются в кассете с точным восстановлением типа
+	valueTypes = map[string]reflect.Type{
+		"bool":    reflect.TypeOf(false),
+		"string":  reflect.TypeOf(""),
+		"bytes":   reflect.TypeOf([]byte(nil)),
+		"time":    reflect.TypeOf(time.Time{}),
+		"int":     reflect.TypeOf(0),
+		"int8":    reflect.TypeOf(int8(0)),
+		"int16":   reflect.TypeOf(int16(0)),
+		"int32":   reflect.TypeOf(int32(0)),
+		"int64":   reflect.TypeOf(int64(0)),
+		"uint":    reflect.TypeOf(uint(0)),
+		"uint8":   reflect.TypeOf(uint8(0)),
+		"uint16":  reflect.TypeOf(uint16(0)),
+		"uint32":  reflect.TypeOf(uint32(0)),
+		"uint64":  reflect.TypeOf(uint64(0)),
+		"float32": reflect.TypeOf(float32(0)),
+		"float64": reflect.TypeOf(float64(0)),
+	}
+
+	// knownErrors - ошибки, принадлежность к которым сохраняется при воспроизведении
+	knownErrors = []error{
+		storage.ErrEmptyResult,
+		storage.ErrTxRolledBack,
+		storage.ErrUniqueViolation,
+		storage.ErrForeignKeyViolation,
+		storage.ErrNotNullViolation,
+		storage.ErrCheckViolation,
+		storage.ErrSerializationFailure,
+		storage.ErrDeadlock,
+		storage.ErrLockTimeout,
+		storage.ErrQueryCanceled,
+		storage.ErrConnectionLost,
+		storage.ErrReadOnlyViolation,
+		sql.ErrNoRows,
+		sql.ErrTxDone,
+		context.Canceled,
+		context.DeadlineExceeded,
+	}
+)
+
+type (
+	// cassette - файл с записанным взаимодействием с хранилищем
+	cassette struct {
+		Version      int            `json:"version"`
+		Interactions []*interaction `json:"interactions"`
+	}
+
+	// interaction - один записанный вызов хранилища или транзакции
+	interaction struct {
+		Method string          `json:"method"`
+		SQL    string          `json:"sql,omitempty"`
+		Params json.RawMessage `json:"params,omitempty"`
+		Tx     int             `json:"tx,omitempty"`
+		Parent int             `json:"parent,omitempty"`
+		Table  *tableRecord    `json:"table,omitempty"`
+		Result *resultRecord   `json:"result,omitempty"`
+		Error  *errorRecord    `json:"error,omitempty"`
+	}
+
+	tableRecord struct {
+		Headers []string  `json:"headers"`
+		Rows    [][]value `json:"rows"`
+	}
+
+	resultRecord struct {
+		LastInsertID      int64  `json:"last_insert_id"`
+		RowsAffected      int64  `json:"rows_affected"`
+		LastInsertIDError string `json:"last_insert_id_error,omitempty"`
+		RowsAffectedError string `json:"rows_affected_error,omitempty"`
+	}
+
+	errorRecord struct {
+		Message    string `json:"message"`
+		Kind       string `json:"kind,omitempty"`
+		Class      string `json:"class,omitempty"`
+		Code       string `json:"code,omitempty"`
+		Constraint string `json:"constraint,omitempty"`
+		Table      string `json:"table,omitempty"`
+		Column     string `json:"column,omitempty"`
+	}
+
+	// value - значение с именем типа, восстанавливаемое при воспроизведении
+	value struct {
+		Type  string          `json:"t"`
+		Value json.RawMessage `json:"v,omitempty"`
+	}
+
+	// replayedError - ошибка, восстановленная из кассеты: сообщение исходной ошибки
+	// и принадлежность к известной ошибке для errors.Is
+	replayedError struct {
+		message string
+		kind    error
+	}
+
+	replayedResult struct {
+		rec *resultRecord
+	}
+)
+
+func loadCassette(path string) (*cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Ctx().Str("path", path).Wrap(err, "read cassette")
+	}
+
+	var cas cassette
+
+	if err = json.Unmarshal(data, &cas); err != nil {
+		return nil, errors.Ctx().Str("path", path).Wrap(err, "decode cassette")
+	}
+
+	if cas.Version != cassetteVersion {
+		return nil, errors.Ctx().
+			Str("path", path).
+			Int("version", cas.Version).
+			Just(ErrCassetteVersion)
+	}
+
+	return &cas, nil
+}
+
+func (cas *cassette) save(path string) error {
+	data, err := json.MarshalIndent(cas, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encode cassette")
+	}
+
+	if err = os.WriteFile(path, data, 0o644); err != nil { // nolint: gosec
+		return errors.Ctx().Str("path", path).Wrap(err, "write cassette")
+	}
+
+	return nil
+}
+
+// key - ключ сопоставления вызова при воспроизведении: метод, транзакция, текст запроса
+// и параметры. Begin сопоставляется по внешней транзакции, остальные вызовы - по порядковому
+// номеру транзакции, в которой они выполнены
+func (in *interaction) key() string {
+	params := in.Params
+
+	if len(params) != 0 {
+		var buf bytes.Buffer
+
+		if err := json.Compact(&buf, params); err == nil {
+			params = buf.Bytes()
+		}
+	}
+
+	tx := in.Tx
+	if in.Method == methodBegin {
+		tx = in.Parent
+	}
+
+	return in.Method + "\x00" + strconv.Itoa(tx) + "\x00" + in.SQL + "\x00" + string(params)
+}
+
+func encodeParams(params any) (json.RawMessage, error) {
+	if params == nil {
+		return nil, nil
+	}
+
+	if positional, ok := params.([]any); ok {
+		values := make([]value, 0, len(positional))
+
+		for _, param := range positional {
+			val, err := encodeValue(param)
+			if err != nil {
+				return nil, err
+			}
+
+			values = append(values, val)
+		}
+
+		return json.Marshal(values)
+	}
+
+	return json.Marshal(params)
+}
+
+func encodeValue(in any) (value, error) {
+	if in == nil {
+		return value{Type: valueNull}, nil
+	}
+
+	typ := reflect.TypeOf(in)
+
+	for name, known := range valueTypes {
+		if typ != known {
+			continue
+		}
+
+		data, err := json.Marshal(in)
+		if err != nil {
+			return value{}, errors.Ctx().Str("type", name).Wrap(err, "encode value")
+		}
+
+		return value{Type: name, Value: data}, nil
+	}
+
+	if valuer, ok := in.(driver.Valuer); ok {
+		val, err := valuer.Value()
+		if err != nil {
+			return value{}, errors.Ctx().Str("type", typ.String()).Wrap(err, "get driver value")
+		}
+
+		return encodeValue(val)
+	}
+
+	data, err := json.Marshal(in)
+	if err != nil {
+		return value{}, errors.Ctx().Str("type", typ.String()).Wrap(err, "encode value")
+	}
+
+	return value{Type: valueJSON, Value: data}, nil
+}
+
+func (val value) decode() (any, error) {
+	if val.Type == valueNull {
+		return nil, nil
+	}
+
+	if val.Type == valueJSON {
+		var out any
+
+		if err := json.Unmarshal(val.Value, &out); err != nil {
+			return nil, errors.Wrap(err, "decode json value")
+		}
+
+		return out, nil
+	}
+
+	typ, ok := valueTypes[val.Type]
+	if !ok {
+		return nil, errors.Ctx().Str("type", val.Type).Just(ErrUnsupportedValue)
+	}
+
+	out := reflect.New(typ)
+
+	if err := json.Unmarshal(val.Value, out.Interface()); err != nil {
+		return nil, errors.Ctx().Str("type", val.Type).Wrap(err, "decode value")
+	}
+
+	return out.Elem().Interface(), nil
+}
+
+func encodeTable(table storage.Table) (*tableRecord, error) {
+	rec := &tableRecord{Headers: table.Headers, Rows: make([][]value, 0, len(table.Rows))}
+
+	for _, row := range table.Rows {
+		values := make([]value, 0, len(row))
+
+		for i, cell := range row {
+			val, err := encodeValue(cell)
+			if err != nil {
+				return nil, errors.Ctx().Int("column", i).Wrap(err, "encode table cell")
+			}
+
+			values = append(values, val)
+		}
+
+		rec.Rows = append(rec.Rows, values)
+	}
+
+	return rec, nil
+}
+
+func (rec *tableRecord) decode() (storage.Table, error) {
+	if rec == nil {
+		return storage.Table{}, nil
+	}
+
+	table := storage.Table{Headers: rec.Headers, Rows: make([][]any, 0, len(rec.Rows))}
+
+	for _, values := range rec.Rows {
+		row := make([]any, 0, len(values))
+
+		for i, val := range values {
+			cell, err := val.decode()
+			if err != nil {
+				return storage.Table{}, errors.Ctx().Int("column", i).Wrap(err, "decode table cell")
+			}
+
+			row = append(row, cell)
+		}
+
+		table.Rows = append(table.Rows, row)
+	}
+
+	return table, nil
+}
+
+func encodeResult(res sql.Result) *resultRecord {
+	if res == nil {
+		return nil
+	}
+
+	rec := &resultRecord{}
+
+	var err error
+
+	if rec.LastInsertID, err = res.LastInsertId(); err != nil {
+		rec.LastInsertIDError = err.Error()
+	}
+
+	if rec.RowsAffected, err = res.RowsAffected(); err != nil {
+		rec.RowsAffectedError = err.Error()
+	}
+
+	return rec
+}
+
+func encodeError(err error) *errorRecord {
+	if err == nil {
+		return nil
+	}
+
+	rec := &errorRecord{Message: err.Error()}
+
+	if drvErr, ok := storage.AsDriverError(err); ok {
+		rec.Code = drvErr.Code
+		rec.Constraint = drvErr.Constraint
+		rec.Table = drvErr.Table
+		rec.Column = drvErr.Column
+
+		if drvErr.Class != nil {
+			rec.Class = drvErr.Class.Error()
+		}
+	}
+
+	for _, known := range knownErrors {
+		if errors.Is(err, known) {
+			rec.Kind = known.Error()
+
+			break
+		}
+	}
+
+	return rec
+}
+
+func (rec *errorRecord) decode() error {
+	if rec == nil {
+		return nil
+	}
+
+	err := &replayedError{message: rec.Message, kind: knownError(rec.Kind)}
+
+	if rec.Class == "" && rec.Code == "" {
+		return err
+	}
+
+	return &storage.DriverError{
+		Class:      knownError(rec.Class),
+		Code:       rec.Code,
+		Constraint: rec.Constraint,
+		Table:      rec.Table,
+		Column:     rec.Column,
+		Err:        err,
+	}
+}
+
+func knownError(text string) error {
+	if text == "" {
+		return nil
+	}
+
+	for _, known := range knownErrors {
+		if known.Error() == text {
+			return known
+		}
+	}
+
+	return nil
+}
+
+func (e *replayedError) Error() string {
+	return e.message
+}
+
+// Is - сопоставляет ошибку с известной ошибкой, к которой относилась исходная
+func (e *replayedError) Is(target error) bool {
+	return e.kind != nil && target == e.kind
+}
+
+func (res replayedResult) LastInsertId() (int64, error) {
+	if res.rec.LastInsertIDError != "" {
+		return 0, &replayedError{message: res.rec.LastInsertIDError}
+	}
+
+	return res.rec.LastInsertID, nil
+}
+
+func (res replayedResult) RowsAffected() (int64, error) {
+	if res.rec.RowsAffectedError != "" {
+		return 0, &replayedError{message: res.rec.RowsAffectedError}
+	}
+
+	return res.rec.RowsAffected, nil
+}
@@ -0,0 +1,437 @@
+// Package replay - обертка над storage.Storage, записывающая взаимодействие с базой данных
+// в файл кассеты и воспроизводящая его без базы данных. Кассета записывается один раз
+// на реальном сервере, после чего тесты выполняются быстро и детерминированно.
+// Результаты запросов и в режиме записи декодируются из storage.Table, см. Storage.Query
+package replay
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+// Режимы работы обертки
+const (
+	ModeRecord Mode = iota + 1
+	ModeReplay
+)
+
+// Методы, указываемые в записях кассеты
+const (
+	methodExec     = "Exec"
+	methodQuery    = "Query"
+	methodQueryRow = "QueryRow"
+	methodIterate  = "Iterate"
+	methodBegin    = "Begin"
+	methodCommit   = "Commit"
+	methodRollback = "Rollback"
+)
+
+const (
+	ErrNotRecorded      = errors.Const("call was not recorded in cassette")
+	ErrCassetteVersion  = errors.Const("unsupported cassette version")
+	ErrUnsupportedValue = errors.Const("unsupported cassette value type")
+)
+
+var (
+	_ storage.Storage     = (*Storage)(nil)
+	_ storage.Transaction = (*recordTx)(nil)
+	_ storage.Transaction = (*replayTx)(nil)
+	_ sql.Result          = replayedResult{}
+)
+
+type (
+	// Mode - режим работы обертки
+	Mode int
+
+	// Storage - хранилище, записывающее или воспроизводящее взаимодействие с базой данных
+	Storage struct {
+		mode     Mode
+		inner    storage.Storage
+		path     string
+		mu       sync.Mutex
+		cassette *cassette
+		pending  map[string][]*interaction
+		txSeq    int
+	}
+
+	txKey struct{}
+
+	recordTx struct {
+		storage *Storage
+		tx      storage.Transaction
+		id      int
+	}
+
+	replayTx struct {
+		storage.TxHooks
+
+		storage *Storage
+		ctx     context.Context
+		id      int
+		parent  *replayTx
+	}
+)
+
+// Record - оборачивает хранилище в режиме записи: все вызовы выполняются на s,
+// а их результаты сохраняются в кассету path при Save или Close
+func Record(s storage.Storage, path string) *Storage {
+	return &Storage{
+		mode:     ModeRecord,
+		inner:    s,
+		path:     path,
+		cassette: &cassette{Version: cassetteVersion},
+	}
+}
+
+// Replay - загружает кассету path и возвращает хранилище, отвечающее записанными результатами.
+// Вызов, которого нет в кассете, завершается ошибкой ErrNotRecorded. Вызовы в транзакциях
+// сопоставляются с записанными по порядковому номеру транзакции, поэтому транзакции должны
+// открываться в том же порядке, что и при записи
+func Replay(path string) (*Storage, error) {
+	cas, err := loadCassette(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Storage{
+		mode:     ModeReplay,
+		path:     path,
+		cassette: cas,
+		pending:  make(map[string][]*interaction),
+	}
+
+	for _, in := range cas.Interactions {
+		key := in.key()
+		s.pending[key] = append(s.pending[key], in)
+	}
+
+	return s, nil
+}
+
+// Mode - возвращает режим работы обертки
+func (s *Storage) Mode() Mode {
+	return s.mode
+}
+
+// Save - сохраняет записанные вызовы в кассету, в режиме воспроизведения ничего не делает
+func (s *Storage) Save() error {
+	if s.mode != ModeRecord {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.cassette.save(s.path)
+}
+
+// Remaining - возвращает количество записанных вызовов, которые еще не были воспроизведены
+func (s *Storage) Remaining() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var count int
+
+	for _, queue := range s.pending {
+		count += len(queue)
+	}
+
+	return count
+}
+
+// Close - имплементация io.Closer, в режиме записи сохраняет кассету и закрывает хранилище
+func (s *Storage) Close() error {
+	if s.mode != ModeRecord {
+		return nil
+	}
+
+	if err := s.Save(); err != nil {
+		return err
+	}
+
+	return s.inner.Close()
+}
+
+// Begin - имплементация storage.Storage
+func (s *Storage) Begin(ctx context.Context, opts ...any) (storage.Transaction, error) {
+	parent := txID(ctx)
+
+	if s.mode == ModeReplay {
+		in, err := s.replay(&interaction{Method: methodBegin, Parent: parent})
+		if err != nil {
+			return nil, err
+		}
+
+		if err = in.Error.decode(); err != nil {
+			return nil, err
+		}
+
+		// вызовы транзакции сопоставляются по номеру, присвоенному ей при записи
+		tx := &replayTx{storage: s, ctx: ctx, id: in.Tx}
+		tx.parent, _ = ctx.Value(txKey{}).(*replayTx)
+
+		return tx, nil
+	}
+
+	tx, err := s.inner.Begin(ctx, opts...)
+	in := &interaction{Method: methodBegin, Parent: parent, Error: encodeError(err)}
+
+	if err == nil {
+		in.Tx = s.nextTxID()
+	}
+
+	s.record(in)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &recordTx{storage: s, tx: tx, id: in.Tx}, nil
+}
+
+// Exec - имплементация storage.Storage
+func (s *Storage) Exec(ctx context.Context, query storage.Query) (sql.Result, error) {
+	call, err := newInteraction(ctx, methodExec, query)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.mode == ModeReplay {
+		in, err := s.replay(call)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = in.Error.decode(); err != nil {
+			return nil, err
+		}
+
+		if in.Result == nil {
+			in.Result = &resultRecord{}
+		}
+
+		return replayedResult{rec: in.Result}, nil
+	}
+
+	res, err := s.inner.Exec(ctx, query)
+	call.Result = encodeResult(res)
+	call.Error = encodeError(err)
+	s.record(call)
+
+	return res, err
+}
+
+// Query - имплементация storage.Storage. В обоих режимах результат запрашивается у хранилища
+// таблицей (storage.Table) и декодируется в result методом Table.Decode, а не сканером драйвера,
+// чтобы записанный и воспроизведенный результаты совпадали. Поэтому результат может отличаться
+// от прямого вызова драйвера для типов, которые драйвер сканирует сам: sql.Scanner и типы
+// драйвера получают значения колонок таблицы (числа, строки, []byte, time.Time),
+// а не исходные значения протокола
+func (s *Storage) Query(ctx context.Context, query storage.Query, result any) error {
+	if result == nil {
+		_, err := s.rows(ctx, methodQuery, query, false)
+
+		return err
+	}
+
+	table, err := s.rows(ctx, methodQuery, query, true)
+	if err != nil {
+		return err
+	}
+
+	return table.Decode(result)
+}
+
+// QueryRow - имплементация storage.Storage
+func (s *Storage) QueryRow(ctx context.Context, query storage.Query, dest ...any) error {
+	table, err := s.rows(ctx, methodQueryRow, query, true)
+	if err != nil {
+		return err
+	}
+
+	return table.ScanRow(dest...)
+}
+
+// Iterate - имплементация storage.Storage, записанный результат итерируется из памяти
+func (s *Storage) Iterate(ctx context.Context, query storage.Query) (storage.Iterator, error) {
+	table, err := s.rows(ctx, methodIterate, query, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.NewTableIterator(table), nil
+}
+
+// rows - выполняет или воспроизводит запрос, возвращающий строки
+func (s *Storage) rows(ctx context.Context, method string, query storage.Query, fetch bool) (storage.Table, error) {
+	call, err := newInteraction(ctx, method, query)
+	if err != nil {
+		return storage.Table{}, err
+	}
+
+	if s.mode == ModeReplay {
+		in, err := s.replay(call)
+		if err != nil {
+			return storage.Table{}, err
+		}
+
+		if err = in.Error.decode(); err != nil {
+			return storage.Table{}, err
+		}
+
+		return in.Table.decode()
+	}
+
+	var table storage.Table
+
+	if fetch {
+		err = s.inner.Query(ctx, query, &table)
+	} else {
+		err = s.inner.Query(ctx, query, nil)
+	}
+
+	if err == nil && fetch {
+		if call.Table, err = encodeTable(table); err != nil {
+			return storage.Table{}, errors.Ctx().Str("query", query.String()).Wrap(err, "record query rows")
+		}
+	}
+
+	call.Error = encodeError(err)
+	s.record(call)
+
+	return table, err
+}
+
+func (s *Storage) record(in *interaction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cassette.Interactions = append(s.cassette.Interactions, in)
+}
+
+// replay - извлекает очередной записанный вызов с тем же методом, транзакцией, запросом и параметрами
+func (s *Storage) replay(call *interaction) (*interaction, error) {
+	key := call.key()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queue := s.pending[key]
+	if len(queue) == 0 {
+		return nil, errors.Ctx().
+			Str("method", call.Method).
+			Int("tx", call.Tx).
+			Int("parent-tx", call.Parent).
+			Str("sql", call.SQL).
+			Str("params", string(call.Params)).
+			Str("cassette", s.path).
+			Just(ErrNotRecorded)
+	}
+
+	s.pending[key] = queue[1:]
+
+	return queue[0], nil
+}
+
+func (s *Storage) nextTxID() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.txSeq++
+
+	return s.txSeq
+}
+
+func newInteraction(ctx context.Context, method string, query storage.Query) (*interaction, error) {
+	params, err := encodeParams(query.Params())
+	if err != nil {
+		return nil, errors.Ctx().Str("query", query.String()).Wrap(err, "encode query params")
+	}
+
+	in := &interaction{Method: method, SQL: query.String(), Params: params, Tx: txID(ctx)}
+
+	if sqlText, ok := query.Query().(string); ok {
+		in.SQL = sqlText
+	}
+
+	return in, nil
+}
+
+func txID(ctx context.Context) int {
+	switch tx := ctx.Value(txKey{}).(type) {
+	case *recordTx:
+		return tx.id
+	case *replayTx:
+		return tx.id
+	default:
+		return 0
+	}
+}
+
+func (tx *recordTx) Context() context.Context {
+	return storage.ContextWithTransaction(context.WithValue(tx.tx.Context(), txKey{}, tx), tx)
+}
+
+func (tx *recordTx) Commit(ctx context.Context) error {
+	err := tx.tx.Commit(ctx)
+	tx.storage.record(&interaction{Method: methodCommit, Tx: tx.id, Error: encodeError(err)})
+
+	return err
+}
+
+func (tx *recordTx) Rollback(ctx context.Context) error {
+	err := tx.tx.Rollback(ctx)
+	tx.storage.record(&interaction{Method: methodRollback, Tx: tx.id, Error: encodeError(err)})
+
+	return err
+}
+
+func (tx *recordTx) OnCommit(fn func(ctx context.Context)) {
+	tx.tx.OnCommit(fn)
+}
+
+func (tx *recordTx) OnRollback(fn func(ctx context.Context, err error)) {
+	tx.tx.OnRollback(fn)
+}
+
+func (tx *replayTx) Context() context.Context {
+	return storage.ContextWithTransaction(context.WithValue(tx.ctx, txKey{}, tx), tx)
+}
+
+func (tx *replayTx) Commit(ctx context.Context) error {
+	in, err := tx.storage.replay(&interaction{Method: methodCommit, Tx: tx.id})
+	if err != nil {
+		return err
+	}
+
+	if err = in.Error.decode(); err != nil {
+		tx.RunRollbackHooks(ctx, err)
+
+		return err
+	}
+
+	if tx.parent != nil {
+		tx.MoveTo(&tx.parent.TxHooks)
+	} else {
+		tx.RunCommitHooks(ctx)
+	}
+
+	return nil
+}
+
+func (tx *replayTx) Rollback(ctx context.Context) error {
+	in, err := tx.storage.replay(&interaction{Method: methodRollback, Tx: tx.id})
+	if err != nil {
+		return err
+	}
+
+	err = in.Error.decode()
+	tx.RunRollbackHooks(ctx, err)
+
+	return err
+}
@@ -0,0 +1,341 @@
+package replay_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+	"gopkg.in/gomisc/storage.v1/storagetest/fake"
+	"gopkg.in/gomisc/storage.v1/storagetest/replay"
+)
+
+type user struct {
+	ID      int64     `db:"id"`
+	Name    string    `db:"name"`
+	Avatar  []byte    `db:"avatar"`
+	Created time.Time `db:"created"`
+	Score   *float64  `db:"score"`
+}
+
+var (
+	created    = time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	usersTable = storage.Table{
+		Headers: []string{"id", "name", "avatar", "created", "score"},
+		Rows: [][]any{
+			{int64(1), "alpha", []byte{0x01, 0x02}, created, 1.5},
+			{int64(2), "beta", nil, created.Add(time.Hour), nil},
+		},
+	}
+	errDuplicate = &storage.DriverError{
+		Class:      storage.ErrUniqueViolation,
+		Code:       "23505",
+		Constraint: "users_name_key",
+		Err:        errors.Const("duplicate key value violates unique constraint"),
+	}
+)
+
+// expectSession - ожидания фейка для session
+func expectSession(s *fake.Storage) {
+	s.ExpectExec(`^UPDATE`).WithArgs("alpha", int64(1)).WillReturnResult(0, 1)
+	s.ExpectQuery(`^SELECT id, name, avatar, created, score FROM users`).WillReturnRows(usersTable).Times(3)
+	s.ExpectQuery(`^SELECT name FROM users WHERE id = :id`).WithArgs(map[string]any{"id": 7}).
+		WillReturnRows(storage.Table{Headers: []string{"name"}})
+	s.ExpectExec(`^INSERT`).WillReturnError(errDuplicate)
+	s.ExpectBegin()
+	s.ExpectExec(`^INSERT INTO audit`).WillReturnResult(10, 1).Times(2)
+	s.ExpectBegin()
+	s.ExpectCommit().Times(2)
+}
+
+// session - выполняет сценарий на хранилище и возвращает наблюдаемые результаты вызовов
+func session(t *testing.T, s storage.Storage) []string {
+	t.Helper()
+
+	ctx := context.Background()
+
+	var events []string
+
+	event := func(format string, args ...any) {
+		events = append(events, fmt.Sprintf(format, args...))
+	}
+
+	res, err := s.Exec(ctx, storage.NewQuery("UPDATE users SET name = $1 WHERE id = $2", "alpha", int64(1)))
+	if err != nil {
+		t.Fatalf("exec: %s", errors.Formatted(err))
+	}
+
+	affected, _ := res.RowsAffected()
+	event("exec: %d", affected)
+
+	selectUsers := storage.NewQuery("SELECT id, name, avatar, created, score FROM users")
+
+	var users []user
+
+	if err = s.Query(ctx, selectUsers, &users); err != nil {
+		t.Fatalf("query: %s", errors.Formatted(err))
+	}
+
+	for _, u := range users {
+		event("query: %d %s %v %s %v", u.ID, u.Name, u.Avatar, u.Created.Format(time.RFC3339), u.Score != nil)
+	}
+
+	var (
+		id   int64
+		name string
+	)
+
+	if err = s.QueryRow(ctx, storage.NewQuery(selectUsers.Query().(string)+" LIMIT 1"), &id, &name, new([]byte), new(time.Time), new(*float64)); err != nil {
+		t.Fatalf("query row: %s", errors.Formatted(err))
+	}
+
+	event("query row: %d %s", id, name)
+
+	iter, err := s.Iterate(ctx, selectUsers)
+	if err != nil {
+		t.Fatalf("iterate: %s", errors.Formatted(err))
+	}
+
+	for iter.Next(ctx) {
+		var u user
+
+		if err = iter.Decode(&u); err != nil {
+			t.Fatalf("decode: %s", errors.Formatted(err))
+		}
+
+		event("iterate: %s", u.Name)
+	}
+
+	_ = iter.Close()
+
+	err = s.QueryRow(ctx, storage.NewBindQuery("SELECT name FROM users WHERE id = :id", map[string]any{"id": 7}), &name)
+	event("empty: %t", errors.Is(err, storage.ErrEmptyResult))
+
+	_, err = s.Exec(ctx, storage.NewQuery("INSERT INTO users (name) VALUES ('alpha')"))
+	drvErr, _ := storage.AsDriverError(err)
+	event("duplicate: %t %s %s %s", errors.Is(err, storage.ErrUniqueViolation), drvErr.Code, drvErr.Constraint, err)
+
+	err = storage.RunInTx(ctx, s, nil, func(ctx context.Context) error {
+		storage.OnCommit(ctx, func(context.Context) { event("outer commit hook") })
+
+		if _, err := s.Exec(ctx, storage.NewQuery("INSERT INTO audit (event) VALUES ('outer')")); err != nil {
+			return err
+		}
+
+		return storage.RunInTx(ctx, s, nil, func(ctx context.Context) error {
+			storage.OnCommit(ctx, func(context.Context) { event("nested commit hook") })
+
+			res, err := s.Exec(ctx, storage.NewQuery("INSERT INTO audit (event) VALUES ('nested')"))
+			if err != nil {
+				return err
+			}
+
+			lastID, _ := res.LastInsertId()
+			event("nested exec: %d", lastID)
+
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("transaction: %s", errors.Formatted(err))
+	}
+
+	return events
+}
+
+func TestRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	inner := fake.New(t)
+	expectSession(inner)
+
+	recorder := replay.Record(inner, path)
+	recorded := session(t, recorder)
+
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("save cassette: %s", errors.Formatted(err))
+	}
+
+	player, err := replay.Replay(path)
+	if err != nil {
+		t.Fatalf("load cassette: %s", errors.Formatted(err))
+	}
+
+	replayed := session(t, player)
+
+	if !reflect.DeepEqual(replayed, recorded) {
+		t.Fatalf("replayed session differs:\n got %q\nwant %q", replayed, recorded)
+	}
+
+	if remaining := player.Remaining(); remaining != 0 {
+		t.Fatalf("remaining: got %d calls, want 0", remaining)
+	}
+
+	// хуки вложенной транзакции переносятся во внешнюю и выполняются после ее фиксации
+	// в порядке регистрации
+	if n := len(recorded); recorded[n-2] != "outer commit hook" || recorded[n-1] != "nested commit hook" {
+		t.Fatalf("hooks: got %q", recorded[n-2:])
+	}
+}
+
+func TestNotRecorded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	ctx := context.Background()
+	query := storage.NewQuery("UPDATE users SET name = $1 WHERE id = $2", "alpha", int64(1))
+
+	inner := fake.New(t)
+	inner.ExpectExec(`^UPDATE`)
+
+	recorder := replay.Record(inner, path)
+
+	if _, err := recorder.Exec(ctx, query); err != nil {
+		t.Fatalf("exec: %s", errors.Formatted(err))
+	}
+
+	if err := recorder.Save(); err != nil {
+		t.Fatalf("save cassette: %s", errors.Formatted(err))
+	}
+
+	player, err := replay.Replay(path)
+	if err != nil {
+		t.Fatalf("load cassette: %s", errors.Formatted(err))
+	}
+
+	exec := func(query storage.Query) func() error {
+		return func() error {
+			_, err := player.Exec(ctx, query)
+			return err
+		}
+	}
+
+	// вызовы воспроизводятся по очереди: записанный вызов можно воспроизвести один раз
+	for _, test := range []struct {
+		name string
+		call func() error
+		err  error
+	}{
+		{
+			name: "other params",
+			call: exec(storage.NewQuery("UPDATE users SET name = $1 WHERE id = $2", "beta", int64(1))),
+			err:  replay.ErrNotRecorded,
+		},
+		{name: "other method", call: func() error { return player.Query(ctx, query, nil) }, err: replay.ErrNotRecorded},
+		{name: "transaction", call: func() error { _, err := player.Begin(ctx); return err }, err: replay.ErrNotRecorded},
+		{name: "recorded", call: exec(query)},
+		{name: "replayed twice", call: exec(query), err: replay.ErrNotRecorded},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.call(); !errors.Is(err, test.err) {
+				t.Fatalf("call: got %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestTransactionMatching(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	ctx := context.Background()
+	first := storage.NewQuery("INSERT INTO audit (event) VALUES ('first')")
+	second := storage.NewQuery("INSERT INTO audit (event) VALUES ('second')")
+
+	inner := fake.New(t)
+	inner.ExpectBegin().Times(3)
+	inner.ExpectExec(`^INSERT`).Times(2)
+	inner.ExpectCommit().Times(2)
+	inner.ExpectRollback()
+
+	recorder := replay.Record(inner, path)
+
+	// первая транзакция выполняет first во вложенной транзакции и фиксируется,
+	// вторая выполняет second и откатывается
+	run := func(s storage.Storage) (events []string, err error) {
+		tx, err := s.Begin(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		nested, err := s.Begin(tx.Context())
+		if err != nil {
+			return nil, err
+		}
+
+		storage.OnCommit(nested.Context(), func(context.Context) { events = append(events, "nested commit") })
+		storage.OnRollback(nested.Context(), func(context.Context, error) { events = append(events, "nested rollback") })
+
+		if _, err = s.Exec(nested.Context(), first); err != nil {
+			return nil, err
+		}
+
+		if err = nested.Commit(ctx); err != nil {
+			return nil, err
+		}
+
+		if len(events) != 0 {
+			return nil, errors.New("nested hooks ran before outer transaction ended")
+		}
+
+		if err = tx.Commit(ctx); err != nil {
+			return nil, err
+		}
+
+		tx, err = s.Begin(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		storage.OnRollback(tx.Context(), func(context.Context, error) { events = append(events, "rollback") })
+
+		if _, err = s.Exec(tx.Context(), second); err != nil {
+			return nil, err
+		}
+
+		return events, tx.Rollback(ctx)
+	}
+
+	recorded, err := run(recorder)
+	if err != nil {
+		t.Fatalf("record: %s", errors.Formatted(err))
+	}
+
+	if err = recorder.Save(); err != nil {
+		t.Fatalf("save cassette: %s", errors.Formatted(err))
+	}
+
+	player, err := replay.Replay(path)
+	if err != nil {
+		t.Fatalf("load cassette: %s", errors.Formatted(err))
+	}
+
+	replayed, err := run(player)
+	if err != nil {
+		t.Fatalf("replay: %s", errors.Formatted(err))
+	}
+
+	if want := []string{"nested commit", "rollback"}; !reflect.DeepEqual(replayed, want) || !reflect.DeepEqual(recorded, want) {
+		t.Fatalf("hooks: recorded %q, replayed %q, want %q", recorded, replayed, want)
+	}
+
+	// вызов сопоставляется с транзакцией по номеру: first записан во вложенной транзакции первой
+	player, err = replay.Replay(path)
+	if err != nil {
+		t.Fatalf("load cassette: %s", errors.Formatted(err))
+	}
+
+	tx, err := player.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %s", errors.Formatted(err))
+	}
+
+	if _, err = player.Exec(tx.Context(), first); !errors.Is(err, replay.ErrNotRecorded) {
+		t.Fatalf("exec outside nested transaction: got %v, want %v", err, replay.ErrNotRecorded)
+	}
+
+	if _, err = player.Exec(tx.Context(), second); !errors.Is(err, replay.ErrNotRecorded) {
+		t.Fatalf("exec in other transaction: got %v, want %v", err, replay.ErrNotRecorded)
+	}
+}
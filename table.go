@@ -0,0 +1,336 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"reflect"
+
+	"github.com/georgysavva/scany/dbscan"
+	"gopkg.in/gomisc/errors.v1"
+)
+
+const (
+	ErrColumnsMismatch = errors.Const("destinations count does not match columns count")
+	ErrUnsupportedScan = errors.Const("unsupported scan conversion")
+	ErrValueOutOfRange = errors.Const("value is out of range of destination type")
+)
+
+var (
+	_ Scanner     = (*tableScanner)(nil)
+	_ Iterator    = (*tableIterator)(nil)
+	_ dbscan.Rows = (*tableRows)(nil)
+
+	tableScanAPI = mustTableScanAPI()
+)
+
+type (
+	// tableRows - адаптер таблицы к строкам dbscan
+	tableRows struct {
+		table *Table
+		row   int
+	}
+
+	tableScanner struct {
+		table Table
+	}
+
+	tableIterator struct {
+		rows    *tableRows
+		scanner *dbscan.RowScanner
+	}
+)
+
+// NewTableScanner - возвращает Scanner, декодирующий уже полученную таблицу результата
+// с той же семантикой, что и сканеры драйверов
+func NewTableScanner(table Table) Scanner {
+	return &tableScanner{table: table}
+}
+
+// NewTableIterator - возвращает итератор по строкам уже полученной таблицы результата
+func NewTableIterator(table Table) Iterator {
+	rows := &tableRows{table: &table, row: -1}
+
+	return &tableIterator{
+		rows:    rows,
+		scanner: tableScanAPI.NewRowScanner(rows),
+	}
+}
+
+// Decode - декодирует таблицу в результат произвольного типа так же, как Storage.Query:
+// *Result, *Table, указатель на слайс или на единственное значение
+func (t Table) Decode(result any) error {
+	scanner := NewTableScanner(t)
+
+	switch res := result.(type) {
+	case *Result:
+		return scanner.ScanResult(res)
+	case *Table:
+		return scanner.ScanTable(res)
+	default:
+		return scanner.Scan(result)
+	}
+}
+
+// ScanRow - сканирует колонки единственной строки таблицы в dest так же, как Storage.QueryRow
+func (t Table) ScanRow(dest ...any) error {
+	if len(t.Rows) == 0 {
+		return ErrEmptyResult
+	}
+
+	rows := &tableRows{table: &t, row: 0}
+
+	return rows.Scan(dest...)
+}
+
+func (ts *tableScanner) Scan(result any) error {
+	rows := &tableRows{table: &ts.table, row: -1}
+	val := reflect.ValueOf(result)
+
+	if val.Kind() == reflect.Ptr && val.Type().Elem().Kind() == reflect.Slice {
+		if err := tableScanAPI.ScanAll(result, rows); err != nil {
+			return errors.Wrap(err, "scan table to slice")
+		}
+
+		return nil
+	}
+
+	if err := tableScanAPI.ScanOne(result, rows); err != nil {
+		if dbscan.NotFound(err) {
+			return ErrEmptyResult
+		}
+
+		return errors.Wrap(err, "scan table to object")
+	}
+
+	return nil
+}
+
+func (ts *tableScanner) ScanResult(result *Result) error {
+	for _, values := range ts.table.Rows {
+		row := make(map[string]any, len(ts.table.Headers))
+
+		for i, header := range ts.table.Headers {
+			if i < len(values) {
+				row[header] = values[i]
+			}
+		}
+
+		*result = append(*result, row)
+	}
+
+	return nil
+}
+
+func (ts *tableScanner) ScanTable(table *Table) error {
+	table.Headers = append(table.Headers, ts.table.Headers...)
+
+	for _, values := range ts.table.Rows {
+		table.Rows = append(table.Rows, append([]any(nil), values...))
+	}
+
+	return nil
+}
+
+func (it *tableIterator) Close() error {
+	return it.rows.Close()
+}
+
+func (it *tableIterator) Next(_ context.Context) bool {
+	return it.rows.Next()
+}
+
+func (it *tableIterator) Err() error {
+	return nil
+}
+
+func (it *tableIterator) Decode(result any) error {
+	if err := it.scanner.Scan(result); err != nil {
+		return errors.Wrap(err, "decode table row")
+	}
+
+	return nil
+}
+
+func (rows *tableRows) Close() error {
+	rows.row = len(rows.table.Rows)
+
+	return nil
+}
+
+func (rows *tableRows) Err() error {
+	return nil
+}
+
+func (rows *tableRows) Next() bool {
+	if rows.row < len(rows.table.Rows) {
+		rows.row++
+	}
+
+	return rows.row < len(rows.table.Rows)
+}
+
+func (rows *tableRows) Columns() ([]string, error) {
+	return rows.table.Headers, nil
+}
+
+func (rows *tableRows) Scan(dest ...any) error {
+	// Decode до Next или после исчерпания строк
+	if rows.row < 0 || rows.row >= len(rows.table.Rows) {
+		return ErrEmptyResult
+	}
+
+	values := rows.table.Rows[rows.row]
+
+	if len(dest) != len(values) {
+		return errors.Ctx().
+			Int("destinations", len(dest)).
+			Int("columns", len(values)).
+			Just(ErrColumnsMismatch)
+	}
+
+	for i := range dest {
+		if err := assignValue(dest[i], values[i]); err != nil {
+			return errors.Ctx().Str("column", rows.column(i)).Wrap(err, "assign column value")
+		}
+	}
+
+	return nil
+}
+
+func (rows *tableRows) column(i int) string {
+	if i < len(rows.table.Headers) {
+		return rows.table.Headers[i]
+	}
+
+	return ""
+}
+
+// assignValue - присваивает значение колонки приемнику по правилам, близким к database/sql:
+// sql.Scanner, прямое присваивание, числовые преобразования, []byte <-> string и указатели
+func assignValue(dest any, src any) error {
+	if scanner, ok := dest.(sql.Scanner); ok {
+		return scanner.Scan(src)
+	}
+
+	dst := reflect.ValueOf(dest)
+	if !dst.IsValid() {
+		return errors.Ctx().Str("type", "nil").Just(ErrUnsupportedScan)
+	}
+
+	if dst.Kind() != reflect.Ptr || dst.IsNil() {
+		return errors.Ctx().Str("type", dst.Type().String()).Just(ErrUnsupportedScan)
+	}
+
+	return assignReflect(dst.Elem(), src)
+}
+
+func assignReflect(dst reflect.Value, src any) error {
+	if src == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+
+		return nil
+	}
+
+	srcVal := reflect.ValueOf(src)
+
+	switch {
+	case srcVal.Type().AssignableTo(dst.Type()):
+		dst.Set(srcVal)
+	case dst.Kind() == reflect.Ptr:
+		elem := reflect.New(dst.Type().Elem())
+
+		if err := assignReflect(elem.Elem(), src); err != nil {
+			return err
+		}
+
+		dst.Set(elem)
+	case dst.Kind() == reflect.String && srcVal.Kind() == reflect.Slice &&
+		srcVal.Type().Elem().Kind() == reflect.Uint8:
+		dst.SetString(string(srcVal.Bytes()))
+	case dst.Kind() == reflect.Slice && dst.Type().Elem().Kind() == reflect.Uint8 &&
+		srcVal.Kind() == reflect.String:
+		dst.SetBytes([]byte(srcVal.String()))
+	case isNumericKind(dst.Kind()) && isNumericKind(srcVal.Kind()):
+		return assignNumeric(dst, srcVal)
+	default:
+		return errors.Ctx().
+			Str("from", srcVal.Type().String()).
+			Str("to", dst.Type().String()).
+			Just(ErrUnsupportedScan)
+	}
+
+	return nil
+}
+
+// assignNumeric - числовое преобразование без потери значения: переполнение приемника
+// и отбрасывание дробной части возвращают ErrValueOutOfRange
+func assignNumeric(dst reflect.Value, src reflect.Value) error {
+	inRange := true
+
+	switch {
+	case isIntKind(src.Kind()):
+		val := src.Int()
+
+		switch {
+		case isIntKind(dst.Kind()):
+			inRange = !dst.OverflowInt(val)
+		case isUintKind(dst.Kind()):
+			inRange = val >= 0 && !dst.OverflowUint(uint64(val))
+		}
+	case isUintKind(src.Kind()):
+		val := src.Uint()
+
+		switch {
+		case isIntKind(dst.Kind()):
+			inRange = val <= math.MaxInt64 && !dst.OverflowInt(int64(val))
+		case isUintKind(dst.Kind()):
+			inRange = !dst.OverflowUint(val)
+		}
+	default:
+		val := src.Float()
+
+		switch {
+		case isIntKind(dst.Kind()):
+			inRange = val == math.Trunc(val) && val >= math.MinInt64 && val < math.MaxInt64 &&
+				!dst.OverflowInt(int64(val))
+		case isUintKind(dst.Kind()):
+			inRange = val == math.Trunc(val) && val >= 0 && val < math.MaxUint64 &&
+				!dst.OverflowUint(uint64(val))
+		default:
+			inRange = math.IsInf(val, 0) || math.IsNaN(val) || !dst.OverflowFloat(val)
+		}
+	}
+
+	if !inRange {
+		return errors.Ctx().
+			Any("value", src.Interface()).
+			Str("to", dst.Type().String()).
+			Just(ErrValueOutOfRange)
+	}
+
+	dst.Set(src.Convert(dst.Type()))
+
+	return nil
+}
+
+func isIntKind(kind reflect.Kind) bool {
+	return kind >= reflect.Int && kind <= reflect.Int64
+}
+
+func isUintKind(kind reflect.Kind) bool {
+	return kind >= reflect.Uint && kind <= reflect.Uintptr
+}
+
+func isNumericKind(kind reflect.Kind) bool {
+	return kind >= reflect.Int && kind <= reflect.Float64
+}
+
+func mustTableScanAPI() *dbscan.API {
+	api, err := dbscan.NewAPI(dbscan.WithScannableTypes((*sql.Scanner)(nil)))
+	if err != nil {
+		panic(err)
+	}
+
+	return api
+}
@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/gomisc/errors.v1"
+)
+
+// Уровни изоляции транзакций
+const (
+	LevelDefault IsolationLevel = iota
+	LevelReadUncommitted
+	LevelReadCommitted
+	LevelRepeatableRead
+	LevelSerializable
+)
+
+const (
+	ErrUnsupportedTxOptions = errors.Const("unsupported transaction options")
+)
+
+type (
+	// IsolationLevel - уровень изоляции транзакции
+	IsolationLevel int
+
+	// TxOptions - переносимые между драйверами опции транзакции,
+	// передаются в Storage.Begin значением или указателем
+	TxOptions struct {
+		// Isolation - уровень изоляции, LevelDefault оставляет уровень сервера
+		Isolation IsolationLevel
+		// ReadOnly - транзакция только для чтения
+		ReadOnly bool
+		// Deferrable - отложенная serializable read only транзакция (только PostgreSQL)
+		Deferrable bool
+		// Timeout - ограничение времени жизни транзакции, по истечении запросы в ней прерываются
+		Timeout time.Duration
+		// Label - метка транзакции для трейсинга и контекста ошибок
+		Label string
+	}
+)
+
+func (l IsolationLevel) String() string {
+	switch l {
+	case LevelDefault:
+		return "default"
+	case LevelReadUncommitted:
+		return "read uncommitted"
+	case LevelReadCommitted:
+		return "read committed"
+	case LevelRepeatableRead:
+		return "repeatable read"
+	case LevelSerializable:
+		return "serializable"
+	default:
+		return fmt.Sprintf("isolation level %d", int(l))
+	}
+}
+
+// TxOptionsFrom - извлекает переносимые опции транзакции из аргументов Storage.Begin,
+// ok равен false если первый аргумент не является TxOptions
+func TxOptionsFrom(in ...any) (opts *TxOptions, ok bool) {
+	if len(in) == 0 {
+		return nil, false
+	}
+
+	switch val := in[0].(type) {
+	case TxOptions:
+		return &val, true
+	case *TxOptions:
+		return val, val != nil
+	default:
+		return nil, false
+	}
+}
+
+// Validate - проверяет корректность опций транзакции
+func (opts *TxOptions) Validate() error {
+	if opts.Isolation < LevelDefault || opts.Isolation > LevelSerializable {
+		return errors.Ctx().Stringer("isolation", opts.Isolation).Just(ErrUnsupportedTxOptions)
+	}
+
+	if opts.Timeout < 0 {
+		return errors.Ctx().Stringer("timeout", opts.Timeout).Just(ErrUnsupportedTxOptions)
+	}
+
+	return nil
+}
+
+// NestedTxOptions - извлекает опции вложенной транзакции (точки сохранения) из аргументов
+// Storage.Begin. Вложенная транзакция наследует характеристики внешней транзакции с опциями outer,
+// поэтому допускаются совместимые с ними опции: уровень изоляции не строже внешнего, read only
+// и deferrable - только если они заданы у внешней. Внешняя транзакция с LevelDefault выполняется
+// на уровне сервера level, который у каждого драйвера свой
+func NestedTxOptions(outer *TxOptions, level IsolationLevel, in ...any) (*TxOptions, error) {
+	if len(in) == 0 || in[0] == nil {
+		return nil, nil
+	}
+
+	opts, ok := TxOptionsFrom(in...)
+	if !ok {
+		if ptr, isPtr := in[0].(*TxOptions); isPtr && ptr == nil {
+			return nil, nil
+		}
+
+		return nil, errors.Ctx().
+			Str("type", fmt.Sprintf("%T", in[0])).
+			Wrap(ErrUnsupportedTxOptions, "nested transaction options")
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	if outer == nil {
+		outer = &TxOptions{}
+	}
+
+	outerLevel := outer.Isolation
+	if outerLevel == LevelDefault {
+		outerLevel = level
+	}
+
+	if opts.Isolation > outerLevel {
+		return nil, errors.Ctx().
+			Stringer("isolation", opts.Isolation).
+			Stringer("outer-isolation", outerLevel).
+			Wrap(ErrUnsupportedTxOptions, "nested transaction isolation is stricter than outer")
+	}
+
+	if (opts.ReadOnly && !outer.ReadOnly) || (opts.Deferrable && !outer.Deferrable) {
+		return nil, errors.Ctx().
+			Bool("read-only", opts.ReadOnly).
+			Bool("deferrable", opts.Deferrable).
+			Bool("outer-read-only", outer.ReadOnly).
+			Bool("outer-deferrable", outer.Deferrable).
+			Wrap(ErrUnsupportedTxOptions, "nested transaction inherits outer transaction access mode")
+	}
+
+	return opts, nil
+}
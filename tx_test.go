@@ -0,0 +1,92 @@
+package storage_test
+
+import (
+	"testing"
+
+	"gopkg.in/gomisc/errors.v1"
+
+	"gopkg.in/gomisc/storage.v1"
+)
+
+func TestNestedTxOptions(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		outer *storage.TxOptions
+		level storage.IsolationLevel
+		in    []any
+		err   error
+	}{
+		{name: "no options", level: storage.LevelReadCommitted},
+		{name: "nil options", level: storage.LevelReadCommitted, in: []any{(*storage.TxOptions)(nil)}},
+		{
+			name:  "server default is read committed",
+			level: storage.LevelReadCommitted,
+			in:    []any{storage.TxOptions{Isolation: storage.LevelReadCommitted}},
+		},
+		{
+			name:  "stricter than server read committed",
+			level: storage.LevelReadCommitted,
+			in:    []any{storage.TxOptions{Isolation: storage.LevelRepeatableRead}},
+			err:   storage.ErrUnsupportedTxOptions,
+		},
+		{
+			name:  "server default is repeatable read",
+			level: storage.LevelRepeatableRead,
+			in:    []any{storage.TxOptions{Isolation: storage.LevelRepeatableRead}},
+		},
+		{
+			name:  "server default is serializable",
+			outer: &storage.TxOptions{},
+			level: storage.LevelSerializable,
+			in:    []any{&storage.TxOptions{Isolation: storage.LevelSerializable}},
+		},
+		{
+			name:  "explicit outer level wins over server default",
+			outer: &storage.TxOptions{Isolation: storage.LevelReadCommitted},
+			level: storage.LevelSerializable,
+			in:    []any{storage.TxOptions{Isolation: storage.LevelRepeatableRead}},
+			err:   storage.ErrUnsupportedTxOptions,
+		},
+		{
+			name:  "read only in read write",
+			level: storage.LevelReadCommitted,
+			in:    []any{storage.TxOptions{ReadOnly: true}},
+			err:   storage.ErrUnsupportedTxOptions,
+		},
+		{
+			name:  "read only in read only",
+			outer: &storage.TxOptions{ReadOnly: true},
+			level: storage.LevelReadCommitted,
+			in:    []any{storage.TxOptions{ReadOnly: true}},
+		},
+		{
+			name:  "deferrable",
+			outer: &storage.TxOptions{Isolation: storage.LevelSerializable, ReadOnly: true},
+			level: storage.LevelReadCommitted,
+			in:    []any{storage.TxOptions{Deferrable: true}},
+			err:   storage.ErrUnsupportedTxOptions,
+		},
+		{
+			name:  "driver options",
+			level: storage.LevelReadCommitted,
+			in:    []any{struct{}{}},
+			err:   storage.ErrUnsupportedTxOptions,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := storage.NestedTxOptions(test.outer, test.level, test.in...)
+
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("nested options: got %v, want %v", err, test.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("nested options: %s", errors.Formatted(err))
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"io"
+
+	"gopkg.in/gomisc/errors.v1"
+)
+
+var _ TypedIterator[any] = (*typedIterator[any])(nil)
+
+type (
+	// TypedIterator - типизированный итератор по многоэлементному результату запроса
+	TypedIterator[T any] interface {
+		io.Closer
+		// Next - перемещает курсор итератора на следующий элемент и декодирует его
+		Next(ctx context.Context) bool
+		// Err - возвращает ошибку итератора или декодирования, если такая имела место
+		Err() error
+		// Value - возвращает текущий элемент итерации
+		Value() T
+	}
+
+	typedIterator[T any] struct {
+		iter  Iterator
+		value T
+		err   error
+	}
+)
+
+// Get - выполняет запрос и возвращает единственную строку результата, приведенную к типу T
+func Get[T any](ctx context.Context, s Storage, query Query) (T, error) {
+	var result T
+
+	if err := s.Query(ctx, query, &result); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// Select - выполняет запрос и возвращает все строки результата, приведенные к типу T
+func Select[T any](ctx context.Context, s Storage, query Query) ([]T, error) {
+	result := make([]T, 0)
+
+	if err := s.Query(ctx, query, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Scalar - выполняет запрос и возвращает значение единственной колонки единственной строки результата
+func Scalar[T any](ctx context.Context, s Storage, query Query) (T, error) {
+	var result T
+
+	if err := s.QueryRow(ctx, query, &result); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// IterateAs - выполняет запрос и возвращает типизированный итератор по его результату
+func IterateAs[T any](ctx context.Context, s Storage, query Query) (TypedIterator[T], error) {
+	iter, err := s.Iterate(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTypedIterator[T](iter), nil
+}
+
+// NewTypedIterator - оборачивает итератор в типизированный итератор
+func NewTypedIterator[T any](iter Iterator) TypedIterator[T] {
+	return &typedIterator[T]{iter: iter}
+}
+
+// Close - имплементация io.Closer
+func (it *typedIterator[T]) Close() error {
+	return it.iter.Close()
+}
+
+// Next - имплементация TypedIterator
+func (it *typedIterator[T]) Next(ctx context.Context) bool {
+	if it.err != nil || !it.iter.Next(ctx) {
+		return false
+	}
+
+	var value T
+
+	if err := it.iter.Decode(&value); err != nil {
+		it.err = errors.Wrap(err, "decode typed item")
+
+		return false
+	}
+
+	it.value = value
+
+	return true
+}
+
+// Err - имплементация TypedIterator
+func (it *typedIterator[T]) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+
+	return it.iter.Err()
+}
+
+// Value - имплементация TypedIterator
+func (it *typedIterator[T]) Value() T {
+	return it.value
+}